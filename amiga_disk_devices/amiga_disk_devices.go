@@ -1,6 +1,9 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io/fs"
 	"log"
 	"os"
@@ -8,13 +11,16 @@ import (
 	"path"
 	"strings"
 	"syscall"
+	"time"
 
 	components_amiga_disk_devices "github.com/skazanyNaGlany/go.amipi400/amiga_disk_devices/components"
+	cache_amiga_disk_devices "github.com/skazanyNaGlany/go.amipi400/amiga_disk_devices/components/cache"
 	drivers_amiga_disk_devices "github.com/skazanyNaGlany/go.amipi400/amiga_disk_devices/components/drivers"
 	medium_amiga_disk_devices "github.com/skazanyNaGlany/go.amipi400/amiga_disk_devices/components/medium"
 	interfaces_amiga_disk_devices "github.com/skazanyNaGlany/go.amipi400/amiga_disk_devices/interfaces"
 	"github.com/skazanyNaGlany/go.amipi400/shared"
 	"github.com/skazanyNaGlany/go.amipi400/shared/components"
+	"github.com/skazanyNaGlany/go.amipi400/shared/components/amigafs"
 	"github.com/skazanyNaGlany/go.amipi400/shared/components/utils"
 	"github.com/thoas/go-funk"
 )
@@ -32,8 +38,39 @@ var asyncFileOpsDf1 components.AsyncFileOps
 var asyncFileOpsDf2 components.AsyncFileOps
 var asyncFileOpsDf3 components.AsyncFileOps
 var allKeyboardsControl components.AllKeyboardsControl
+var cachedAdfIndex cache_amiga_disk_devices.CachedADFIndex
 var cachedAdfsDir = ""
+var cachedAdfsIndexPathname = ""
+var floppyWriteJournal cache_amiga_disk_devices.WriteJournal
+var floppyJournalDir = ""
 var floppyDevices []string
+var config = components_amiga_disk_devices.NewADDConfig(shared.AMIGA_DISK_DEVICES_CONFIG_INI_PATHNAME)
+
+// mediumStatus is a single medium in the status
+// file (shared.FILE_SYSTEM_STATUS_FILENAME)
+type mediumStatus struct {
+	DevicePathname    string  `json:"device_pathname"`
+	PublicPathname    string  `json:"public_pathname"`
+	Driver            string  `json:"driver"`
+	Writable          bool    `json:"writable"`
+	FloppyUUID        string  `json:"floppy_uuid,omitempty"`
+	CachedAdfPathname string  `json:"cached_adf_pathname,omitempty"`
+	FullyCached       bool    `json:"fully_cached"`
+	Pinned            bool    `json:"pinned"`
+	Dirty             bool    `json:"dirty"`
+	Rewriting         bool    `json:"rewriting"`
+	QueuedOperations  int     `json:"queued_operations"`
+	QueuedSectors     []int64 `json:"queued_sectors"`
+	PendingSectors    []int64 `json:"pending_sectors"`
+	DivergedSectors   []int64 `json:"diverged_sectors"`
+	BadSectors        []int64 `json:"bad_sectors"`
+}
+
+type status struct {
+	Mediums    []mediumStatus                               `json:"mediums"`
+	DoNotEject bool                                         `json:"do_not_eject"`
+	CachedAdfs cache_amiga_disk_devices.CachedADFIndexStats `json:"cached_adfs"`
+}
 
 func ProbeMediumForDriver(
 	name string,
@@ -51,6 +88,16 @@ func ProbeMediumForDriver(
 		defer powerLEDControl.BlinkPowerLEDSecs(shared.CMD_SUCCESS_BLINK_POWER_SECS)
 	}
 
+	pinCachedAdf := allKeyboardsControl.IsKeysPressed(shared.PIN_CACHED_ADF_KEYS) ||
+		allKeyboardsControl.IsKeysPressed(shared.PIN_CACHED_ADF_KEYS_ALT)
+
+	if pinCachedAdf {
+		allKeyboardsControl.ClearPressedKeys()
+
+		powerLEDControl.BlinkPowerLEDSecs(shared.CMD_PENDING_BLINK_POWER_SECS)
+		defer powerLEDControl.BlinkPowerLEDSecs(shared.CMD_SUCCESS_BLINK_POWER_SECS)
+	}
+
 	// try FloppyMediumDriver
 	floppyDriver := drivers_amiga_disk_devices.FloppyMediumDriver{}
 
@@ -58,7 +105,12 @@ func ProbeMediumForDriver(
 	floppyDriver.SetVerboseMode(shared.DRIVERS_VERBOSE_MODE)
 	floppyDriver.SetDebugMode(shared.DRIVERS_DEBUG_MODE)
 	floppyDriver.SetOutsideAsyncFileWriterCallback(outsideAsyncFileWriterCallback)
+	floppyDriver.SetOutsideAsyncFileFlushCallback(outsideAsyncFileFlushCallback)
 	floppyDriver.SetPreCacheADFCallback(preCacheADFCallback)
+	floppyDriver.SetCachedAdfIndex(&cachedAdfIndex)
+	floppyDriver.SetPinCachedAdf(pinCachedAdf)
+	floppyDriver.SetRescueBadSectors(config.AmigaDiskDevices.FloppyRescueBadSectors)
+	floppyDriver.SetWriteJournal(&floppyWriteJournal)
 
 	medium, err := floppyDriver.Probe(
 		shared.FILE_SYSTEM_MOUNT,
@@ -181,29 +233,38 @@ func formatDeviceIfNeeded(
 
 	log.Println("Formatting device", path)
 
-	n, err := utils.FileUtilsInstance.FileWriteBytes(
-		path,
-		0,
-		shared.EMPTY_DEVICE_HEADER[:],
-		os.O_RDWR|os.O_SYNC,
-		0,
-		nil)
-
-	if err != nil {
+	if err := formatDevice(path, size); err != nil {
 		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
 
-		log.Println(err)
+		log.Println("Cannot format medium in", path, ":", err)
 		return false
 	}
 
-	if n < len(shared.EMPTY_DEVICE_HEADER) {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
+	return true
+}
 
-		log.Println("Cannot format medium in", path)
-		return false
+// formatDevice creates empty Amiga volume on the device, floppy
+// is formatted as ADF (the first shared.FLOPPY_ADF_SIZE bytes)
+func formatDevice(path string, size uint64) error {
+	options := amigafs.FormatOptions{
+		VolumeName: shared.AMIGAFS_DEFAULT_VOLUME_NAME,
+		FFS:        shared.FORMAT_HD_FFS}
+	volumeSize := int64(size)
+
+	if size == shared.FLOPPY_DEVICE_SIZE {
+		options.FFS = shared.FORMAT_FLOPPY_FFS
+		volumeSize = shared.FLOPPY_ADF_SIZE
 	}
 
-	return true
+	handle, err := os.OpenFile(path, os.O_RDWR|os.O_SYNC, 0)
+
+	if err != nil {
+		return err
+	}
+
+	defer handle.Close()
+
+	return amigafs.Format(handle, volumeSize, options)
 }
 
 func attachedBlockDeviceCallback(
@@ -303,6 +364,12 @@ func attachedBlockDeviceCallback(
 	}
 
 	fileSystem.AddMedium(_medium)
+
+	if isFloppy {
+		logCachedAdfsStats()
+		replayWriteJournal(floppyMedium)
+		schedulePrefetch(floppyMedium)
+	}
 }
 
 func detachedBlockDeviceCallback(
@@ -332,9 +399,23 @@ func detachedBlockDeviceCallback(
 		readOnly,
 	)
 
+	async := devicePathnameToAsyncFileOps(path)
+
+	async.CancelPrefetch(path)
+
+	// writes cannot be done anymore, their sectors are
+	// journaled and written when the medium is inserted again
+	if cancelled := async.CancelWrites(path); cancelled > 0 {
+		log.Printf("Cancelled %v queued writes to medium in %v\n", cancelled, path)
+	}
+
+	warnDirtyMediumRemoved(path)
+
 	if _, err := fileSystem.RemoveMediumByDevicePathname(path); err != nil {
 		log.Println("Unable to close medium:", path, ":", err)
 	}
+
+	updateDirtyState()
 }
 
 func devicePathnameToAsyncFileOps(devicePathname string) *components.AsyncFileOps {
@@ -353,6 +434,79 @@ func devicePathnameToAsyncFileOps(devicePathname string) *components.AsyncFileOp
 	return &asyncFileOps
 }
 
+// replayWriteJournal writes sectors which were not written
+// when the medium was removed the last time
+func replayWriteJournal(floppyMedium *medium_amiga_disk_devices.FloppyMedium) {
+	floppyDriver, isFloppy := floppyMedium.GetDriver().(*drivers_amiga_disk_devices.FloppyMediumDriver)
+
+	if !isFloppy {
+		return
+	}
+
+	count, err := floppyDriver.ReplayWriteJournal(floppyMedium)
+
+	if err != nil {
+		log.Println(floppyMedium.GetDevicePathname(), err)
+	}
+
+	if count > 0 {
+		log.Printf(
+			"Replaying %v pending sectors to the medium in %v\n",
+			count,
+			floppyMedium.GetDevicePathname(),
+		)
+	}
+}
+
+// schedulePrefetch queues reading of the not cached sectors, track
+// by track, the medium is read in the background when
+// the emulator does not use it
+func schedulePrefetch(floppyMedium *medium_amiga_disk_devices.FloppyMedium) {
+	sectorBitmap := floppyMedium.GetSectorBitmap()
+
+	if floppyMedium.GetCachedAdfPathname() != "" || sectorBitmap == nil {
+		return
+	}
+
+	devicePathname := floppyMedium.GetDevicePathname()
+	async := devicePathnameToAsyncFileOps(devicePathname)
+
+	for ofst := int64(0); ofst < shared.FLOPPY_ADF_SIZE; ofst += shared.FLOPPY_TRACK_SIZE {
+		if !sectorBitmap.IsRangeSet(ofst, shared.FLOPPY_TRACK_SIZE) {
+			async.FilePrefetch(devicePathname, ofst, shared.FLOPPY_TRACK_SIZE, prefetchCallback)
+		}
+	}
+
+	log.Printf(
+		"Scheduled prefetch of %v tracks for medium in %v\n",
+		async.GetPrefetchCount(devicePathname),
+		devicePathname,
+	)
+}
+
+func prefetchCallback(name string, offset int64, size int64) bool {
+	_medium := fileSystem.FindMediumByDevicePathname(name)
+
+	if _medium == nil {
+		// medium was removed
+		return true
+	}
+
+	floppyDriver, isFloppy := _medium.GetDriver().(*drivers_amiga_disk_devices.FloppyMediumDriver)
+
+	if !isFloppy {
+		return true
+	}
+
+	done, err := floppyDriver.Prefetch(_medium, offset, size)
+
+	if err != nil {
+		log.Println(name, err)
+	}
+
+	return done
+}
+
 func onFloppyRead(_medium interfaces_amiga_disk_devices.Medium, ofst int64) {
 	floppyMedium, isFloppy := _medium.(*medium_amiga_disk_devices.FloppyMedium)
 
@@ -384,9 +538,10 @@ func onFloppyRead(_medium interfaces_amiga_disk_devices.Medium, ofst int64) {
 
 		// reading from cached floppy medium
 		// read from real device to move the motor
+		// to the track being emulated
 		async.FileReadBytesDirect(
 			devicePathname,
-			ofst,
+			ofst-ofst%shared.FLOPPY_TRACK_SIZE,
 			flag,
 			0,
 			deviceDirectIOHandle,
@@ -481,17 +636,45 @@ func postWriteCallback(
 func closedCallback(_medium interfaces_amiga_disk_devices.Medium, err error) {
 }
 
+// fileWriteBytesCallback is called when the write queued for
+// the floppy with floppyUUID was executed or cancelled
 func fileWriteBytesCallback(
+	floppyUUID string,
 	name string,
 	offset int64,
 	buff []byte,
-	flag int,
-	perm fs.FileMode,
-	useHandle *os.File,
 	n int,
 	err error,
 ) {
-	powerLEDControl.BlinkPowerLEDSecs(shared.FLOPPY_WRITE_BLINK_POWER_SECS)
+	if errors.Is(err, components.ErrAsyncFileOpCancelled) {
+		err = nil
+		n = 0
+	} else {
+		powerLEDControl.BlinkPowerLEDSecs(shared.FLOPPY_WRITE_BLINK_POWER_SECS)
+	}
+
+	if err != nil {
+		log.Println(name, err)
+	}
+
+	if floppyUUID == "" {
+		return
+	}
+
+	floppyMedium, isFloppy := fileSystem.FindMediumByDevicePathname(name).(*medium_amiga_disk_devices.FloppyMedium)
+
+	// when the medium was removed (or replaced) pending sectors
+	// stay in the journal, only the queued count is released
+	written := err == nil &&
+		n == len(buff) &&
+		isFloppy &&
+		floppyMedium.GetFloppyUUID() == floppyUUID
+
+	if err := floppyWriteJournal.Release(floppyUUID, offset, int64(len(buff)), written); err != nil {
+		log.Println(err)
+	}
+
+	updateDirtyState()
 }
 
 func outsideAsyncFileWriterCallback(
@@ -507,6 +690,27 @@ func outsideAsyncFileWriterCallback(
 
 	async := devicePathnameToAsyncFileOps(name)
 
+	// the write is journaled for the floppy in the drive
+	// now, it can be removed before the write is done
+	floppyUUID := ""
+
+	if floppyMedium, isFloppy := fileSystem.FindMediumByDevicePathname(name).(*medium_amiga_disk_devices.FloppyMedium); isFloppy {
+		floppyUUID = floppyMedium.GetFloppyUUID()
+	}
+
+	callback := func(
+		name string,
+		offset int64,
+		buff []byte,
+		flag int,
+		perm fs.FileMode,
+		useHandle *os.File,
+		n int,
+		err error,
+	) {
+		fileWriteBytesCallback(floppyUUID, name, offset, buff, n, err)
+	}
+
 	if oneTimeFinal {
 		async.FileWriteBytesOneTimeFinal(
 			name,
@@ -515,11 +719,157 @@ func outsideAsyncFileWriterCallback(
 			flag,
 			perm,
 			useHandle,
-			fileWriteBytesCallback,
+			callback,
 		)
 	} else {
-		async.FileWriteBytes(name, offset, buff, flag, perm, useHandle, 0, fileWriteBytesCallback)
+		async.FileWriteBytes(name, offset, buff, flag, perm, useHandle, 0, callback)
+	}
+
+	updateDirtyState()
+}
+
+// outsideAsyncFileFlushCallback waits for the writes
+// queued for the device, returns false on timeout
+func outsideAsyncFileFlushCallback(name string) bool {
+	return devicePathnameToAsyncFileOps(name).FlushTimeout(
+		name,
+		time.Second*shared.FLOPPY_FSYNC_TIMEOUT_SECS)
+}
+
+// updateDirtyState marks floppy mediums with queued writes as
+// dirty, the power LED is kept in the do not eject pattern
+// while any of them is dirty
+func updateDirtyState() {
+	doNotEject := false
+
+	for _, _medium := range fileSystem.GetMediums() {
+		floppyMedium, isFloppy := _medium.(*medium_amiga_disk_devices.FloppyMedium)
+
+		if !isFloppy || floppyMedium.GetFloppyUUID() == "" {
+			continue
+		}
+
+		dirty := len(floppyWriteJournal.GetQueuedSectors(floppyMedium.GetFloppyUUID())) > 0
+
+		if dirty != floppyMedium.IsDirty() {
+			floppyMedium.SetDirty(dirty)
+
+			if dirty {
+				log.Printf("Writing to medium %v, do not eject\n", floppyMedium.GetDevicePathname())
+			} else {
+				log.Printf("All writes to medium %v finished\n", floppyMedium.GetDevicePathname())
+			}
+		}
+
+		doNotEject = doNotEject || dirty
+	}
+
+	powerLEDControl.SetDoNotEject(doNotEject)
+}
+
+// warnDirtyMediumRemoved logs sectors not written to the removed
+// floppy medium, they stay in the journal and are written
+// when the medium is inserted again
+func warnDirtyMediumRemoved(devicePathname string) {
+	floppyMedium, isFloppy := fileSystem.FindMediumByDevicePathname(devicePathname).(*medium_amiga_disk_devices.FloppyMedium)
+
+	if !isFloppy || floppyMedium.GetFloppyUUID() == "" {
+		return
+	}
+
+	pendingSectors, err := floppyWriteJournal.GetPendingSectors(floppyMedium.GetFloppyUUID())
+
+	if err != nil {
+		log.Println(devicePathname, err)
+
+		return
+	}
+
+	if len(pendingSectors) == 0 {
+		return
 	}
+
+	log.Println("!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!")
+	log.Printf(
+		"WARNING: medium %v (%v) removed with %v not written sectors\n",
+		devicePathname,
+		floppyMedium.GetFloppyUUID(),
+		len(pendingSectors),
+	)
+	log.Printf("Lost sectors: %v\n", pendingSectors)
+	log.Println("They will be written when the medium is inserted again")
+	log.Println("!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!")
+}
+
+func getMediumStatus(_medium interfaces_amiga_disk_devices.Medium) mediumStatus {
+	devicePathname := _medium.GetDevicePathname()
+
+	mStatus := mediumStatus{
+		DevicePathname:   devicePathname,
+		PublicPathname:   _medium.GetPublicPathname(),
+		Driver:           fmt.Sprintf("%T", _medium.GetDriver()),
+		Writable:         _medium.IsWritable(),
+		QueuedOperations: devicePathnameToAsyncFileOps(devicePathname).GetQueuedCount(devicePathname),
+		QueuedSectors:    []int64{},
+		PendingSectors:   []int64{},
+		DivergedSectors:  []int64{},
+		BadSectors:       []int64{}}
+
+	floppyMedium, isFloppy := _medium.(*medium_amiga_disk_devices.FloppyMedium)
+
+	if !isFloppy {
+		return mStatus
+	}
+
+	uuidStr := floppyMedium.GetFloppyUUID()
+
+	mStatus.FloppyUUID = uuidStr
+	mStatus.CachedAdfPathname = floppyMedium.GetCachedAdfPathname()
+	mStatus.FullyCached = floppyMedium.IsFullyCached()
+	mStatus.Pinned = floppyMedium.IsPinned()
+	mStatus.Dirty = floppyMedium.IsDirty()
+	mStatus.Rewriting = floppyMedium.IsRewriting()
+
+	if divergedSectors := floppyMedium.GetDivergedSectors(); divergedSectors != nil {
+		mStatus.DivergedSectors = divergedSectors
+	}
+
+	if badSectorMap := floppyMedium.GetBadSectorMap(); badSectorMap != nil {
+		mStatus.BadSectors = badSectorMap.GetSetSectors()
+	}
+
+	if uuidStr != "" {
+		mStatus.QueuedSectors = floppyWriteJournal.GetQueuedSectors(uuidStr)
+
+		if pendingSectors, err := floppyWriteJournal.GetPendingSectors(uuidStr); err == nil {
+			mStatus.PendingSectors = pendingSectors
+		}
+	}
+
+	return mStatus
+}
+
+// statusCallback returns content of the status
+// file (shared.FILE_SYSTEM_STATUS_FILENAME)
+func statusCallback() []byte {
+	_status := status{
+		Mediums:    []mediumStatus{},
+		DoNotEject: powerLEDControl.IsDoNotEject(),
+		CachedAdfs: cachedAdfIndex.GetStats()}
+
+	for _, _medium := range fileSystem.GetMediums() {
+		_status.Mediums = append(_status.Mediums, getMediumStatus(_medium))
+	}
+
+	data, err := json.MarshalIndent(_status, "", "  ")
+
+	if err != nil {
+		log.Println(err)
+
+		return []byte{}
+	}
+
+	return append(data, '\n')
 }
 
 func keyEventCallback(sender any, key string, pressed bool) {
@@ -529,44 +879,209 @@ func preCacheADFCallback(
 	_medium interfaces_amiga_disk_devices.Medium,
 	targetADFpathname string,
 ) error {
-	size, err := utils.FileUtilsInstance.GetDirSize(cachedAdfsDir)
+	targetUUID := strings.TrimSuffix(
+		path.Base(targetADFpathname),
+		shared.FLOPPY_ADF_FULL_EXTENSION)
 
-	if err != nil {
-		return err
+	for {
+		size, err := utils.FileUtilsInstance.GetDirSize(cachedAdfsDir)
+
+		if err != nil {
+			return err
+		}
+
+		if size < shared.CACHED_ADFS_QUOTA {
+			// quota not exceeded
+			return nil
+		}
+
+		// exceeded the quota
+		log.Printf(
+			"Exceeded the quota for %v (max %v bytes)\n",
+			cachedAdfsDir,
+			shared.CACHED_ADFS_QUOTA,
+		)
+
+		// files of the inserted mediums and the
+		// ADF being cached cannot be deleted
+		usedUUIDs, usedPathnames := getUsedCachedAdfsFiles()
+
+		usedUUIDs[targetUUID] = true
+		usedPathnames[path.Clean(targetADFpathname)] = true
+
+		deleted, err := deleteLeastRecentlyUsedCachedAdf(usedUUIDs)
+
+		if err != nil {
+			return err
+		}
+
+		if !deleted {
+			if deleted, err = deleteOldestNotIndexedFile(usedPathnames); err != nil {
+				return err
+			}
+		}
+
+		if !deleted {
+			// only pinned or used ADFs left
+			log.Println("Nothing to delete, all cached ADFs are pinned or used")
+
+			return nil
+		}
+	}
+}
+
+// getUsedCachedAdfsFiles returns UUIDs and pathnames of
+// the files (cached ADFs, partially cached ADFs, sector
+// bitmaps and bad-sector maps) used by the inserted mediums
+func getUsedCachedAdfsFiles() (map[string]bool, map[string]bool) {
+	uuids := make(map[string]bool)
+	pathnames := make(map[string]bool)
+
+	for _, _medium := range fileSystem.GetMediums() {
+		floppyMedium, isFloppy := _medium.(*medium_amiga_disk_devices.FloppyMedium)
+
+		if !isFloppy {
+			continue
+		}
+
+		if uuidStr := floppyMedium.GetFloppyUUID(); uuidStr != "" {
+			uuids[uuidStr] = true
+		}
+
+		mediumPathnames := []string{
+			floppyMedium.GetCachedAdfPathname(),
+			floppyMedium.GetPartialAdfPathname()}
+
+		if sectorBitmap := floppyMedium.GetSectorBitmap(); sectorBitmap != nil {
+			mediumPathnames = append(mediumPathnames, sectorBitmap.GetPathname())
+		}
+
+		if badSectorMap := floppyMedium.GetBadSectorMap(); badSectorMap != nil {
+			mediumPathnames = append(mediumPathnames, badSectorMap.GetPathname())
+		}
+
+		for _, pathname := range mediumPathnames {
+			if pathname != "" {
+				pathnames[path.Clean(pathname)] = true
+			}
+		}
 	}
 
-	if size < shared.CACHED_ADFS_QUOTA {
-		// quota not exceeded
-		return nil
+	return uuids, pathnames
+}
+
+func deleteLeastRecentlyUsedCachedAdf(excludeUUIDs map[string]bool) (bool, error) {
+	entry, found := cachedAdfIndex.GetLeastRecentlyUsed(
+		func(entry cache_amiga_disk_devices.CachedADFIndexEntry) bool {
+			// cached ADF with pending writes is the only
+			// valid copy of the data
+			return excludeUUIDs[entry.UUID] || floppyWriteJournal.HasPending(entry.UUID)
+		})
+
+	if !found {
+		return false, nil
 	}
 
-	// exceeded the quota
+	pathname := path.Join(cachedAdfsDir, entry.UUID+shared.FLOPPY_ADF_FULL_EXTENSION)
+
 	log.Printf(
-		"Exceeded the quota for %v (max %v bytes)\n",
-		cachedAdfsDir,
-		shared.CACHED_ADFS_QUOTA,
+		"Deleting least recently used cached ADF %v (last access %v, hits %v)\n",
+		pathname,
+		time.Unix(entry.LastAccessTime, 0).Format("2006-01-02 15:04:05"),
+		entry.Hits,
 	)
-	log.Println("Trying to find oldest file to delete it")
 
-	oldest, err := utils.FileUtilsInstance.GetDirOldestFile(cachedAdfsDir)
+	if err := os.Remove(pathname); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+
+	// bad-sector map of the cached ADF, if any
+	os.Remove(path.Join(cachedAdfsDir, entry.UUID+"."+shared.FLOPPY_BAD_SECTORS_EXTENSION))
+
+	return true, cachedAdfIndex.Remove(entry.UUID)
+}
+
+// deleteOldestNotIndexedFile deletes oldest files which are not
+// cached ADFs from the index (like partially cached ADF), files
+// with the same name (partially cached ADF, its sector bitmap
+// and bad-sector map) are deleted together, files
+// in excludePathnames are never deleted
+func deleteOldestNotIndexedFile(excludePathnames map[string]bool) (bool, error) {
+	entries, err := os.ReadDir(cachedAdfsDir)
 
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	if oldest == nil {
-		return nil
+	groups := make(map[string][]string)
+	modTimes := make(map[string]time.Time)
+	excluded := make(map[string]bool)
+
+	for _, dirEntry := range entries {
+		info, err := dirEntry.Info()
+
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		name := strings.TrimSuffix(info.Name(), path.Ext(info.Name()))
+
+		if _, indexed := cachedAdfIndex.Get(name); indexed {
+			continue
+		}
+
+		pathname := path.Join(cachedAdfsDir, info.Name())
+
+		if excludePathnames[pathname] {
+			excluded[name] = true
+		}
+
+		groups[name] = append(groups[name], pathname)
+
+		// the group is as old as its newest file
+		if info.ModTime().After(modTimes[name]) {
+			modTimes[name] = info.ModTime()
+		}
 	}
 
-	pathname := path.Join(cachedAdfsDir, oldest.Name())
+	oldest := ""
 
-	log.Printf("Deleting oldest file %v\n", pathname)
+	for name := range groups {
+		if excluded[name] {
+			continue
+		}
 
-	if err = os.Remove(pathname); err != nil {
-		return err
+		if oldest == "" || modTimes[name].Before(modTimes[oldest]) {
+			oldest = name
+		}
+	}
+
+	if oldest == "" {
+		return false, nil
+	}
+
+	for _, pathname := range groups[oldest] {
+		log.Printf("Deleting oldest file %v\n", pathname)
+
+		if err = os.Remove(pathname); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return false, err
+		}
 	}
 
-	return nil
+	return true, nil
+}
+
+func logCachedAdfsStats() {
+	stats := cachedAdfIndex.GetStats()
+
+	log.Printf(
+		"Cached ADFs: %v (%v bytes), pinned: %v, deduplicated: %v, hits: %v\n",
+		stats.Entries,
+		stats.Size,
+		stats.Pinned,
+		stats.Deduplicated,
+		stats.Hits,
+	)
 }
 
 func initCreateDirs(exeDir string) {
@@ -579,6 +1094,26 @@ func initCreateDirs(exeDir string) {
 	if err := os.MkdirAll(cachedAdfsDir, 0777); err != nil {
 		log.Fatalln(err)
 	}
+
+	floppyJournalDir = path.Join(exeDir, shared.FLOPPY_JOURNAL)
+
+	if err := os.MkdirAll(floppyJournalDir, 0777); err != nil {
+		log.Fatalln(err)
+	}
+
+	floppyWriteJournal.SetDirectory(floppyJournalDir)
+
+	cachedAdfsIndexPathname = path.Join(exeDir, shared.CACHED_ADFS_INDEX)
+
+	if err := cachedAdfIndex.Load(cachedAdfsIndexPathname); err != nil {
+		// broken index, write-protected mediums
+		// will be cached again
+		log.Println(err)
+	}
+
+	if err := cachedAdfIndex.Sync(cachedAdfsDir, shared.FLOPPY_ADF_FULL_EXTENSION); err != nil {
+		log.Println(err)
+	}
 }
 
 func discoverDriveDevices() {
@@ -614,11 +1149,36 @@ func printCDROMDevices() {
 	}
 }
 
+// flushAsyncFileOps waits for the queued writes, so
+// the mediums are up to date before exiting
+func flushAsyncFileOps() {
+	for _, async := range []*components.AsyncFileOps{
+		&asyncFileOps,
+		&asyncFileOpsDf0,
+		&asyncFileOpsDf1,
+		&asyncFileOpsDf2,
+		&asyncFileOpsDf3,
+	} {
+		count := async.GetQueuedCount("")
+
+		if count == 0 {
+			continue
+		}
+
+		log.Printf("Flushing %v queued file operations\n", count)
+
+		if !async.FlushTimeout("", time.Second*shared.ASYNC_FILE_OPS_FLUSH_TIMEOUT_SECS) {
+			log.Println("Timeout while flushing queued file operations")
+		}
+	}
+}
+
 func stopServices() {
 	fileSystem.Stop(&fileSystem)
 	blockDevices.Stop(&blockDevices)
 	volumeControl.Stop(&volumeControl)
 	powerLEDControl.Stop(&powerLEDControl)
+	flushAsyncFileOps()
 	asyncFileOps.Stop(&asyncFileOps)
 	asyncFileOpsDf0.Stop(&asyncFileOpsDf0)
 	asyncFileOpsDf1.Stop(&asyncFileOpsDf1)
@@ -643,6 +1203,12 @@ func main() {
 	utils.SysUtilsInstance.CheckForExecutables(
 		shared.AMIGA_DISK_DEVICES_NEEDED_EXECUTABLES)
 
+	config.Load()
+
+	// this will save config file
+	// with default values if not exists
+	config.Save()
+
 	exeDir := utils.GoUtilsInstance.MustCwdToExeOrScript()
 	logFilename := utils.GoUtilsInstance.MustDuplicateLog(exeDir)
 
@@ -656,9 +1222,17 @@ func main() {
 	log.Printf("Executable directory %v\n", exeDir)
 	log.Printf("Log filename %v\n", logFilename)
 	log.Println("File system directory " + shared.FILE_SYSTEM_MOUNT)
+	log.Println("Status file " + path.Join(shared.FILE_SYSTEM_MOUNT, shared.FILE_SYSTEM_STATUS_FILENAME))
 	log.Println("Cached ADFs directory " + cachedAdfsDir)
+	log.Println("Cached ADFs index " + cachedAdfsIndexPathname)
+	log.Println("Floppy write journal directory " + floppyJournalDir)
+	log.Println("Config file " + shared.AMIGA_DISK_DEVICES_CONFIG_INI_PATHNAME)
+	log.Printf("Floppy bad sectors rescue %v\n", config.AmigaDiskDevices.FloppyRescueBadSectors)
+
+	logCachedAdfsStats()
 
 	fileSystem.SetMountDir(shared.FILE_SYSTEM_MOUNT)
+	fileSystem.SetStatusCallback(statusCallback)
 
 	discoverDriveDevices()
 	printFloppyDevices()
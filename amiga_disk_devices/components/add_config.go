@@ -0,0 +1,68 @@
+package components
+
+import (
+	"os"
+
+	"github.com/skazanyNaGlany/go.amipi400/shared/components/utils"
+	"github.com/subpop/go-ini"
+)
+
+type ADDConfig struct {
+	pathname string `ini:"-"`
+
+	AmigaDiskDevices struct {
+		// cache the ADF with unreadable sectors filled
+		// with zeros, off by default since the cached
+		// ADF is not the same as the medium
+		FloppyRescueBadSectors bool `ini:"floppy_rescue_bad_sectors"`
+	} `ini:"amiga_disk_devices"`
+}
+
+func NewADDConfig(pathname string) *ADDConfig {
+	ac := ADDConfig{}
+	ac.pathname = pathname
+
+	return &ac
+}
+
+func (ac *ADDConfig) Load() error {
+	data, _, err := utils.FileUtilsInstance.FileReadBytes(
+		ac.pathname,
+		0,
+		-1,
+		0,
+		0,
+		nil)
+
+	if err != nil {
+		return err
+	}
+
+	if err := ini.Unmarshal(data, ac); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (ac *ADDConfig) Save() error {
+	data, err := ini.Marshal(ac)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = utils.FileUtilsInstance.FileWriteBytes(
+		ac.pathname,
+		0,
+		data,
+		os.O_CREATE|os.O_WRONLY|os.O_TRUNC,
+		0777,
+		nil)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
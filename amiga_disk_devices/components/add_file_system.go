@@ -2,10 +2,14 @@ package components
 
 import (
 	"log"
+	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/skazanyNaGlany/go.amipi400/amiga_disk_devices/interfaces"
+	"github.com/skazanyNaGlany/go.amipi400/shared"
 	shared_components "github.com/skazanyNaGlany/go.amipi400/shared/components"
+	"github.com/skazanyNaGlany/go.amipi400/shared/components/amigafs"
 	"github.com/winfsp/cgofuse/fuse"
 	"golang.org/x/exp/slices"
 )
@@ -14,8 +18,16 @@ type ADDFileSystem struct {
 	shared_components.RunnerBase
 	fuse.FileSystemBase
 
-	mountDir string
-	mediums  []interfaces.Medium
+	mountDir        string
+	mediums         []interfaces.Medium
+	statusCallback  interfaces.StatusCallback
+	statusSnapshots map[uint64][]byte
+	lastStatusSize  int64
+	volumeFiles     map[uint64]*amigafs.File
+	lastFh          uint64
+	openFilesMutex  sync.Mutex
+	volumes         map[interfaces.Medium]mediumVolume
+	volumesMutex    sync.Mutex
 }
 
 func (addfs *ADDFileSystem) start() {
@@ -40,6 +52,80 @@ func (addfs *ADDFileSystem) SetMountDir(mountDir string) {
 	addfs.mountDir = mountDir
 }
 
+// SetStatusCallback enables read-only status file
+// (shared.FILE_SYSTEM_STATUS_FILENAME) in the root
+// directory, callback returns its content
+func (addfs *ADDFileSystem) SetStatusCallback(statusCallback interfaces.StatusCallback) {
+	addfs.statusCallback = statusCallback
+}
+
+func (addfs *ADDFileSystem) isStatusPathname(path string) bool {
+	return addfs.statusCallback != nil && path == "/"+shared.FILE_SYSTEM_STATUS_FILENAME
+}
+
+// allocateFh returns new file handle, must be called
+// with openFilesMutex locked
+func (addfs *ADDFileSystem) allocateFh() uint64 {
+	addfs.lastFh++
+
+	return addfs.lastFh
+}
+
+// openStatus takes snapshot of the status file, it is
+// served by Read until the file is released, so the
+// content does not change while being read
+func (addfs *ADDFileSystem) openStatus() uint64 {
+	status := addfs.statusCallback()
+
+	addfs.openFilesMutex.Lock()
+	defer addfs.openFilesMutex.Unlock()
+
+	if addfs.statusSnapshots == nil {
+		addfs.statusSnapshots = make(map[uint64][]byte)
+	}
+
+	fh := addfs.allocateFh()
+
+	addfs.statusSnapshots[fh] = status
+	addfs.lastStatusSize = int64(len(status))
+
+	return fh
+}
+
+func (addfs *ADDFileSystem) getStatusSnapshot(fh uint64) ([]byte, bool) {
+	addfs.openFilesMutex.Lock()
+	defer addfs.openFilesMutex.Unlock()
+
+	status, exists := addfs.statusSnapshots[fh]
+
+	return status, exists
+}
+
+// getStatusSize returns size of the snapshot of the open
+// status file, or size of the last snapshot (the
+// file system uses direct_io, so it can change)
+func (addfs *ADDFileSystem) getStatusSize(fh uint64) int64 {
+	addfs.openFilesMutex.Lock()
+	defer addfs.openFilesMutex.Unlock()
+
+	if status, exists := addfs.statusSnapshots[fh]; exists {
+		return int64(len(status))
+	}
+
+	return addfs.lastStatusSize
+}
+
+func (addfs *ADDFileSystem) releaseStatus(fh uint64) {
+	addfs.openFilesMutex.Lock()
+	defer addfs.openFilesMutex.Unlock()
+
+	delete(addfs.statusSnapshots, fh)
+}
+
+func (addfs *ADDFileSystem) GetMediums() []interfaces.Medium {
+	return slices.Clone(addfs.mediums)
+}
+
 func (addfs *ADDFileSystem) AddMedium(medium interfaces.Medium) {
 	addfs.mediums = append(addfs.mediums, medium)
 }
@@ -50,6 +136,7 @@ func (addfs *ADDFileSystem) RemoveMediumByDevicePathname(
 	for i, medium := range addfs.mediums {
 		if medium.GetDevicePathname() == devicePathname {
 			addfs.mediums = slices.Delete(addfs.mediums, i, i+1)
+			addfs.forgetMediumVolume(medium)
 
 			if err := medium.Close(); err != nil {
 				return medium, err
@@ -62,8 +149,22 @@ func (addfs *ADDFileSystem) RemoveMediumByDevicePathname(
 	return nil, nil
 }
 
+func (addfs *ADDFileSystem) FindMediumByDevicePathname(
+	devicePathname string,
+) interfaces.Medium {
+	for _, medium := range addfs.mediums {
+		if medium.GetDevicePathname() == devicePathname {
+			return medium
+		}
+	}
+
+	return nil
+}
+
 // Find the medium by public file-system pathname
 // like /__dev__sda.adf , /__dev__sdb.adf etc.
+// (the files inside AmigaDOS mediums are exposed
+// read-only as /__dev__sda/... etc.)
 func (addfs *ADDFileSystem) FindMediumByPublicFSPathname(
 	publicFSPathname string,
 ) interfaces.Medium {
@@ -85,18 +186,86 @@ func (addfs *ADDFileSystem) FindMediumByPublicFSPathname(
 // Readdir
 // Read
 // Write
+// Release
 
 func (addfs *ADDFileSystem) Open(path string, flags int) (errc int, fh uint64) {
+	if addfs.isStatusPathname(path) {
+		if flags&(os.O_WRONLY|os.O_RDWR) != 0 {
+			return -fuse.EACCES, ^uint64(0)
+		}
+
+		return 0, addfs.openStatus()
+	}
+
 	if medium := addfs.FindMediumByPublicFSPathname(path); medium != nil {
 		return medium.Open(path, flags)
 	}
 
+	if medium, volumePathname := addfs.findMediumByVolumePathname(path); medium != nil {
+		return addfs.volumeOpen(medium, volumePathname, flags)
+	}
+
 	return -fuse.ENOENT, ^uint64(0)
 }
 
-// Block device cannot be truncated, so just return here
+// Block device cannot be truncated, the driver decides
+// what truncating of the medium means
 func (addfs *ADDFileSystem) Truncate(path string, size int64, fh uint64) int {
-	return 0
+	if addfs.isStatusPathname(path) {
+		return -fuse.EACCES
+	}
+
+	if medium := addfs.FindMediumByPublicFSPathname(path); medium != nil {
+		result, err := medium.Truncate(path, size, fh)
+
+		if err != nil {
+			if addfs.IsDebugMode() {
+				log.Printf("%v: %v\n", path, err)
+			}
+
+			if result < 0 {
+				return result
+			}
+
+			return -fuse.EIO
+		}
+
+		return result
+	}
+
+	if medium, _ := addfs.findMediumByVolumePathname(path); medium != nil {
+		return -fuse.EROFS
+	}
+
+	return -fuse.ENOENT
+}
+
+func (addfs *ADDFileSystem) Fsync(path string, datasync bool, fh uint64) int {
+	if addfs.isStatusPathname(path) {
+		return 0
+	}
+
+	if medium := addfs.FindMediumByPublicFSPathname(path); medium != nil {
+		result, err := medium.Fsync(path, datasync, fh)
+
+		if err != nil {
+			log.Printf("%v: %v\n", path, err)
+
+			if result < 0 {
+				return result
+			}
+
+			return -fuse.EIO
+		}
+
+		return result
+	}
+
+	if medium, _ := addfs.findMediumByVolumePathname(path); medium != nil {
+		return 0
+	}
+
+	return -fuse.ENOENT
 }
 
 func (addfs *ADDFileSystem) Getattr(
@@ -109,6 +278,13 @@ func (addfs *ADDFileSystem) Getattr(
 		return 0
 	}
 
+	if addfs.isStatusPathname(path) {
+		stat.Mode = fuse.S_IFREG | 0444
+		stat.Size = addfs.getStatusSize(fh)
+
+		return 0
+	}
+
 	if medium := addfs.FindMediumByPublicFSPathname(path); medium != nil {
 		result, err := medium.Getattr(path, stat, fh)
 
@@ -129,6 +305,10 @@ func (addfs *ADDFileSystem) Getattr(
 		return 0
 	}
 
+	if medium, volumePathname := addfs.findMediumByVolumePathname(path); medium != nil {
+		return addfs.volumeGetattr(medium, volumePathname, stat)
+	}
+
 	return -fuse.ENOENT
 }
 
@@ -136,17 +316,31 @@ func (addfs *ADDFileSystem) Readdir(path string,
 	fill func(name string, stat *fuse.Stat_t, ofst int64) bool,
 	ofst int64,
 	fh uint64) (errc int) {
+	if path != "/" {
+		if medium, volumePathname := addfs.findMediumByVolumePathname(path); medium != nil {
+			return addfs.volumeReaddir(medium, volumePathname, fill)
+		}
+	}
+
 	fill(".", nil, 0)
 	fill("..", nil, 0)
 
 	fullMountPath := filepath.Join(addfs.mountDir, path)
 
+	if path == "/" && addfs.statusCallback != nil {
+		fill(shared.FILE_SYSTEM_STATUS_FILENAME, nil, 0)
+	}
+
 	for _, medium := range addfs.mediums {
 		publicPathname := medium.GetPublicPathname()
 		dirName := filepath.Dir(publicPathname)
 
 		if dirName == fullMountPath {
 			fill(medium.GetPublicName(), nil, 0)
+
+			if addfs.hasVolumeDir(medium) {
+				fill(addfs.getVolumeDirName(medium), nil, 0)
+			}
 		}
 	}
 
@@ -159,6 +353,20 @@ func (addfs *ADDFileSystem) Read(
 	ofst int64,
 	fh uint64,
 ) (n int) {
+	if addfs.isStatusPathname(path) {
+		status, exists := addfs.getStatusSnapshot(fh)
+
+		if !exists {
+			return -fuse.EBADF
+		}
+
+		if ofst >= int64(len(status)) {
+			return 0
+		}
+
+		return copy(buff, status[ofst:])
+	}
+
 	if medium := addfs.FindMediumByPublicFSPathname(path); medium != nil {
 		n, err := medium.Read(path, buff, ofst, fh)
 
@@ -179,10 +387,18 @@ func (addfs *ADDFileSystem) Read(
 		return n
 	}
 
+	if medium, volumePathname := addfs.findMediumByVolumePathname(path); medium != nil {
+		return addfs.volumeRead(medium, volumePathname, buff, ofst, fh)
+	}
+
 	return -fuse.ENOENT
 }
 
 func (addfs *ADDFileSystem) Write(path string, buff []byte, ofst int64, fh uint64) int {
+	if addfs.isStatusPathname(path) {
+		return -fuse.EACCES
+	}
+
 	if medium := addfs.FindMediumByPublicFSPathname(path); medium != nil {
 		n, err := medium.Write(path, buff, ofst, fh)
 
@@ -207,9 +423,27 @@ func (addfs *ADDFileSystem) Write(path string, buff []byte, ofst int64, fh uint6
 		return n
 	}
 
+	if medium, _ := addfs.findMediumByVolumePathname(path); medium != nil {
+		return -fuse.EROFS
+	}
+
 	return -fuse.ENOSYS
 }
 
+func (addfs *ADDFileSystem) Release(path string, fh uint64) int {
+	if addfs.isStatusPathname(path) {
+		addfs.releaseStatus(fh)
+
+		return 0
+	}
+
+	// the medium can be removed already, file
+	// handles are unique, so it is released
+	addfs.releaseVolumeFile(fh)
+
+	return 0
+}
+
 func (addfs *ADDFileSystem) Run() {
 	addfs.start()
 }
@@ -0,0 +1,372 @@
+package components
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/skazanyNaGlany/go.amipi400/amiga_disk_devices/interfaces"
+	"github.com/skazanyNaGlany/go.amipi400/shared"
+	"github.com/skazanyNaGlany/go.amipi400/shared/components/amigafs"
+	"github.com/winfsp/cgofuse/fuse"
+)
+
+// mediumReaderAt reads the medium through the driver (so the
+// cached ADFs are used), without the callbacks and without
+// updating the access time, reading the files is not
+// taken as the emulator activity
+type mediumReaderAt struct {
+	medium interfaces.Medium
+}
+
+func (mra *mediumReaderAt) ReadAt(buff []byte, ofst int64) (int, error) {
+	n := 0
+
+	for n < len(buff) {
+		result, err := mra.medium.GetDriver().ReadUntracked(mra.medium, buff[n:], ofst+int64(n))
+
+		if err != nil {
+			return n, err
+		}
+
+		if result <= 0 {
+			return n, io.ErrUnexpectedEOF
+		}
+
+		n += result
+	}
+
+	return n, nil
+}
+
+// mediumVolume is the Amiga file system of the medium, volume
+// is nil when the medium is not AmigaDOS disk (eg. NDOS disk
+// or HDF with RDB), it is opened again when the medium
+// was modified
+type mediumVolume struct {
+	volume           *amigafs.Volume
+	modificationTime int64
+}
+
+// getVolumeDirName returns name of the directory with the
+// files of the medium, eg. __dev__sda for __dev__sda.adf
+func (addfs *ADDFileSystem) getVolumeDirName(medium interfaces.Medium) string {
+	publicName := medium.GetPublicName()
+
+	return strings.TrimSuffix(publicName, filepath.Ext(publicName))
+}
+
+// hasVolumeDir returns true when the directory with the files
+// is exposed for the medium, the medium is not read, so it
+// is listed for every ADF and HDF (ISO is not AmigaDOS)
+func (addfs *ADDFileSystem) hasVolumeDir(medium interfaces.Medium) bool {
+	return !strings.EqualFold(filepath.Ext(medium.GetPublicName()), shared.CD_ISO_FULL_EXTENSION)
+}
+
+// getMediumVolume opens the volume on the first access, the
+// mutex is not held while the medium is read, so other
+// mediums are not blocked
+func (addfs *ADDFileSystem) getMediumVolume(medium interfaces.Medium) *amigafs.Volume {
+	modificationTime := medium.GetModificationTime()
+
+	addfs.volumesMutex.Lock()
+	cached, exists := addfs.volumes[medium]
+	addfs.volumesMutex.Unlock()
+
+	if exists && cached.modificationTime == modificationTime {
+		return cached.volume
+	}
+
+	volume, err := amigafs.Open(&mediumReaderAt{medium: medium}, medium.GetSize())
+
+	if err != nil {
+		if addfs.IsDebugMode() {
+			log.Printf("%v: %v\n", medium.GetDevicePathname(), err)
+		}
+
+		volume = nil
+	}
+
+	addfs.volumesMutex.Lock()
+	defer addfs.volumesMutex.Unlock()
+
+	// removed while being read
+	if !addfs.hasMedium(medium) {
+		return volume
+	}
+
+	if addfs.volumes == nil {
+		addfs.volumes = make(map[interfaces.Medium]mediumVolume)
+	}
+
+	addfs.volumes[medium] = mediumVolume{volume: volume, modificationTime: modificationTime}
+
+	return volume
+}
+
+func (addfs *ADDFileSystem) hasMedium(medium interfaces.Medium) bool {
+	for _, current := range addfs.mediums {
+		if current == medium {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (addfs *ADDFileSystem) forgetMediumVolume(medium interfaces.Medium) {
+	addfs.volumesMutex.Lock()
+	defer addfs.volumesMutex.Unlock()
+
+	delete(addfs.volumes, medium)
+}
+
+// findMediumByVolumePathname finds the medium by public file-system
+// pathname like /__dev__sda/S/Startup-Sequence, the pathname
+// inside the volume (S/Startup-Sequence) is returned too,
+// the medium is not read
+func (addfs *ADDFileSystem) findMediumByVolumePathname(
+	publicFSPathname string,
+) (interfaces.Medium, string) {
+	dirName, volumePathname, _ := strings.Cut(strings.TrimPrefix(publicFSPathname, "/"), "/")
+
+	if dirName == "" {
+		return nil, ""
+	}
+
+	for _, medium := range addfs.mediums {
+		if addfs.hasVolumeDir(medium) && addfs.getVolumeDirName(medium) == dirName {
+			return medium, strings.Trim(volumePathname, "/")
+		}
+	}
+
+	return nil, ""
+}
+
+// openVolumeFile stores the open file, it is
+// used by Read until the file is released
+func (addfs *ADDFileSystem) openVolumeFile(file *amigafs.File) uint64 {
+	addfs.openFilesMutex.Lock()
+	defer addfs.openFilesMutex.Unlock()
+
+	if addfs.volumeFiles == nil {
+		addfs.volumeFiles = make(map[uint64]*amigafs.File)
+	}
+
+	fh := addfs.allocateFh()
+
+	addfs.volumeFiles[fh] = file
+
+	return fh
+}
+
+func (addfs *ADDFileSystem) getVolumeFile(fh uint64) *amigafs.File {
+	addfs.openFilesMutex.Lock()
+	defer addfs.openFilesMutex.Unlock()
+
+	return addfs.volumeFiles[fh]
+}
+
+func (addfs *ADDFileSystem) releaseVolumeFile(fh uint64) {
+	addfs.openFilesMutex.Lock()
+	defer addfs.openFilesMutex.Unlock()
+
+	delete(addfs.volumeFiles, fh)
+}
+
+func (addfs *ADDFileSystem) volumeErrorToErrc(err error) int {
+	switch {
+	case errors.Is(err, amigafs.ErrNotFound):
+		return -fuse.ENOENT
+	case errors.Is(err, amigafs.ErrNotDirectory):
+		return -fuse.ENOTDIR
+	case errors.Is(err, amigafs.ErrNotFile):
+		return -fuse.EISDIR
+	}
+
+	return -fuse.EIO
+}
+
+// lookupVolumeEntry returns the entry, soft links are
+// not exposed, they point to AmigaDOS paths
+func (addfs *ADDFileSystem) lookupVolumeEntry(
+	medium interfaces.Medium,
+	volumePathname string,
+) (*amigafs.Volume, *amigafs.Entry, int) {
+	volume := addfs.getMediumVolume(medium)
+
+	if volume == nil {
+		return nil, nil, -fuse.ENOENT
+	}
+
+	entry, err := volume.Lookup(volumePathname)
+
+	if err != nil {
+		return nil, nil, addfs.volumeErrorToErrc(err)
+	}
+
+	if entry.IsSoftLink() {
+		return nil, nil, -fuse.ENOENT
+	}
+
+	return volume, entry, 0
+}
+
+func (addfs *ADDFileSystem) volumeOpen(
+	medium interfaces.Medium,
+	volumePathname string,
+	flags int,
+) (errc int, fh uint64) {
+	if flags&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return -fuse.EROFS, ^uint64(0)
+	}
+
+	volume, entry, errc := addfs.lookupVolumeEntry(medium, volumePathname)
+
+	if errc < 0 {
+		return errc, ^uint64(0)
+	}
+
+	if entry.IsDir() {
+		return 0, ^uint64(0)
+	}
+
+	file, err := volume.OpenFile(entry)
+
+	if err != nil {
+		if addfs.IsDebugMode() {
+			log.Printf("%v: %v\n", volumePathname, err)
+		}
+
+		return addfs.volumeErrorToErrc(err), ^uint64(0)
+	}
+
+	return 0, addfs.openVolumeFile(file)
+}
+
+func (addfs *ADDFileSystem) volumeGetattr(
+	medium interfaces.Medium,
+	volumePathname string,
+	stat *fuse.Stat_t,
+) int {
+	if volumePathname == "" {
+		// the medium is not read until
+		// the directory is listed
+		modified := fuse.Timespec{Sec: medium.GetModificationTime()}
+
+		stat.Mode = fuse.S_IFDIR | 0555
+		stat.Mtim = modified
+		stat.Ctim = modified
+		stat.Atim = modified
+
+		return 0
+	}
+
+	_, entry, errc := addfs.lookupVolumeEntry(medium, volumePathname)
+
+	if errc < 0 {
+		return errc
+	}
+
+	modified := fuse.NewTimespec(entry.Modified())
+
+	stat.Mtim = modified
+	stat.Ctim = modified
+	stat.Atim = modified
+
+	if entry.IsDir() {
+		stat.Mode = fuse.S_IFDIR | 0555
+
+		return 0
+	}
+
+	stat.Mode = fuse.S_IFREG | 0444
+	stat.Size = int64(entry.Size())
+
+	return 0
+}
+
+func (addfs *ADDFileSystem) volumeReaddir(
+	medium interfaces.Medium,
+	volumePathname string,
+	fill func(name string, stat *fuse.Stat_t, ofst int64) bool,
+) int {
+	if volumePathname == "" && addfs.getMediumVolume(medium) == nil {
+		// not AmigaDOS disk
+		fill(".", nil, 0)
+		fill("..", nil, 0)
+
+		return 0
+	}
+
+	volume, dir, errc := addfs.lookupVolumeEntry(medium, volumePathname)
+
+	if errc < 0 {
+		return errc
+	}
+
+	entries, err := volume.ReadDir(dir)
+
+	if err != nil {
+		if addfs.IsDebugMode() {
+			log.Printf("%v: %v\n", volumePathname, err)
+		}
+
+		return addfs.volumeErrorToErrc(err)
+	}
+
+	fill(".", nil, 0)
+	fill("..", nil, 0)
+
+	for _, entry := range entries {
+		if entry.IsSoftLink() {
+			continue
+		}
+
+		if !fill(entry.Name(), nil, 0) {
+			break
+		}
+	}
+
+	return 0
+}
+
+func (addfs *ADDFileSystem) volumeRead(
+	medium interfaces.Medium,
+	volumePathname string,
+	buff []byte,
+	ofst int64,
+	fh uint64,
+) int {
+	file := addfs.getVolumeFile(fh)
+
+	if file == nil {
+		// not opened by Open
+		errc, openFh := addfs.volumeOpen(medium, volumePathname, os.O_RDONLY)
+
+		if errc < 0 {
+			return errc
+		}
+
+		defer addfs.releaseVolumeFile(openFh)
+
+		if file = addfs.getVolumeFile(openFh); file == nil {
+			return -fuse.EISDIR
+		}
+	}
+
+	n, err := file.ReadAt(buff, ofst)
+
+	if err != nil && err != io.EOF {
+		if addfs.IsDebugMode() {
+			log.Printf("%v: %v\n", volumePathname, err)
+		}
+
+		return addfs.volumeErrorToErrc(err)
+	}
+
+	return n
+}
@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"errors"
+	"os"
+
+	"github.com/skazanyNaGlany/go.amipi400/shared"
+	"github.com/skazanyNaGlany/go.amipi400/shared/components/utils"
+)
+
+// ADFFingerprint returns SHA512 of the sample of ADF sectors
+// (shared.FLOPPY_FINGERPRINT_SECTORS), it identifies the medium
+// without writing anything to it, readSector returns data
+// of the sector at given offset
+func ADFFingerprint(readSector func(offset int64) ([]byte, error)) (string, error) {
+	sample := make([]byte, 0, len(shared.FLOPPY_FINGERPRINT_SECTORS)*shared.FLOPPY_DEVICE_SECTOR_SIZE)
+
+	for _, sector := range shared.FLOPPY_FINGERPRINT_SECTORS {
+		data, err := readSector(sector * shared.FLOPPY_DEVICE_SECTOR_SIZE)
+
+		if err != nil {
+			return "", err
+		}
+
+		if len(data) < shared.FLOPPY_DEVICE_SECTOR_SIZE {
+			return "", errors.New("cannot read sector for the fingerprint")
+		}
+
+		sample = append(sample, data[:shared.FLOPPY_DEVICE_SECTOR_SIZE]...)
+	}
+
+	return utils.CryptoUtilsInstance.BytesToSha512Hex(sample), nil
+}
+
+// ADFFingerprintFromData returns fingerprint of ADF already read
+// into the memory
+func ADFFingerprintFromData(data []byte) (string, error) {
+	return ADFFingerprint(func(offset int64) ([]byte, error) {
+		if offset+shared.FLOPPY_DEVICE_SECTOR_SIZE > int64(len(data)) {
+			return nil, errors.New("ADF data is too short")
+		}
+
+		return data[offset : offset+shared.FLOPPY_DEVICE_SECTOR_SIZE], nil
+	})
+}
+
+// ADFFingerprintFromFile returns fingerprint of ADF file
+// or the device, the file is opened read-only
+func ADFFingerprintFromFile(pathname string) (string, error) {
+	handle, err := os.OpenFile(pathname, os.O_RDONLY, 0)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer handle.Close()
+
+	return ADFFingerprint(func(offset int64) ([]byte, error) {
+		data, n, err := utils.FileUtilsInstance.FileReadBytes(
+			"",
+			offset,
+			shared.FLOPPY_DEVICE_SECTOR_SIZE,
+			0,
+			0,
+			handle)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return data[:n], nil
+	})
+}
@@ -0,0 +1,372 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skazanyNaGlany/go.amipi400/shared/components/utils"
+)
+
+// CachedADFIndexEntry describes single cached ADF, Sha512 is
+// SHA512 of the content at the time of caching (empty when
+// the cached ADF was modified or is not known), Fingerprint
+// is used to recognise write-protected mediums
+type CachedADFIndexEntry struct {
+	UUID           string `json:"uuid"`
+	Sha512         string `json:"sha512"`
+	Fingerprint    string `json:"fingerprint"`
+	Size           int64  `json:"size"`
+	CreateTime     int64  `json:"create_time"`
+	LastAccessTime int64  `json:"last_access_time"`
+	Hits           uint64 `json:"hits"`
+	Pinned         bool   `json:"pinned"`
+	VolumeName     string `json:"volume_name"`
+}
+
+type CachedADFIndexStats struct {
+	Entries      int    `json:"entries"`
+	Pinned       int    `json:"pinned"`
+	Deduplicated int    `json:"deduplicated"`
+	Size         int64  `json:"size"`
+	Hits         uint64 `json:"hits"`
+}
+
+// CachedADFIndex keeps all the cached ADFs by their UUID,
+// it is stored as JSON file and saved after every change
+type CachedADFIndex struct {
+	pathname string
+	entries  map[string]CachedADFIndexEntry
+	mutex    sync.Mutex
+}
+
+func (cai *CachedADFIndex) Load(pathname string) error {
+	cai.mutex.Lock()
+	defer cai.mutex.Unlock()
+
+	cai.pathname = pathname
+	cai.entries = make(map[string]CachedADFIndexEntry)
+
+	data, n, err := utils.FileUtilsInstance.FileReadBytes(pathname, 0, -1, 0, 0, nil)
+
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// no index yet
+			return nil
+		}
+
+		return err
+	}
+
+	if n == 0 {
+		return nil
+	}
+
+	entries := make(map[string]CachedADFIndexEntry)
+
+	if err = json.Unmarshal(data[:n], &entries); err != nil {
+		return err
+	}
+
+	// the first version of the index was
+	// keyed by the fingerprint
+	for _, entry := range entries {
+		if entry.UUID != "" {
+			cai.entries[entry.UUID] = entry
+		}
+	}
+
+	return nil
+}
+
+func (cai *CachedADFIndex) save() error {
+	if cai.pathname == "" {
+		return errors.New("cached ADF index is not loaded")
+	}
+
+	data, err := json.MarshalIndent(cai.entries, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	// write to the temporary file first, so the index
+	// is not truncated on power loss
+	tmpPathname := cai.pathname + ".tmp"
+
+	n, err := utils.FileUtilsInstance.FileWriteBytes(
+		tmpPathname,
+		0,
+		data,
+		os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_SYNC,
+		0777,
+		nil)
+
+	if err != nil {
+		return err
+	}
+
+	if n < len(data) {
+		return errors.New("cannot write cached ADF index")
+	}
+
+	return os.Rename(tmpPathname, cai.pathname)
+}
+
+// Sync adds cached ADFs which are not in the index yet (cached
+// before the index existed) and removes entries of the
+// cached ADFs which do not exist anymore
+func (cai *CachedADFIndex) Sync(dir, extension string) error {
+	cai.mutex.Lock()
+	defer cai.mutex.Unlock()
+
+	changed := false
+	uuids := make(map[string]bool)
+
+	for _, pathname := range utils.FileUtilsInstance.GetDirFiles(dir, false, extension) {
+		uuidStr := strings.TrimSuffix(filepath.Base(pathname), extension)
+		uuids[uuidStr] = true
+
+		if _, exists := cai.entries[uuidStr]; exists {
+			continue
+		}
+
+		stat, err := os.Stat(pathname)
+
+		if err != nil {
+			continue
+		}
+
+		cai.entries[uuidStr] = CachedADFIndexEntry{
+			UUID:           uuidStr,
+			Size:           stat.Size(),
+			CreateTime:     stat.ModTime().Unix(),
+			LastAccessTime: stat.ModTime().Unix()}
+
+		changed = true
+	}
+
+	for uuidStr := range cai.entries {
+		if !uuids[uuidStr] {
+			delete(cai.entries, uuidStr)
+
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return cai.save()
+}
+
+func (cai *CachedADFIndex) Get(uuidStr string) (CachedADFIndexEntry, bool) {
+	cai.mutex.Lock()
+	defer cai.mutex.Unlock()
+
+	entry, exists := cai.entries[uuidStr]
+
+	return entry, exists
+}
+
+func (cai *CachedADFIndex) FindByFingerprint(fingerprint string) (CachedADFIndexEntry, bool) {
+	cai.mutex.Lock()
+	defer cai.mutex.Unlock()
+
+	if fingerprint == "" {
+		return CachedADFIndexEntry{}, false
+	}
+
+	for _, entry := range cai.entries {
+		if entry.Fingerprint == fingerprint {
+			return entry, true
+		}
+	}
+
+	return CachedADFIndexEntry{}, false
+}
+
+// FindBySha512 returns cached ADF with the same content,
+// other than excludeUUID
+func (cai *CachedADFIndex) FindBySha512(sha512 string, excludeUUID string) (CachedADFIndexEntry, bool) {
+	cai.mutex.Lock()
+	defer cai.mutex.Unlock()
+
+	if sha512 == "" {
+		return CachedADFIndexEntry{}, false
+	}
+
+	for _, entry := range cai.entries {
+		if entry.Sha512 == sha512 && entry.UUID != excludeUUID {
+			return entry, true
+		}
+	}
+
+	return CachedADFIndexEntry{}, false
+}
+
+func (cai *CachedADFIndex) Put(entry CachedADFIndexEntry) error {
+	cai.mutex.Lock()
+	defer cai.mutex.Unlock()
+
+	if cai.entries == nil {
+		cai.entries = make(map[string]CachedADFIndexEntry)
+	}
+
+	cai.entries[entry.UUID] = entry
+
+	return cai.save()
+}
+
+// Touch marks the cached ADF as used
+func (cai *CachedADFIndex) Touch(uuidStr string) error {
+	cai.mutex.Lock()
+	defer cai.mutex.Unlock()
+
+	entry, exists := cai.entries[uuidStr]
+
+	if !exists {
+		return nil
+	}
+
+	entry.Hits++
+	entry.LastAccessTime = time.Now().Unix()
+
+	cai.entries[uuidStr] = entry
+
+	return cai.save()
+}
+
+func (cai *CachedADFIndex) SetPinned(uuidStr string, pinned bool) error {
+	cai.mutex.Lock()
+	defer cai.mutex.Unlock()
+
+	entry, exists := cai.entries[uuidStr]
+
+	if !exists {
+		return errors.New("cached ADF " + uuidStr + " is not in the index")
+	}
+
+	entry.Pinned = pinned
+
+	cai.entries[uuidStr] = entry
+
+	return cai.save()
+}
+
+// SetVolumeName stores the volume name of the cached ADF,
+// so it is known without reading the root block
+func (cai *CachedADFIndex) SetVolumeName(uuidStr string, volumeName string) error {
+	cai.mutex.Lock()
+	defer cai.mutex.Unlock()
+
+	entry, exists := cai.entries[uuidStr]
+
+	if !exists {
+		return errors.New("cached ADF " + uuidStr + " is not in the index")
+	}
+
+	if entry.VolumeName == volumeName {
+		return nil
+	}
+
+	entry.VolumeName = volumeName
+
+	cai.entries[uuidStr] = entry
+
+	return cai.save()
+}
+
+// MarkModified clears SHA512 and the fingerprint of the cached
+// ADF, so it is not used for deduplication anymore and
+// write-protected mediums are not recognised by it
+func (cai *CachedADFIndex) MarkModified(uuidStr string) error {
+	cai.mutex.Lock()
+	defer cai.mutex.Unlock()
+
+	entry, exists := cai.entries[uuidStr]
+
+	if !exists || (entry.Sha512 == "" && entry.Fingerprint == "") {
+		return nil
+	}
+
+	entry.Sha512 = ""
+	entry.Fingerprint = ""
+
+	cai.entries[uuidStr] = entry
+
+	return cai.save()
+}
+
+func (cai *CachedADFIndex) Remove(uuidStr string) error {
+	cai.mutex.Lock()
+	defer cai.mutex.Unlock()
+
+	if _, exists := cai.entries[uuidStr]; !exists {
+		return nil
+	}
+
+	delete(cai.entries, uuidStr)
+
+	return cai.save()
+}
+
+// GetLeastRecentlyUsed returns not pinned cached ADF with the
+// oldest LastAccessTime, for which exclude returns false
+func (cai *CachedADFIndex) GetLeastRecentlyUsed(
+	exclude func(entry CachedADFIndexEntry) bool,
+) (CachedADFIndexEntry, bool) {
+	cai.mutex.Lock()
+	defer cai.mutex.Unlock()
+
+	var lru CachedADFIndexEntry
+	found := false
+
+	for _, entry := range cai.entries {
+		if entry.Pinned || (exclude != nil && exclude(entry)) {
+			continue
+		}
+
+		if !found || entry.LastAccessTime < lru.LastAccessTime {
+			lru = entry
+			found = true
+		}
+	}
+
+	return lru, found
+}
+
+func (cai *CachedADFIndex) GetStats() CachedADFIndexStats {
+	cai.mutex.Lock()
+	defer cai.mutex.Unlock()
+
+	stats := CachedADFIndexStats{}
+	sha512s := make(map[string]int)
+
+	for _, entry := range cai.entries {
+		stats.Entries++
+		stats.Size += entry.Size
+		stats.Hits += entry.Hits
+
+		if entry.Pinned {
+			stats.Pinned++
+		}
+
+		if entry.Sha512 != "" {
+			sha512s[entry.Sha512]++
+		}
+	}
+
+	for _, count := range sha512s {
+		if count > 1 {
+			stats.Deduplicated += count - 1
+		}
+	}
+
+	return stats
+}
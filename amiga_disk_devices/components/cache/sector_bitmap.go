@@ -0,0 +1,238 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"os"
+	"sync"
+
+	"github.com/skazanyNaGlany/go.amipi400/shared/components/utils"
+)
+
+// SectorBitmap keeps track of the sectors already stored
+// in the partially cached ADF, one bit per sector,
+// it is saved next to the partially cached ADF
+type SectorBitmap struct {
+	pathname   string
+	sectorSize int64
+	sectors    int64
+	bitmap     []byte
+	mutex      sync.Mutex
+}
+
+func NewSectorBitmap(pathname string, size, sectorSize int64) *SectorBitmap {
+	sectors := size / sectorSize
+
+	sb := SectorBitmap{
+		pathname:   pathname,
+		sectorSize: sectorSize,
+		sectors:    sectors,
+		bitmap:     make([]byte, (sectors+7)/8)}
+
+	return &sb
+}
+
+func (sb *SectorBitmap) GetPathname() string {
+	return sb.pathname
+}
+
+// Load reads the bitmap from the file, missing
+// file means that nothing is cached yet
+func (sb *SectorBitmap) Load() error {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+
+	data, n, err := utils.FileUtilsInstance.FileReadBytes(sb.pathname, 0, -1, 0, 0, nil)
+
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	if n != len(sb.bitmap) {
+		return fmt.Errorf("invalid sector bitmap %v, size %v, expected %v", sb.pathname, n, len(sb.bitmap))
+	}
+
+	copy(sb.bitmap, data)
+
+	return nil
+}
+
+func (sb *SectorBitmap) Save() error {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+
+	tmpPathname := sb.pathname + ".tmp"
+
+	n, err := utils.FileUtilsInstance.FileWriteBytes(
+		tmpPathname,
+		0,
+		sb.bitmap,
+		os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_SYNC,
+		0777,
+		nil)
+
+	if err != nil {
+		return err
+	}
+
+	if n < len(sb.bitmap) {
+		return errors.New("cannot write sector bitmap")
+	}
+
+	return os.Rename(tmpPathname, sb.pathname)
+}
+
+func (sb *SectorBitmap) Remove() error {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+
+	sb.clear()
+
+	if err := os.Remove(sb.pathname); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (sb *SectorBitmap) Clear() {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+
+	sb.clear()
+}
+
+func (sb *SectorBitmap) clear() {
+	for i := range sb.bitmap {
+		sb.bitmap[i] = 0
+	}
+}
+
+func (sb *SectorBitmap) isSet(sector int64) bool {
+	return sb.bitmap[sector/8]&(1<<(sector%8)) != 0
+}
+
+func (sb *SectorBitmap) IsSet(sector int64) bool {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+
+	if sector < 0 || sector >= sb.sectors {
+		return false
+	}
+
+	return sb.isSet(sector)
+}
+
+func (sb *SectorBitmap) Set(sector int64) {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+
+	if sector >= 0 && sector < sb.sectors {
+		sb.bitmap[sector/8] |= 1 << (sector % 8)
+	}
+}
+
+func (sb *SectorBitmap) Unset(sector int64) {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+
+	if sector >= 0 && sector < sb.sectors {
+		sb.bitmap[sector/8] &^= 1 << (sector % 8)
+	}
+}
+
+// GetSetSectors returns all the marked sectors, in order
+func (sb *SectorBitmap) GetSetSectors() []int64 {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+
+	sectors := make([]int64, 0)
+
+	for sector := int64(0); sector < sb.sectors; sector++ {
+		if sb.isSet(sector) {
+			sectors = append(sectors, sector)
+		}
+	}
+
+	return sectors
+}
+
+// SetRange marks sectors fully covered by the data
+// at given offset, returns number of newly marked sectors
+func (sb *SectorBitmap) SetRange(offset, size int64) int64 {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+
+	first := (offset + sb.sectorSize - 1) / sb.sectorSize
+	last := (offset + size) / sb.sectorSize
+	marked := int64(0)
+
+	if first < 0 {
+		first = 0
+	}
+
+	if last > sb.sectors {
+		last = sb.sectors
+	}
+
+	for sector := first; sector < last; sector++ {
+		if !sb.isSet(sector) {
+			sb.bitmap[sector/8] |= 1 << (sector % 8)
+			marked++
+		}
+	}
+
+	return marked
+}
+
+// IsRangeSet returns true when all the sectors
+// touched by the range are cached
+func (sb *SectorBitmap) IsRangeSet(offset, size int64) bool {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+
+	if size <= 0 {
+		return false
+	}
+
+	first := offset / sb.sectorSize
+	last := (offset + size - 1) / sb.sectorSize
+
+	if first < 0 || last >= sb.sectors {
+		return false
+	}
+
+	for sector := first; sector <= last; sector++ {
+		if !sb.isSet(sector) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (sb *SectorBitmap) Count() int64 {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+
+	count := 0
+
+	for _, b := range sb.bitmap {
+		count += bits.OnesCount8(b)
+	}
+
+	return int64(count)
+}
+
+func (sb *SectorBitmap) GetSectors() int64 {
+	return sb.sectors
+}
+
+func (sb *SectorBitmap) IsComplete() bool {
+	return sb.Count() == sb.sectors
+}
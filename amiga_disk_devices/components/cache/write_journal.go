@@ -0,0 +1,218 @@
+package cache
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/skazanyNaGlany/go.amipi400/shared"
+	"golang.org/x/exp/slices"
+)
+
+// WriteJournal keeps sectors written to the cached ADF which are
+// not written to the medium yet, per floppy UUID, it is saved
+// before the write is queued, so pending writes survive removing
+// the medium (or power loss) and can be replayed
+type WriteJournal struct {
+	directory string
+	bitmaps   map[string]*SectorBitmap
+	// number of queued writes of every sector
+	queued map[string]map[int64]int
+	mutex  sync.Mutex
+}
+
+func (wj *WriteJournal) SetDirectory(directory string) {
+	wj.directory = directory
+}
+
+func (wj *WriteJournal) getBitmap(uuidStr string) (*SectorBitmap, error) {
+	if wj.bitmaps == nil {
+		wj.bitmaps = make(map[string]*SectorBitmap)
+		wj.queued = make(map[string]map[int64]int)
+	}
+
+	if bitmap, exists := wj.bitmaps[uuidStr]; exists {
+		return bitmap, nil
+	}
+
+	bitmap := NewSectorBitmap(
+		wj.getPathname(uuidStr),
+		shared.FLOPPY_ADF_SIZE,
+		shared.FLOPPY_DEVICE_SECTOR_SIZE)
+
+	if err := bitmap.Load(); err != nil {
+		return nil, err
+	}
+
+	wj.bitmaps[uuidStr] = bitmap
+	wj.queued[uuidStr] = make(map[int64]int)
+
+	return bitmap, nil
+}
+
+func (wj *WriteJournal) getPathname(uuidStr string) string {
+	return filepath.Join(wj.directory, uuidStr+"."+shared.FLOPPY_JOURNAL_EXTENSION)
+}
+
+// touchedSectors returns sectors touched by the range,
+// limited to the ADF
+func (wj *WriteJournal) touchedSectors(offset, size int64) []int64 {
+	sectors := make([]int64, 0)
+
+	if size <= 0 {
+		return sectors
+	}
+
+	first := offset / shared.FLOPPY_DEVICE_SECTOR_SIZE
+	last := (offset + size - 1) / shared.FLOPPY_DEVICE_SECTOR_SIZE
+
+	for sector := first; sector <= last; sector++ {
+		if sector >= 0 && sector*shared.FLOPPY_DEVICE_SECTOR_SIZE < shared.FLOPPY_ADF_SIZE {
+			sectors = append(sectors, sector)
+		}
+	}
+
+	return sectors
+}
+
+// MarkPending journals the write, must be called
+// before the write is queued
+func (wj *WriteJournal) MarkPending(uuidStr string, offset, size int64) error {
+	wj.mutex.Lock()
+	defer wj.mutex.Unlock()
+
+	sectors := wj.touchedSectors(offset, size)
+
+	if len(sectors) == 0 {
+		return nil
+	}
+
+	bitmap, err := wj.getBitmap(uuidStr)
+
+	if err != nil {
+		return err
+	}
+
+	for _, sector := range sectors {
+		bitmap.Set(sector)
+
+		wj.queued[uuidStr][sector]++
+	}
+
+	return bitmap.Save()
+}
+
+// Release is called when the queued write is finished, sectors
+// are removed from the journal when written and there are
+// no other queued writes for them
+func (wj *WriteJournal) Release(uuidStr string, offset, size int64, written bool) error {
+	wj.mutex.Lock()
+	defer wj.mutex.Unlock()
+
+	sectors := wj.touchedSectors(offset, size)
+
+	if len(sectors) == 0 {
+		return nil
+	}
+
+	bitmap, err := wj.getBitmap(uuidStr)
+
+	if err != nil {
+		return err
+	}
+
+	queued := wj.queued[uuidStr]
+	changed := false
+
+	for _, sector := range sectors {
+		if queued[sector] > 0 {
+			queued[sector]--
+		}
+
+		if written && queued[sector] == 0 && bitmap.IsSet(sector) {
+			bitmap.Unset(sector)
+
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	if bitmap.Count() == 0 {
+		return bitmap.Remove()
+	}
+
+	return bitmap.Save()
+}
+
+// ResetQueued forgets queued writes, used when the medium is
+// inserted again, writes queued for the removed medium
+// were not (and will not be) written
+func (wj *WriteJournal) ResetQueued(uuidStr string) {
+	wj.mutex.Lock()
+	defer wj.mutex.Unlock()
+
+	if _, err := wj.getBitmap(uuidStr); err != nil {
+		return
+	}
+
+	wj.queued[uuidStr] = make(map[int64]int)
+}
+
+// GetPendingSectors returns sectors not written to the medium
+func (wj *WriteJournal) GetPendingSectors(uuidStr string) ([]int64, error) {
+	wj.mutex.Lock()
+	defer wj.mutex.Unlock()
+
+	bitmap, err := wj.getBitmap(uuidStr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return bitmap.GetSetSectors(), nil
+}
+
+// GetQueuedSectors returns sectors with writes queued
+// but not finished yet
+func (wj *WriteJournal) GetQueuedSectors(uuidStr string) []int64 {
+	wj.mutex.Lock()
+	defer wj.mutex.Unlock()
+
+	sectors := make([]int64, 0)
+
+	for sector, count := range wj.queued[uuidStr] {
+		if count > 0 {
+			sectors = append(sectors, sector)
+		}
+	}
+
+	slices.Sort(sectors)
+
+	return sectors
+}
+
+func (wj *WriteJournal) HasPending(uuidStr string) bool {
+	sectors, err := wj.GetPendingSectors(uuidStr)
+
+	return err == nil && len(sectors) > 0
+}
+
+// Discard removes the journal, used when
+// the cached ADF is removed
+func (wj *WriteJournal) Discard(uuidStr string) error {
+	wj.mutex.Lock()
+	defer wj.mutex.Unlock()
+
+	delete(wj.bitmaps, uuidStr)
+	delete(wj.queued, uuidStr)
+
+	if err := os.Remove(wj.getPathname(uuidStr)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
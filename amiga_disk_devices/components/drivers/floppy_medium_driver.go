@@ -1,6 +1,7 @@
 package drivers
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"log"
@@ -8,16 +9,20 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 	"unsafe"
 
 	"github.com/google/uuid"
 	"github.com/ncw/directio"
+	"github.com/skazanyNaGlany/go.amipi400/amiga_disk_devices/components/cache"
 	"github.com/skazanyNaGlany/go.amipi400/amiga_disk_devices/components/drivers/headers"
 	"github.com/skazanyNaGlany/go.amipi400/amiga_disk_devices/components/medium"
 	"github.com/skazanyNaGlany/go.amipi400/amiga_disk_devices/interfaces"
 	"github.com/skazanyNaGlany/go.amipi400/shared"
+	"github.com/skazanyNaGlany/go.amipi400/shared/components/amigafs"
 	"github.com/skazanyNaGlany/go.amipi400/shared/components/utils"
+	"github.com/thoas/go-funk"
 	"github.com/winfsp/cgofuse/fuse"
 )
 
@@ -26,7 +31,12 @@ type FloppyMediumDriver struct {
 
 	cachedAdfsDirectory            string
 	outsideAsyncFileWriterCallback interfaces.OutsideAsyncFileWriterCallback
+	outsideAsyncFileFlushCallback  interfaces.OutsideAsyncFileFlushCallback
 	preCacheADFCallback            interfaces.PreCacheADFCallback
+	cachedAdfIndex                 *cache.CachedADFIndex
+	pinCachedAdf                   bool
+	rescueBadSectors               bool
+	writeJournal                   *cache.WriteJournal
 }
 
 func (fmd *FloppyMediumDriver) Probe(
@@ -84,12 +94,58 @@ func (fmd *FloppyMediumDriver) Probe(
 	// does not exists)
 	fmd.decodeCachedADFHeader(&_medium)
 
+	if _medium.GetCachedAdfPathname() != "" {
+		// make sure the medium was not
+		// modified somewhere else
+		if err := fmd.reconcileCachedAdf(&_medium); err != nil {
+			if fmd.debugMode {
+				log.Println(err)
+			}
+		}
+	}
+
+	if _medium.GetCachedAdfPathname() == "" {
+		// not cached (or write-protected medium which
+		// has no CachedADFHeader), identify it by its
+		// fingerprint
+		if err := fmd.fingerprintMedium(&_medium, readOnly); err != nil {
+			if fmd.debugMode {
+				log.Println(err)
+			}
+		}
+	}
+
+	if err := fmd.openBadSectorMap(&_medium); err != nil {
+		if fmd.debugMode {
+			log.Println(err)
+		}
+	}
+
+	if fmd.pinCachedAdf {
+		fmd.setPinned(&_medium, true)
+	}
+
 	if formatted || force {
+		// formatting may fix the sectors
+		if err := _medium.GetBadSectorMap().Remove(); err != nil {
+			if fmd.debugMode {
+				log.Println(err)
+			}
+		}
+
 		if _medium.GetCachedAdfPathname() != "" {
 			// ADF is cached but the medium was
 			// formatted, remove cached ADF file
 			os.Remove(_medium.GetCachedAdfPathname())
 
+			if fmd.cachedAdfIndex != nil {
+				fmd.cachedAdfIndex.Remove(_medium.GetFloppyUUID())
+			}
+
+			if fmd.writeJournal != nil {
+				fmd.writeJournal.Discard(_medium.GetFloppyUUID())
+			}
+
 			_medium.SetCachedAdfPathname("")
 
 			if formatted {
@@ -108,30 +164,100 @@ func (fmd *FloppyMediumDriver) Probe(
 				)
 			}
 		}
+
+		if _medium.GetSectorBitmap() != nil {
+			// the same for partially cached ADF
+			fmd.removePartialAdf(&_medium)
+		}
+	}
+
+	// NDOS disks (eg. most of the games)
+	// keep the device-derived name
+	if volumeName, err := fmd.readVolumeName(&_medium); err != nil {
+		if fmd.debugMode {
+			log.Println(path, err)
+		}
+	} else {
+		filename = _medium.DevicePathnameAndVolumeNameToPublicFilename(
+			path,
+			volumeName,
+			shared.FLOPPY_ADF_EXTENSION)
+
+		_medium.SetPublicPathname(
+			filepath.Join(basePath, filename),
+		)
+
+		if fmd.verboseMode {
+			log.Printf("Medium in %v has volume name %v\n", path, volumeName)
+		}
 	}
 
 	return &_medium, nil
 }
 
-func (fmd *FloppyMediumDriver) floppyCacheAdf(_medium *medium.FloppyMedium) error {
-	var sha512Id string
-	var uuidStr string
-	var err error
-	var n int
+// readVolumeName returns the volume name of the disk in the
+// medium, for known disks it is kept in the cached ADF index,
+// otherwise it is read from the root block of the cached
+// ADF or the medium
+func (fmd *FloppyMediumDriver) readVolumeName(_medium *medium.FloppyMedium) (string, error) {
+	uuidStr := _medium.GetFloppyUUID()
+	cachedAdfPathname := _medium.GetCachedAdfPathname()
+	indexed := cachedAdfPathname != "" && fmd.cachedAdfIndex != nil
+
+	if indexed {
+		entry, exists := fmd.cachedAdfIndex.Get(uuidStr)
+
+		// SHA512 is cleared when the cached ADF was
+		// modified, the volume may be renamed then
+		if exists && entry.Sha512 != "" && entry.VolumeName != "" {
+			return entry.VolumeName, nil
+		}
+	}
+
+	pathname := cachedAdfPathname
+
+	if pathname == "" {
+		pathname = _medium.GetDevicePathname()
+	}
+
+	handle, err := os.OpenFile(pathname, os.O_RDONLY, 0)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer handle.Close()
+
+	volume, err := amigafs.Open(handle, shared.FLOPPY_ADF_SIZE)
+
+	if err != nil {
+		return "", err
+	}
+
+	if indexed {
+		if err := fmd.cachedAdfIndex.SetVolumeName(uuidStr, volume.Name()); err != nil {
+			if fmd.debugMode {
+				log.Println(err)
+			}
+		}
+	}
+
+	return volume.Name(), nil
+}
 
+func (fmd *FloppyMediumDriver) floppyCacheAdf(_medium *medium.FloppyMedium) error {
 	handle, err := fmd.OpenMediumHandle(_medium, shared.FLOPPY_READ_AHEAD)
 
 	if err != nil {
 		return err
 	}
 
-	data, len_data, err := utils.FileUtilsInstance.FileReadBytes(
-		"",
+	data, len_data, err := fmd.readWithRetries(
+		_medium,
+		handle,
 		0,
 		shared.FLOPPY_ADF_SIZE,
-		0,
-		0,
-		handle)
+		fmd.rescueBadSectors)
 
 	if err != nil {
 		return err
@@ -145,6 +271,17 @@ func (fmd *FloppyMediumDriver) floppyCacheAdf(_medium *medium.FloppyMedium) erro
 		return errors.New("cannot read medium data")
 	}
 
+	return fmd.storeCachedAdf(_medium, data)
+}
+
+// storeCachedAdf creates cached ADF from the whole medium data
+// and makes the medium cached
+func (fmd *FloppyMediumDriver) storeCachedAdf(_medium *medium.FloppyMedium, data []byte) error {
+	var sha512Id string
+	var uuidStr string
+	var err error
+	var n int
+
 	sha512Id = _medium.GetCachedAdfSha512()
 
 	if sha512Id == "" {
@@ -170,54 +307,207 @@ func (fmd *FloppyMediumDriver) floppyCacheAdf(_medium *medium.FloppyMedium) erro
 		return err
 	}
 
-	n, err = utils.FileUtilsInstance.FileWriteBytes(
-		cachedAdfPathname,
-		0,
-		data,
-		os.O_CREATE|os.O_WRONLY,
-		0777,
-		nil)
+	// sha512Id comes from CachedADFHeader and may be
+	// outdated, deduplicate by the real content
+	contentSha512 := utils.CryptoUtilsInstance.BytesToSha512Hex(data)
 
-	if err != nil {
-		return err
+	if !fmd.linkDuplicatedAdf(contentSha512, uuidStr, cachedAdfPathname) {
+		n, err = utils.FileUtilsInstance.FileWriteBytes(
+			cachedAdfPathname,
+			0,
+			data,
+			os.O_CREATE|os.O_WRONLY,
+			0777,
+			nil)
+
+		if err != nil {
+			return err
+		}
+
+		if n < len(data) {
+			return errors.New("cannot create cached ADF file")
+		}
 	}
 
-	if n < len(data) {
-		return errors.New("cannot create cached ADF file")
+	stat, err := os.Stat(cachedAdfPathname)
+
+	if err != nil {
+		return err
 	}
 
-	stat, _ := os.Stat(cachedAdfPathname)
+	if _medium.IsWritable() {
+		err = fmd.updateCachedADFHeader(
+			_medium.GetDevicePathname(),
+			sha512Id,
+			uuidStr,
+			stat.ModTime().Unix())
 
-	err = fmd.updateCachedADFHeader(
-		_medium.GetDevicePathname(),
-		sha512Id,
-		uuidStr,
-		stat.ModTime().Unix())
+		if err != nil {
+			return err
+		}
+	}
 
-	if err != nil {
+	// write-protected medium cannot hold CachedADFHeader
+	// it is recognised by the fingerprint from the index
+	if err = fmd.putCachedAdfIndexEntry(_medium, data, contentSha512, stat.Size()); err != nil {
 		return err
 	}
 
 	_medium.SetCachedAdfPathname(cachedAdfPathname)
 
+	// bad-sector map is kept with the cached ADF
+	if err = fmd.moveBadSectorMap(_medium, uuidStr); err != nil {
+		if fmd.debugMode {
+			log.Println(err)
+		}
+	}
+
+	// not needed anymore
+	fmd.removePartialAdf(_medium)
+
 	// close the handle, it will be opened
 	// again for cached ADF
-	if err = handle.Close(); err != nil {
-		return err
-	}
+	handle, _ := _medium.GetHandle()
+
+	if handle != nil {
+		if err = handle.Close(); err != nil {
+			return err
+		}
 
-	_medium.SetHandle(nil)
+		_medium.SetHandle(nil)
+	}
 
 	if fmd.verboseMode {
 		log.Printf("ADF in medium %v have been cached\n", _medium.GetDevicePathname())
 		log.Printf("\tCached ADF: %v\n", cachedAdfPathname)
 		log.Printf("\tSHA512 ID:  %v\n", sha512Id)
 		log.Printf("\tUUID:  %v\n", uuidStr)
+
+		if badSectors := _medium.GetBadSectorMap().GetSetSectors(); len(badSectors) > 0 {
+			log.Printf("\tBad sectors (filled with zeros): %v\n", badSectors)
+		}
 	}
 
 	return nil
 }
 
+// linkDuplicatedAdf creates cached ADF as a hard link to already
+// cached ADF with the same content, the link is broken
+// on the first write (see unshareCachedAdf)
+func (fmd *FloppyMediumDriver) linkDuplicatedAdf(contentSha512, uuidStr, cachedAdfPathname string) bool {
+	if fmd.cachedAdfIndex == nil {
+		return false
+	}
+
+	entry, exists := fmd.cachedAdfIndex.FindBySha512(contentSha512, uuidStr)
+
+	if !exists {
+		return false
+	}
+
+	duplicatedAdfPathname := path.Join(
+		fmd.cachedAdfsDirectory,
+		fmd.buildCachedAdfFilename(entry.UUID, shared.FLOPPY_ADF_EXTENSION))
+
+	os.Remove(cachedAdfPathname)
+
+	if err := os.Link(duplicatedAdfPathname, cachedAdfPathname); err != nil {
+		if fmd.debugMode {
+			log.Println(err)
+		}
+
+		return false
+	}
+
+	if fmd.verboseMode {
+		log.Printf("Cached ADF %v is the same as %v, linked\n", cachedAdfPathname, duplicatedAdfPathname)
+	}
+
+	return true
+}
+
+// unshareCachedAdf makes a private copy of the cached ADF
+// linked by linkDuplicatedAdf, before it is modified
+func (fmd *FloppyMediumDriver) unshareCachedAdf(floppyMedium *medium.FloppyMedium) error {
+	cachedAdfPathname := floppyMedium.GetCachedAdfPathname()
+	stat := syscall.Stat_t{}
+
+	if err := syscall.Stat(cachedAdfPathname, &stat); err != nil {
+		return err
+	}
+
+	if stat.Nlink <= 1 {
+		return nil
+	}
+
+	data, n, err := utils.FileUtilsInstance.FileReadBytes(cachedAdfPathname, 0, -1, 0, 0, nil)
+
+	if err != nil {
+		return err
+	}
+
+	tmpPathname := cachedAdfPathname + ".tmp"
+
+	written, err := utils.FileUtilsInstance.FileWriteBytes(
+		tmpPathname,
+		0,
+		data[:n],
+		os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_SYNC,
+		0777,
+		nil)
+
+	if err != nil {
+		return err
+	}
+
+	if written < n {
+		return errors.New("cannot copy cached ADF file")
+	}
+
+	if err = os.Rename(tmpPathname, cachedAdfPathname); err != nil {
+		return err
+	}
+
+	// handles point to the shared file,
+	// they will be opened again
+	return fmd.CloseMedium(floppyMedium)
+}
+
+// setPinned pins (or unpins) cached ADF of the medium, so it
+// is never evicted, not cached medium is pinned when cached,
+// probing the medium again does not change the state
+func (fmd *FloppyMediumDriver) setPinned(_medium *medium.FloppyMedium, pinned bool) {
+	if fmd.cachedAdfIndex == nil {
+		return
+	}
+
+	entry, exists := fmd.cachedAdfIndex.Get(_medium.GetFloppyUUID())
+
+	if !exists || _medium.GetCachedAdfPathname() == "" {
+		_medium.SetPinned(pinned)
+
+		log.Printf("Cached ADF of medium in %v will be pinned: %v\n", _medium.GetDevicePathname(), pinned)
+
+		return
+	}
+
+	if entry.Pinned != pinned {
+		if err := fmd.cachedAdfIndex.SetPinned(entry.UUID, pinned); err != nil {
+			log.Println(err)
+
+			return
+		}
+	}
+
+	_medium.SetPinned(pinned)
+
+	log.Printf(
+		"Cached ADF %v of medium in %v pinned: %v\n",
+		entry.UUID,
+		_medium.GetDevicePathname(),
+		pinned)
+}
+
 func (fmd *FloppyMediumDriver) updateCachedADFHeader(
 	pathname, sha512Id string,
 	uuidStr string,
@@ -309,6 +599,8 @@ func (fmd *FloppyMediumDriver) decodeCachedADFHeader(_medium *medium.FloppyMediu
 	// it seems that ADF is properly cached
 	_medium.SetCachedAdfPathname(cachedAdfPathname)
 
+	fmd.touchCachedAdf(_medium)
+
 	if fmd.verboseMode {
 		log.Printf("ADF in medium %v is cached\n", _medium.GetDevicePathname())
 		log.Printf("\tCached ADF: %v\n", cachedAdfPathname)
@@ -319,94 +611,791 @@ func (fmd *FloppyMediumDriver) decodeCachedADFHeader(_medium *medium.FloppyMediu
 	return nil
 }
 
-func (fmd *FloppyMediumDriver) buildCachedAdfFilename(uuidStr, extension string) string {
-	return uuidStr + "." + extension
-}
+// fingerprintMedium identifies the medium by a sample of its sectors
+// (device is opened read-only, nothing is written to the medium),
+// write-protected medium is looked up in the cached ADF index,
+// then partially cached ADF is opened if the medium is still
+// not cached
+func (fmd *FloppyMediumDriver) fingerprintMedium(_medium *medium.FloppyMedium, readOnly bool) error {
+	fingerprint, err := cache.ADFFingerprintFromFile(_medium.GetDevicePathname())
 
-func (mdb *FloppyMediumDriver) SetCachedAdfsDirectory(cachedAdfsDirectory string) {
-	mdb.cachedAdfsDirectory = cachedAdfsDirectory
-}
+	if err != nil {
+		return err
+	}
 
-func (fmd *FloppyMediumDriver) OpenMediumHandle(
-	_medium interfaces.Medium,
-	readAhead ...int,
-) (*os.File, error) {
-	floppyMedium, castOk := _medium.(*medium.FloppyMedium)
+	_medium.SetFingerprint(fingerprint)
 
-	if !castOk {
-		return nil, errors.New("cannot cast Medium to FloppyMedium")
+	if readOnly {
+		if err = fmd.findCachedAdfByFingerprint(_medium); err != nil {
+			return err
+		}
+
+		if _medium.GetCachedAdfPathname() != "" {
+			return nil
+		}
 	}
 
-	handle, err := floppyMedium.GetHandle()
+	return fmd.openPartialAdf(_medium)
+}
 
-	if err != nil {
-		return nil, err
+func (fmd *FloppyMediumDriver) findCachedAdfByFingerprint(_medium *medium.FloppyMedium) error {
+	if fmd.cachedAdfIndex == nil {
+		return nil
 	}
 
-	if handle != nil {
-		// already open
-		return handle, nil
+	fingerprint := _medium.GetFingerprint()
+	entry, exists := fmd.cachedAdfIndex.FindByFingerprint(fingerprint)
+
+	if !exists {
+		return nil
 	}
 
-	isReadable := floppyMedium.IsReadable()
-	isWritable := floppyMedium.IsWritable()
+	cachedAdfPathname := path.Join(
+		fmd.cachedAdfsDirectory,
+		fmd.buildCachedAdfFilename(entry.UUID, shared.FLOPPY_ADF_EXTENSION))
 
-	flag := os.O_SYNC
+	stat, err := os.Stat(cachedAdfPathname)
 
-	if isReadable && isWritable {
-		flag |= os.O_RDWR
-	} else {
-		flag |= os.O_RDONLY
+	if err != nil || stat.IsDir() || stat.Size() < shared.FLOPPY_ADF_SIZE {
+		// cached ADF was removed (the entry is removed by
+		// Sync) or is not complete, it will be cached again
+		return nil
 	}
 
-	cachedAdfPathname := floppyMedium.GetCachedAdfPathname()
-	devicePathname := floppyMedium.GetDevicePathname()
+	cachedFingerprint, err := cache.ADFFingerprintFromFile(cachedAdfPathname)
 
-	if cachedAdfPathname == "" && devicePathname != "" {
-		// not cached yet, just open device handle
-		return fmd.openDeviceHandle(floppyMedium, flag, readAhead...)
-	} else if cachedAdfPathname != "" && devicePathname != "" {
-		// cached, open two handles, one for device, second for cached ADF
-		// set 0 bytes read-a-head for device handle to speed-up FileReadBytesDirect
-		// (to speed-up moving the motor)
-		return fmd.openDeviceAndADFHandles(floppyMedium, flag, readAhead...)
+	if err != nil {
+		return err
 	}
 
-	return nil, nil
-}
+	if cachedFingerprint != fingerprint {
+		// the entry can be used by other medium, so it is
+		// skipped, not removed, the floppy will be cached again
+		if fmd.debugMode {
+			log.Printf("Cached ADF %v does not match the fingerprint\n", cachedAdfPathname)
+		}
 
-func (fmd *FloppyMediumDriver) openDeviceHandle(
-	floppyMedium *medium.FloppyMedium,
-	flag int,
-	readAhead ...int,
-) (*os.File, error) {
-	devicePathname := floppyMedium.GetDevicePathname()
+		return nil
+	}
 
-	deviceHandle, err := os.OpenFile(
-		devicePathname,
-		flag,
-		0,
-	)
+	_medium.SetCachedAdfSha512(entry.Sha512)
+	_medium.SetFloppyUUID(entry.UUID)
+	_medium.SetCachedAdfPathname(cachedAdfPathname)
 
-	if err != nil {
-		return nil, err
+	fmd.touchCachedAdf(_medium)
+
+	if fmd.verboseMode {
+		log.Printf("ADF in write-protected medium %v is cached\n", _medium.GetDevicePathname())
+		log.Printf("\tCached ADF: %v\n", cachedAdfPathname)
+		log.Printf("\tSHA512 ID:  %v\n", entry.Sha512)
+		log.Printf("\tUUID:  %v\n", entry.UUID)
 	}
 
-	_readAhead := shared.DEFAULT_READ_AHEAD
+	return nil
+}
 
-	if len(readAhead) == 1 {
-		_readAhead = readAhead[0]
+func (fmd *FloppyMediumDriver) putCachedAdfIndexEntry(
+	_medium *medium.FloppyMedium,
+	data []byte,
+	contentSha512 string,
+	size int64,
+) error {
+	if fmd.cachedAdfIndex == nil {
+		return errors.New("cached ADF index is not set")
 	}
 
-	if err = utils.UnixUtilsInstance.SetDeviceReadAHead(deviceHandle, _readAhead); err != nil {
-		deviceHandle.Close()
+	fingerprint, err := cache.ADFFingerprintFromData(data)
 
-		return nil, err
+	if err != nil {
+		return err
 	}
 
-	floppyMedium.SetHandle(deviceHandle)
+	uuidStr := _medium.GetFloppyUUID()
+	now := time.Now().Unix()
+	pinned := _medium.IsPinned()
 
-	return deviceHandle, nil
+	if entry, exists := fmd.cachedAdfIndex.Get(uuidStr); exists {
+		// re-cached, keep it pinned
+		pinned = pinned || entry.Pinned
+	}
+
+	// NDOS disks have no volume name
+	volumeName, _ := amigafs.ReadVolumeName(data)
+
+	return fmd.cachedAdfIndex.Put(cache.CachedADFIndexEntry{
+		UUID:           uuidStr,
+		Sha512:         contentSha512,
+		Fingerprint:    fingerprint,
+		Size:           size,
+		CreateTime:     now,
+		LastAccessTime: now,
+		Pinned:         pinned,
+		VolumeName:     volumeName})
+}
+
+func (fmd *FloppyMediumDriver) touchCachedAdf(_medium *medium.FloppyMedium) {
+	if fmd.cachedAdfIndex == nil {
+		return
+	}
+
+	if err := fmd.cachedAdfIndex.Touch(_medium.GetFloppyUUID()); err != nil {
+		if fmd.debugMode {
+			log.Println(err)
+		}
+	}
+
+	if entry, exists := fmd.cachedAdfIndex.Get(_medium.GetFloppyUUID()); exists {
+		_medium.SetPinned(entry.Pinned)
+	}
+}
+
+// openPartialAdf sets partially cached ADF and its SectorBitmap
+// for the medium, both are named after the fingerprint, AmigaDOS
+// updates the root block on every write, so the fingerprint
+// changes when the medium was modified somewhere else
+func (fmd *FloppyMediumDriver) openPartialAdf(_medium *medium.FloppyMedium) error {
+	fingerprint := _medium.GetFingerprint()
+
+	if len(fingerprint) < shared.CACHED_ADF_HEADER_UUID_LENGTH {
+		return errors.New("medium has no fingerprint")
+	}
+
+	name := fingerprint[:shared.CACHED_ADF_HEADER_UUID_LENGTH]
+
+	partialAdfPathname := path.Join(
+		fmd.cachedAdfsDirectory,
+		fmd.buildCachedAdfFilename(name, shared.FLOPPY_PARTIAL_ADF_EXTENSION))
+	sectorBitmap := cache.NewSectorBitmap(
+		path.Join(
+			fmd.cachedAdfsDirectory,
+			fmd.buildCachedAdfFilename(name, shared.FLOPPY_SECTOR_BITMAP_EXTENSION)),
+		shared.FLOPPY_ADF_SIZE,
+		shared.FLOPPY_DEVICE_SECTOR_SIZE)
+
+	_medium.SetPartialAdfPathname(partialAdfPathname)
+	_medium.SetSectorBitmap(sectorBitmap)
+
+	if _, err := os.Stat(partialAdfPathname); err != nil {
+		// partially cached ADF was removed (or never
+		// created), the bitmap is not valid anymore
+		return sectorBitmap.Remove()
+	}
+
+	if err := sectorBitmap.Load(); err != nil {
+		sectorBitmap.Clear()
+
+		return err
+	}
+
+	if fmd.verboseMode {
+		log.Printf("ADF in medium %v is partially cached\n", _medium.GetDevicePathname())
+		log.Printf("\tPartially cached ADF: %v\n", partialAdfPathname)
+		log.Printf("\tSectors: %v/%v\n", sectorBitmap.Count(), sectorBitmap.GetSectors())
+	}
+
+	return nil
+}
+
+// openBadSectorMap sets bad-sector map for the medium, it is kept
+// with the cached ADF (named after the UUID), or with partially
+// cached ADF (named after the fingerprint), map of unknown
+// medium is not saved
+func (fmd *FloppyMediumDriver) openBadSectorMap(_medium *medium.FloppyMedium) error {
+	name := ""
+
+	if _medium.GetCachedAdfPathname() != "" {
+		name = _medium.GetFloppyUUID()
+	} else if len(_medium.GetFingerprint()) >= shared.CACHED_ADF_HEADER_UUID_LENGTH {
+		name = _medium.GetFingerprint()[:shared.CACHED_ADF_HEADER_UUID_LENGTH]
+	}
+
+	badSectorMap := cache.NewSectorBitmap(
+		fmd.buildBadSectorMapPathname(name),
+		shared.FLOPPY_ADF_SIZE,
+		shared.FLOPPY_DEVICE_SECTOR_SIZE)
+
+	_medium.SetBadSectorMap(badSectorMap)
+
+	if name == "" {
+		return nil
+	}
+
+	if err := badSectorMap.Load(); err != nil {
+		badSectorMap.Clear()
+
+		return err
+	}
+
+	if badSectors := badSectorMap.GetSetSectors(); len(badSectors) > 0 {
+		log.Printf(
+			"Medium in %v has %v known bad sectors: %v\n",
+			_medium.GetDevicePathname(),
+			len(badSectors),
+			badSectors,
+		)
+	}
+
+	return nil
+}
+
+func (fmd *FloppyMediumDriver) buildBadSectorMapPathname(name string) string {
+	if name == "" {
+		return ""
+	}
+
+	return path.Join(
+		fmd.cachedAdfsDirectory,
+		fmd.buildCachedAdfFilename(name, shared.FLOPPY_BAD_SECTORS_EXTENSION))
+}
+
+// moveBadSectorMap renames bad-sector map of the
+// medium which has been cached after its UUID
+func (fmd *FloppyMediumDriver) moveBadSectorMap(_medium *medium.FloppyMedium, uuidStr string) error {
+	oldMap := _medium.GetBadSectorMap()
+	pathname := fmd.buildBadSectorMapPathname(uuidStr)
+
+	if oldMap != nil && oldMap.GetPathname() == pathname {
+		return nil
+	}
+
+	newMap := cache.NewSectorBitmap(
+		pathname,
+		shared.FLOPPY_ADF_SIZE,
+		shared.FLOPPY_DEVICE_SECTOR_SIZE)
+
+	_medium.SetBadSectorMap(newMap)
+
+	if oldMap == nil {
+		return nil
+	}
+
+	badSectors := oldMap.GetSetSectors()
+
+	if oldMap.GetPathname() != "" {
+		if err := oldMap.Remove(); err != nil {
+			return err
+		}
+	}
+
+	if len(badSectors) == 0 {
+		return newMap.Remove()
+	}
+
+	for _, sector := range badSectors {
+		newMap.Set(sector)
+	}
+
+	return newMap.Save()
+}
+
+// markBadSectors records sectors which could not be read
+func (fmd *FloppyMediumDriver) markBadSectors(_medium *medium.FloppyMedium, sectors []int64) {
+	log.Printf(
+		"Cannot read %v sectors from medium in %v: %v\n",
+		len(sectors),
+		_medium.GetDevicePathname(),
+		sectors,
+	)
+
+	badSectorMap := _medium.GetBadSectorMap()
+
+	if badSectorMap == nil {
+		return
+	}
+
+	for _, sector := range sectors {
+		badSectorMap.Set(sector)
+	}
+
+	if badSectorMap.GetPathname() == "" {
+		return
+	}
+
+	if err := badSectorMap.Save(); err != nil {
+		if fmd.debugMode {
+			log.Println(err)
+		}
+	}
+}
+
+// readWithRetries reads the range from the medium, when it fails
+// the range is read again sector by sector, every sector up to
+// shared.FLOPPY_READ_RETRIES times, unreadable sectors are
+// recorded in the bad-sector map, they are filled with zeros
+// when fill is true, otherwise the error is returned
+func (fmd *FloppyMediumDriver) readWithRetries(
+	_medium *medium.FloppyMedium,
+	handle *os.File,
+	ofst, size int64,
+	fill bool,
+) ([]byte, int, error) {
+	data, n, err := utils.FileUtilsInstance.FileReadBytes("", ofst, size, 0, 0, handle)
+
+	if err == nil {
+		return data, n, nil
+	}
+
+	if fmd.debugMode {
+		log.Println(_medium.GetDevicePathname(), err)
+	}
+
+	data = make([]byte, size)
+	badSectors := make([]int64, 0)
+	var lastErr error
+
+	for sectorOfst := ofst; sectorOfst < ofst+size; sectorOfst += shared.FLOPPY_DEVICE_SECTOR_SIZE {
+		sectorSize := ofst + size - sectorOfst
+
+		if sectorSize > shared.FLOPPY_DEVICE_SECTOR_SIZE {
+			sectorSize = shared.FLOPPY_DEVICE_SECTOR_SIZE
+		}
+
+		sectorData, sectorN, err := fmd.readSectorWithRetries(_medium, handle, sectorOfst, sectorSize)
+
+		if err != nil {
+			badSectors = append(badSectors, sectorOfst/shared.FLOPPY_DEVICE_SECTOR_SIZE)
+			lastErr = err
+
+			continue
+		}
+
+		copy(data[sectorOfst-ofst:], sectorData[:sectorN])
+	}
+
+	if len(badSectors) == 0 {
+		return data, int(size), nil
+	}
+
+	fmd.markBadSectors(_medium, badSectors)
+
+	if !fill {
+		return nil, 0, lastErr
+	}
+
+	return data, int(size), nil
+}
+
+func (fmd *FloppyMediumDriver) readSectorWithRetries(
+	_medium *medium.FloppyMedium,
+	handle *os.File,
+	ofst, size int64,
+) ([]byte, int, error) {
+	var data []byte
+	var n int
+	var err error
+
+	for retry := 0; retry <= shared.FLOPPY_READ_RETRIES; retry++ {
+		if retry > 0 {
+			time.Sleep(time.Millisecond * shared.FLOPPY_READ_RETRY_DELAY_MS)
+
+			if fmd.debugMode {
+				log.Printf(
+					"Retrying read of sector %v from medium in %v (%v/%v)\n",
+					ofst/shared.FLOPPY_DEVICE_SECTOR_SIZE,
+					_medium.GetDevicePathname(),
+					retry,
+					shared.FLOPPY_READ_RETRIES,
+				)
+			}
+		}
+
+		data, n, err = utils.FileUtilsInstance.FileReadBytes("", ofst, size, 0, 0, handle)
+
+		if err == nil {
+			return data, n, nil
+		}
+	}
+
+	return nil, 0, err
+}
+
+func (fmd *FloppyMediumDriver) removePartialAdf(_medium *medium.FloppyMedium) {
+	sectorBitmap := _medium.GetSectorBitmap()
+
+	if sectorBitmap == nil {
+		return
+	}
+
+	if err := sectorBitmap.Remove(); err != nil {
+		if fmd.debugMode {
+			log.Println(err)
+		}
+	}
+
+	os.Remove(_medium.GetPartialAdfPathname())
+
+	_medium.SetSectorBitmap(nil)
+	_medium.SetPartialAdfPathname("")
+}
+
+// cacheSectors stores data read from (or written to) the medium
+// in the partially cached ADF, the medium becomes cached
+// when all the sectors are stored
+func (fmd *FloppyMediumDriver) cacheSectors(
+	floppyMedium *medium.FloppyMedium,
+	ofst int64,
+	data []byte,
+) error {
+	sectorBitmap := floppyMedium.GetSectorBitmap()
+
+	if sectorBitmap == nil ||
+		len(data) == 0 ||
+		floppyMedium.IsCachingDisabled() ||
+		floppyMedium.GetCachedAdfPathname() != "" {
+		return nil
+	}
+
+	n, err := utils.FileUtilsInstance.FileWriteBytes(
+		floppyMedium.GetPartialAdfPathname(),
+		ofst,
+		data,
+		os.O_CREATE|os.O_WRONLY|os.O_SYNC,
+		0777,
+		nil)
+
+	if err != nil {
+		return err
+	}
+
+	if sectorBitmap.SetRange(ofst, int64(n)) == 0 {
+		return nil
+	}
+
+	// data is stored first, the bitmap then, so it never
+	// points to the sectors which were not stored
+	if err = sectorBitmap.Save(); err != nil {
+		return err
+	}
+
+	if !sectorBitmap.IsComplete() {
+		return nil
+	}
+
+	return fmd.promotePartialAdf(floppyMedium)
+}
+
+// promotePartialAdf turns complete partially cached ADF
+// into cached ADF
+func (fmd *FloppyMediumDriver) promotePartialAdf(floppyMedium *medium.FloppyMedium) error {
+	data, n, err := utils.FileUtilsInstance.FileReadBytes(
+		floppyMedium.GetPartialAdfPathname(),
+		0,
+		shared.FLOPPY_ADF_SIZE,
+		0,
+		0,
+		nil)
+
+	if err != nil {
+		return err
+	}
+
+	if n < shared.FLOPPY_ADF_SIZE {
+		return errors.New("cannot read partially cached ADF")
+	}
+
+	return fmd.storeCachedAdf(floppyMedium, data)
+}
+
+// reconcileCachedAdf compares the sample of the sectors (the same
+// as for the fingerprint) of the medium and its cached ADF, sectors
+// pending in the write journal are skipped, when they differ and
+// there are no pending writes the medium was modified somewhere
+// else, cached ADF is outdated and it is removed, otherwise
+// the cached ADF wins and pending writes are replayed
+func (fmd *FloppyMediumDriver) reconcileCachedAdf(_medium *medium.FloppyMedium) error {
+	uuidStr := _medium.GetFloppyUUID()
+	pending := make([]int64, 0)
+
+	if fmd.writeJournal != nil {
+		var err error
+
+		if pending, err = fmd.writeJournal.GetPendingSectors(uuidStr); err != nil {
+			return err
+		}
+	}
+
+	deviceHandle, err := os.OpenFile(_medium.GetDevicePathname(), os.O_RDONLY, 0)
+
+	if err != nil {
+		return err
+	}
+
+	defer deviceHandle.Close()
+
+	cachedAdfHandle, err := os.OpenFile(_medium.GetCachedAdfPathname(), os.O_RDONLY, 0)
+
+	if err != nil {
+		return err
+	}
+
+	defer cachedAdfHandle.Close()
+
+	diverged := make([]int64, 0)
+
+	for _, sector := range shared.FLOPPY_FINGERPRINT_SECTORS {
+		if funk.ContainsInt64(pending, sector) {
+			continue
+		}
+
+		offset := sector * shared.FLOPPY_DEVICE_SECTOR_SIZE
+
+		deviceData, n0, err := utils.FileUtilsInstance.FileReadBytes(
+			"",
+			offset,
+			shared.FLOPPY_DEVICE_SECTOR_SIZE,
+			0,
+			0,
+			deviceHandle)
+
+		if err != nil {
+			return err
+		}
+
+		cachedData, n1, err := utils.FileUtilsInstance.FileReadBytes(
+			"",
+			offset,
+			shared.FLOPPY_DEVICE_SECTOR_SIZE,
+			0,
+			0,
+			cachedAdfHandle)
+
+		if err != nil {
+			return err
+		}
+
+		if !bytes.Equal(deviceData[:n0], cachedData[:n1]) {
+			diverged = append(diverged, sector)
+		}
+	}
+
+	_medium.SetDivergedSectors(diverged)
+
+	if len(diverged) == 0 {
+		return nil
+	}
+
+	log.Printf(
+		"Cached ADF %v differs from the medium in %v, sectors: %v\n",
+		_medium.GetCachedAdfPathname(),
+		_medium.GetDevicePathname(),
+		diverged,
+	)
+
+	if len(pending) > 0 {
+		log.Printf("Keeping cached ADF, %v sectors will be written to the medium\n", len(pending))
+
+		return nil
+	}
+
+	log.Println("Removing outdated cached ADF, medium will be cached again")
+
+	os.Remove(_medium.GetCachedAdfPathname())
+
+	if fmd.cachedAdfIndex != nil {
+		fmd.cachedAdfIndex.Remove(uuidStr)
+	}
+
+	_medium.SetCachedAdfPathname("")
+
+	return nil
+}
+
+// ReplayWriteJournal writes sectors from the cached ADF to the medium,
+// which were not written since the medium was removed too early,
+// returns the number of replayed sectors
+func (fmd *FloppyMediumDriver) ReplayWriteJournal(_medium interfaces.Medium) (int, error) {
+	mutex := _medium.GetMutex()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	floppyMedium, castOk := _medium.(*medium.FloppyMedium)
+
+	if !castOk {
+		return 0, errors.New("cannot cast Medium to FloppyMedium")
+	}
+
+	if fmd.writeJournal == nil ||
+		!floppyMedium.IsWritable() ||
+		floppyMedium.GetCachedAdfPathname() == "" {
+		return 0, nil
+	}
+
+	uuidStr := floppyMedium.GetFloppyUUID()
+
+	fmd.writeJournal.ResetQueued(uuidStr)
+
+	sectors, err := fmd.writeJournal.GetPendingSectors(uuidStr)
+
+	if err != nil {
+		return 0, err
+	}
+
+	// write continuous runs of the sectors at once
+	for i := 0; i < len(sectors); {
+		j := i + 1
+
+		for j < len(sectors) && sectors[j] == sectors[j-1]+1 {
+			j++
+		}
+
+		offset := sectors[i] * shared.FLOPPY_DEVICE_SECTOR_SIZE
+		size := int64(j-i) * shared.FLOPPY_DEVICE_SECTOR_SIZE
+
+		data, n, err := utils.FileUtilsInstance.FileReadBytes(
+			floppyMedium.GetCachedAdfPathname(),
+			offset,
+			size,
+			0,
+			0,
+			nil)
+
+		if err != nil {
+			return i, err
+		}
+
+		if err = fmd.writeJournal.MarkPending(uuidStr, offset, int64(n)); err != nil {
+			return i, err
+		}
+
+		fmd.outsideAsyncFileWriterCallback(
+			floppyMedium.GetDevicePathname(),
+			offset,
+			data[:n],
+			os.O_SYNC|os.O_RDWR,
+			0777,
+			nil,
+			false)
+
+		i = j
+	}
+
+	if len(sectors) > 0 {
+		stat, err := os.Stat(floppyMedium.GetCachedAdfPathname())
+
+		if err != nil {
+			return len(sectors), err
+		}
+
+		fmd.updateCachedADFHeader(
+			floppyMedium.GetDevicePathname(),
+			floppyMedium.GetCachedAdfSha512(),
+			uuidStr,
+			stat.ModTime().Unix())
+	}
+
+	return len(sectors), nil
+}
+
+func (fmd *FloppyMediumDriver) buildCachedAdfFilename(uuidStr, extension string) string {
+	return uuidStr + "." + extension
+}
+
+func (mdb *FloppyMediumDriver) SetCachedAdfsDirectory(cachedAdfsDirectory string) {
+	mdb.cachedAdfsDirectory = cachedAdfsDirectory
+}
+
+func (fmd *FloppyMediumDriver) SetCachedAdfIndex(cachedAdfIndex *cache.CachedADFIndex) {
+	fmd.cachedAdfIndex = cachedAdfIndex
+}
+
+func (fmd *FloppyMediumDriver) SetWriteJournal(writeJournal *cache.WriteJournal) {
+	fmd.writeJournal = writeJournal
+}
+
+// SetPinCachedAdf pins cached ADF
+// of the probed medium
+func (fmd *FloppyMediumDriver) SetPinCachedAdf(pinCachedAdf bool) {
+	fmd.pinCachedAdf = pinCachedAdf
+}
+
+// SetRescueBadSectors enables caching the whole ADF with
+// unreadable sectors filled with zeros, the sectors are
+// never filled while prefetching
+func (fmd *FloppyMediumDriver) SetRescueBadSectors(rescueBadSectors bool) {
+	fmd.rescueBadSectors = rescueBadSectors
+}
+
+func (fmd *FloppyMediumDriver) OpenMediumHandle(
+	_medium interfaces.Medium,
+	readAhead ...int,
+) (*os.File, error) {
+	floppyMedium, castOk := _medium.(*medium.FloppyMedium)
+
+	if !castOk {
+		return nil, errors.New("cannot cast Medium to FloppyMedium")
+	}
+
+	handle, err := floppyMedium.GetHandle()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if handle != nil {
+		// already open
+		return handle, nil
+	}
+
+	isReadable := floppyMedium.IsReadable()
+	isWritable := floppyMedium.IsWritable()
+
+	flag := os.O_SYNC
+
+	if isReadable && isWritable {
+		flag |= os.O_RDWR
+	} else {
+		flag |= os.O_RDONLY
+	}
+
+	cachedAdfPathname := floppyMedium.GetCachedAdfPathname()
+	devicePathname := floppyMedium.GetDevicePathname()
+
+	if cachedAdfPathname == "" && devicePathname != "" {
+		// not cached yet, just open device handle
+		return fmd.openDeviceHandle(floppyMedium, flag, readAhead...)
+	} else if cachedAdfPathname != "" && devicePathname != "" {
+		// cached, open two handles, one for device, second for cached ADF
+		// set 0 bytes read-a-head for device handle to speed-up FileReadBytesDirect
+		// (to speed-up moving the motor)
+		return fmd.openDeviceAndADFHandles(floppyMedium, flag, readAhead...)
+	}
+
+	return nil, nil
+}
+
+func (fmd *FloppyMediumDriver) openDeviceHandle(
+	floppyMedium *medium.FloppyMedium,
+	flag int,
+	readAhead ...int,
+) (*os.File, error) {
+	devicePathname := floppyMedium.GetDevicePathname()
+
+	deviceHandle, err := os.OpenFile(
+		devicePathname,
+		flag,
+		0,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_readAhead := shared.DEFAULT_READ_AHEAD
+
+	if len(readAhead) == 1 {
+		_readAhead = readAhead[0]
+	}
+
+	if err = utils.UnixUtilsInstance.SetDeviceReadAHead(deviceHandle, _readAhead); err != nil {
+		deviceHandle.Close()
+
+		return nil, err
+	}
+
+	floppyMedium.SetHandle(deviceHandle)
+
+	return deviceHandle, nil
 
 }
 
@@ -468,96 +1457,362 @@ func (fmd *FloppyMediumDriver) CloseMedium(_medium interfaces.Medium) error {
 		return nil
 	}
 
-	floppyMedium.SetHandle(nil)
-	floppyMedium.SetDeviceDirectIOHandle(nil)
+	floppyMedium.SetHandle(nil)
+	floppyMedium.SetDeviceDirectIOHandle(nil)
+
+	if err0 != nil {
+		return err0
+	}
+
+	if err1 != nil {
+		return err1
+	}
+
+	err0 = handle.Close()
+	err1 = directIOhandle.Close()
+
+	if err0 != nil {
+		return err0
+	}
+
+	if err1 != nil {
+		return err1
+	}
+
+	return nil
+}
+
+func (fmd *FloppyMediumDriver) SetOutsideAsyncFileWriterCallback(
+	callback interfaces.OutsideAsyncFileWriterCallback,
+) {
+	fmd.outsideAsyncFileWriterCallback = callback
+}
+
+func (fmd *FloppyMediumDriver) SetOutsideAsyncFileFlushCallback(
+	callback interfaces.OutsideAsyncFileFlushCallback,
+) {
+	fmd.outsideAsyncFileFlushCallback = callback
+}
+
+func (fmd *FloppyMediumDriver) SetPreCacheADFCallback(
+	callback interfaces.PreCacheADFCallback,
+) {
+	fmd.preCacheADFCallback = callback
+}
+
+// Read
+func (fmd *FloppyMediumDriver) Read(
+	_medium interfaces.Medium,
+	path string,
+	buff []byte,
+	ofst int64,
+	fh uint64,
+) (int, error) {
+	mutex := _medium.GetMutex()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	floppyMedium, castOk := _medium.(*medium.FloppyMedium)
+
+	if !castOk {
+		return 0, errors.New("cannot cast Medium to FloppyMedium")
+	}
+
+	floppyMedium.SetAccessTime(
+		time.Now().Unix())
+
+	lenBuff := int64(len(buff))
+	toReadSize := lenBuff
+	fileSize := floppyMedium.GetSize()
+
+	if ofst >= fileSize {
+		// eg. direct_io read past the end
+		return 0, nil
+	}
+
+	if ofst+int64(toReadSize) > int64(fileSize) {
+		toReadSize = fileSize - ofst
+	}
+
+	if floppyMedium.GetCachedAdfPathname() == "" {
+		return fmd.realRead(floppyMedium, path, buff, ofst, toReadSize, fh)
+	}
+
+	return fmd.cachedRead(floppyMedium, path, buff, ofst, toReadSize, fh)
+}
+
+// ReadUntracked reads the medium without calling the callbacks
+// and without updating the access time, not cached sectors
+// are read from the medium and cached, like Prefetch does
+func (fmd *FloppyMediumDriver) ReadUntracked(
+	_medium interfaces.Medium,
+	buff []byte,
+	ofst int64,
+) (int, error) {
+	mutex := _medium.GetMutex()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	floppyMedium, castOk := _medium.(*medium.FloppyMedium)
+
+	if !castOk {
+		return 0, errors.New("cannot cast Medium to FloppyMedium")
+	}
+
+	toReadSize := int64(len(buff))
+	fileSize := floppyMedium.GetSize()
+
+	if ofst+toReadSize > fileSize {
+		toReadSize = fileSize - ofst
+	}
+
+	if toReadSize <= 0 {
+		return 0, nil
+	}
+
+	var handle *os.File
+	var data []byte
+	var n int
+	var err error
+
+	sectorBitmap := floppyMedium.GetSectorBitmap()
+
+	if floppyMedium.GetCachedAdfPathname() != "" {
+		if handle, err = fmd.OpenMediumHandle(floppyMedium); err != nil {
+			return 0, err
+		}
+
+		data, n, err = utils.FileUtilsInstance.FileReadBytes("", ofst, toReadSize, 0, 0, handle)
+	} else if sectorBitmap != nil && sectorBitmap.IsRangeSet(ofst, toReadSize) {
+		data, n, err = utils.FileUtilsInstance.FileReadBytes(
+			floppyMedium.GetPartialAdfPathname(),
+			ofst,
+			toReadSize,
+			0,
+			0,
+			nil)
+	} else {
+		if handle, err = fmd.OpenMediumHandle(floppyMedium, shared.FLOPPY_READ_AHEAD); err != nil {
+			return 0, err
+		}
+
+		data, n, err = fmd.readWithRetries(floppyMedium, handle, ofst, toReadSize, false)
+
+		if err == nil {
+			if err := fmd.cacheSectors(floppyMedium, ofst, data[:n]); err != nil && fmd.debugMode {
+				log.Println(err)
+			}
+		}
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return copy(buff, data[:n]), nil
+}
+
+// Prefetch reads part of not cached medium into the partially
+// cached ADF, returns false (postponed) when the emulator is
+// reading the medium, so it is always served first, the medium
+// is read sector by sector and the mutex is released between
+// the sectors, so the emulator waits for a single sector only
+func (fmd *FloppyMediumDriver) Prefetch(
+	_medium interfaces.Medium,
+	ofst, size int64,
+) (bool, error) {
+	floppyMedium, castOk := _medium.(*medium.FloppyMedium)
+
+	if !castOk {
+		return true, errors.New("cannot cast Medium to FloppyMedium")
+	}
+
+	var lastErr error
+
+	for sectorOfst := ofst; sectorOfst < ofst+size; sectorOfst += shared.FLOPPY_DEVICE_SECTOR_SIZE {
+		sectorSize := ofst + size - sectorOfst
+
+		if sectorSize > shared.FLOPPY_DEVICE_SECTOR_SIZE {
+			sectorSize = shared.FLOPPY_DEVICE_SECTOR_SIZE
+		}
+
+		done, err := fmd.prefetchSector(floppyMedium, sectorOfst, sectorSize)
+
+		if !done {
+			// already prefetched sectors are
+			// skipped when it is retried
+			return false, nil
+		}
+
+		if err != nil {
+			lastErr = err
+		}
+	}
+
+	return true, lastErr
+}
+
+func (fmd *FloppyMediumDriver) prefetchSector(
+	floppyMedium *medium.FloppyMedium,
+	ofst, size int64,
+) (bool, error) {
+	mutex := floppyMedium.GetMutex()
+
+	if !mutex.TryLock() {
+		// medium is being read or written
+		return false, nil
+	}
+
+	defer mutex.Unlock()
+
+	if time.Now().Unix()-floppyMedium.GetAccessTime() < shared.FLOPPY_PREFETCH_IDLE_SECS {
+		// emulator is still reading the medium
+		return false, nil
+	}
+
+	sectorBitmap := floppyMedium.GetSectorBitmap()
 
-	if err0 != nil {
-		return err0
+	if sectorBitmap == nil ||
+		floppyMedium.IsCachingDisabled() ||
+		floppyMedium.GetCachedAdfPathname() != "" {
+		// nothing to prefetch
+		return true, nil
 	}
 
-	if err1 != nil {
-		return err1
+	if sectorBitmap.IsRangeSet(ofst, size) {
+		return true, nil
 	}
 
-	err0 = handle.Close()
-	err1 = directIOhandle.Close()
-
-	if err0 != nil {
-		return err0
-	}
+	handle, err := fmd.OpenMediumHandle(floppyMedium, shared.FLOPPY_READ_AHEAD)
 
-	if err1 != nil {
-		return err1
+	if err != nil {
+		return true, err
 	}
 
-	return nil
-}
+	data, n, err := fmd.readWithRetries(
+		floppyMedium,
+		handle,
+		ofst,
+		size,
+		false)
 
-func (fmd *FloppyMediumDriver) SetOutsideAsyncFileWriterCallback(
-	callback interfaces.OutsideAsyncFileWriterCallback,
-) {
-	fmd.outsideAsyncFileWriterCallback = callback
-}
+	if err != nil {
+		return true, err
+	}
 
-func (fmd *FloppyMediumDriver) SetPreCacheADFCallback(
-	callback interfaces.PreCacheADFCallback,
-) {
-	fmd.preCacheADFCallback = callback
+	return true, fmd.cacheSectors(floppyMedium, ofst, data[:n])
 }
 
-// Read
-func (fmd *FloppyMediumDriver) Read(
-	_medium interfaces.Medium,
+func (fmd *FloppyMediumDriver) realRead(
+	floppyMedium *medium.FloppyMedium,
 	path string,
 	buff []byte,
-	ofst int64,
+	ofst, toReadSize int64,
 	fh uint64,
 ) (int, error) {
-	mutex := _medium.GetMutex()
+	if toReadSize <= 0 {
+		return 0, nil
+	}
 
-	mutex.Lock()
-	defer mutex.Unlock()
+	if sectorBitmap := floppyMedium.GetSectorBitmap(); sectorBitmap != nil {
+		if sectorBitmap.IsRangeSet(ofst, toReadSize) {
+			return fmd.partiallyCachedRead(floppyMedium, path, buff, ofst, toReadSize, fh)
+		}
+	}
 
-	floppyMedium, castOk := _medium.(*medium.FloppyMedium)
+	// read whole tracks, the drive reads them
+	// anyway, and cache all of them
+	trackOfst, trackSize := fmd.trackAlignedRange(ofst, toReadSize)
 
-	if !castOk {
-		return 0, errors.New("cannot cast Medium to FloppyMedium")
+	data, n_int64, err := fmd.realRead2(floppyMedium, path, trackOfst, trackSize, fh)
+
+	if err != nil {
+		return 0, err
 	}
 
-	floppyMedium.SetAccessTime(
-		time.Now().Unix())
+	if err = fmd.cacheSectors(floppyMedium, trackOfst, data[:n_int64]); err != nil {
+		if fmd.debugMode {
+			log.Println(err)
+		}
+	}
 
-	lenBuff := int64(len(buff))
-	toReadSize := lenBuff
-	fileSize := floppyMedium.GetSize()
+	skip := ofst - trackOfst
 
-	if ofst+int64(toReadSize) > int64(fileSize) {
-		toReadSize = fileSize - ofst
+	if n_int64 <= skip {
+		return 0, nil
 	}
 
-	if floppyMedium.GetCachedAdfPathname() == "" {
-		return fmd.realRead(floppyMedium, path, buff, ofst, toReadSize, fh)
+	n := copy(buff[:toReadSize], data[skip:n_int64])
+
+	return n, nil
+}
+
+// trackAlignedRange expands the range to the whole
+// Amiga tracks, limited to the ADF
+func (fmd *FloppyMediumDriver) trackAlignedRange(ofst, size int64) (int64, int64) {
+	start := ofst - ofst%shared.FLOPPY_TRACK_SIZE
+	end := ofst + size
+
+	if end%shared.FLOPPY_TRACK_SIZE != 0 {
+		end += shared.FLOPPY_TRACK_SIZE - end%shared.FLOPPY_TRACK_SIZE
 	}
 
-	return fmd.cachedRead(floppyMedium, path, buff, ofst, toReadSize, fh)
+	if end > shared.FLOPPY_ADF_SIZE {
+		end = shared.FLOPPY_ADF_SIZE
+	}
+
+	if end < ofst+size {
+		end = ofst + size
+	}
+
+	return start, end - start
 }
 
-func (fmd *FloppyMediumDriver) realRead(
+func (fmd *FloppyMediumDriver) partiallyCachedRead(
 	floppyMedium *medium.FloppyMedium,
 	path string,
 	buff []byte,
 	ofst, toReadSize int64,
 	fh uint64,
 ) (int, error) {
-	data, n_int64, err := fmd.realRead2(floppyMedium, path, ofst, toReadSize, fh)
+	all_data := make([]byte, 0)
+
+	floppyMedium.CallPreReadCallbacks(
+		floppyMedium,
+		path,
+		all_data,
+		ofst,
+		fh)
+
+	data, n, err := utils.FileUtilsInstance.FileReadBytes(
+		floppyMedium.GetPartialAdfPathname(),
+		ofst,
+		toReadSize,
+		0,
+		0,
+		nil)
 
 	if err != nil {
+		floppyMedium.CallPostReadCallbacks(
+			floppyMedium,
+			path,
+			data,
+			ofst,
+			fh,
+			-fuse.EIO,
+			0,
+		)
+
 		return 0, err
 	}
 
-	copy(buff, data)
+	floppyMedium.CallPostReadCallbacks(floppyMedium, path, data, ofst, fh, n, 0)
+
+	copy(buff, data[:n])
 
-	return int(n_int64), nil
+	return n, nil
 }
 
 func (mdb *FloppyMediumDriver) realRead2(
@@ -614,7 +1869,7 @@ func (mdb *FloppyMediumDriver) realRead2(
 		if rr2_total_read_time_ms < shared.FLOPPY_SECTOR_READ_TIME_MS {
 			floppyMedium.SetFullyCached(true)
 
-			if !floppyMedium.IsCachingDisabled() && floppyMedium.IsWritable() {
+			if !floppyMedium.IsCachingDisabled() {
 				err := mdb.floppyCacheAdf(floppyMedium)
 
 				if err != nil {
@@ -660,6 +1915,14 @@ func (mdb *FloppyMediumDriver) partialRead(
 	}
 
 	for {
+		// read up to the end of the track of the drive,
+		// so the single read does not need to seek
+		chunk_size := shared.FLOPPY_DEVICE_TRACK_SIZE - dynamic_offset%shared.FLOPPY_DEVICE_TRACK_SIZE
+
+		if to_read_size > 0 && to_read_size < chunk_size {
+			chunk_size = to_read_size
+		}
+
 		start_time := time.Now().UnixMilli()
 
 		medium.CallPreReadCallbacks(
@@ -669,13 +1932,12 @@ func (mdb *FloppyMediumDriver) partialRead(
 			dynamic_offset,
 			fh)
 
-		data, len_data, err := utils.FileUtilsInstance.FileReadBytes(
-			"",
+		data, len_data, err := mdb.readWithRetries(
+			medium,
+			handle,
 			dynamic_offset,
-			shared.FLOPPY_DEVICE_SECTOR_SIZE,
-			0,
-			0,
-			handle)
+			chunk_size,
+			false)
 
 		read_time_ms = time.Now().UnixMilli() - start_time
 		total_read_time_ms += read_time_ms
@@ -708,13 +1970,13 @@ func (mdb *FloppyMediumDriver) partialRead(
 		)
 
 		if read_time_ms > shared.FLOPPY_SECTOR_READ_TIME_MS {
-			count_real_read_sectors += 1
+			count_real_read_sectors += int64(len_data) / shared.FLOPPY_DEVICE_SECTOR_SIZE
 		}
 
-		all_data = append(all_data, data...)
+		all_data = append(all_data, data[:len_data]...)
 		to_read_size -= int64(len_data)
 
-		if len_data < shared.FLOPPY_DEVICE_SECTOR_SIZE {
+		if int64(len_data) < chunk_size {
 			break
 		}
 
@@ -735,7 +1997,9 @@ func (mdb *FloppyMediumDriver) partialRead(
 		}
 	}
 
-	all_data = all_data[:size]
+	if int64(len(all_data)) > size {
+		all_data = all_data[:size]
+	}
 
 	return all_data, total_read_time_ms, count_real_read_sectors, nil
 }
@@ -870,6 +2134,13 @@ func (fmd *FloppyMediumDriver) realWrite(
 
 	floppyMedium.CallPostWriteCallbacks(floppyMedium, path, buff, ofst, fh, n, totalTime)
 
+	// keep partially cached ADF in sync with the medium
+	if err = fmd.cacheSectors(floppyMedium, ofst, buff[:n]); err != nil {
+		if fmd.debugMode {
+			log.Println(err)
+		}
+	}
+
 	return n, nil
 }
 
@@ -880,6 +2151,10 @@ func (fmd *FloppyMediumDriver) cachedWrite(
 	ofst int64,
 	fh uint64,
 ) (int, error) {
+	if err := fmd.unshareCachedAdf(floppyMedium); err != nil {
+		return 0, err
+	}
+
 	handle, err := fmd.OpenMediumHandle(floppyMedium)
 
 	if err != nil {
@@ -891,25 +2166,9 @@ func (fmd *FloppyMediumDriver) cachedWrite(
 
 	n, err := utils.FileUtilsInstance.FileWriteBytes("", ofst, buff, 0, 0, handle)
 
-	fmd.outsideAsyncFileWriterCallback(
-		floppyMedium.GetDevicePathname(),
-		ofst,
-		buff,
-		os.O_SYNC|os.O_RDWR,
-		0777,
-		nil,
-		false)
-
-	stat, _ := os.Stat(
-		floppyMedium.GetCachedAdfPathname())
-
-	fmd.updateCachedADFHeader(
-		floppyMedium.GetDevicePathname(),
-		floppyMedium.GetCachedAdfSha512(),
-		floppyMedium.GetFloppyUUID(),
-		stat.ModTime().Unix())
-
 	if err != nil {
+		// not written to the cached ADF, so it is
+		// not journaled nor written to the medium
 		floppyMedium.CallPostWriteCallbacks(
 			floppyMedium,
 			path,
@@ -923,7 +2182,202 @@ func (fmd *FloppyMediumDriver) cachedWrite(
 		return 0, err
 	}
 
+	// journal the write before it is queued, so it can be
+	// replayed if the medium is removed too early
+	if fmd.writeJournal != nil {
+		if jerr := fmd.writeJournal.MarkPending(floppyMedium.GetFloppyUUID(), ofst, int64(len(buff))); jerr != nil {
+			log.Println(jerr)
+		}
+	}
+
+	fmd.outsideAsyncFileWriterCallback(
+		floppyMedium.GetDevicePathname(),
+		ofst,
+		buff,
+		os.O_SYNC|os.O_RDWR,
+		0777,
+		nil,
+		false)
+
+	if fmd.cachedAdfIndex != nil {
+		fmd.cachedAdfIndex.MarkModified(floppyMedium.GetFloppyUUID())
+	}
+
+	stat, err := os.Stat(
+		floppyMedium.GetCachedAdfPathname())
+
+	if err != nil {
+		log.Println(err)
+	} else {
+		fmd.updateCachedADFHeader(
+			floppyMedium.GetDevicePathname(),
+			floppyMedium.GetCachedAdfSha512(),
+			floppyMedium.GetFloppyUUID(),
+			stat.ModTime().Unix())
+	}
+
 	floppyMedium.CallPostWriteCallbacks(floppyMedium, path, buff, ofst, fh, n, 0)
 
 	return n, nil
 }
+
+// Truncate to zero starts rewriting of the whole medium, medium which
+// is not cached gets an empty cached ADF, so all the writes go through
+// the cached ADF (and CachedADFHeader) to the async file writer,
+// the medium is verified on Fsync
+func (fmd *FloppyMediumDriver) Truncate(
+	_medium interfaces.Medium,
+	path string,
+	size int64,
+	fh uint64,
+) (int, error) {
+	mutex := _medium.GetMutex()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if !_medium.IsWritable() {
+		return -fuse.EPERM, errors.New("device is not writable")
+	}
+
+	if size != 0 {
+		// block device cannot be truncated
+		return 0, nil
+	}
+
+	floppyMedium, castOk := _medium.(*medium.FloppyMedium)
+
+	if !castOk {
+		return 0, errors.New("cannot cast Medium to FloppyMedium")
+	}
+
+	if floppyMedium.GetCachedAdfPathname() == "" {
+		// content of the medium is replaced, no need to read it
+		if err := fmd.storeCachedAdf(floppyMedium, make([]byte, shared.FLOPPY_ADF_SIZE)); err != nil {
+			return 0, err
+		}
+	}
+
+	floppyMedium.SetRewriting(true)
+
+	if fmd.verboseMode {
+		log.Printf("Rewriting medium %v\n", floppyMedium.GetDevicePathname())
+		log.Printf("\tCached ADF: %v\n", floppyMedium.GetCachedAdfPathname())
+		log.Printf("\tUUID:  %v\n", floppyMedium.GetFloppyUUID())
+	}
+
+	return 0, nil
+}
+
+// Fsync waits for the queued writes, rewritten
+// medium is verified then
+func (fmd *FloppyMediumDriver) Fsync(
+	_medium interfaces.Medium,
+	path string,
+	datasync bool,
+	fh uint64,
+) (int, error) {
+	floppyMedium, castOk := _medium.(*medium.FloppyMedium)
+
+	if !castOk {
+		return 0, errors.New("cannot cast Medium to FloppyMedium")
+	}
+
+	if fmd.outsideAsyncFileFlushCallback != nil &&
+		!fmd.outsideAsyncFileFlushCallback(floppyMedium.GetDevicePathname()) {
+		return -fuse.EIO, errors.New("timeout while waiting for the queued writes")
+	}
+
+	mutex := _medium.GetMutex()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if !floppyMedium.IsRewriting() {
+		return 0, nil
+	}
+
+	floppyMedium.SetRewriting(false)
+
+	if err := fmd.verifyMedium(floppyMedium); err != nil {
+		return -fuse.EIO, err
+	}
+
+	return 0, nil
+}
+
+// verifyMedium reads the whole medium bypassing the buffers
+// of the device, and compares it with the cached ADF
+func (fmd *FloppyMediumDriver) verifyMedium(floppyMedium *medium.FloppyMedium) error {
+	cachedData, n, err := utils.FileUtilsInstance.FileReadBytes(
+		floppyMedium.GetCachedAdfPathname(),
+		0,
+		shared.FLOPPY_ADF_SIZE,
+		0,
+		0,
+		nil)
+
+	if err != nil {
+		return err
+	}
+
+	if n < shared.FLOPPY_ADF_SIZE {
+		return errors.New("cannot read cached ADF")
+	}
+
+	deviceHandle, err := os.OpenFile(floppyMedium.GetDevicePathname(), os.O_RDONLY, 0)
+
+	if err != nil {
+		return err
+	}
+
+	defer deviceHandle.Close()
+
+	// make sure the data comes from the medium
+	if err = utils.UnixUtilsInstance.FlushDeviceBuffers(deviceHandle); err != nil {
+		return err
+	}
+
+	deviceData, n, err := fmd.readWithRetries(
+		floppyMedium,
+		deviceHandle,
+		0,
+		shared.FLOPPY_ADF_SIZE,
+		false)
+
+	if err != nil {
+		return err
+	}
+
+	if n < shared.FLOPPY_ADF_SIZE {
+		return errors.New("cannot read medium data")
+	}
+
+	cachedSha512 := utils.CryptoUtilsInstance.BytesToSha512Hex(cachedData)
+	deviceSha512 := utils.CryptoUtilsInstance.BytesToSha512Hex(deviceData)
+
+	diverged := make([]int64, 0)
+
+	if cachedSha512 != deviceSha512 {
+		for ofst := 0; ofst < shared.FLOPPY_ADF_SIZE; ofst += shared.FLOPPY_DEVICE_SECTOR_SIZE {
+			end := ofst + shared.FLOPPY_DEVICE_SECTOR_SIZE
+
+			if !bytes.Equal(cachedData[ofst:end], deviceData[ofst:end]) {
+				diverged = append(diverged, int64(ofst/shared.FLOPPY_DEVICE_SECTOR_SIZE))
+			}
+		}
+	}
+
+	floppyMedium.SetDivergedSectors(diverged)
+
+	if len(diverged) > 0 {
+		return fmt.Errorf("medium differs from cached ADF, sectors: %v", diverged)
+	}
+
+	if fmd.verboseMode {
+		log.Printf("Medium %v have been verified\n", floppyMedium.GetDevicePathname())
+		log.Printf("\tSHA512:  %v\n", deviceSha512)
+	}
+
+	return nil
+}
@@ -88,6 +88,37 @@ func (mdb *MediumDriverBase) Read(
 	medium.SetAccessTime(
 		time.Now().Unix())
 
+	return mdb.readHandle(medium, handle, buff, ofst)
+}
+
+// ReadUntracked reads the medium without calling the callbacks
+// and without updating the access time, so it is not taken
+// as the emulator activity (eg. reading the files of the volume)
+func (mdb *MediumDriverBase) ReadUntracked(
+	medium interfaces.Medium,
+	buff []byte,
+	ofst int64,
+) (int, error) {
+	mutex := medium.GetMutex()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	handle, err := mdb.OpenMediumHandle(medium)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return mdb.readHandle(medium, handle, buff, ofst)
+}
+
+func (mdb *MediumDriverBase) readHandle(
+	medium interfaces.Medium,
+	handle *os.File,
+	buff []byte,
+	ofst int64,
+) (int, error) {
 	lenBuff := int64(len(buff))
 	toReadSize := lenBuff
 	fileSize := medium.GetSize()
@@ -154,6 +185,26 @@ func (mdb *MediumDriverBase) Write(
 	return n, nil
 }
 
+// Block device cannot be truncated, so just return here
+func (mdb *MediumDriverBase) Truncate(
+	medium interfaces.Medium,
+	path string,
+	size int64,
+	fh uint64,
+) (int, error) {
+	return 0, nil
+}
+
+// Writes are synchronous, nothing to flush
+func (mdb *MediumDriverBase) Fsync(
+	medium interfaces.Medium,
+	path string,
+	datasync bool,
+	fh uint64,
+) (int, error) {
+	return 0, nil
+}
+
 func (mdb *MediumDriverBase) generatePermIntMask(
 	userCanRead bool,
 	userCanWrite bool,
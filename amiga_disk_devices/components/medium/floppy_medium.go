@@ -1,6 +1,10 @@
 package medium
 
-import "os"
+import (
+	"os"
+
+	"github.com/skazanyNaGlany/go.amipi400/amiga_disk_devices/components/cache"
+)
 
 type FloppyMedium struct {
 	MediumBase
@@ -13,6 +17,14 @@ type FloppyMedium struct {
 	floppyUUID           string
 	cachingDisabled      bool
 	deviceDirectIOHandle *os.File
+	fingerprint          string
+	partialAdfPathname   string
+	sectorBitmap         *cache.SectorBitmap
+	pinned               bool
+	divergedSectors      []int64
+	dirty                bool
+	badSectorMap         *cache.SectorBitmap
+	rewriting            bool
 }
 
 func (fm *FloppyMedium) GetDeviceDirectIOHandle() (*os.File, error) {
@@ -79,6 +91,78 @@ func (fm *FloppyMedium) IsCachingDisabled() bool {
 	return fm.cachingDisabled
 }
 
+func (fm *FloppyMedium) SetFingerprint(fingerprint string) {
+	fm.fingerprint = fingerprint
+}
+
+func (fm *FloppyMedium) GetFingerprint() string {
+	return fm.fingerprint
+}
+
+func (fm *FloppyMedium) SetPartialAdfPathname(partialAdfPathname string) {
+	fm.partialAdfPathname = partialAdfPathname
+}
+
+func (fm *FloppyMedium) GetPartialAdfPathname() string {
+	return fm.partialAdfPathname
+}
+
+func (fm *FloppyMedium) SetSectorBitmap(sectorBitmap *cache.SectorBitmap) {
+	fm.sectorBitmap = sectorBitmap
+}
+
+func (fm *FloppyMedium) GetSectorBitmap() *cache.SectorBitmap {
+	return fm.sectorBitmap
+}
+
+func (fm *FloppyMedium) SetPinned(pinned bool) {
+	fm.pinned = pinned
+}
+
+func (fm *FloppyMedium) IsPinned() bool {
+	return fm.pinned
+}
+
+// SetDivergedSectors sets sectors which differ between
+// the medium and its cached ADF, found on insert
+func (fm *FloppyMedium) SetDivergedSectors(divergedSectors []int64) {
+	fm.divergedSectors = divergedSectors
+}
+
+func (fm *FloppyMedium) GetDivergedSectors() []int64 {
+	return fm.divergedSectors
+}
+
+// SetDirty is set while the writes to the
+// medium are queued and not finished yet
+func (fm *FloppyMedium) SetDirty(dirty bool) {
+	fm.dirty = dirty
+}
+
+func (fm *FloppyMedium) IsDirty() bool {
+	return fm.dirty
+}
+
+// SetBadSectorMap sets sectors which could not
+// be read from the medium
+func (fm *FloppyMedium) SetBadSectorMap(badSectorMap *cache.SectorBitmap) {
+	fm.badSectorMap = badSectorMap
+}
+
+func (fm *FloppyMedium) GetBadSectorMap() *cache.SectorBitmap {
+	return fm.badSectorMap
+}
+
+// SetRewriting marks the medium as being rewritten with the whole
+// ADF image, it is verified when the writes are flushed
+func (fm *FloppyMedium) SetRewriting(rewriting bool) {
+	fm.rewriting = rewriting
+}
+
+func (fm *FloppyMedium) IsRewriting() bool {
+	return fm.rewriting
+}
+
 func (fm *FloppyMedium) Read(
 	path string,
 	buff []byte,
@@ -97,6 +181,14 @@ func (mb *FloppyMedium) Write(
 	return mb.driver.Write(mb, path, buff, ofst, fh)
 }
 
+func (fm *FloppyMedium) Truncate(path string, size int64, fh uint64) (int, error) {
+	return fm.driver.Truncate(fm, path, size, fh)
+}
+
+func (fm *FloppyMedium) Fsync(path string, datasync bool, fh uint64) (int, error) {
+	return fm.driver.Fsync(fm, path, datasync, fh)
+}
+
 func (fm *FloppyMedium) Close() error {
 	err := fm.driver.CloseMedium(fm)
 
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/skazanyNaGlany/go.amipi400/amiga_disk_devices/interfaces"
+	"github.com/skazanyNaGlany/go.amipi400/shared"
 	"github.com/winfsp/cgofuse/fuse"
 )
 
@@ -138,6 +139,14 @@ func (mb *MediumBase) Write(
 	return result, err
 }
 
+func (mb *MediumBase) Truncate(path string, size int64, fh uint64) (int, error) {
+	return mb.driver.Truncate(mb, path, size, fh)
+}
+
+func (mb *MediumBase) Fsync(path string, datasync bool, fh uint64) (int, error) {
+	return mb.driver.Fsync(mb, path, datasync, fh)
+}
+
 func (mb *MediumBase) Close() error {
 	err := mb.driver.CloseMedium(mb)
 
@@ -257,3 +266,38 @@ func (mb *MediumBase) DevicePathnameToPublicFilename(
 
 	return filename + "." + extension
 }
+
+// DevicePathnameAndVolumeNameToPublicFilename works like
+// DevicePathnameToPublicFilename, but the volume name of the
+// disk is appended, so the medium is easy to recognise, '#'
+// and ',' are replaced too, Amiberry command executor cuts
+// the line at '#' and splits the arguments at ','
+func (mb *MediumBase) DevicePathnameAndVolumeNameToPublicFilename(
+	devicePathname string,
+	volumeName string,
+	extension string,
+) string {
+	volumeName = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f || r == '/' || r == '#' || r == ',' {
+			return '_'
+		}
+
+		return r
+	}, volumeName)
+	volumeName = strings.TrimSpace(volumeName)
+
+	if volumeName == "" {
+		return mb.DevicePathnameToPublicFilename(devicePathname, extension)
+	}
+
+	filename := strings.ReplaceAll(
+		devicePathname,
+		"/",
+		"__")
+
+	return filename +
+		shared.PUBLIC_FILENAME_VOLUME_NAME_PREFIX +
+		volumeName +
+		shared.PUBLIC_FILENAME_VOLUME_NAME_SUFFIX +
+		"." + extension
+}
@@ -0,0 +1,35 @@
+package medium
+
+import "testing"
+
+func TestDevicePathnameAndVolumeNameToPublicFilename(t *testing.T) {
+	tests := []struct {
+		devicePathname string
+		volumeName     string
+		expected       string
+	}{
+		{"/dev/sda", "Workbench", "__dev__sda [Workbench].adf"},
+		{"/dev/sda", "", "__dev__sda.adf"},
+		{"/dev/sda", "  ", "__dev__sda.adf"},
+		{"/dev/sda", " Work ", "__dev__sda [Work].adf"},
+		{"/dev/sda", "A/B", "__dev__sda [A_B].adf"},
+		{"/dev/sda", "A\x00B\x7f", "__dev__sda [A_B_].adf"},
+		{"/dev/sda", "Disk #1", "__dev__sda [Disk _1].adf"},
+		{"/dev/sda", "Game,Save", "__dev__sda [Game_Save].adf"},
+	}
+
+	mb := MediumBase{}
+
+	for _, test := range tests {
+		filename := mb.DevicePathnameAndVolumeNameToPublicFilename(test.devicePathname, test.volumeName, "adf")
+
+		if filename != test.expected {
+			t.Errorf(
+				"DevicePathnameAndVolumeNameToPublicFilename(%q, %q) = %q, expected %q",
+				test.devicePathname,
+				test.volumeName,
+				filename,
+				test.expected)
+		}
+	}
+}
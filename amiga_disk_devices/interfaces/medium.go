@@ -58,8 +58,11 @@ type Medium interface {
 	)
 	CallClosedCallbacks(_medium Medium, err error)
 	DevicePathnameToPublicFilename(devicePathname string, extension string) string
+	DevicePathnameAndVolumeNameToPublicFilename(devicePathname string, volumeName string, extension string) string
 	Open(path string, flags int) (errc int, fh uint64)
 	Getattr(path string, stat *fuse.Stat_t, fh uint64) (int, error)
 	Read(path string, buff []byte, ofst int64, fh uint64) (int, error)
 	Write(path string, buff []byte, ofst int64, fh uint64) (int, error)
+	Truncate(path string, size int64, fh uint64) (int, error)
+	Fsync(path string, datasync bool, fh uint64) (int, error)
 }
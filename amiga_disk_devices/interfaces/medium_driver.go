@@ -16,7 +16,10 @@ type MediumDriver interface {
 	Getattr(medium Medium, path string, stat *fuse.Stat_t, fh uint64) (int, error)
 	OpenMediumHandle(medium Medium, readAhead ...int) (*os.File, error)
 	Read(medium Medium, path string, buff []byte, ofst int64, fh uint64) (int, error)
+	ReadUntracked(medium Medium, buff []byte, ofst int64) (int, error)
 	Write(medium Medium, path string, buff []byte, ofst int64, fh uint64) (int, error)
+	Truncate(medium Medium, path string, size int64, fh uint64) (int, error)
+	Fsync(medium Medium, path string, datasync bool, fh uint64) (int, error)
 	CloseMedium(medium Medium) error
 	SetVerboseMode(verboseMode bool)
 	SetDebugMode(debugMode bool)
@@ -0,0 +1,5 @@
+package interfaces
+
+// OutsideAsyncFileFlushCallback waits for the queued writes
+// of the file, returns false on timeout
+type OutsideAsyncFileFlushCallback func(name string) bool
@@ -0,0 +1,3 @@
+package interfaces
+
+type StatusCallback func() []byte
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -10,12 +11,16 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	components_amipi400 "github.com/skazanyNaGlany/go.amipi400/amipi400/components"
+	"github.com/skazanyNaGlany/go.amipi400/amipi400/components/commands"
 	"github.com/skazanyNaGlany/go.amipi400/shared"
 	"github.com/skazanyNaGlany/go.amipi400/shared/components"
+	"github.com/skazanyNaGlany/go.amipi400/shared/components/amigafs"
 	"github.com/skazanyNaGlany/go.amipi400/shared/components/utils"
 	"github.com/thoas/go-funk"
 )
@@ -29,21 +34,30 @@ var emulator components_amipi400.AmiberryEmulator
 var driveDevicesDiscovery components.DriveDevicesDiscovery
 var commander components_amipi400.AmiberryCommander
 var blockDevices components.BlockDevices
+var controlServer components_amipi400.ControlServer
 var wifiControl = components_amipi400.NewWIFIControl()
 var mountpoints = components_amipi400.NewMountpointList()
 var mainConfig = components_amipi400.NewMainConfig(shared.MAIN_CONFIG_INI_PATHNAME)
-var initializing = true
+var initialized atomic.Bool // set when all services are idle
+var commandMutex sync.Mutex
+var floppyCopyPathname string // guarded by commandMutex
+var commandRegistry = commands.NewRegistry()
+
+// publicPathnameToDevicePathname converts pathname of the file
+// exposed by amiga_disk_devices to the device pathname, the
+// volume name (if any) is dropped
+func publicPathnameToDevicePathname(pathname string) string {
+	baseName := filepath.Base(pathname)
+	baseName = strings.TrimSuffix(baseName, filepath.Ext(baseName))
+	baseName, _, _ = strings.Cut(baseName, shared.PUBLIC_FILENAME_VOLUME_NAME_PREFIX)
+
+	return strings.ReplaceAll(baseName, "__", "/")
+}
 
 func adfPathnameToDFIndex(pathname string) int {
 	floppyDevices := driveDevicesDiscovery.GetFloppies()
 
-	// get basename and convert it
-	// to the device pathname
-	baseName := filepath.Base(pathname)
-	baseName = strings.ReplaceAll(baseName, "__", "/")
-	baseName = strings.Replace(baseName, shared.FLOPPY_ADF_FULL_EXTENSION, "", 1)
-
-	index := funk.IndexOfString(floppyDevices, baseName)
+	index := funk.IndexOfString(floppyDevices, publicPathnameToDevicePathname(pathname))
 
 	if index < 0 {
 		return 0
@@ -59,13 +73,7 @@ func adfPathnameToDFIndex(pathname string) int {
 func isoPathnameToCDIndex(pathname string) int {
 	cdromDevices := driveDevicesDiscovery.GetCDROMs()
 
-	// get basename and convert it
-	// to the device pathname
-	baseName := filepath.Base(pathname)
-	baseName = strings.ReplaceAll(baseName, "__", "/")
-	baseName = strings.Replace(baseName, shared.CD_ISO_FULL_EXTENSION, "", 1)
-
-	index := funk.IndexOfString(cdromDevices, baseName)
+	index := funk.IndexOfString(cdromDevices, publicPathnameToDevicePathname(pathname))
 
 	if index < 0 {
 		return 0
@@ -78,6 +86,19 @@ func isoPathnameToCDIndex(pathname string) int {
 	return index
 }
 
+func isDFSlotUsed(index int) bool {
+	return emulator.GetAdf(index) != "" || mountpoints.GetMountpointByDFIndex(index) != nil
+}
+
+// DH and HF media share the same slots
+func isDHSlotUsed(index int) bool {
+	return emulator.GetHd(index) != "" || mountpoints.GetMountpointByDHIndex(index) != nil
+}
+
+func isCDSlotUsed(index int) bool {
+	return emulator.GetIso(index) != "" || mountpoints.GetMountpointByCDIndex(index) != nil
+}
+
 func getHdfFreeSlot() int {
 	for index := 0; index < shared.MAX_HDFS; index++ {
 		if emulator.GetHd(index) == "" {
@@ -98,6 +119,19 @@ func getHdfSlot(pathname string) int {
 	return shared.DRIVE_INDEX_UNSPECIFIED
 }
 
+// showOSDMessage displays message on the emulator status line
+func showOSDMessage(format string, a ...any) {
+	emulator.ShowOSDMessage(fmt.Sprintf(format, a...))
+}
+
+// getMediumName returns the filename without
+// extension, used in the OSD messages
+func getMediumName(pathname string) string {
+	basename := filepath.Base(pathname)
+
+	return strings.TrimSuffix(basename, filepath.Ext(basename))
+}
+
 func attachAdf(index int, pathname string) bool {
 	strIndex := fmt.Sprint(index)
 
@@ -107,6 +141,12 @@ func attachAdf(index int, pathname string) bool {
 		return false
 	}
 
+	if pathname == floppyCopyPathname {
+		log.Println(pathname, "is being copied, cannot attach it to DF"+strIndex)
+
+		return false
+	}
+
 	volume := 0
 
 	if !amigaDiskDevicesDiscovery.HasFile(pathname) {
@@ -116,6 +156,7 @@ func attachAdf(index int, pathname string) bool {
 	log.Println("Attaching", pathname, "to DF"+strIndex)
 
 	emulator.AttachAdf(index, pathname, volume, 0)
+	showOSDMessage("DF%v: %v inserted", index, getMediumName(pathname))
 
 	return true
 }
@@ -133,6 +174,7 @@ func attachIso(index int, pathname string) bool {
 	log.Println("Attaching", pathname, "to CD"+strIndex)
 
 	emulator.AttachCd(index, pathname)
+	showOSDMessage("CD%v: %v inserted", index, getMediumName(pathname))
 
 	return true
 }
@@ -191,6 +233,7 @@ func detachIso(index int, pathname string) bool {
 	log.Println("Detaching", pathname, "from CD"+strIndex)
 
 	emulator.DetachCd(index)
+	showOSDMessage("CD%v: %v ejected", index, getMediumName(pathname))
 
 	return true
 }
@@ -215,6 +258,7 @@ func detachAdf(index int, pathname string) bool {
 	log.Println("Detaching", pathname, "from DF"+strIndex)
 
 	emulator.DetachAdf(index, 0, 0)
+	showOSDMessage("DF%v: %v ejected", index, getMediumName(pathname))
 
 	return true
 }
@@ -301,6 +345,9 @@ func detachAmigaDiskDeviceHdf(pathname string) {
 }
 
 func attachedAmigaDiskDeviceCallback(pathname string) {
+	commandMutex.Lock()
+	defer commandMutex.Unlock()
+
 	isAdf := strings.HasSuffix(pathname, shared.FLOPPY_ADF_FULL_EXTENSION)
 
 	if isAdf {
@@ -329,6 +376,9 @@ func attachedAmigaDiskDeviceCallback(pathname string) {
 }
 
 func detachedAmigaDiskDeviceCallback(pathname string) {
+	commandMutex.Lock()
+	defer commandMutex.Unlock()
+
 	isAdf := strings.HasSuffix(pathname, shared.FLOPPY_ADF_FULL_EXTENSION)
 
 	if isAdf {
@@ -357,7 +407,7 @@ func detachedAmigaDiskDeviceCallback(pathname string) {
 }
 
 func servicesIdleCallback(sender any) {
-	if !initializing {
+	if initialized.Load() {
 		return
 	}
 
@@ -369,7 +419,7 @@ func servicesIdleCallback(sender any) {
 		return
 	}
 
-	initializing = false
+	initialized.Store(true)
 
 	log.Println("All services idle, running emulator")
 
@@ -409,19 +459,19 @@ func isShutdownKeys() bool {
 	)
 }
 
-func getKeyboardCommand() (string, string) {
+func getKeyboardCommand() string {
 	releasedSequence := allKeyboardsControl.GetReleasedKeysSequenceAsString()
 	lenReleasedSequence := len(releasedSequence)
 
 	if lenReleasedSequence < 4 {
-		return "", ""
+		return ""
 	}
 
 	if releasedSequence[0] != shared.KEY_TAB ||
 		releasedSequence[1] != shared.KEY_TAB ||
 		releasedSequence[lenReleasedSequence-1] != shared.KEY_TAB ||
 		releasedSequence[lenReleasedSequence-2] != shared.KEY_TAB {
-		return "", ""
+		return ""
 	}
 
 	releasedSequence = releasedSequence[2:]
@@ -438,132 +488,230 @@ func getKeyboardCommand() (string, string) {
 		}
 	}
 
-	keyboardCommand := strings.Join(releasedSequence, "")
-	keyboardCommandUpper := strings.ToUpper(keyboardCommand)
-
-	return keyboardCommand, keyboardCommandUpper
+	return strings.Join(releasedSequence, "")
 }
 
 func clearAllKeyboardsControl() {
 	allKeyboardsControl.ClearAll()
 }
 
-func processKeyboardCommand(keyboardCommand string, keyboardCommandUpper string) {
-	if dfEjectRule := utils.RegExInstance.FindNamedMatches(
-		shared.DF_EJECT_FROM_SOURCE_INDEX_RE,
-		keyboardCommandUpper); len(dfEjectRule) > 0 {
-		// example: df0
-		// example: dfn
-		dfEjectFromSourceIndex(dfEjectRule["source_index"])
-	} else if dfSourceByDiskRule := utils.RegExInstance.FindNamedMatches(
-		shared.DF_INSERT_FROM_SOURCE_INDEX_BY_DISK_NO_RE,
-		keyboardCommandUpper); len(dfSourceByDiskRule) > 0 {
-		// example: df02
-		dfInsertFromSourceIndexToTargetIndexByDiskNo(
-			dfSourceByDiskRule["disk_no"],
-			dfSourceByDiskRule["source_index"],
-			shared.DRIVE_INDEX_UNSPECIFIED_STR)
-	} else if dfSourceTargetByDiskRule := utils.RegExInstance.FindNamedMatches(
-		shared.DF_INSERT_FROM_SOURCE_TO_TARGET_INDEX_BY_DISK_NO_RE,
-		keyboardCommandUpper); len(dfSourceTargetByDiskRule) > 0 {
-		// example: df02df1
-		dfInsertFromSourceIndexToTargetIndexByDiskNo(
-			dfSourceTargetByDiskRule["disk_no"],
-			dfSourceTargetByDiskRule["source_index"],
-			dfSourceTargetByDiskRule["target_index"])
-	} else if dfSourceTargetRule := utils.RegExInstance.FindNamedMatches(
-		shared.DF_INSERT_FROM_SOURCE_TO_TARGET_INDEX_RE,
-		keyboardCommandUpper); len(dfSourceTargetRule) > 0 {
-		// example: df0kwaterdf1
-		// example: df0kwaterdfn
-		dfInsertFromSourceIndexToTargetIndex(
-			dfSourceTargetRule["filename_part"],
-			dfSourceTargetRule["source_index"],
-			dfSourceTargetRule["target_index"])
-	} else if dfSourceRule := utils.RegExInstance.FindNamedMatches(
-		shared.DF_INSERT_FROM_SOURCE_INDEX_RE,
-		keyboardCommandUpper); len(dfSourceRule) > 0 {
-		// example: df0traps
-		dfInsertFromSourceIndexToTargetIndex(
-			dfSourceRule["filename_part"],
-			dfSourceRule["source_index"],
-			shared.DRIVE_INDEX_UNSPECIFIED_STR)
-	} else if cdEjectRule := utils.RegExInstance.FindNamedMatches(
-		shared.CD_EJECT_FROM_SOURCE_INDEX_RE,
-		keyboardCommandUpper); len(cdEjectRule) > 0 {
-		// example: cd0
-		cdEjectFromSourceIndex(cdEjectRule["source_index"])
-	} else if cdSourceRule := utils.RegExInstance.FindNamedMatches(
-		shared.CD_INSERT_FROM_SOURCE_INDEX_RE,
-		keyboardCommandUpper); len(cdSourceRule) > 0 {
-		// example: cd0workbenchiso
-		cdInsertFromSourceIndexToTargetIndex(
-			cdSourceRule["filename_part"],
-			cdSourceRule["source_index"],
-			shared.DRIVE_INDEX_UNSPECIFIED_STR)
-	} else if hfEjectRule := utils.RegExInstance.FindNamedMatches(
-		shared.HF_EJECT_FROM_SOURCE_INDEX_RE,
-		keyboardCommandUpper); len(hfEjectRule) > 0 {
-		// example: hf0
-		hfEjectFromSourceIndex(hfEjectRule["source_index"])
-	} else if hfSourceRule := utils.RegExInstance.FindNamedMatches(
-		shared.HF_INSERT_FROM_SOURCE_INDEX_RE,
-		keyboardCommandUpper); len(hfSourceRule) > 0 {
-		// example: hf0workbenchhdf
-		hfInsertFromSourceIndexToTargetIndex(
-			hfSourceRule["filename_part"],
-			hfSourceRule["source_index"],
-			shared.DRIVE_INDEX_UNSPECIFIED_STR)
-	} else if shared.UNMOUNT_ALL_RE.MatchString(keyboardCommandUpper) {
-		// example: u
-		unmountAll(false)
-	} else if dfUnmountRule := utils.RegExInstance.FindNamedMatches(
-		shared.DF_UNMOUNT_FROM_SOURCE_INDEX_RE,
-		keyboardCommandUpper); len(dfUnmountRule) > 0 {
-		// example: udf0
-		// example: udfn
-		dfUnmountFromSourceIndex(dfUnmountRule["source_index"])
-	} else if cdUnmountRule := utils.RegExInstance.FindNamedMatches(
-		shared.CD_UNMOUNT_FROM_SOURCE_INDEX_RE,
-		keyboardCommandUpper); len(cdUnmountRule) > 0 {
-		// example: ucd0
-		// example: ucdn
-		cdUnmountFromSourceIndex(cdUnmountRule["source_index"])
-	} else if hfUnmountRule := utils.RegExInstance.FindNamedMatches(
-		shared.HF_UNMOUNT_FROM_SOURCE_INDEX_RE,
-		keyboardCommandUpper); len(hfUnmountRule) > 0 {
-		// example: uhf0
-		// example: uhfn
-		hfUnmountFromSourceIndex(hfUnmountRule["source_index"])
-	} else if dhUnmountRule := utils.RegExInstance.FindNamedMatches(
-		shared.DH_UNMOUNT_FROM_SOURCE_INDEX_RE,
-		keyboardCommandUpper); len(dhUnmountRule) > 0 {
-		// example: udh0
-		// example: udhn
-		dhUnmountFromSourceIndex(dhUnmountRule["source_index"])
-	} else if lowLevelCopyRule := utils.RegExInstance.FindNamedMatches(
-		shared.LOW_LEVEL_COPY_RE,
-		keyboardCommandUpper); len(lowLevelCopyRule) > 0 {
-		// example: cdf0dh1
-		lowLevelCopy(
-			lowLevelCopyRule["source_low_level_device"],
-			lowLevelCopyRule["source_index"],
-			lowLevelCopyRule["target_low_level_device"],
-			lowLevelCopyRule["target_index"])
-	} else if shared.WIFI_DISCONNECT_RE.MatchString(keyboardCommandUpper) {
-		// example: wifi
-		wifiDisconect()
-	} else if wifiConnectRule := utils.RegExInstance.FindNamedMatches(
-		shared.WIFI_CONNECT_RE,
-		keyboardCommand); len(wifiConnectRule) > 0 {
-		wifiConnect(
-			wifiConnectRule["country_code_iso_iec_3166_1"],
-			wifiConnectRule["ssid"],
-			wifiConnectRule["password"])
-	}
-}
-
-func wifiDisconect() {
+// runKeyboardCommand is the keyboard front-end for the command
+// handlers, it reports failures by blinking the Num Lock LED,
+// commandMutex must be locked by the caller
+func runKeyboardCommand(keyboardCommand string) {
+	if _, err := commandRegistry.ExecuteString(keyboardCommand); err != nil {
+		log.Printf("Keyboard command %v failed (%v): %v\n", keyboardCommand, commands.ErrorKind(err), err)
+
+		showOSDMessage("%v", err)
+
+		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
+	}
+}
+
+func registerCommandHandlers() {
+	commandRegistry.Register(
+		shared.CONTROL_CMD_DF_EJECT,
+		func(command commands.Command) (any, error) {
+			return nil, dfEjectFromSourceIndex(command.(*commands.EjectFloppy).Source)
+		})
+	commandRegistry.Register(
+		shared.CONTROL_CMD_DF_INSERT,
+		func(command commands.Command) (any, error) {
+			insert := command.(*commands.InsertFloppy)
+
+			if insert.IsByDiskNo() {
+				return nil, dfInsertFromSourceIndexToTargetIndexByDiskNo(
+					insert.DiskNo,
+					insert.Source,
+					insert.Target)
+			}
+
+			return nil, dfInsertFromSourceIndexToTargetIndex(
+				insert.Pattern,
+				insert.Source,
+				insert.Target)
+		})
+	commandRegistry.Register(
+		shared.CONTROL_CMD_CD_EJECT,
+		func(command commands.Command) (any, error) {
+			return nil, cdEjectFromSourceIndex(command.(*commands.EjectCD).Source)
+		})
+	commandRegistry.Register(
+		shared.CONTROL_CMD_CD_INSERT,
+		func(command commands.Command) (any, error) {
+			insert := command.(*commands.InsertCD)
+
+			return nil, cdInsertFromSourceIndexToTargetIndex(
+				insert.Pattern,
+				insert.Source,
+				insert.Target)
+		})
+	commandRegistry.Register(
+		shared.CONTROL_CMD_HF_EJECT,
+		func(command commands.Command) (any, error) {
+			return nil, hfEjectFromSourceIndex(command.(*commands.EjectHardFile).Source)
+		})
+	commandRegistry.Register(
+		shared.CONTROL_CMD_HF_INSERT,
+		func(command commands.Command) (any, error) {
+			insert := command.(*commands.InsertHardFile)
+
+			return nil, hfInsertFromSourceIndexToTargetIndex(
+				insert.Pattern,
+				insert.Source,
+				insert.Target)
+		})
+	commandRegistry.Register(
+		shared.CONTROL_CMD_UNMOUNT_ALL,
+		func(command commands.Command) (any, error) {
+			return nil, unmountAll(false)
+		})
+	commandRegistry.Register(
+		shared.CONTROL_CMD_DF_UNMOUNT,
+		func(command commands.Command) (any, error) {
+			return nil, dfUnmountFromSourceIndex(command.(*commands.Unmount).Source)
+		})
+	commandRegistry.Register(
+		shared.CONTROL_CMD_CD_UNMOUNT,
+		func(command commands.Command) (any, error) {
+			return nil, cdUnmountFromSourceIndex(command.(*commands.Unmount).Source)
+		})
+	commandRegistry.Register(
+		shared.CONTROL_CMD_HF_UNMOUNT,
+		func(command commands.Command) (any, error) {
+			return nil, hfUnmountFromSourceIndex(command.(*commands.Unmount).Source)
+		})
+	commandRegistry.Register(
+		shared.CONTROL_CMD_DH_UNMOUNT,
+		func(command commands.Command) (any, error) {
+			return nil, dhUnmountFromSourceIndex(command.(*commands.Unmount).Source)
+		})
+	commandRegistry.Register(
+		shared.CONTROL_CMD_LOW_LEVEL_COPY,
+		func(command commands.Command) (any, error) {
+			copy := command.(*commands.LowLevelCopy)
+
+			return nil, lowLevelCopy(
+				copy.SourceDevice,
+				copy.Source,
+				copy.TargetDevice,
+				copy.Target)
+		})
+	commandRegistry.Register(
+		shared.CONTROL_CMD_DF_ARCHIVE,
+		func(command commands.Command) (any, error) {
+			archive := command.(*commands.ArchiveFloppy)
+
+			return nil, archiveFloppy(
+				archive.Source,
+				archive.TargetDevice,
+				archive.Target)
+		})
+	commandRegistry.Register(
+		shared.CONTROL_CMD_DF_WRITE,
+		func(command commands.Command) (any, error) {
+			write := command.(*commands.WriteFloppy)
+
+			return nil, writeFloppy(
+				write.Source,
+				write.Pattern,
+				write.Target)
+		})
+	commandRegistry.Register(
+		shared.CONTROL_CMD_CREATE_ADF,
+		func(command commands.Command) (any, error) {
+			create := command.(*commands.CreateAdf)
+
+			return nil, createAdf(
+				create.TargetDevice,
+				create.Target,
+				create.VolumeName,
+				create.FFS)
+		})
+	commandRegistry.Register(
+		shared.CONTROL_CMD_SOFT_RESET,
+		func(command commands.Command) (any, error) {
+			return nil, softReset()
+		})
+	commandRegistry.Register(
+		shared.CONTROL_CMD_HARD_RESET,
+		func(command commands.Command) (any, error) {
+			return nil, hardReset()
+		})
+	commandRegistry.Register(
+		shared.CONTROL_CMD_TOGGLE_ZOOM,
+		func(command commands.Command) (any, error) {
+			return nil, toggleZoom()
+		})
+	commandRegistry.Register(
+		shared.CONTROL_CMD_WIFI_CONNECT,
+		func(command commands.Command) (any, error) {
+			connect := command.(*commands.WifiConnect)
+
+			return nil, wifiConnect(connect.CountryCode, connect.SSID, connect.Password)
+		})
+	commandRegistry.Register(
+		shared.CONTROL_CMD_WIFI_DISCONNECT,
+		func(command commands.Command) (any, error) {
+			return nil, wifiDisconect()
+		})
+	commandRegistry.Register(
+		shared.CONTROL_CMD_SWITCH_PROFILE,
+		func(command commands.Command) (any, error) {
+			return nil, switchProfile(command.(*commands.SwitchProfile).Profile)
+		})
+	commandRegistry.Register(
+		shared.CONTROL_CMD_SAVE_STATE,
+		func(command commands.Command) (any, error) {
+			return nil, saveState(command.(*commands.SaveState).Slot)
+		})
+	commandRegistry.Register(
+		shared.CONTROL_CMD_LOAD_STATE,
+		func(command commands.Command) (any, error) {
+			return nil, loadState(command.(*commands.LoadState).Slot)
+		})
+	commandRegistry.Register(
+		shared.CONTROL_CMD_CONFIG,
+		func(command commands.Command) (any, error) {
+			return components_amipi400.NewControlConfig(&emulator), nil
+		})
+	commandRegistry.Register(
+		shared.CONTROL_CMD_STATUS,
+		func(command commands.Command) (any, error) {
+			return components_amipi400.NewControlStatus(
+				&emulator,
+				mountpoints,
+				commandRegistry.ErrorCounts()), nil
+		})
+}
+
+// controlRequestCallback is the ControlServer front-end for the command
+// handlers, errors are returned to the client instead of blinking
+// the Num Lock LED
+func controlRequestCallback(name string, args map[string]string) (any, error) {
+	command, err := commands.FromArgs(name, args)
+
+	if err != nil {
+		return nil, commandRegistry.WrapError(name, err)
+	}
+
+	if !initialized.Load() && command.Name() != shared.CONTROL_CMD_STATUS {
+		return nil, commandRegistry.WrapError(name, commands.ErrNotReady)
+	}
+
+	commandMutex.Lock()
+	defer commandMutex.Unlock()
+
+	data, err := commandRegistry.Execute(command)
+
+	if err != nil {
+		log.Printf("Control command failed (%v): %v\n", commands.ErrorKind(err), err)
+	}
+
+	return data, err
+}
+
+func wifiDisconect() error {
 	powerLEDControl.BlinkPowerLEDSecs(shared.CMD_PENDING_BLINK_POWER_SECS)
 	defer powerLEDControl.BlinkPowerLEDSecs(shared.CMD_SUCCESS_BLINK_POWER_SECS)
 
@@ -573,12 +721,10 @@ func wifiDisconect() {
 
 	saveWifiSetting(false, "", "", "")
 
-	if wifiControl.GetLastError() != nil {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-	}
+	return wifiControl.GetLastError()
 }
 
-func wifiConnect(country_code_iso_iec_3166_1 string, ssid string, password string) {
+func wifiConnect(country_code_iso_iec_3166_1 string, ssid string, password string) error {
 	powerLEDControl.BlinkPowerLEDSecs(shared.CMD_PENDING_BLINK_POWER_SECS)
 	defer powerLEDControl.BlinkPowerLEDSecs(shared.CMD_SUCCESS_BLINK_POWER_SECS)
 
@@ -593,32 +739,28 @@ func wifiConnect(country_code_iso_iec_3166_1 string, ssid string, password strin
 
 	saveWifiSetting(true, countryCodeUpper, ssid, password)
 
-	if wifiControl.GetLastError() != nil {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-	}
+	return wifiControl.GetLastError()
+}
+
+func isValidIndex(index int, maxIndex int) bool {
+	return index >= 0 && index < maxIndex
 }
 
-func fillIndexes(indexStr string, maxIndex int) []int {
+func fillIndexes(index int, maxIndex int) []int {
 	indexes := make([]int, 0)
 
-	if indexStr == "N" {
+	if index == shared.DRIVE_INDEX_ALL {
 		for index := 0; index < maxIndex; index++ {
 			indexes = append(indexes, index)
 		}
-	} else {
-		indexInt, _ := utils.StringUtilsInstance.StringToInt(indexStr, 10, 16)
-
-		if indexInt > shared.MAX_ADFS-1 {
-			return indexes
-		}
-
-		indexes = append(indexes, indexInt)
+	} else if isValidIndex(index, maxIndex) {
+		indexes = append(indexes, index)
 	}
 
 	return indexes
 }
 
-func dfUnmountFromSourceIndex(sourceIndex string) {
+func dfUnmountFromSourceIndex(sourceIndex int) error {
 	powerLEDControl.BlinkPowerLEDSecs(shared.CMD_PENDING_BLINK_POWER_SECS)
 	defer powerLEDControl.BlinkPowerLEDSecs(shared.CMD_SUCCESS_BLINK_POWER_SECS)
 
@@ -640,11 +782,13 @@ func dfUnmountFromSourceIndex(sourceIndex string) {
 	}
 
 	if countFailed > 0 {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
+		return fmt.Errorf("%w %v DF medium(s)", commands.ErrUnmountFailed, countFailed)
 	}
+
+	return nil
 }
 
-func cdUnmountFromSourceIndex(sourceIndex string) {
+func cdUnmountFromSourceIndex(sourceIndex int) error {
 	powerLEDControl.BlinkPowerLEDSecs(shared.CMD_PENDING_BLINK_POWER_SECS)
 	defer powerLEDControl.BlinkPowerLEDSecs(shared.CMD_SUCCESS_BLINK_POWER_SECS)
 
@@ -666,15 +810,17 @@ func cdUnmountFromSourceIndex(sourceIndex string) {
 	}
 
 	if countFailed > 0 {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
+		return fmt.Errorf("%w %v CD medium(s)", commands.ErrUnmountFailed, countFailed)
 	}
+
+	return nil
 }
 
-func hfUnmountFromSourceIndex(sourceIndex string) {
-	dhUnmountFromSourceIndex(sourceIndex)
+func hfUnmountFromSourceIndex(sourceIndex int) error {
+	return dhUnmountFromSourceIndex(sourceIndex)
 }
 
-func dhUnmountFromSourceIndex(sourceIndex string) {
+func dhUnmountFromSourceIndex(sourceIndex int) error {
 	powerLEDControl.BlinkPowerLEDSecs(shared.CMD_PENDING_BLINK_POWER_SECS)
 	defer powerLEDControl.BlinkPowerLEDSecs(shared.CMD_SUCCESS_BLINK_POWER_SECS)
 
@@ -699,8 +845,10 @@ func dhUnmountFromSourceIndex(sourceIndex string) {
 	}
 
 	if countFailed > 0 {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
+		return fmt.Errorf("%w %v DH medium(s)", commands.ErrUnmountFailed, countFailed)
 	}
+
+	return nil
 }
 
 func copyFile(sourcePathname string, targetPathname string) error {
@@ -730,9 +878,9 @@ func copyFile(sourcePathname string, targetPathname string) error {
 
 func lowLevelCopy(
 	sourceLowLevelDevice string,
-	sourceIndex string,
+	sourceIndexInt int,
 	targetLowLevelDevice string,
-	targetIndex string) {
+	targetIndexInt int) error {
 	var sourceMountpoint *components_amipi400.Mountpoint
 	var targetMountpoint *components_amipi400.Mountpoint
 	powerLEDControl.BlinkPowerLEDSecs(shared.CMD_PENDING_BLINK_POWER_SECS)
@@ -744,51 +892,45 @@ func lowLevelCopy(
 		shared.LOW_LEVEL_DEVICE_HARD_DISK,
 	}
 
-	sourceIndexInt, _ := utils.StringUtilsInstance.StringToInt(sourceIndex, 10, 16)
-	targetIndexInt, _ := utils.StringUtilsInstance.StringToInt(targetIndex, 10, 16)
-
 	if !funk.ContainsString(supportedDevices, sourceLowLevelDevice) {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return fmt.Errorf("%w %v", commands.ErrUnsupportedDevice, sourceLowLevelDevice)
 	}
 
 	if !funk.ContainsString(supportedDevices, targetLowLevelDevice) {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return fmt.Errorf("%w %v", commands.ErrUnsupportedDevice, targetLowLevelDevice)
 	}
 
 	// valiate source/target indexes
 
 	// source
 	if sourceLowLevelDevice == shared.LOW_LEVEL_DEVICE_FLOPPY {
-		if sourceIndexInt > shared.MAX_ADFS-1 {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
+		if !isValidIndex(sourceIndexInt, shared.MAX_ADFS) {
+			return fmt.Errorf("%w %v%v", commands.ErrInvalidIndex, sourceLowLevelDevice, sourceIndexInt)
 		}
 	} else if sourceLowLevelDevice == shared.LOW_LEVEL_DEVICE_HARD_DISK {
-		if sourceIndexInt > shared.MAX_HDFS-1 {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
+		if !isValidIndex(sourceIndexInt, shared.MAX_HDFS) {
+			return fmt.Errorf("%w %v%v", commands.ErrInvalidIndex, sourceLowLevelDevice, sourceIndexInt)
 		}
 	}
 
 	// target
 	if targetLowLevelDevice == shared.LOW_LEVEL_DEVICE_FLOPPY {
-		if targetIndexInt > shared.MAX_ADFS-1 {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
+		if !isValidIndex(targetIndexInt, shared.MAX_ADFS) {
+			return fmt.Errorf("%w %v%v", commands.ErrInvalidIndex, targetLowLevelDevice, targetIndexInt)
 		}
-	} else if sourceLowLevelDevice == shared.LOW_LEVEL_DEVICE_HARD_DISK {
-		if targetIndexInt > shared.MAX_HDFS-1 {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
+	} else if targetLowLevelDevice == shared.LOW_LEVEL_DEVICE_HARD_DISK {
+		if !isValidIndex(targetIndexInt, shared.MAX_HDFS) {
+			return fmt.Errorf("%w %v%v", commands.ErrInvalidIndex, targetLowLevelDevice, targetIndexInt)
 		}
 	}
 
 	if sourceLowLevelDevice == targetLowLevelDevice {
 		if sourceIndexInt == targetIndexInt {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
+			return fmt.Errorf(
+				"%w %v%v to itself",
+				commands.ErrCopyFailed,
+				sourceLowLevelDevice,
+				sourceIndexInt)
 		}
 	}
 
@@ -817,46 +959,39 @@ func lowLevelCopy(
 		sourcePathname = emulator.GetAdf(sourceIndexInt)
 
 		if sourcePathname == "" {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
+			return fmt.Errorf("ADF %w to DF%v", commands.ErrNotAttached, sourceIndexInt)
 		}
 
 		if !detachAdf(sourceIndexInt, sourcePathname) {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
+			return fmt.Errorf("%w %v from DF%v", commands.ErrDetachFailed, sourcePathname, sourceIndexInt)
 		}
 
 		sourceMountpoint = mountpoints.GetMountpointByDFIndex(sourceIndexInt)
 
 		if sourceMountpoint == nil {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
+			return fmt.Errorf("%w as DF%v", commands.ErrNoMountpoint, sourceIndexInt)
 		}
 	} else if sourceLowLevelDevice == shared.LOW_LEVEL_DEVICE_HARD_DISK {
 		sourcePathname = emulator.GetHd(sourceIndexInt)
 
 		if sourcePathname == "" {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
+			return fmt.Errorf("HDF %w to DH%v", commands.ErrNotAttached, sourceIndexInt)
 		}
 
 		sourceIsHdf := strings.HasSuffix(sourcePathname, shared.HD_HDF_FULL_EXTENSION)
 
 		if !sourceIsHdf {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
+			return fmt.Errorf("%w: %v attached to DH%v is not HDF file", commands.ErrWrongMediumType, sourcePathname, sourceIndexInt)
 		}
 
 		if !detachHd(sourceIndexInt, sourcePathname) {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
+			return fmt.Errorf("%w %v from DH%v", commands.ErrDetachFailed, sourcePathname, sourceIndexInt)
 		}
 
 		sourceMountpoint = mountpoints.GetMountpointByDHIndex(sourceIndexInt)
 
 		if sourceMountpoint == nil {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
+			return fmt.Errorf("%w as DH%v", commands.ErrNoMountpoint, sourceIndexInt)
 		}
 	}
 
@@ -864,112 +999,606 @@ func lowLevelCopy(
 	if targetLowLevelDevice == shared.LOW_LEVEL_DEVICE_FLOPPY {
 		targetPathname = emulator.GetAdf(targetIndexInt)
 
-		if targetPathname == "" {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
-		}
+		if targetPathname == "" {
+			return fmt.Errorf("ADF %w to DF%v", commands.ErrNotAttached, targetIndexInt)
+		}
+
+		if !detachAdf(targetIndexInt, targetPathname) {
+			return fmt.Errorf("%w %v from DF%v", commands.ErrDetachFailed, targetPathname, targetIndexInt)
+		}
+
+		targetMountpoint = mountpoints.GetMountpointByDFIndex(targetIndexInt)
+
+		if targetMountpoint == nil {
+			return fmt.Errorf("%w as DF%v", commands.ErrNoMountpoint, targetIndexInt)
+		}
+	} else if targetLowLevelDevice == shared.LOW_LEVEL_DEVICE_HARD_DISK {
+		targetPathname = emulator.GetHd(targetIndexInt)
+
+		if targetPathname == "" {
+			return fmt.Errorf("HDF %w to DH%v", commands.ErrNotAttached, targetIndexInt)
+		}
+
+		targetIsHdf := strings.HasSuffix(targetPathname, shared.HD_HDF_FULL_EXTENSION)
+
+		if !targetIsHdf {
+			return fmt.Errorf("%w: %v attached to DH%v is not HDF file", commands.ErrWrongMediumType, targetPathname, targetIndexInt)
+		}
+
+		if !detachHd(targetIndexInt, targetPathname) {
+			return fmt.Errorf("%w %v from DH%v", commands.ErrDetachFailed, targetPathname, targetIndexInt)
+		}
+
+		targetMountpoint = mountpoints.GetMountpointByDHIndex(targetIndexInt)
+
+		if targetMountpoint == nil {
+			return fmt.Errorf("%w as DH%v", commands.ErrNoMountpoint, targetIndexInt)
+		}
+	}
+
+	log.Println("Low-level copying", sourcePathname, "to", targetPathname)
+
+	utils.UnixUtilsInstance.Sync()
+
+	if err := copyFile(sourcePathname, targetPathname); err != nil {
+		if err != io.EOF {
+			return fmt.Errorf("%w %v to %v: %v", commands.ErrCopyFailed, sourcePathname, targetPathname, err)
+		}
+	}
+
+	utils.UnixUtilsInstance.Sync()
+
+	// attach both
+	// source
+	if sourceLowLevelDevice == shared.LOW_LEVEL_DEVICE_FLOPPY {
+		if !attachAdf(sourceIndexInt, sourcePathname) {
+			return fmt.Errorf("%w %v to DF%v", commands.ErrAttachFailed, sourcePathname, sourceIndexInt)
+		}
+	} else if sourceLowLevelDevice == shared.LOW_LEVEL_DEVICE_HARD_DISK {
+		if !attachHdf(sourceIndexInt, sourceMountpoint.DHBootPriority, sourcePathname) {
+			return fmt.Errorf("%w %v to DH%v", commands.ErrAttachFailed, sourcePathname, sourceIndexInt)
+		}
+	}
+
+	// target
+	if targetLowLevelDevice == shared.LOW_LEVEL_DEVICE_FLOPPY {
+		if !attachAdf(targetIndexInt, targetPathname) {
+			return fmt.Errorf("%w %v to DF%v", commands.ErrAttachFailed, targetPathname, targetIndexInt)
+		}
+	} else if targetLowLevelDevice == shared.LOW_LEVEL_DEVICE_HARD_DISK {
+		if !attachHdf(targetIndexInt, targetMountpoint.DHBootPriority, targetPathname) {
+			return fmt.Errorf("%w %v to DH%v", commands.ErrAttachFailed, targetPathname, targetIndexInt)
+		}
+	}
+
+	utils.UnixUtilsInstance.Sync()
+
+	return nil
+}
+
+// getPhysicalFloppyAdfPathname returns pathname of the ADF file
+// exposed by amiga_disk_devices for the physical floppy drive
+func getPhysicalFloppyAdfPathname(index int) (string, error) {
+	floppyDevices := driveDevicesDiscovery.GetFloppies()
+
+	if index >= len(floppyDevices) {
+		return "", fmt.Errorf("%w physical floppy drive DF%v", commands.ErrNotFound, index)
+	}
+
+	// the name may contain the volume name
+	// of the disk, so look for the device
+	for _, pathname := range amigaDiskDevicesDiscovery.GetFiles() {
+		if filepath.Ext(pathname) != shared.FLOPPY_ADF_FULL_EXTENSION {
+			continue
+		}
+
+		if publicPathnameToDevicePathname(pathname) == floppyDevices[index] {
+			return pathname, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w disk in physical floppy drive DF%v", commands.ErrNotFound, index)
+}
+
+// runFloppyCopy runs the copy of the physical floppy with
+// commandMutex unlocked, so the other commands and the
+// attach/detach callbacks are not blocked for minutes,
+// the floppy cannot be attached nor copied again
+// meanwhile, commandMutex must be locked by the caller
+func runFloppyCopy(pathname string, fn func() error) error {
+	if floppyCopyPathname != "" {
+		return fmt.Errorf("%w: %v", commands.ErrBusy, floppyCopyPathname)
+	}
+
+	floppyCopyPathname = pathname
+	commandMutex.Unlock()
+
+	defer func() {
+		commandMutex.Lock()
+		floppyCopyPathname = ""
+	}()
+
+	return fn()
+}
+
+// showFloppyCopyProgress shows the message from the
+// copy run by runFloppyCopy (commandMutex unlocked)
+func showFloppyCopyProgress(format string, a ...any) {
+	commandMutex.Lock()
+	defer commandMutex.Unlock()
+
+	showOSDMessage(format, a...)
+}
+
+// readPhysicalFloppy reads whole disk track by track,
+// the progress is logged and displayed on the OSD,
+// it is run by runFloppyCopy
+func readPhysicalFloppy(pathname string, index int) ([]byte, error) {
+	handle, err := os.Open(pathname)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer handle.Close()
+
+	data := make([]byte, 0, shared.FLOPPY_ADF_SIZE)
+	oldPercent := 0
+
+	for offset := 0; offset < shared.FLOPPY_ADF_SIZE; offset += shared.FLOPPY_TRACK_SIZE {
+		track, n, err := utils.FileUtilsInstance.FileReadBytes(
+			pathname,
+			int64(offset),
+			shared.FLOPPY_TRACK_SIZE,
+			0,
+			0,
+			handle)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if n != shared.FLOPPY_TRACK_SIZE {
+			return nil, fmt.Errorf("short read at offset %v", offset)
+		}
+
+		data = append(data, track...)
+
+		percent := len(data) * 100 / shared.FLOPPY_ADF_SIZE
+
+		if percent-oldPercent >= shared.ARCHIVE_PROGRESS_STEP_PERCENT {
+			log.Println(percent, "%")
+			showFloppyCopyProgress("Reading DF%v %v%%", index, percent)
+
+			oldPercent = percent
+		}
+	}
+
+	return data, nil
+}
+
+// getArchiveAdfPathname returns not existing pathname in the dir,
+// named after the volume name, like "Workbench.adf" or
+// "Workbench (2).adf" when the first one already exists
+func getArchiveAdfPathname(dir string, volumeName string) string {
+	filename := strings.Map(func(r rune) rune {
+		if r < ' ' || strings.ContainsRune(shared.ARCHIVE_FILENAME_INVALID_CHARS, r) {
+			return '_'
+		}
+
+		return r
+	}, volumeName)
+
+	// FAT does not like leading/trailing spaces and dots
+	filename = strings.Trim(filename, " .")
+
+	if filename == "" {
+		filename = shared.ARCHIVE_DEFAULT_VOLUME_NAME
+	}
+
+	pathname := filepath.Join(dir, filename+shared.FLOPPY_ADF_FULL_EXTENSION)
+
+	for no := 2; ; no++ {
+		if _, err := os.Stat(pathname); err != nil {
+			break
+		}
+
+		pathname = filepath.Join(
+			dir,
+			fmt.Sprintf("%v (%v)%v", filename, no, shared.FLOPPY_ADF_FULL_EXTENSION))
+	}
+
+	return pathname
+}
+
+// getAdfTargetMountpoint returns the medium mounted
+// as DF or DH, where new ADF files can be stored
+func getAdfTargetMountpoint(
+	targetLowLevelDevice string,
+	targetIndexInt int,
+) (*components_amipi400.Mountpoint, error) {
+	var targetMountpoint *components_amipi400.Mountpoint
+
+	if targetLowLevelDevice == shared.LOW_LEVEL_DEVICE_FLOPPY {
+		if !isValidIndex(targetIndexInt, shared.MAX_ADFS) {
+			return nil, fmt.Errorf("%w %v%v", commands.ErrInvalidIndex, targetLowLevelDevice, targetIndexInt)
+		}
+
+		targetMountpoint = mountpoints.GetMountpointByDFIndex(targetIndexInt)
+	} else if targetLowLevelDevice == shared.LOW_LEVEL_DEVICE_HARD_DISK {
+		if !isValidIndex(targetIndexInt, shared.MAX_HDFS) {
+			return nil, fmt.Errorf("%w %v%v", commands.ErrInvalidIndex, targetLowLevelDevice, targetIndexInt)
+		}
+
+		targetMountpoint = mountpoints.GetMountpointByDHIndex(targetIndexInt)
+	} else {
+		return nil, fmt.Errorf("%w %v", commands.ErrUnsupportedDevice, targetLowLevelDevice)
+	}
+
+	if targetMountpoint == nil {
+		return nil, fmt.Errorf("%w as %v%v", commands.ErrNoMountpoint, targetLowLevelDevice, targetIndexInt)
+	}
+
+	return targetMountpoint, nil
+}
+
+// verifyArchivedAdf compares the file with data, the file
+// is dropped from the page cache before, so it is read
+// back from the medium
+func verifyArchivedAdf(pathname string, data []byte) error {
+	handle, err := os.OpenFile(pathname, os.O_RDONLY, 0)
+
+	if err != nil {
+		return err
+	}
+
+	defer handle.Close()
+
+	if err := utils.UnixUtilsInstance.DropFileCache(handle); err != nil {
+		return err
+	}
+
+	written, n, err := utils.FileUtilsInstance.FileReadBytes(pathname, 0, -1, 0, 0, handle)
+
+	if err != nil {
+		return err
+	}
+
+	if n != len(data) ||
+		utils.CryptoUtilsInstance.BytesToSha512Hex(written[:n]) != utils.CryptoUtilsInstance.BytesToSha512Hex(data) {
+		return errors.New("SHA512 differs")
+	}
+
+	return nil
+}
+
+// archiveFloppy dumps the disk in the physical floppy drive
+// to the ADF file on the medium mounted as DF or DH
+func archiveFloppy(sourceIndexInt int, targetLowLevelDevice string, targetIndexInt int) error {
+	powerLEDControl.BlinkPowerLEDSecs(shared.CMD_PENDING_BLINK_POWER_SECS)
+	defer powerLEDControl.BlinkPowerLEDSecs(shared.CMD_SUCCESS_BLINK_POWER_SECS)
+
+	if !isValidIndex(sourceIndexInt, shared.MAX_ADFS) {
+		return fmt.Errorf("%w DF%v", commands.ErrInvalidIndex, sourceIndexInt)
+	}
+
+	if _, err := getAdfTargetMountpoint(targetLowLevelDevice, targetIndexInt); err != nil {
+		return err
+	}
+
+	sourcePathname, err := getPhysicalFloppyAdfPathname(sourceIndexInt)
+
+	if err != nil {
+		return err
+	}
+
+	log.Println("Reading", sourcePathname)
+
+	var data []byte
+
+	err = runFloppyCopy(sourcePathname, func() (err error) {
+		data, err = readPhysicalFloppy(sourcePathname, sourceIndexInt)
+
+		return err
+	})
+
+	if err != nil {
+		if errors.Is(err, commands.ErrBusy) {
+			return err
+		}
+
+		return fmt.Errorf("%w %v: %v", commands.ErrCopyFailed, sourcePathname, err)
+	}
+
+	// the medium may be unmounted meanwhile
+	targetMountpoint, err := getAdfTargetMountpoint(targetLowLevelDevice, targetIndexInt)
+
+	if err != nil {
+		return err
+	}
+
+	volumeName, err := amigafs.ReadVolumeName(data)
+
+	if err != nil {
+		log.Println(sourcePathname, err)
+
+		volumeName = shared.ARCHIVE_DEFAULT_VOLUME_NAME
+	}
+
+	targetPathname := getArchiveAdfPathname(targetMountpoint.Mountpoint, volumeName)
+	tmpPathname := targetPathname + shared.ARCHIVE_TMP_EXTENSION
+
+	log.Println("Archiving", sourcePathname, "to", targetPathname)
+
+	n, err := utils.FileUtilsInstance.FileWriteBytes(
+		tmpPathname,
+		0,
+		data,
+		os.O_CREATE|os.O_WRONLY|os.O_TRUNC,
+		0777,
+		nil)
+
+	if err == nil && n != len(data) {
+		err = io.ErrShortWrite
+	}
+
+	if err != nil {
+		os.Remove(tmpPathname)
+
+		return fmt.Errorf("%w %v to %v: %v", commands.ErrCopyFailed, sourcePathname, tmpPathname, err)
+	}
+
+	utils.UnixUtilsInstance.Sync()
+
+	if err := verifyArchivedAdf(tmpPathname, data); err != nil {
+		os.Remove(tmpPathname)
+
+		return fmt.Errorf("%w %v: %v", commands.ErrVerifyFailed, tmpPathname, err)
+	}
+
+	if err := os.Rename(tmpPathname, targetPathname); err != nil {
+		os.Remove(tmpPathname)
+
+		return fmt.Errorf("%w %v to %v: %v", commands.ErrCopyFailed, tmpPathname, targetPathname, err)
+	}
+
+	utils.UnixUtilsInstance.Sync()
+
+	if targetLowLevelDevice == shared.LOW_LEVEL_DEVICE_FLOPPY {
+		targetMountpoint.LoadFiles([]string{shared.FLOPPY_ADF_FULL_EXTENSION})
+	}
+
+	log.Println("Archived", sourcePathname, "to", targetPathname)
+	showOSDMessage("DF%v archived as %v", sourceIndexInt, getMediumName(targetPathname))
+
+	return nil
+}
+
+// createAdf creates blank ADF on the medium mounted
+// as DF or DH, named after the volume
+func createAdf(targetLowLevelDevice string, targetIndexInt int, volumeName string, ffs bool) error {
+	powerLEDControl.BlinkPowerLEDSecs(shared.CMD_PENDING_BLINK_POWER_SECS)
+	defer powerLEDControl.BlinkPowerLEDSecs(shared.CMD_SUCCESS_BLINK_POWER_SECS)
+
+	targetMountpoint, err := getAdfTargetMountpoint(targetLowLevelDevice, targetIndexInt)
+
+	if err != nil {
+		return err
+	}
+
+	if volumeName == "" {
+		volumeName = shared.AMIGAFS_DEFAULT_VOLUME_NAME
+	}
+
+	// INTL is the default for FFS since Kickstart 2.0
+	image, err := amigafs.NewImage(
+		shared.FLOPPY_ADF_SIZE,
+		amigafs.FormatOptions{
+			VolumeName:    volumeName,
+			FFS:           ffs,
+			International: ffs})
+
+	if err != nil {
+		return fmt.Errorf("%w: %v %v", commands.ErrInvalidCommand, err, volumeName)
+	}
+
+	targetPathname := getArchiveAdfPathname(targetMountpoint.Mountpoint, volumeName)
+
+	log.Println("Creating", targetPathname)
+
+	// existing file is never overwritten (nor removed),
+	// umask is cleared like FileWriteBytes does
+	oldUmask := syscall.Umask(0)
+	handle, err := os.OpenFile(targetPathname, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0777)
+	syscall.Umask(oldUmask)
+
+	if err != nil {
+		return fmt.Errorf("%w %v: %v", commands.ErrCopyFailed, targetPathname, err)
+	}
+
+	n, err := utils.FileUtilsInstance.FileWriteBytes(targetPathname, 0, image, 0, 0, handle)
+
+	if err == nil && n != len(image) {
+		err = io.ErrShortWrite
+	}
+
+	if closeErr := handle.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		// created by this call
+		os.Remove(targetPathname)
+
+		return fmt.Errorf("%w %v: %v", commands.ErrCopyFailed, targetPathname, err)
+	}
+
+	utils.UnixUtilsInstance.Sync()
+
+	if targetLowLevelDevice == shared.LOW_LEVEL_DEVICE_FLOPPY {
+		targetMountpoint.LoadFiles([]string{shared.FLOPPY_ADF_FULL_EXTENSION})
+	}
+
+	showOSDMessage("%v created on %v%v", getMediumName(targetPathname), targetLowLevelDevice, targetIndexInt)
+
+	return nil
+}
+
+// writePhysicalFloppy writes the whole disk track by track, the
+// progress is logged and displayed on the OSD, amiga_disk_devices
+// verifies the disk when the writes are synced, it is
+// run by runFloppyCopy
+func writePhysicalFloppy(pathname string, index int, data []byte) error {
+	// truncating starts rewriting of the whole disk,
+	// the writes go through the cached ADF then
+	if err := os.Truncate(pathname, 0); err != nil {
+		return fmt.Errorf("%w %v: %v", commands.ErrCopyFailed, pathname, err)
+	}
+
+	handle, err := os.OpenFile(pathname, os.O_WRONLY, 0)
+
+	if err != nil {
+		return fmt.Errorf("%w %v: %v", commands.ErrCopyFailed, pathname, err)
+	}
+
+	defer handle.Close()
+
+	oldPercent := 0
+
+	for offset := 0; offset < len(data); offset += shared.FLOPPY_TRACK_SIZE {
+		n, err := utils.FileUtilsInstance.FileWriteBytes(
+			pathname,
+			int64(offset),
+			data[offset:offset+shared.FLOPPY_TRACK_SIZE],
+			0,
+			0,
+			handle)
+
+		if err == nil && n != shared.FLOPPY_TRACK_SIZE {
+			err = io.ErrShortWrite
+		}
+
+		if err != nil {
+			return fmt.Errorf("%w %v at offset %v: %v", commands.ErrCopyFailed, pathname, offset, err)
+		}
+
+		percent := (offset + n) * 100 / len(data)
+
+		if percent-oldPercent >= shared.ARCHIVE_PROGRESS_STEP_PERCENT {
+			log.Println(percent, "%")
+			showFloppyCopyProgress("Writing DF%v %v%%", index, percent)
+
+			oldPercent = percent
+		}
+	}
+
+	log.Println("Waiting for", pathname, "to be written and verified")
+	showFloppyCopyProgress("Verifying DF%v", index)
+
+	if err := handle.Sync(); err != nil {
+		return fmt.Errorf("%w %v: %v", commands.ErrVerifyFailed, pathname, err)
+	}
+
+	return nil
+}
+
+// writeFloppy writes ADF from the medium mounted
+// as DF to the disk in the physical floppy drive
+func writeFloppy(sourceIndexInt int, filenamePart string, targetIndexInt int) error {
+	powerLEDControl.BlinkPowerLEDSecs(shared.CMD_PENDING_BLINK_POWER_SECS)
+	defer powerLEDControl.BlinkPowerLEDSecs(shared.CMD_SUCCESS_BLINK_POWER_SECS)
+
+	filenamePart = strings.TrimSpace(filenamePart)
 
-		if !detachAdf(targetIndexInt, targetPathname) {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
-		}
+	if filenamePart == "" {
+		return commands.ErrEmptyPattern
+	}
 
-		targetMountpoint = mountpoints.GetMountpointByDFIndex(targetIndexInt)
+	if !isValidIndex(sourceIndexInt, shared.MAX_ADFS) || !isValidIndex(targetIndexInt, shared.MAX_ADFS) {
+		return fmt.Errorf("%w DF%v or DF%v", commands.ErrInvalidIndex, sourceIndexInt, targetIndexInt)
+	}
 
-		if targetMountpoint == nil {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
-		}
-	} else if targetLowLevelDevice == shared.LOW_LEVEL_DEVICE_HARD_DISK {
-		targetPathname = emulator.GetHd(targetIndexInt)
+	mountpoint := mountpoints.GetMountpointByDFIndex(sourceIndexInt)
 
-		if targetPathname == "" {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
-		}
+	if mountpoint == nil {
+		return fmt.Errorf("%w as DF%v", commands.ErrNoMountpoint, sourceIndexInt)
+	}
 
-		targetIsHdf := strings.HasSuffix(targetPathname, shared.HD_HDF_FULL_EXTENSION)
+	sourcePathname := findSimilarROMFile(mountpoint, filenamePart)
 
-		if !targetIsHdf {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
-		}
+	if sourcePathname == "" {
+		return fmt.Errorf("%w ADF matching %v on DF%v medium", commands.ErrNotFound, filenamePart, sourceIndexInt)
+	}
 
-		if !detachHd(targetIndexInt, targetPathname) {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
-		}
+	data, _, err := utils.FileUtilsInstance.FileReadBytes(sourcePathname, 0, -1, 0, 0, nil)
 
-		targetMountpoint = mountpoints.GetMountpointByDHIndex(targetIndexInt)
+	if err != nil {
+		return fmt.Errorf("%w %v: %v", commands.ErrCopyFailed, sourcePathname, err)
+	}
 
-		if targetMountpoint == nil {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
-		}
+	if len(data) != shared.FLOPPY_ADF_SIZE {
+		return fmt.Errorf("%w: %v is not %v bytes ADF", commands.ErrWrongMediumType, sourcePathname, shared.FLOPPY_ADF_SIZE)
 	}
 
-	log.Println("Low-level copying", sourcePathname, "to", targetPathname)
+	targetPathname, err := getPhysicalFloppyAdfPathname(targetIndexInt)
 
-	utils.UnixUtilsInstance.Sync()
+	if err != nil {
+		return err
+	}
 
-	if err := copyFile(sourcePathname, targetPathname); err != nil {
-		if err != io.EOF {
-			log.Println(err)
+	if floppyCopyPathname != "" {
+		return fmt.Errorf("%w: %v", commands.ErrBusy, floppyCopyPathname)
+	}
 
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
+	// the emulator cannot use the disk while it is rewritten
+	if attachedIndex := isAdfAttached(targetPathname); attachedIndex != shared.DRIVE_INDEX_UNSPECIFIED {
+		if !detachAdf(attachedIndex, targetPathname) {
+			return fmt.Errorf("%w %v from DF%v", commands.ErrDetachFailed, targetPathname, attachedIndex)
 		}
-	}
 
-	utils.UnixUtilsInstance.Sync()
+		defer func() {
+			// the disk may be removed meanwhile
+			if !amigaDiskDevicesDiscovery.HasFile(targetPathname) {
+				return
+			}
 
-	// attach both
-	// source
-	if sourceLowLevelDevice == shared.LOW_LEVEL_DEVICE_FLOPPY {
-		if !attachAdf(sourceIndexInt, sourcePathname) {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
-		}
-	} else if sourceLowLevelDevice == shared.LOW_LEVEL_DEVICE_HARD_DISK {
-		if !attachHdf(sourceIndexInt, sourceMountpoint.DHBootPriority, sourcePathname) {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
-		}
+			if !attachAdf(attachedIndex, targetPathname) {
+				log.Printf("%v %v to DF%v\n", commands.ErrAttachFailed, targetPathname, attachedIndex)
+			}
+		}()
 	}
 
-	// target
-	if targetLowLevelDevice == shared.LOW_LEVEL_DEVICE_FLOPPY {
-		if !attachAdf(targetIndexInt, targetPathname) {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
-		}
-	} else if targetLowLevelDevice == shared.LOW_LEVEL_DEVICE_HARD_DISK {
-		if !attachHdf(targetIndexInt, targetMountpoint.DHBootPriority, targetPathname) {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
-		}
+	log.Println("Writing", sourcePathname, "to", targetPathname)
+
+	// reading the disk back here would read the cached ADF,
+	// the disk itself is verified by amiga_disk_devices
+	// before the sync returns
+	err = runFloppyCopy(targetPathname, func() error {
+		return writePhysicalFloppy(targetPathname, targetIndexInt, data)
+	})
+
+	if err != nil {
+		return err
 	}
 
-	utils.UnixUtilsInstance.Sync()
+	log.Println("Written", sourcePathname, "to", targetPathname)
+	showOSDMessage("%v written to DF%v", getMediumName(sourcePathname), targetIndexInt)
+
+	return nil
 }
 
 func dfInsertFromSourceIndexToTargetIndexByDiskNo(
-	diskNo, sourceIndex, targetIndex string,
-) {
+	diskNoInt, sourceIndexInt, targetIndexInt int,
+) error {
 	powerLEDControl.BlinkPowerLEDSecs(shared.CMD_PENDING_BLINK_POWER_SECS)
 	defer powerLEDControl.BlinkPowerLEDSecs(shared.CMD_SUCCESS_BLINK_POWER_SECS)
 
-	diskNoInt, _ := utils.StringUtilsInstance.StringToInt(diskNo, 10, 16)
-	sourceIndexInt, _ := utils.StringUtilsInstance.StringToInt(sourceIndex, 10, 16)
-	targetIndexInt, _ := utils.StringUtilsInstance.StringToInt(targetIndex, 10, 16)
-
 	if targetIndexInt == shared.DRIVE_INDEX_UNSPECIFIED {
 		targetIndexInt = sourceIndexInt
 	}
 
-	if sourceIndexInt > shared.MAX_ADFS-1 || targetIndexInt > shared.MAX_ADFS-1 {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+	if !isValidIndex(sourceIndexInt, shared.MAX_ADFS) || !isValidIndex(targetIndexInt, shared.MAX_ADFS) {
+		return fmt.Errorf("%w DF%v or DF%v", commands.ErrInvalidIndex, sourceIndexInt, targetIndexInt)
 	}
 
 	mountpoint := mountpoints.GetMountpointByDFIndex(sourceIndexInt)
@@ -977,8 +1606,7 @@ func dfInsertFromSourceIndexToTargetIndexByDiskNo(
 	if mountpoint == nil {
 		// allow to manage only these drives mounted by amipi400.go
 		// so skip these from amiga_disk_devices.go
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return fmt.Errorf("%w as DF%v", commands.ErrNoMountpoint, sourceIndexInt)
 	}
 
 	sourceIndexAdf := emulator.GetAdf(sourceIndexInt)
@@ -987,19 +1615,16 @@ func dfInsertFromSourceIndexToTargetIndexByDiskNo(
 	if targetIndexAdf != "" {
 		if amigaDiskDevicesDiscovery.HasFile(targetIndexAdf) {
 			// ADF attached by amiga_disk_devices.go
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
+			return fmt.Errorf("DF%v is %w", targetIndexInt, commands.ErrManagedExternally)
 		}
 
 		if !detachAdf(targetIndexInt, targetIndexAdf) {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
+			return fmt.Errorf("%w %v from DF%v", commands.ErrDetachFailed, targetIndexAdf, targetIndexInt)
 		}
 	}
 
 	if sourceIndexAdf == "" {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return fmt.Errorf("ADF %w to DF%v", commands.ErrNotAttached, sourceIndexInt)
 	}
 
 	foundAdfPathnames := findSimilarROMFiles(mountpoint, sourceIndexAdf)
@@ -1007,8 +1632,7 @@ func dfInsertFromSourceIndexToTargetIndexByDiskNo(
 	toInsertPathname := ""
 
 	if lenFoundAdfPathnames == 0 {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return fmt.Errorf("%w ADFs similar to %v", commands.ErrNotFound, sourceIndexAdf)
 	}
 
 	requiredDiskNoOfMax := fmt.Sprintf(
@@ -1025,48 +1649,45 @@ func dfInsertFromSourceIndexToTargetIndexByDiskNo(
 	}
 
 	if toInsertPathname == "" {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return fmt.Errorf("%w ADF %v for %v", commands.ErrNotFound, requiredDiskNoOfMax, sourceIndexAdf)
 	}
 
 	if attachedIndex := isAdfAttached(toInsertPathname); attachedIndex != shared.DRIVE_INDEX_UNSPECIFIED {
 		if !detachAdf(attachedIndex, toInsertPathname) {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
+			return fmt.Errorf("%w %v from DF%v", commands.ErrDetachFailed, toInsertPathname, attachedIndex)
 		}
 	}
 
 	if !attachAdf(targetIndexInt, toInsertPathname) {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return fmt.Errorf("%w %v to DF%v", commands.ErrAttachFailed, toInsertPathname, targetIndexInt)
 	}
+
+	return nil
 }
 
-func dfInsertFromSourceIndexToTargetIndex(filenamePart, sourceIndex, targetIndex string) {
-	if targetIndex == "N" {
-		dfInsertFromSourceIndexToManyIndex(filenamePart, sourceIndex)
-		return
+func dfInsertFromSourceIndexToTargetIndex(
+	filenamePart string,
+	sourceIndexInt, targetIndexInt int,
+) error {
+	if targetIndexInt == shared.DRIVE_INDEX_ALL {
+		return dfInsertFromSourceIndexToManyIndex(filenamePart, sourceIndexInt)
 	}
 
 	powerLEDControl.BlinkPowerLEDSecs(shared.CMD_PENDING_BLINK_POWER_SECS)
 	defer powerLEDControl.BlinkPowerLEDSecs(shared.CMD_SUCCESS_BLINK_POWER_SECS)
 
 	filenamePart = strings.TrimSpace(filenamePart)
-	sourceIndexInt, _ := utils.StringUtilsInstance.StringToInt(sourceIndex, 10, 16)
-	targetIndexInt, _ := utils.StringUtilsInstance.StringToInt(targetIndex, 10, 16)
 
 	if filenamePart == "" {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return commands.ErrEmptyPattern
 	}
 
 	if targetIndexInt == shared.DRIVE_INDEX_UNSPECIFIED {
 		targetIndexInt = sourceIndexInt
 	}
 
-	if sourceIndexInt > shared.MAX_ADFS-1 || targetIndexInt > shared.MAX_ADFS-1 {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+	if !isValidIndex(sourceIndexInt, shared.MAX_ADFS) || !isValidIndex(targetIndexInt, shared.MAX_ADFS) {
+		return fmt.Errorf("%w DF%v or DF%v", commands.ErrInvalidIndex, sourceIndexInt, targetIndexInt)
 	}
 
 	mountpoint := mountpoints.GetMountpointByDFIndex(sourceIndexInt)
@@ -1074,8 +1695,7 @@ func dfInsertFromSourceIndexToTargetIndex(filenamePart, sourceIndex, targetIndex
 	if mountpoint == nil {
 		// allow to manage only these drives mounted by amipi400.go
 		// so skip these from amiga_disk_devices.go
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return fmt.Errorf("%w as DF%v", commands.ErrNoMountpoint, sourceIndexInt)
 	}
 
 	targetIndexAdf := emulator.GetAdf(targetIndexInt)
@@ -1083,56 +1703,52 @@ func dfInsertFromSourceIndexToTargetIndex(filenamePart, sourceIndex, targetIndex
 	if targetIndexAdf != "" {
 		if amigaDiskDevicesDiscovery.HasFile(targetIndexAdf) {
 			// ADF attached by amiga_disk_devices.go
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
+			return fmt.Errorf("DF%v is %w", targetIndexInt, commands.ErrManagedExternally)
 		}
 
 		if !detachAdf(targetIndexInt, targetIndexAdf) {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
+			return fmt.Errorf("%w %v from DF%v", commands.ErrDetachFailed, targetIndexAdf, targetIndexInt)
 		}
 	}
 
 	foundAdfPathname := findSimilarROMFile(mountpoint, filenamePart)
 
 	if foundAdfPathname == "" {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return fmt.Errorf("%w ADF matching %v on DF%v medium", commands.ErrNotFound, filenamePart, sourceIndexInt)
 	}
 
 	if attachedIndex := isAdfAttached(foundAdfPathname); attachedIndex != shared.DRIVE_INDEX_UNSPECIFIED {
 		if !detachAdf(attachedIndex, foundAdfPathname) {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
+			return fmt.Errorf("%w %v from DF%v", commands.ErrDetachFailed, foundAdfPathname, attachedIndex)
 		}
 	}
 
 	if !attachAdf(targetIndexInt, foundAdfPathname) {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return fmt.Errorf("%w %v to DF%v", commands.ErrAttachFailed, foundAdfPathname, targetIndexInt)
 	}
+
+	return nil
 }
 
-func hfInsertFromSourceIndexToTargetIndex(filenamePart, sourceIndex, targetIndex string) {
+func hfInsertFromSourceIndexToTargetIndex(
+	filenamePart string,
+	sourceIndexInt, targetIndexInt int,
+) error {
 	powerLEDControl.BlinkPowerLEDSecs(shared.CMD_PENDING_BLINK_POWER_SECS)
 	defer powerLEDControl.BlinkPowerLEDSecs(shared.CMD_SUCCESS_BLINK_POWER_SECS)
 
 	filenamePart = strings.TrimSpace(filenamePart)
-	sourceIndexInt, _ := utils.StringUtilsInstance.StringToInt(sourceIndex, 10, 16)
-	targetIndexInt, _ := utils.StringUtilsInstance.StringToInt(targetIndex, 10, 16)
 
 	if filenamePart == "" {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return commands.ErrEmptyPattern
 	}
 
 	if targetIndexInt == shared.DRIVE_INDEX_UNSPECIFIED {
 		targetIndexInt = sourceIndexInt
 	}
 
-	if sourceIndexInt > shared.MAX_HDFS-1 || targetIndexInt > shared.MAX_HDFS-1 {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+	if !isValidIndex(sourceIndexInt, shared.MAX_HDFS) || !isValidIndex(targetIndexInt, shared.MAX_HDFS) {
+		return fmt.Errorf("%w DH%v or DH%v", commands.ErrInvalidIndex, sourceIndexInt, targetIndexInt)
 	}
 
 	mountpoint := mountpoints.GetMountpointByDHIndex(sourceIndexInt)
@@ -1140,7 +1756,7 @@ func hfInsertFromSourceIndexToTargetIndex(filenamePart, sourceIndex, targetIndex
 	if mountpoint == nil {
 		// allow to manage only these drives mounted by amipi400.go
 		// so skip these from amiga_disk_devices.go
-		return
+		return fmt.Errorf("%w as DH%v", commands.ErrNoMountpoint, sourceIndexInt)
 	}
 
 	matched := utils.RegExInstance.FindNamedMatches(
@@ -1150,8 +1766,7 @@ func hfInsertFromSourceIndexToTargetIndex(filenamePart, sourceIndex, targetIndex
 
 	if !isHdLabel {
 		// source medium is not HF (perhaps DH), cannot use it
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return fmt.Errorf("%w: medium %v is not HF medium", commands.ErrWrongMediumType, mountpoint.Label)
 	}
 
 	onHDOperationStart()
@@ -1162,27 +1777,23 @@ func hfInsertFromSourceIndexToTargetIndex(filenamePart, sourceIndex, targetIndex
 	if targetIndexHdf != "" {
 		if amigaDiskDevicesDiscovery.HasFile(targetIndexHdf) {
 			// HDF attached by amiga_disk_devices.go
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
+			return fmt.Errorf("DH%v is %w", targetIndexInt, commands.ErrManagedExternally)
 		}
 
 		if !detachHd(targetIndexInt, targetIndexHdf) {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
+			return fmt.Errorf("%w %v from DH%v", commands.ErrDetachFailed, targetIndexHdf, targetIndexInt)
 		}
 	}
 
 	foundHdfPathname := findSimilarROMFile(mountpoint, filenamePart)
 
 	if foundHdfPathname == "" {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return fmt.Errorf("%w HDF matching %v on DH%v medium", commands.ErrNotFound, filenamePart, sourceIndexInt)
 	}
 
 	if attachedIndex := isHdfAttached(foundHdfPathname); attachedIndex != shared.DRIVE_INDEX_UNSPECIFIED {
 		if !detachHd(attachedIndex, foundHdfPathname) {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
+			return fmt.Errorf("%w %v from DH%v", commands.ErrDetachFailed, foundHdfPathname, attachedIndex)
 		}
 	}
 
@@ -1190,180 +1801,167 @@ func hfInsertFromSourceIndexToTargetIndex(filenamePart, sourceIndex, targetIndex
 		targetIndexInt,
 		mountpoint.DHBootPriority,
 		foundHdfPathname) {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return fmt.Errorf("%w %v to DH%v", commands.ErrAttachFailed, foundHdfPathname, targetIndexInt)
 	}
+
+	return nil
 }
 
-func cdInsertFromSourceIndexToTargetIndex(filenamePart, sourceIndex, targetIndex string) {
+func cdInsertFromSourceIndexToTargetIndex(
+	filenamePart string,
+	sourceIndexInt, targetIndexInt int,
+) error {
 	powerLEDControl.BlinkPowerLEDSecs(shared.CMD_PENDING_BLINK_POWER_SECS)
 	defer powerLEDControl.BlinkPowerLEDSecs(shared.CMD_SUCCESS_BLINK_POWER_SECS)
 
 	// TODO test me - check if ISO attached by this method works in the emulator
 	// eg. by using emulating CD32 (use cd32.uae.template config)
 	filenamePart = strings.TrimSpace(filenamePart)
-	sourceIndexInt, _ := utils.StringUtilsInstance.StringToInt(sourceIndex, 10, 16)
-	targetIndexInt, _ := utils.StringUtilsInstance.StringToInt(targetIndex, 10, 16)
 
 	if filenamePart == "" {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return commands.ErrEmptyPattern
 	}
 
 	if targetIndexInt == shared.DRIVE_INDEX_UNSPECIFIED {
 		targetIndexInt = sourceIndexInt
 	}
 
-	if sourceIndexInt > shared.MAX_CDS-1 || targetIndexInt > shared.MAX_CDS-1 {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+	if !isValidIndex(sourceIndexInt, shared.MAX_CDS) || !isValidIndex(targetIndexInt, shared.MAX_CDS) {
+		return fmt.Errorf("%w CD%v or CD%v", commands.ErrInvalidIndex, sourceIndexInt, targetIndexInt)
 	}
 
 	mountpoint := mountpoints.GetMountpointByCDIndex(sourceIndexInt)
 
+	if mountpoint == nil {
+		return fmt.Errorf("%w as CD%v", commands.ErrNoMountpoint, sourceIndexInt)
+	}
+
 	targetIndexIso := emulator.GetIso(targetIndexInt)
 
 	if targetIndexIso != "" {
 		if amigaDiskDevicesDiscovery.HasFile(targetIndexIso) {
 			// ISO attached by amiga_disk_devices.go
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
+			return fmt.Errorf("CD%v is %w", targetIndexInt, commands.ErrManagedExternally)
 		}
 
 		if !detachIso(targetIndexInt, targetIndexIso) {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
+			return fmt.Errorf("%w %v from CD%v", commands.ErrDetachFailed, targetIndexIso, targetIndexInt)
 		}
 	}
 
 	foundIsoPathname := findSimilarROMFile(mountpoint, filenamePart)
 
 	if foundIsoPathname == "" {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return fmt.Errorf("%w ISO matching %v on CD%v medium", commands.ErrNotFound, filenamePart, sourceIndexInt)
 	}
 
 	if attachedIndex := isIsoAttached(foundIsoPathname); attachedIndex != shared.DRIVE_INDEX_UNSPECIFIED {
 		if !detachIso(attachedIndex, foundIsoPathname) {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
+			return fmt.Errorf("%w %v from CD%v", commands.ErrDetachFailed, foundIsoPathname, attachedIndex)
 		}
 	}
 
 	if !attachIso(targetIndexInt, foundIsoPathname) {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return fmt.Errorf("%w %v to CD%v", commands.ErrAttachFailed, foundIsoPathname, targetIndexInt)
 	}
+
+	return nil
 }
 
-func dfEjectFromSourceIndex(sourceIndex string) {
-	if sourceIndex == "N" {
-		dfEjectFromSourceIndexAll()
-		return
+func dfEjectFromSourceIndex(sourceIndexInt int) error {
+	if sourceIndexInt == shared.DRIVE_INDEX_ALL {
+		return dfEjectFromSourceIndexAll()
 	}
 
 	powerLEDControl.BlinkPowerLEDSecs(shared.CMD_PENDING_BLINK_POWER_SECS)
 	defer powerLEDControl.BlinkPowerLEDSecs(shared.CMD_SUCCESS_BLINK_POWER_SECS)
 
-	sourceIndexInt, _ := utils.StringUtilsInstance.StringToInt(sourceIndex, 10, 16)
-
-	if sourceIndexInt > shared.MAX_ADFS-1 {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+	if !isValidIndex(sourceIndexInt, shared.MAX_ADFS) {
+		return fmt.Errorf("%w DF%v", commands.ErrInvalidIndex, sourceIndexInt)
 	}
 
 	sourceIndexAdf := emulator.GetAdf(sourceIndexInt)
 
 	if sourceIndexAdf == "" {
 		// ADF not attached at index
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return fmt.Errorf("ADF %w to DF%v", commands.ErrNotAttached, sourceIndexInt)
 	}
 
 	if amigaDiskDevicesDiscovery.HasFile(sourceIndexAdf) {
 		// ADF attached by amiga_disk_devices.go
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return fmt.Errorf("DF%v is %w", sourceIndexInt, commands.ErrManagedExternally)
 	}
 
 	if !detachAdf(sourceIndexInt, sourceIndexAdf) {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return fmt.Errorf("%w %v from DF%v", commands.ErrDetachFailed, sourceIndexAdf, sourceIndexInt)
 	}
+
+	return nil
 }
 
-func cdEjectFromSourceIndex(sourceIndex string) {
+func cdEjectFromSourceIndex(sourceIndexInt int) error {
 	powerLEDControl.BlinkPowerLEDSecs(shared.CMD_PENDING_BLINK_POWER_SECS)
 	defer powerLEDControl.BlinkPowerLEDSecs(shared.CMD_SUCCESS_BLINK_POWER_SECS)
 
-	sourceIndexInt, _ := utils.StringUtilsInstance.StringToInt(sourceIndex, 10, 16)
-
-	if sourceIndexInt > shared.MAX_CDS-1 {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+	if !isValidIndex(sourceIndexInt, shared.MAX_CDS) {
+		return fmt.Errorf("%w CD%v", commands.ErrInvalidIndex, sourceIndexInt)
 	}
 
 	sourceIndexIso := emulator.GetIso(sourceIndexInt)
 
 	if sourceIndexIso == "" {
 		// ISO not attached at index
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return fmt.Errorf("ISO %w to CD%v", commands.ErrNotAttached, sourceIndexInt)
 	}
 
 	if amigaDiskDevicesDiscovery.HasFile(sourceIndexIso) {
 		// ISO attached by amiga_disk_devices.go
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return fmt.Errorf("CD%v is %w", sourceIndexInt, commands.ErrManagedExternally)
 	}
 
 	if !detachIso(sourceIndexInt, sourceIndexIso) {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return fmt.Errorf("%w %v from CD%v", commands.ErrDetachFailed, sourceIndexIso, sourceIndexInt)
 	}
+
+	return nil
 }
 
-func hfEjectFromSourceIndex(sourceIndex string) {
+func hfEjectFromSourceIndex(sourceIndexInt int) error {
 	powerLEDControl.BlinkPowerLEDSecs(shared.CMD_PENDING_BLINK_POWER_SECS)
 	defer powerLEDControl.BlinkPowerLEDSecs(shared.CMD_SUCCESS_BLINK_POWER_SECS)
 
-	sourceIndexInt, _ := utils.StringUtilsInstance.StringToInt(sourceIndex, 10, 16)
-
-	if sourceIndexInt > shared.MAX_HDFS-1 {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+	if !isValidIndex(sourceIndexInt, shared.MAX_HDFS) {
+		return fmt.Errorf("%w DH%v", commands.ErrInvalidIndex, sourceIndexInt)
 	}
 
 	sourceIndexHdf := emulator.GetHd(sourceIndexInt)
 
 	if sourceIndexHdf == "" {
 		// HDF not attached at index
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return fmt.Errorf("HDF %w to DH%v", commands.ErrNotAttached, sourceIndexInt)
 	}
 
 	if amigaDiskDevicesDiscovery.HasFile(sourceIndexHdf) {
 		// HDF attached by amiga_disk_devices.go
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return fmt.Errorf("DH%v is %w", sourceIndexInt, commands.ErrManagedExternally)
 	}
 
 	if strings.HasSuffix(sourceIndexHdf, "/") {
 		// DH is not HDF file but directory, cannot detach
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return fmt.Errorf("%w: DH%v is a directory, not HDF file", commands.ErrWrongMediumType, sourceIndexInt)
 	}
 
 	onHDOperationStart()
 	defer onHDOperationDone()
 
 	if !detachHd(sourceIndexInt, sourceIndexHdf) {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return fmt.Errorf("%w %v from DH%v", commands.ErrDetachFailed, sourceIndexHdf, sourceIndexInt)
 	}
+
+	return nil
 }
 
-func dfEjectFromSourceIndexAll() {
+func dfEjectFromSourceIndexAll() error {
 	powerLEDControl.BlinkPowerLEDSecs(shared.CMD_PENDING_BLINK_POWER_SECS)
 	defer powerLEDControl.BlinkPowerLEDSecs(shared.CMD_SUCCESS_BLINK_POWER_SECS)
 
@@ -1380,10 +1978,11 @@ func dfEjectFromSourceIndexAll() {
 		}
 
 		if !detachAdf(index, adfPathname) {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
+			return fmt.Errorf("%w %v from DF%v", commands.ErrDetachFailed, adfPathname, index)
 		}
 	}
+
+	return nil
 }
 
 func isAdfAttached(adfPathname string) int {
@@ -1507,21 +2106,17 @@ func findSimilarROMFile(
 	return ""
 }
 
-func dfInsertFromSourceIndexToManyIndex(filenamePart, sourceIndex string) {
+func dfInsertFromSourceIndexToManyIndex(filenamePart string, sourceIndexInt int) error {
 	powerLEDControl.BlinkPowerLEDSecs(shared.CMD_PENDING_BLINK_POWER_SECS)
 	defer powerLEDControl.BlinkPowerLEDSecs(shared.CMD_SUCCESS_BLINK_POWER_SECS)
 
 	filenamePart = strings.TrimSpace(filenamePart)
-	sourceIndexInt, _ := utils.StringUtilsInstance.StringToInt(sourceIndex, 10, 16)
-
 	if filenamePart == "" {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return commands.ErrEmptyPattern
 	}
 
-	if sourceIndexInt > shared.MAX_ADFS-1 {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+	if !isValidIndex(sourceIndexInt, shared.MAX_ADFS) {
+		return fmt.Errorf("%w DF%v", commands.ErrInvalidIndex, sourceIndexInt)
 	}
 
 	mountpoint := mountpoints.GetMountpointByDFIndex(sourceIndexInt)
@@ -1529,16 +2124,14 @@ func dfInsertFromSourceIndexToManyIndex(filenamePart, sourceIndex string) {
 	if mountpoint == nil {
 		// allow to manage only these drives mounted by amipi400.go
 		// so skip these from amiga_disk_devices.go
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return fmt.Errorf("%w as DF%v", commands.ErrNoMountpoint, sourceIndexInt)
 	}
 
 	// find first ADF by pattern typed by the user
 	foundAdfPathname := findSimilarROMFile(mountpoint, filenamePart)
 
 	if foundAdfPathname == "" {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return fmt.Errorf("%w ADF matching %v on DF%v medium", commands.ErrNotFound, filenamePart, sourceIndexInt)
 	}
 
 	// find similar ADFs by the first ADF and attach
@@ -1547,14 +2140,12 @@ func dfInsertFromSourceIndexToManyIndex(filenamePart, sourceIndex string) {
 	lenFoundAdfPathnames := len(foundAdfPathnames)
 
 	if lenFoundAdfPathnames == 0 {
-		numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		return
+		return fmt.Errorf("%w ADFs similar to %v", commands.ErrNotFound, foundAdfPathname)
 	}
 
 	for targetIndexInt, pathname := range foundAdfPathnames {
 		if targetIndexInt+1 > shared.MAX_ADFS {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
+			return fmt.Errorf("%w: too many ADFs similar to %v", commands.ErrAmbiguousMatch, foundAdfPathname)
 		}
 
 		targetIndexAdf := emulator.GetAdf(targetIndexInt)
@@ -1566,41 +2157,38 @@ func dfInsertFromSourceIndexToManyIndex(filenamePart, sourceIndex string) {
 			}
 
 			if !detachAdf(targetIndexInt, targetIndexAdf) {
-				numLockLEDControl.BlinkNumLockLEDSecs(
-					shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS,
-				)
-				return
+				return fmt.Errorf("%w %v from DF%v", commands.ErrDetachFailed, targetIndexAdf, targetIndexInt)
 			}
 		}
 
 		if !attachAdf(targetIndexInt, pathname) {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-			return
+			return fmt.Errorf("%w %v to DF%v", commands.ErrAttachFailed, pathname, targetIndexInt)
 		}
 	}
+
+	return nil
 }
 
 func keyEventCallback(sender any, key string, pressed bool) {
-	if initializing {
+	if !initialized.Load() {
 		return
 	}
 
+	commandMutex.Lock()
+	defer commandMutex.Unlock()
+
 	if isSoftResetKeys() {
 		clearAllKeyboardsControl()
 
-		utils.UnixUtilsInstance.Sync()
-		emulator.SoftReset()
+		softReset()
 	} else if isHardResetKeys() {
 		clearAllKeyboardsControl()
 
-		utils.UnixUtilsInstance.Sync()
-		emulator.HardReset()
+		hardReset()
 	} else if isToggleZoomKeys() {
 		clearAllKeyboardsControl()
 
-		emulator.ToggleZoom()
-
-		saveZoomConfigSetting()
+		toggleZoom()
 	} else if isShutdownKeys() {
 		clearAllKeyboardsControl()
 
@@ -1613,22 +2201,161 @@ func keyEventCallback(sender any, key string, pressed bool) {
 	} else if diskNo := isReplaceDFByIndexShortcut(); diskNo != shared.DISK_INDEX_UNSPECIFIED {
 		clearAllKeyboardsControl()
 
-		dfInsertFromSourceIndexToTargetIndexByDiskNo(
-			fmt.Sprint(diskNo),
-			"0",
-			shared.DRIVE_INDEX_UNSPECIFIED_STR)
+		// example: KEY_LEFTMETA+2 is the same as df02
+		runKeyboardCommand(fmt.Sprintf("df0%v", diskNo))
 	} else {
-		keyboardCommand, keyboardCommandUpper := getKeyboardCommand()
+		keyboardCommand := getKeyboardCommand()
 
 		if keyboardCommand != "" {
 			clearAllKeyboardsControl()
-			processKeyboardCommand(keyboardCommand, keyboardCommandUpper)
+			runKeyboardCommand(keyboardCommand)
 		} else {
 			emulateNumPad()
 		}
 	}
 }
 
+// getSaveStatePathname returns pathname of the save-state slot,
+// save-states are stored on the medium which holds
+// the DF0 image, or the DH0 image when DF0 is empty
+func getSaveStatePathname(slot int) (string, error) {
+	pathname := emulator.GetAdf(0)
+
+	if pathname == "" {
+		pathname = emulator.GetHd(0)
+	}
+
+	if pathname == "" {
+		return "", fmt.Errorf("DF0 or DH0 image %w", commands.ErrNotAttached)
+	}
+
+	mountpoint := mountpoints.GetMountpointByPathname(pathname)
+
+	if mountpoint == nil {
+		// amiga_disk_devices.go media are not writable
+		return "", fmt.Errorf("%w for %v", commands.ErrNoMountpoint, pathname)
+	}
+
+	return filepath.Join(
+		mountpoint.Mountpoint,
+		shared.SAVESTATES_DIR_NAME,
+		fmt.Sprintf(shared.SAVESTATE_FILENAME_FORMAT, slot)), nil
+}
+
+func saveState(slot int) error {
+	pathname, err := getSaveStatePathname(slot)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(pathname), 0777); err != nil {
+		return err
+	}
+
+	log.Println("Saving state to", pathname)
+
+	if err := emulator.SaveState(pathname); err != nil {
+		return err
+	}
+
+	showOSDMessage("Saving state to slot %v", slot)
+
+	return nil
+}
+
+func loadState(slot int) error {
+	pathname, err := getSaveStatePathname(slot)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(pathname); err != nil {
+		return fmt.Errorf("%w state in slot %v (%v)", commands.ErrNotFound, slot, pathname)
+	}
+
+	log.Println("Loading state from", pathname)
+
+	if err := emulator.LoadState(pathname); err != nil {
+		return err
+	}
+
+	showOSDMessage("State loaded from slot %v", slot)
+
+	return nil
+}
+
+// getProfileConfigPathname returns config template
+// pathname for the profile
+func getProfileConfigPathname(profile string) string {
+	if profile == shared.AMIPI400_AMIBERRY_DEFAULT_PROFILE {
+		return shared.AMIPI400_AMIBERRY_CONFIG_PATHNAME
+	}
+
+	return fmt.Sprintf(shared.AMIPI400_AMIBERRY_PROFILE_CONFIG_PATHNAME_FORMAT, profile)
+}
+
+// switchProfile switches the config template and
+// restarts the emulator, attached media stay attached
+func switchProfile(profile string) error {
+	profile = strings.ToLower(strings.TrimSpace(profile))
+
+	if profile != shared.AMIPI400_AMIBERRY_DEFAULT_PROFILE &&
+		!shared.PROFILE_NAME_RE.MatchString(profile) {
+		return fmt.Errorf("%w: bad profile name %v", commands.ErrInvalidCommand, profile)
+	}
+
+	if profile == emulator.GetProfile() {
+		return nil
+	}
+
+	configPathname := getProfileConfigPathname(profile)
+
+	if _, err := os.Stat(configPathname); err != nil {
+		return fmt.Errorf("%w config template %v", commands.ErrNotFound, configPathname)
+	}
+
+	log.Printf("Switching profile to \"%v\" (%v)\n", profile, configPathname)
+
+	emulator.SetProfile(profile, configPathname)
+
+	return hardReset()
+}
+
+// applyMountpointProfile switches profile to the one
+// set in the medium config, media without profile
+// do not change the current one
+func applyMountpointProfile(mountpoint *components_amipi400.Mountpoint) {
+	profile := mountpoint.Config.AmiPi400.Profile
+
+	if profile == "" {
+		return
+	}
+
+	if err := switchProfile(profile); err != nil {
+		log.Printf("Cannot switch profile for %v: %v\n", mountpoint.Mountpoint, err)
+	}
+}
+
+func softReset() error {
+	utils.UnixUtilsInstance.Sync()
+
+	return emulator.SoftReset()
+}
+
+func hardReset() error {
+	utils.UnixUtilsInstance.Sync()
+
+	return emulator.HardReset()
+}
+
+func toggleZoom() error {
+	emulator.ToggleZoom()
+
+	return saveZoomConfigSetting()
+}
+
 func emulateNumPad() {
 	if allKeyboardsControl.IsKeysReleasedAgo(
 		shared.NUMPAD_EMULATE_ENTER_KEYS,
@@ -1638,12 +2365,16 @@ func emulateNumPad() {
 	}
 }
 
-func saveZoomConfigSetting() {
+func saveZoomConfigSetting() error {
 	mainConfig.AmiPi400.Zoom = emulator.IsZoom()
 
 	if err := mainConfig.Save(); err != nil {
 		log.Println(err)
+
+		return err
 	}
+
+	return nil
 }
 
 func saveWifiSetting(manage bool, countryCode string, ssid string, password string) {
@@ -1772,6 +2503,8 @@ func setupAddMountpoint(
 		mountpoint.CDIndex = cdIndex
 	}
 
+	applyMountpointProfile(mountpoint)
+
 	mountpoints.AddMountpoint(mountpoint)
 
 	return mountpoint, nil
@@ -1819,17 +2552,19 @@ func attachDFMediumDiskImage(
 	_type, mountpointStr, label, path, fsType, ptType string,
 	readOnly bool) {
 
-	index, _, err := utils.AmiPi400UtilsInstance.ParseMediumLabel(
+	mediumLabel, err := utils.AmiPi400UtilsInstance.ParseMediumLabel(
 		label,
 		shared.AP4_MEDIUM_DF_RE,
-	)
-	// index, _, err := parseMediumLabel(label, shared.AP4_MEDIUM_DF_RE)
+		shared.MAX_ADFS,
+		isDFSlotUsed)
 
-	if err != nil || index == shared.DRIVE_INDEX_UNSPECIFIED {
+	if err != nil {
 		log.Println(path, label, "cannot get index for medium: ", err)
 		return
 	}
 
+	index := mediumLabel.Index
+
 	if emulator.GetAdf(index) != "" {
 		log.Printf("ADF already attached at DF%v, eject it first\n", index)
 		return
@@ -1871,16 +2606,20 @@ func attachDHMediumDiskImage(
 	size uint64,
 	_type, mountpointStr, label, path, fsType, ptType string,
 	readOnly bool) {
-	index, bootPriority, err := utils.AmiPi400UtilsInstance.ParseMediumLabel(
+	mediumLabel, err := utils.AmiPi400UtilsInstance.ParseMediumLabel(
 		label,
 		shared.AP4_MEDIUM_DH_RE,
-	)
+		shared.MAX_HDFS,
+		isDHSlotUsed)
 
-	if err != nil || index == shared.DRIVE_INDEX_UNSPECIFIED {
+	if err != nil {
 		log.Println(path, label, "cannot get index for medium: ", err)
 		return
 	}
 
+	index := mediumLabel.Index
+	bootPriority := mediumLabel.BootPriority
+
 	if emulator.GetHd(index) != "" {
 		log.Printf("HDF already attached at DH%v, eject it first\n", index)
 		return
@@ -1921,16 +2660,20 @@ func attachHFMediumDiskImage(
 	size uint64,
 	_type, mountpointStr, label, path, fsType, ptType string,
 	readOnly bool) {
-	index, bootPriority, err := utils.AmiPi400UtilsInstance.ParseMediumLabel(
+	mediumLabel, err := utils.AmiPi400UtilsInstance.ParseMediumLabel(
 		label,
 		shared.AP4_MEDIUM_HF_RE,
-	)
+		shared.MAX_HDFS,
+		isDHSlotUsed)
 
-	if err != nil || index == shared.DRIVE_INDEX_UNSPECIFIED {
+	if err != nil {
 		log.Println(path, label, "cannot get index for medium: ", err)
 		return
 	}
 
+	index := mediumLabel.Index
+	bootPriority := mediumLabel.BootPriority
+
 	if emulator.GetHd(index) != "" {
 		log.Printf("HDF already attached at DH%v, eject it first\n", index)
 		return
@@ -1974,16 +2717,19 @@ func attachCDMediumDiskImage(
 	size uint64,
 	_type, mountpointStr, label, path, fsType, ptType string,
 	readOnly bool) {
-	index, _, err := utils.AmiPi400UtilsInstance.ParseMediumLabel(
+	mediumLabel, err := utils.AmiPi400UtilsInstance.ParseMediumLabel(
 		label,
 		shared.AP4_MEDIUM_CD_RE,
-	)
+		shared.MAX_CDS,
+		isCDSlotUsed)
 
-	if err != nil || index == shared.DRIVE_INDEX_UNSPECIFIED {
+	if err != nil {
 		log.Println(path, label, "cannot get index for medium: ", err)
 		return
 	}
 
+	index := mediumLabel.Index
+
 	if emulator.GetIso(index) != "" {
 		log.Printf("ISO already attached at CD%v, eject it first\n", index)
 		return
@@ -2150,6 +2896,9 @@ func attachedBlockDeviceCallback(
 	size uint64,
 	_type, mountpoint, label, path, fsType, ptType string,
 	readOnly bool) {
+	commandMutex.Lock()
+	defer commandMutex.Unlock()
+
 	if utils.BlockDeviceUtilsInstance.IsInternalMedium(name) {
 		return
 	}
@@ -2190,6 +2939,9 @@ func detachedBlockDeviceCallback(
 	size uint64,
 	_type, mountpoint, label, path, fsType, ptType string,
 	readOnly bool) {
+	commandMutex.Lock()
+	defer commandMutex.Unlock()
+
 	if utils.BlockDeviceUtilsInstance.IsInternalMedium(name) {
 		return
 	}
@@ -2313,7 +3065,7 @@ func detachMountpointROMs(mountpoint *components_amipi400.Mountpoint) {
 }
 
 func onHDOperationStart() {
-	if initializing {
+	if !initialized.Load() {
 		return
 	}
 
@@ -2321,14 +3073,14 @@ func onHDOperationStart() {
 }
 
 func onHDOperationDone() {
-	if initializing {
+	if !initialized.Load() {
 		return
 	}
 
 	emulator.SetRerunEmulator(true)
 }
 
-func unmountAll(fromSignal bool) {
+func unmountAll(fromSignal bool) error {
 	if !fromSignal {
 		onHDOperationStart()
 		defer onHDOperationDone()
@@ -2359,13 +3111,13 @@ func unmountAll(fromSignal bool) {
 		time.Sleep(time.Second * 1)
 	}
 
+	log.Println("Done unmounting mountpoints")
+
 	if len(mountpoints.Mountpoints) > 0 {
-		if !fromSignal {
-			numLockLEDControl.BlinkNumLockLEDSecs(shared.CMD_FAILURE_BLINK_NUM_LOCK_SECS)
-		}
+		return fmt.Errorf("%w %v mountpoint(s)", commands.ErrUnmountFailed, len(mountpoints.Mountpoints))
 	}
 
-	log.Println("Done unmounting mountpoints")
+	return nil
 }
 
 func initWifi() {
@@ -2388,6 +3140,7 @@ func stopServices() {
 	powerLEDControl.Stop(&powerLEDControl)
 	numLockLEDControl.Stop(&numLockLEDControl)
 	wifiControl.Stop(wifiControl)
+	controlServer.Stop(&controlServer)
 }
 
 func gracefulShutdown() {
@@ -2441,12 +3194,22 @@ func main() {
 	allKeyboardsControl.SetKeyEventCallback(keyEventCallback)
 	commander.SetTmpIniPathname(shared.AMIBERRY_EMULATOR_TMP_INI_PATHNAME)
 	emulator.SetExecutablePathname(shared.AMIBERRY_EXE_PATHNAME)
-	emulator.SetConfigPathname(shared.AMIPI400_AMIBERRY_CONFIG_PATHNAME)
+	emulator.SetProfile(
+		shared.AMIPI400_AMIBERRY_DEFAULT_PROFILE,
+		shared.AMIPI400_AMIBERRY_CONFIG_PATHNAME)
 	emulator.SetAmiberryCommander(&commander)
 	emulator.SetZoom(mainConfig.AmiPi400.Zoom)
 	blockDevices.AddAttachedCallback(attachedBlockDeviceCallback)
 	blockDevices.AddDetachedCallback(detachedBlockDeviceCallback)
 	blockDevices.SetIdleCallback(servicesIdleCallback)
+	registerCommandHandlers()
+	controlServer.SetSocketPathname(shared.CONTROL_SOCKET_PATHNAME)
+	controlServer.SetRequestCallback(controlRequestCallback)
+
+	if mainConfig.AmiPi400.ControlHTTP {
+		controlServer.SetHTTPAddress(shared.CONTROL_HTTP_ADDRESS)
+		controlServer.SetHTTPToken(mainConfig.AmiPi400.ControlHTTPToken)
+	}
 
 	discoverDriveDevices()
 	printFloppyDevices()
@@ -2468,6 +3231,8 @@ func main() {
 	numLockLEDControl.SetDebugMode(shared.RUNNERS_DEBUG_MODE)
 	wifiControl.SetVerboseMode(shared.RUNNERS_VERBOSE_MODE)
 	wifiControl.SetDebugMode(shared.RUNNERS_DEBUG_MODE)
+	controlServer.SetVerboseMode(shared.RUNNERS_VERBOSE_MODE)
+	controlServer.SetDebugMode(shared.RUNNERS_DEBUG_MODE)
 
 	amigaDiskDevicesDiscovery.Start(&amigaDiskDevicesDiscovery)
 	allKeyboardsControl.Start(&allKeyboardsControl)
@@ -2477,6 +3242,7 @@ func main() {
 	powerLEDControl.Start(&powerLEDControl)
 	numLockLEDControl.Start(&numLockLEDControl)
 	wifiControl.Start(wifiControl)
+	controlServer.Start(&controlServer)
 
 	runnersBlocker.AddRunner(&amigaDiskDevicesDiscovery)
 	runnersBlocker.AddRunner(&allKeyboardsControl)
@@ -2486,6 +3252,7 @@ func main() {
 	runnersBlocker.AddRunner(&powerLEDControl)
 	runnersBlocker.AddRunner(&numLockLEDControl)
 	runnersBlocker.AddRunner(wifiControl)
+	runnersBlocker.AddRunner(&controlServer)
 
 	initWifi()
 
@@ -9,7 +9,9 @@ import (
 	"strings"
 	"syscall"
 	"time"
+	"unicode"
 
+	"github.com/skazanyNaGlany/go.amipi400/shared"
 	"github.com/skazanyNaGlany/go.amipi400/shared/components"
 	"github.com/skazanyNaGlany/go.amipi400/shared/components/utils"
 	"golang.org/x/exp/slices"
@@ -24,6 +26,17 @@ type AmiberryCommander struct {
 	commands       []string
 	emulatorPaused bool
 	executeLoops   int
+	runtimeConfig  *UAEConfig
+}
+
+// SetRuntimeConfig sets the config the emulator was started
+// with, options set by PutSetConfigOptionCommand are applied to it
+func (ac *AmiberryCommander) SetRuntimeConfig(config *UAEConfig) {
+	ac.runtimeConfig = config
+}
+
+func (ac *AmiberryCommander) GetRuntimeConfig() *UAEConfig {
+	return ac.runtimeConfig
 }
 
 func (ac *AmiberryCommander) SetEmulatorPaused(paused bool) {
@@ -212,20 +225,22 @@ func (ac *AmiberryCommander) Run() {
 	ac.loop()
 }
 
-func (ac *AmiberryCommander) PutCommand(command string, reset bool, force bool) {
+func (ac *AmiberryCommander) PutCommand(command string, reset bool, force bool) bool {
 	if reset {
 		ac.commands = make([]string, 0)
 	}
 
 	if ac.emulatorPaused && !force {
-		return
+		return false
 	}
 
 	if command == "" {
-		return
+		return false
 	}
 
 	ac.commands = append(ac.commands, command)
+
+	return true
 }
 
 func (ac *AmiberryCommander) PutUAEResetCommand() {
@@ -236,10 +251,49 @@ func (ac *AmiberryCommander) PutConfigChangedCommand() {
 	ac.PutCommand("config_changed 1", false, false)
 }
 
+// PutOSDMessageCommand puts a short text message to be displayed
+// by the emulator on the status line, the message must be a single
+// line without '#' (INI comment) so it is sanitized and truncated
+func (ac *AmiberryCommander) PutOSDMessageCommand(message string) {
+	message = strings.Map(func(r rune) rune {
+		if r == '#' || r < ' ' || r > unicode.MaxASCII {
+			return ' '
+		}
+
+		return r
+	}, message)
+
+	message = strings.Join(strings.Fields(message), " ")
+
+	if len(message) > shared.OSD_MESSAGE_MAX_LEN {
+		message = message[:shared.OSD_MESSAGE_MAX_LEN-3] + "..."
+	}
+
+	if message == "" {
+		return
+	}
+
+	ac.PutCommand("osd_message "+message, false, false)
+}
+
+// PutSaveStateCommand returns false when the command was
+// dropped because the emulator is paused
+func (ac *AmiberryCommander) PutSaveStateCommand(pathname string) bool {
+	return ac.PutCommand("savestate_save "+pathname, false, false)
+}
+
+// PutLoadStateCommand returns false when the command was
+// dropped because the emulator is paused
+func (ac *AmiberryCommander) PutLoadStateCommand(pathname string) bool {
+	return ac.PutCommand("savestate_load "+pathname, false, false)
+}
+
 func (ac *AmiberryCommander) PutSetConfigOptionCommand(option string, value string) {
 	full := fmt.Sprintf("cfgfile_parse_line_type_all %v=%v", option, value)
 
-	ac.PutCommand(full, false, false)
+	if ac.PutCommand(full, false, false) && ac.runtimeConfig != nil {
+		ac.runtimeConfig.Apply(option, value)
+	}
 }
 
 func (ac *AmiberryCommander) FormatFloppyCO(index int, pathname string) (string, string) {
@@ -21,6 +21,8 @@ type AmiberryEmulator struct {
 	emulatorCommand         *exec.Cmd
 	executablePathname      string
 	configPathname          string
+	profile                 string
+	startedConfig           *UAEConfig
 	adfs                    [shared.MAX_ADFS]string
 	hdfs                    [shared.MAX_HDFS]string
 	hdfsBootPriority        [shared.MAX_HDFS]int
@@ -75,33 +77,12 @@ func (ae *AmiberryEmulator) getEmulatorCommandLine(configPathname string) []stri
 }
 
 func (ae *AmiberryEmulator) getEmulatorProcessedConfig() (string, error) {
-	templateContent, n, err := utils.FileUtilsInstance.FileReadBytes(
-		ae.configPathname,
-		0,
-		-1,
-		0,
-		0,
-		nil)
-
-	if err != nil {
-		return "", err
-	}
-
-	if n <= 0 {
-		return "", errors.New("Cannot process config file template " + ae.configPathname)
-	}
-
-	templateContentStr := string(templateContent)
+	configTemplate := NewConfigTemplate(ae.configPathname)
 
-	// adfs, needs to be the same as
-	// nr_floppies= option in the config
-	// otherwise some of the config data
-	// will be not filled, and it will
-	// stay at {{floppyN}}
+	// all the values are always set, so the template
+	// can use less drives than MAX_ADFS etc.
 	for i, pathname := range ae.adfs {
-		key, value := ae.commander.FormatFloppyCO(i, pathname)
-
-		templateContentStr = strings.ReplaceAll(templateContentStr, "{{"+key+"}}", value)
+		configTemplate.Set(ae.commander.FormatFloppyCO(i, pathname))
 	}
 
 	// hdfs
@@ -167,80 +148,54 @@ func (ae *AmiberryEmulator) getEmulatorProcessedConfig() (string, error) {
 		}
 	}
 
-	hard_drives = strings.TrimSpace(hard_drives)
-	templateContentStr = strings.ReplaceAll(
-		templateContentStr,
-		"{{hard_drives}}",
-		hard_drives,
-	)
+	configTemplate.Set("hard_drives", strings.TrimSpace(hard_drives))
 
 	// cds
 	for i, pathname := range ae.cds {
-		key, value := ae.commander.FormatCdImageCO(i, pathname)
-
-		templateContentStr = strings.ReplaceAll(templateContentStr, "{{"+key+"}}", value)
+		configTemplate.Set(ae.commander.FormatCdImageCO(i, pathname))
 	}
 
 	// floppy sound volume
 	for i, volume := range ae.floppySoundVolumeDisk {
-		key, value := ae.commander.FormatFloppySoundConfigOption(i, volume > 0)
-		templateContentStr = strings.ReplaceAll(templateContentStr, "{{"+key+"}}", value)
-
-		key, value = ae.commander.FormatFloppySoundVolumeDiskCO(i, volume)
-		templateContentStr = strings.ReplaceAll(templateContentStr, "{{"+key+"}}", value)
-
-		key, value = ae.commander.FormatFloppySoundVolumeEmptyCO(i, volume)
-		templateContentStr = strings.ReplaceAll(templateContentStr, "{{"+key+"}}", value)
+		configTemplate.Set(ae.commander.FormatFloppySoundConfigOption(i, volume > 0))
+		configTemplate.Set(ae.commander.FormatFloppySoundVolumeDiskCO(i, volume))
+		configTemplate.Set(ae.commander.FormatFloppySoundVolumeEmptyCO(i, volume))
 	}
 
 	// zoom
-	if ae.isZoom {
-		key, value := ae.commander.FormatGfxCenterHorizontalCO(true)
-		templateContentStr = strings.ReplaceAll(templateContentStr, "{{"+key+"}}", value)
+	height := shared.AMIBERRY_DEFAULT_WINDOW_HEIGHT
 
-		key, value = ae.commander.FormatGfxCenterVerticalCO(true)
-		templateContentStr = strings.ReplaceAll(templateContentStr, "{{"+key+"}}", value)
-
-		key, value = ae.commander.FormatGfxHeightCO(shared.AMIBERRY_ZOOM_WINDOW_HEIGHT)
-		templateContentStr = strings.ReplaceAll(templateContentStr, "{{"+key+"}}", value)
+	if ae.isZoom {
+		height = shared.AMIBERRY_ZOOM_WINDOW_HEIGHT
+	}
 
-		key, value = ae.commander.FormatGfxHeightWindowedCO(
-			shared.AMIBERRY_ZOOM_WINDOW_HEIGHT,
-		)
-		templateContentStr = strings.ReplaceAll(templateContentStr, "{{"+key+"}}", value)
-	} else {
-		key, value := ae.commander.FormatGfxCenterHorizontalCO(false)
-		templateContentStr = strings.ReplaceAll(templateContentStr, "{{"+key+"}}", value)
+	configTemplate.Set(ae.commander.FormatGfxCenterHorizontalCO(ae.isZoom))
+	configTemplate.Set(ae.commander.FormatGfxCenterVerticalCO(ae.isZoom))
+	configTemplate.Set(ae.commander.FormatGfxHeightCO(height))
+	configTemplate.Set(ae.commander.FormatGfxHeightWindowedCO(height))
 
-		key, value = ae.commander.FormatGfxCenterVerticalCO(false)
-		templateContentStr = strings.ReplaceAll(templateContentStr, "{{"+key+"}}", value)
+	// for the conditionals, like {{if .zoom}}
+	configTemplate.Set("zoom", ae.isZoom)
+	configTemplate.Set("profile", ae.profile)
 
-		key, value = ae.commander.FormatGfxHeightCO(shared.AMIBERRY_DEFAULT_WINDOW_HEIGHT)
-		templateContentStr = strings.ReplaceAll(templateContentStr, "{{"+key+"}}", value)
+	templateContentStr, err := configTemplate.Render()
 
-		key, value = ae.commander.FormatGfxHeightWindowedCO(shared.AMIBERRY_DEFAULT_WINDOW_HEIGHT)
-		templateContentStr = strings.ReplaceAll(templateContentStr, "{{"+key+"}}", value)
+	if err != nil {
+		return "", err
 	}
 
+	config := ParseUAEConfig(templateContentStr)
+
 	configPathname := filepath.Join(
 		os.TempDir(),
 		shared.AMIBERRY_TEMPORARY_CONFIG_FILENAME)
 
-	n, err = utils.FileUtilsInstance.FileWriteBytes(
-		configPathname,
-		0,
-		[]byte(templateContentStr),
-		os.O_CREATE|os.O_WRONLY,
-		0777,
-		nil)
-
-	if err != nil {
+	if err := config.Save(configPathname); err != nil {
 		return "", err
 	}
 
-	if n <= 0 {
-		return "", errors.New("Cannot write processed config file " + configPathname)
-	}
+	ae.startedConfig = config
+	ae.commander.SetRuntimeConfig(config.Clone())
 
 	return configPathname, nil
 }
@@ -260,9 +215,9 @@ func (ae *AmiberryEmulator) loop() {
 		configPathname, err := ae.getEmulatorProcessedConfig()
 
 		if err != nil {
-			if ae.IsDebugMode() {
-				log.Println(err)
-			}
+			// always logged, usually it is an error
+			// in the config template
+			log.Println(err)
 
 			break
 		}
@@ -318,6 +273,42 @@ func (ae *AmiberryEmulator) GetConfigPathname() string {
 	return ae.configPathname
 }
 
+// SetProfile sets the name of the profile and its config
+// template, it will be used after the next (re)start
+// of the emulator
+func (ae *AmiberryEmulator) SetProfile(profile string, configPathname string) {
+	ae.profile = profile
+	ae.configPathname = configPathname
+}
+
+func (ae *AmiberryEmulator) GetProfile() string {
+	return ae.profile
+}
+
+// GetStartedConfig returns the config the emulator
+// was (re)started with, nil if not started yet
+func (ae *AmiberryEmulator) GetStartedConfig() *UAEConfig {
+	return ae.startedConfig
+}
+
+// GetRuntimeConfig returns the started config with
+// the options changed at runtime applied
+func (ae *AmiberryEmulator) GetRuntimeConfig() *UAEConfig {
+	return ae.commander.GetRuntimeConfig()
+}
+
+// DiffRuntimeConfig returns options changed at runtime
+func (ae *AmiberryEmulator) DiffRuntimeConfig() []UAEConfigChange {
+	startedConfig := ae.GetStartedConfig()
+	runtimeConfig := ae.GetRuntimeConfig()
+
+	if startedConfig == nil || runtimeConfig == nil {
+		return make([]UAEConfigChange, 0)
+	}
+
+	return startedConfig.Diff(runtimeConfig)
+}
+
 func (ae *AmiberryEmulator) AttachAdf(
 	index int,
 	pathname string,
@@ -487,6 +478,47 @@ func (ae *AmiberryEmulator) GetHd(index int) string {
 	return ae.hdfs[index]
 }
 
+func (ae *AmiberryEmulator) ShowOSDMessage(message string) error {
+	ae.commander.PutOSDMessageCommand(message)
+	ae.commander.PutLocalCommitCommand()
+
+	ae.commander.Execute()
+
+	return nil
+}
+
+func (ae *AmiberryEmulator) SaveState(pathname string) error {
+	if strings.Contains(pathname, "#") {
+		return errors.New("save-state pathname cannot contain #")
+	}
+
+	if !ae.commander.PutSaveStateCommand(pathname) {
+		return errors.New("emulator is paused, cannot save state")
+	}
+
+	ae.commander.PutLocalCommitCommand()
+
+	ae.commander.Execute()
+
+	return nil
+}
+
+func (ae *AmiberryEmulator) LoadState(pathname string) error {
+	if strings.Contains(pathname, "#") {
+		return errors.New("save-state pathname cannot contain #")
+	}
+
+	if !ae.commander.PutLoadStateCommand(pathname) {
+		return errors.New("emulator is paused, cannot load state")
+	}
+
+	ae.commander.PutLocalCommitCommand()
+
+	ae.commander.Execute()
+
+	return nil
+}
+
 func (ae *AmiberryEmulator) SoftReset() error {
 	ae.commander.PutUAEResetCommand()
 	ae.commander.Execute()
@@ -1,12 +1,15 @@
 package components
 
 import (
+	"sync"
 	"time"
 
 	"github.com/skazanyNaGlany/go.amipi400/amipi400/interfaces"
+	"github.com/skazanyNaGlany/go.amipi400/shared"
 	"github.com/skazanyNaGlany/go.amipi400/shared/components"
 	"github.com/skazanyNaGlany/go.amipi400/shared/components/utils"
 	"github.com/thoas/go-funk"
+	"golang.org/x/exp/slices"
 )
 
 type AmigaDiskDevicesDiscovery struct {
@@ -15,6 +18,7 @@ type AmigaDiskDevicesDiscovery struct {
 	detachedAmigaDiskDeviceCallback interfaces.DetachedAmigaDiskDeviceCallback
 	mountpoint                      string
 	currentFiles                    []string
+	currentFilesMutex               sync.Mutex
 	isIdle                          bool
 	idleCallback                    interfaces.IdleCallback
 }
@@ -25,20 +29,49 @@ func (addd *AmigaDiskDevicesDiscovery) loop() {
 	for addd.IsRunning() {
 		time.Sleep(time.Millisecond * 10)
 
-		addd.currentFiles = utils.FileUtilsInstance.GetDirFiles(addd.mountpoint, false)
+		// only disk images, there is also
+		// the status file in the mountpoint
+		files := utils.FileUtilsInstance.GetDirFiles(
+			addd.mountpoint,
+			false,
+			shared.FLOPPY_ADF_FULL_EXTENSION,
+			shared.HD_HDF_FULL_EXTENSION,
+			shared.CD_ISO_FULL_EXTENSION)
 
-		addd.callCallbacks(addd.currentFiles, oldFiles)
+		addd.setCurrentFiles(files)
+		addd.callCallbacks(files, oldFiles)
 
-		oldFiles = addd.currentFiles
+		oldFiles = files
 	}
 
 	addd.SetRunning(false)
 }
 
+// setCurrentFiles replaces the files, they
+// are read by other goroutines
+func (addd *AmigaDiskDevicesDiscovery) setCurrentFiles(files []string) {
+	addd.currentFilesMutex.Lock()
+	defer addd.currentFilesMutex.Unlock()
+
+	addd.currentFiles = files
+}
+
 func (addd *AmigaDiskDevicesDiscovery) HasFile(pathname string) bool {
+	addd.currentFilesMutex.Lock()
+	defer addd.currentFilesMutex.Unlock()
+
 	return funk.ContainsString(addd.currentFiles, pathname)
 }
 
+// GetFiles returns copy of pathnames of all the disk
+// images currently exposed by amiga_disk_devices
+func (addd *AmigaDiskDevicesDiscovery) GetFiles() []string {
+	addd.currentFilesMutex.Lock()
+	defer addd.currentFilesMutex.Unlock()
+
+	return slices.Clone(addd.currentFiles)
+}
+
 func (addd *AmigaDiskDevicesDiscovery) callCallbacks(files []string, oldFiles []string) {
 	affected := 0
 	oldIsIdle := addd.isIdle
@@ -83,7 +116,7 @@ func (addd *AmigaDiskDevicesDiscovery) SetDetachedAmigaDiskDeviceCallback(
 }
 
 func (addd *AmigaDiskDevicesDiscovery) Run() {
-	addd.currentFiles = make([]string, 0)
+	addd.setCurrentFiles(make([]string, 0))
 
 	addd.loop()
 }
@@ -0,0 +1,376 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/skazanyNaGlany/go.amipi400/shared"
+	"github.com/skazanyNaGlany/go.amipi400/shared/components/utils"
+	"golang.org/x/exp/slices"
+)
+
+// getArgs returns required arguments, named the same
+// as the groups in the old keyboard regexes
+func getArgs(args map[string]string, upper bool, names ...string) ([]string, error) {
+	values := make([]string, 0, len(names))
+
+	for _, name := range names {
+		value := strings.TrimSpace(args[name])
+
+		if value == "" {
+			return nil, fmt.Errorf("%w: missing argument %v", ErrInvalidCommand, name)
+		}
+
+		if upper {
+			value = strings.ToUpper(value)
+		}
+
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+// getDevice returns upper-cased low-level device
+// argument, it must be one of the devices
+func getDevice(args map[string]string, name string, devices ...string) (string, error) {
+	values, err := getArgs(args, true, name)
+
+	if err != nil {
+		return "", err
+	}
+
+	if !slices.Contains(devices, values[0]) {
+		return "", fmt.Errorf("%w: bad %v %v", ErrInvalidCommand, name, values[0])
+	}
+
+	return values[0], nil
+}
+
+// getIndex returns required index argument
+func getIndex(args map[string]string, name string) (int, error) {
+	values, err := getArgs(args, true, name)
+
+	if err != nil {
+		return shared.DRIVE_INDEX_UNSPECIFIED, err
+	}
+
+	return parseIndex(values[0], false)
+}
+
+func getTargetIndex(args map[string]string, allowAll bool) (int, error) {
+	targetIndex := strings.ToUpper(strings.TrimSpace(args["target_index"]))
+
+	if targetIndex == "" {
+		return shared.DRIVE_INDEX_UNSPECIFIED, nil
+	}
+
+	return parseIndex(targetIndex, allowAll)
+}
+
+func getSourceIndex(args map[string]string, allowAll bool) (int, error) {
+	values, err := getArgs(args, true, "source_index")
+
+	if err != nil {
+		return shared.DRIVE_INDEX_UNSPECIFIED, err
+	}
+
+	return parseIndex(values[0], allowAll)
+}
+
+func argsToInsert(args map[string]string, allowAllTarget bool) (int, int, string, error) {
+	sourceIndex, err := getSourceIndex(args, false)
+
+	if err != nil {
+		return 0, 0, "", err
+	}
+
+	targetIndex, err := getTargetIndex(args, allowAllTarget)
+
+	if err != nil {
+		return 0, 0, "", err
+	}
+
+	values, err := getArgs(args, true, "filename_part")
+
+	if err != nil {
+		return 0, 0, "", err
+	}
+
+	return sourceIndex, targetIndex, values[0], nil
+}
+
+// FromArgs builds a Command from its name and named
+// arguments, used by non-keyboard front-ends
+func FromArgs(name string, args map[string]string) (Command, error) {
+	switch name {
+	case shared.CONTROL_CMD_KEYBOARD:
+		values, err := getArgs(args, false, "command")
+
+		if err != nil {
+			return nil, err
+		}
+
+		return Parse(values[0])
+	case shared.CONTROL_CMD_DF_INSERT:
+		sourceIndex, targetIndex, pattern, err := argsToInsert(args, true)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &InsertFloppy{
+			Source:  sourceIndex,
+			Target:  targetIndex,
+			Pattern: pattern,
+			DiskNo:  shared.DISK_INDEX_UNSPECIFIED}, nil
+	case shared.CONTROL_CMD_DF_INSERT_BY_DISK_NO:
+		sourceIndex, err := getSourceIndex(args, false)
+
+		if err != nil {
+			return nil, err
+		}
+
+		targetIndex, err := getTargetIndex(args, true)
+
+		if err != nil {
+			return nil, err
+		}
+
+		values, err := getArgs(args, false, "disk_no")
+
+		if err != nil {
+			return nil, err
+		}
+
+		diskNo, err := utils.StringUtilsInstance.StringToInt(values[0], 10, 16)
+
+		if err != nil || diskNo < 0 {
+			return nil, fmt.Errorf("%w: bad disk number %v", ErrInvalidCommand, values[0])
+		}
+
+		return &InsertFloppy{
+			Source: sourceIndex,
+			Target: targetIndex,
+			DiskNo: diskNo}, nil
+	case shared.CONTROL_CMD_DF_EJECT:
+		sourceIndex, err := getSourceIndex(args, true)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &EjectFloppy{Source: sourceIndex}, nil
+	case shared.CONTROL_CMD_CD_INSERT:
+		sourceIndex, targetIndex, pattern, err := argsToInsert(args, false)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &InsertCD{Source: sourceIndex, Target: targetIndex, Pattern: pattern}, nil
+	case shared.CONTROL_CMD_CD_EJECT:
+		sourceIndex, err := getSourceIndex(args, false)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &EjectCD{Source: sourceIndex}, nil
+	case shared.CONTROL_CMD_HF_INSERT:
+		sourceIndex, targetIndex, pattern, err := argsToInsert(args, false)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &InsertHardFile{Source: sourceIndex, Target: targetIndex, Pattern: pattern}, nil
+	case shared.CONTROL_CMD_HF_EJECT:
+		sourceIndex, err := getSourceIndex(args, false)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &EjectHardFile{Source: sourceIndex}, nil
+	case shared.CONTROL_CMD_UNMOUNT_ALL:
+		return &UnmountAll{}, nil
+	case shared.CONTROL_CMD_DF_UNMOUNT,
+		shared.CONTROL_CMD_CD_UNMOUNT,
+		shared.CONTROL_CMD_HF_UNMOUNT,
+		shared.CONTROL_CMD_DH_UNMOUNT:
+		sourceIndex, err := getSourceIndex(args, true)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// df_unmount -> DF, etc.
+		device := strings.ToUpper(strings.SplitN(name, "_", 2)[0])
+
+		return &Unmount{Device: device, Source: sourceIndex}, nil
+	case shared.CONTROL_CMD_LOW_LEVEL_COPY:
+		devices := []string{
+			shared.LOW_LEVEL_DEVICE_FLOPPY,
+			shared.LOW_LEVEL_DEVICE_HARD_DISK,
+			shared.LOW_LEVEL_DEVICE_HARD_FILE,
+			shared.LOW_LEVEL_DEVICE_CD}
+
+		sourceDevice, err := getDevice(args, "source_low_level_device", devices...)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sourceIndex, err := getIndex(args, "source_index")
+
+		if err != nil {
+			return nil, err
+		}
+
+		targetDevice, err := getDevice(args, "target_low_level_device", devices...)
+
+		if err != nil {
+			return nil, err
+		}
+
+		targetIndex, err := getIndex(args, "target_index")
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &LowLevelCopy{
+			SourceDevice: sourceDevice,
+			Source:       sourceIndex,
+			TargetDevice: targetDevice,
+			Target:       targetIndex}, nil
+	case shared.CONTROL_CMD_DF_ARCHIVE:
+		sourceIndex, err := getIndex(args, "source_index")
+
+		if err != nil {
+			return nil, err
+		}
+
+		targetDevice, err := getDevice(
+			args,
+			"target_low_level_device",
+			shared.LOW_LEVEL_DEVICE_FLOPPY,
+			shared.LOW_LEVEL_DEVICE_HARD_DISK)
+
+		if err != nil {
+			return nil, err
+		}
+
+		targetIndex, err := getIndex(args, "target_index")
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &ArchiveFloppy{
+			Source:       sourceIndex,
+			TargetDevice: targetDevice,
+			Target:       targetIndex}, nil
+	case shared.CONTROL_CMD_DF_WRITE:
+		sourceIndex, targetIndex, pattern, err := argsToInsert(args, false)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if targetIndex == shared.DRIVE_INDEX_UNSPECIFIED {
+			return nil, fmt.Errorf("%w: missing argument target_index", ErrInvalidCommand)
+		}
+
+		return &WriteFloppy{
+			Source:  sourceIndex,
+			Pattern: pattern,
+			Target:  targetIndex}, nil
+	case shared.CONTROL_CMD_CREATE_ADF:
+		targetDevice, err := getDevice(
+			args,
+			"target_low_level_device",
+			shared.LOW_LEVEL_DEVICE_FLOPPY,
+			shared.LOW_LEVEL_DEVICE_HARD_DISK)
+
+		if err != nil {
+			return nil, err
+		}
+
+		targetIndex, err := getIndex(args, "target_index")
+
+		if err != nil {
+			return nil, err
+		}
+
+		command := &CreateAdf{
+			TargetDevice: targetDevice,
+			Target:       targetIndex,
+			VolumeName:   strings.TrimSpace(args["volume_name"]),
+			FFS:          shared.FORMAT_FLOPPY_FFS}
+
+		switch strings.ToLower(strings.TrimSpace(args["file_system"])) {
+		case "":
+		case shared.CREATE_ADF_FILE_SYSTEM_OFS:
+			command.FFS = false
+		case shared.CREATE_ADF_FILE_SYSTEM_FFS:
+			command.FFS = true
+		default:
+			return nil, fmt.Errorf("%w: bad file system %v", ErrInvalidCommand, args["file_system"])
+		}
+
+		return command, nil
+	case shared.CONTROL_CMD_SOFT_RESET:
+		return &SoftReset{}, nil
+	case shared.CONTROL_CMD_HARD_RESET:
+		return &HardReset{}, nil
+	case shared.CONTROL_CMD_TOGGLE_ZOOM:
+		return &ToggleZoom{}, nil
+	case shared.CONTROL_CMD_WIFI_CONNECT:
+		values, err := getArgs(
+			args,
+			false,
+			"country_code_iso_iec_3166_1",
+			"ssid",
+			"password")
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &WifiConnect{
+			CountryCode: strings.ToUpper(values[0]),
+			SSID:        values[1],
+			Password:    values[2]}, nil
+	case shared.CONTROL_CMD_WIFI_DISCONNECT:
+		return &WifiDisconnect{}, nil
+	case shared.CONTROL_CMD_STATUS:
+		return &Status{}, nil
+	case shared.CONTROL_CMD_CONFIG:
+		return &ShowConfig{}, nil
+	case shared.CONTROL_CMD_SWITCH_PROFILE:
+		// empty or missing profile means the default profile
+		return newSwitchProfile(strings.TrimSpace(args["profile"]))
+	case shared.CONTROL_CMD_SAVE_STATE, shared.CONTROL_CMD_LOAD_STATE:
+		values, err := getArgs(args, false, "slot")
+
+		if err != nil {
+			return nil, err
+		}
+
+		slot, err := parseIndex(values[0], false)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if name == shared.CONTROL_CMD_SAVE_STATE {
+			return &SaveState{Slot: slot}, nil
+		}
+
+		return &LoadState{Slot: slot}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %v", ErrUnknownCommand, name)
+}
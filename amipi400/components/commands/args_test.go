@@ -0,0 +1,127 @@
+package commands
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/skazanyNaGlany/go.amipi400/shared"
+)
+
+func TestFromArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     map[string]string
+		expected Command
+	}{
+		{shared.CONTROL_CMD_KEYBOARD, map[string]string{"command": "df0"}, &EjectFloppy{Source: 0}},
+		{shared.CONTROL_CMD_DF_INSERT,
+			map[string]string{"source_index": "0", "filename_part": "kwater", "target_index": "n"},
+			&InsertFloppy{
+				Source:  0,
+				Target:  shared.DRIVE_INDEX_ALL,
+				Pattern: "KWATER",
+				DiskNo:  shared.DISK_INDEX_UNSPECIFIED}},
+		{shared.CONTROL_CMD_DF_INSERT_BY_DISK_NO,
+			map[string]string{"source_index": "0", "disk_no": "2"},
+			&InsertFloppy{Source: 0, Target: shared.DRIVE_INDEX_UNSPECIFIED, DiskNo: 2}},
+		{shared.CONTROL_CMD_DF_EJECT, map[string]string{"source_index": "N"}, &EjectFloppy{Source: shared.DRIVE_INDEX_ALL}},
+		{shared.CONTROL_CMD_DH_UNMOUNT,
+			map[string]string{"source_index": "1"},
+			&Unmount{Device: shared.LOW_LEVEL_DEVICE_HARD_DISK, Source: 1}},
+		{shared.CONTROL_CMD_LOW_LEVEL_COPY,
+			map[string]string{
+				"source_low_level_device": "df",
+				"source_index":            "0",
+				"target_low_level_device": "dh",
+				"target_index":            "1"},
+			&LowLevelCopy{
+				SourceDevice: shared.LOW_LEVEL_DEVICE_FLOPPY,
+				Source:       0,
+				TargetDevice: shared.LOW_LEVEL_DEVICE_HARD_DISK,
+				Target:       1}},
+		{shared.CONTROL_CMD_DF_ARCHIVE,
+			map[string]string{"source_index": "0", "target_low_level_device": "DH", "target_index": "1"},
+			&ArchiveFloppy{Source: 0, TargetDevice: shared.LOW_LEVEL_DEVICE_HARD_DISK, Target: 1}},
+		{shared.CONTROL_CMD_DF_WRITE,
+			map[string]string{"source_index": "0", "filename_part": "workbench", "target_index": "1"},
+			&WriteFloppy{Source: 0, Pattern: "WORKBENCH", Target: 1}},
+		{shared.CONTROL_CMD_CREATE_ADF,
+			map[string]string{
+				"target_low_level_device": "dh",
+				"target_index":            "1",
+				"volume_name":             " My Work ",
+				"file_system":             "FFS"},
+			&CreateAdf{
+				TargetDevice: shared.LOW_LEVEL_DEVICE_HARD_DISK,
+				Target:       1,
+				VolumeName:   "My Work",
+				FFS:          true}},
+		{shared.CONTROL_CMD_WIFI_CONNECT,
+			map[string]string{"country_code_iso_iec_3166_1": "pl", "ssid": "ssid", "password": "Password"},
+			&WifiConnect{CountryCode: "PL", SSID: "ssid", Password: "Password"}},
+		{shared.CONTROL_CMD_SWITCH_PROFILE, map[string]string{"profile": "CD32"}, &SwitchProfile{Profile: "cd32"}},
+		{shared.CONTROL_CMD_SWITCH_PROFILE, map[string]string{}, &SwitchProfile{}},
+		{shared.CONTROL_CMD_SAVE_STATE, map[string]string{"slot": "1"}, &SaveState{Slot: 1}},
+		{shared.CONTROL_CMD_LOAD_STATE, map[string]string{"slot": "1"}, &LoadState{Slot: 1}},
+	}
+
+	for _, test := range tests {
+		command, err := FromArgs(test.name, test.args)
+
+		if err != nil {
+			t.Errorf("FromArgs(%v, %v): %v", test.name, test.args, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(command, test.expected) {
+			t.Errorf("FromArgs(%v, %v) = %#v, expected %#v", test.name, test.args, command, test.expected)
+		}
+	}
+}
+
+func TestFromArgsErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     map[string]string
+		expected error
+	}{
+		{"unknown", map[string]string{}, ErrUnknownCommand},
+		{shared.CONTROL_CMD_DF_EJECT, map[string]string{}, ErrInvalidCommand},
+		{shared.CONTROL_CMD_LOW_LEVEL_COPY,
+			map[string]string{
+				"source_low_level_device": "D",
+				"source_index":            "0",
+				"target_low_level_device": "DH",
+				"target_index":            "1"},
+			ErrInvalidCommand},
+		{shared.CONTROL_CMD_LOW_LEVEL_COPY,
+			map[string]string{
+				"source_low_level_device": "DF",
+				"source_index":            "0",
+				"target_low_level_device": "DH",
+				"target_index":            "12"},
+			ErrInvalidCommand},
+		{shared.CONTROL_CMD_DF_ARCHIVE,
+			map[string]string{"source_index": "0", "target_low_level_device": "HF", "target_index": "1"},
+			ErrInvalidCommand},
+		{shared.CONTROL_CMD_DF_WRITE,
+			map[string]string{"source_index": "0", "filename_part": "workbench"},
+			ErrInvalidCommand},
+		{shared.CONTROL_CMD_CREATE_ADF,
+			map[string]string{"target_low_level_device": "DH1", "target_index": "1"},
+			ErrInvalidCommand},
+		{shared.CONTROL_CMD_CREATE_ADF,
+			map[string]string{"target_low_level_device": "DH", "target_index": "1", "file_system": "sfs"},
+			ErrInvalidCommand},
+		{shared.CONTROL_CMD_SAVE_STATE, map[string]string{"slot": "N"}, ErrInvalidCommand},
+	}
+
+	for _, test := range tests {
+		command, err := FromArgs(test.name, test.args)
+
+		if !errors.Is(err, test.expected) {
+			t.Errorf("FromArgs(%v, %v) = %#v, %v, expected %v", test.name, test.args, command, err, test.expected)
+		}
+	}
+}
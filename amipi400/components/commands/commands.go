@@ -0,0 +1,226 @@
+package commands
+
+import "github.com/skazanyNaGlany/go.amipi400/shared"
+
+// Command is a single parsed command, Name() is used
+// to find its handler in the Registry
+//
+// Indexes are shared.DRIVE_INDEX_UNSPECIFIED when not given
+// and shared.DRIVE_INDEX_ALL for "N"
+type Command interface {
+	Name() string
+}
+
+// example: df0, dfn
+type EjectFloppy struct {
+	Source int
+}
+
+// example: df0traps, df0kwaterdf1, df0kwaterdfn, df02, df02df1
+type InsertFloppy struct {
+	Source  int
+	Target  int
+	Pattern string
+	DiskNo  int
+}
+
+// example: cd0
+type EjectCD struct {
+	Source int
+}
+
+// example: cd0workbenchiso
+type InsertCD struct {
+	Source  int
+	Target  int
+	Pattern string
+}
+
+// example: hf0
+type EjectHardFile struct {
+	Source int
+}
+
+// example: hf0workbenchhdf
+type InsertHardFile struct {
+	Source  int
+	Target  int
+	Pattern string
+}
+
+// example: u
+type UnmountAll struct{}
+
+// example: udf0, ucdn, uhf1, udhn
+type Unmount struct {
+	Device string
+	Source int
+}
+
+// example: cdf0dh1
+type LowLevelCopy struct {
+	SourceDevice string
+	Source       int
+	TargetDevice string
+	Target       int
+}
+
+// example: w,PL,ssid,password
+type WifiConnect struct {
+	CountryCode string
+	SSID        string
+	Password    string
+}
+
+// example: w
+type WifiDisconnect struct{}
+
+// example: pcd32, p (default profile)
+type SwitchProfile struct {
+	Profile string
+}
+
+// example: ss1
+type SaveState struct {
+	Slot int
+}
+
+// example: ls1
+type LoadState struct {
+	Slot int
+}
+
+// example: adf0dh1, adf0df1
+type ArchiveFloppy struct {
+	Source       int
+	TargetDevice string
+	Target       int
+}
+
+// example: rdf0workbenchdf1, ADF matching the pattern on the medium
+// mounted as DF0 is written to the physical floppy in DF1
+type WriteFloppy struct {
+	Source  int
+	Pattern string
+	Target  int
+}
+
+// example: fdh1Work, blank ADF with "Work" volume
+// is created on the medium mounted as DH1
+type CreateAdf struct {
+	TargetDevice string
+	Target       int
+	VolumeName   string
+	FFS          bool
+}
+
+type SoftReset struct{}
+
+type HardReset struct{}
+
+type ToggleZoom struct{}
+
+type Status struct{}
+
+type ShowConfig struct{}
+
+func (c *EjectFloppy) Name() string {
+	return shared.CONTROL_CMD_DF_EJECT
+}
+
+func (c *InsertFloppy) Name() string {
+	return shared.CONTROL_CMD_DF_INSERT
+}
+
+func (c *InsertFloppy) IsByDiskNo() bool {
+	return c.DiskNo != shared.DISK_INDEX_UNSPECIFIED
+}
+
+func (c *EjectCD) Name() string {
+	return shared.CONTROL_CMD_CD_EJECT
+}
+
+func (c *InsertCD) Name() string {
+	return shared.CONTROL_CMD_CD_INSERT
+}
+
+func (c *EjectHardFile) Name() string {
+	return shared.CONTROL_CMD_HF_EJECT
+}
+
+func (c *InsertHardFile) Name() string {
+	return shared.CONTROL_CMD_HF_INSERT
+}
+
+func (c *UnmountAll) Name() string {
+	return shared.CONTROL_CMD_UNMOUNT_ALL
+}
+
+func (c *Unmount) Name() string {
+	switch c.Device {
+	case shared.LOW_LEVEL_DEVICE_FLOPPY:
+		return shared.CONTROL_CMD_DF_UNMOUNT
+	case shared.LOW_LEVEL_DEVICE_CD:
+		return shared.CONTROL_CMD_CD_UNMOUNT
+	case shared.LOW_LEVEL_DEVICE_HARD_FILE:
+		return shared.CONTROL_CMD_HF_UNMOUNT
+	}
+
+	return shared.CONTROL_CMD_DH_UNMOUNT
+}
+
+func (c *LowLevelCopy) Name() string {
+	return shared.CONTROL_CMD_LOW_LEVEL_COPY
+}
+
+func (c *WifiConnect) Name() string {
+	return shared.CONTROL_CMD_WIFI_CONNECT
+}
+
+func (c *WifiDisconnect) Name() string {
+	return shared.CONTROL_CMD_WIFI_DISCONNECT
+}
+
+func (c *SwitchProfile) Name() string {
+	return shared.CONTROL_CMD_SWITCH_PROFILE
+}
+
+func (c *SaveState) Name() string {
+	return shared.CONTROL_CMD_SAVE_STATE
+}
+
+func (c *LoadState) Name() string {
+	return shared.CONTROL_CMD_LOAD_STATE
+}
+
+func (c *SoftReset) Name() string {
+	return shared.CONTROL_CMD_SOFT_RESET
+}
+
+func (c *HardReset) Name() string {
+	return shared.CONTROL_CMD_HARD_RESET
+}
+
+func (c *ToggleZoom) Name() string {
+	return shared.CONTROL_CMD_TOGGLE_ZOOM
+}
+
+func (c *Status) Name() string {
+	return shared.CONTROL_CMD_STATUS
+}
+
+func (c *ShowConfig) Name() string {
+	return shared.CONTROL_CMD_CONFIG
+}
+
+func (c *ArchiveFloppy) Name() string {
+	return shared.CONTROL_CMD_DF_ARCHIVE
+}
+
+func (c *WriteFloppy) Name() string {
+	return shared.CONTROL_CMD_DF_WRITE
+}
+
+func (c *CreateAdf) Name() string {
+	return shared.CONTROL_CMD_CREATE_ADF
+}
@@ -0,0 +1,95 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/skazanyNaGlany/go.amipi400/shared"
+)
+
+// parser/registry errors
+var ErrUnknownCommand = errors.New("unknown command")
+var ErrInvalidCommand = errors.New("invalid command")
+var ErrNoHandler = errors.New("no handler registered")
+
+// handler errors, wrapped with the context using fmt.Errorf("%w ...")
+var ErrNotReady = errors.New("still initializing, try again later")
+var ErrBusy = errors.New("floppy copy in progress, try again later")
+var ErrInvalidIndex = errors.New("invalid index")
+var ErrUnsupportedDevice = errors.New("unsupported low-level device")
+var ErrEmptyPattern = errors.New("empty filename pattern")
+var ErrNoMountpoint = errors.New("no medium mounted")
+var ErrNotAttached = errors.New("not attached")
+var ErrManagedExternally = errors.New("managed by " + shared.AMIGA_DISK_DEVICES_UNIXNAME)
+var ErrWrongMediumType = errors.New("wrong medium type")
+var ErrNotFound = errors.New("cannot find")
+var ErrAmbiguousMatch = errors.New("ambiguous match")
+var ErrAttachFailed = errors.New("cannot attach")
+var ErrDetachFailed = errors.New("cannot detach")
+var ErrCopyFailed = errors.New("cannot copy")
+var ErrUnmountFailed = errors.New("cannot unmount")
+var ErrVerifyFailed = errors.New("verification failed")
+
+const ERROR_KIND_OTHER = "other"
+
+// errorKinds is ordered, the first matching kind wins
+var errorKinds = []struct {
+	err  error
+	kind string
+}{
+	{ErrUnknownCommand, "unknown_command"},
+	{ErrInvalidCommand, "invalid_command"},
+	{ErrNoHandler, "no_handler"},
+	{ErrNotReady, "not_ready"},
+	{ErrBusy, "busy"},
+	{ErrInvalidIndex, "invalid_index"},
+	{ErrUnsupportedDevice, "unsupported_device"},
+	{ErrEmptyPattern, "empty_pattern"},
+	{ErrNoMountpoint, "no_mountpoint"},
+	{ErrNotAttached, "not_attached"},
+	{ErrManagedExternally, "managed_externally"},
+	{ErrWrongMediumType, "wrong_medium_type"},
+	{ErrNotFound, "not_found"},
+	{ErrAmbiguousMatch, "ambiguous_match"},
+	{ErrAttachFailed, "attach_failed"},
+	{ErrDetachFailed, "detach_failed"},
+	{ErrCopyFailed, "copy_failed"},
+	{ErrUnmountFailed, "unmount_failed"},
+	{ErrVerifyFailed, "verify_failed"},
+}
+
+// CommandError is returned by the Registry, it adds
+// the command name to the error returned by the handler
+type CommandError struct {
+	Name string
+	Err  error
+}
+
+func (ce *CommandError) Error() string {
+	if ce.Name == "" {
+		return ce.Err.Error()
+	}
+
+	return fmt.Sprintf("%v: %v", ce.Name, ce.Err)
+}
+
+func (ce *CommandError) Unwrap() error {
+	return ce.Err
+}
+
+// ErrorKind returns short, stable name of the error,
+// empty string for nil and ERROR_KIND_OTHER
+// for errors not defined here
+func ErrorKind(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	for _, iKind := range errorKinds {
+		if errors.Is(err, iKind.err) {
+			return iKind.kind
+		}
+	}
+
+	return ERROR_KIND_OTHER
+}
@@ -0,0 +1,466 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/skazanyNaGlany/go.amipi400/shared"
+	"github.com/skazanyNaGlany/go.amipi400/shared/components/utils"
+)
+
+// Grammar (case-insensitive, except WIFI SSID and password
+// and the volume name):
+//
+//	command          = unmount-all | wifi-disconnect | wifi-connect | unmount
+//	                 | low-level-copy | floppy | cd | hard-file
+//	                 | save-state | load-state | switch-profile
+//	                 | archive-floppy | write-floppy | create-adf
+//	unmount-all      = "U"
+//	wifi-disconnect  = "W"
+//	wifi-connect     = "W," country-code "," ssid "," password
+//	unmount          = "U" ("DF" | "CD" | "HF" | "DH") index-or-all
+//	low-level-copy   = "C" device digit device digit
+//	floppy           = "DF" index-or-all
+//	                 | "DF" digit (disk-no | pattern) ["DF" index-or-all]
+//	cd               = "CD" digit [pattern]
+//	hard-file        = "HF" digit [pattern]
+//	save-state       = "SS" digit
+//	load-state       = "LS" digit
+//	switch-profile   = "P" [profile-name]
+//	archive-floppy   = "A" "DF" digit ("DF" | "DH") digit
+//	write-floppy     = "R" "DF" digit pattern "DF" digit
+//	create-adf       = "F" ("DF" | "DH") digit [volume-name]
+//	index-or-all     = digit | "N"
+//	disk-no          = digit [digit]
+//
+// Rules are selected by the command prefix, so there is no
+// ordering between them: a floppy command ending with
+// "DF" index-or-all always has a target, and its middle part
+// is a disk number when it has only one or two digits,
+// otherwise it is a filename pattern.
+
+// Parse parses a command typed on the keyboard (without
+// the surrounding TABs) into a typed Command
+func Parse(command string) (Command, error) {
+	upper := strings.ToUpper(command)
+
+	switch {
+	case upper == "U":
+		return &UnmountAll{}, nil
+	case upper == "W":
+		return &WifiDisconnect{}, nil
+	case strings.HasPrefix(upper, "W,"):
+		return parseWifiConnect(command)
+	case strings.HasPrefix(upper, "U"):
+		return parseUnmount(upper)
+	case strings.HasPrefix(upper, shared.LOW_LEVEL_DEVICE_FLOPPY):
+		return parseFloppy(upper)
+	case strings.HasPrefix(upper, shared.LOW_LEVEL_DEVICE_HARD_FILE):
+		return parseHardFile(upper)
+	case isLowLevelCopy(upper):
+		return parseLowLevelCopy(upper)
+	case strings.HasPrefix(upper, shared.LOW_LEVEL_DEVICE_CD):
+		return parseCD(upper)
+	case strings.HasPrefix(upper, shared.SAVESTATE_COMMAND_SAVE):
+		return parseSaveState(upper)
+	case strings.HasPrefix(upper, shared.SAVESTATE_COMMAND_LOAD):
+		return parseLoadState(upper)
+	case strings.HasPrefix(upper, shared.PROFILE_COMMAND):
+		return parseSwitchProfile(upper)
+	case strings.HasPrefix(upper, shared.ARCHIVE_COMMAND):
+		return parseArchiveFloppy(upper)
+	case strings.HasPrefix(upper, shared.WRITE_FLOPPY_COMMAND):
+		return parseWriteFloppy(upper)
+	case strings.HasPrefix(upper, shared.CREATE_ADF_COMMAND):
+		return parseCreateAdf(command)
+	}
+
+	return nil, fmt.Errorf("%w: %v", ErrUnknownCommand, command)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return c >= 'A' && c <= 'Z'
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+
+	return s != ""
+}
+
+// parseIndex parses single digit or "N" (when allowAll)
+func parseIndex(index string, allowAll bool) (int, error) {
+	if allowAll && index == shared.DRIVE_INDEX_ALL_STR {
+		return shared.DRIVE_INDEX_ALL, nil
+	}
+
+	if len(index) != 1 || !isDigit(index[0]) {
+		return shared.DRIVE_INDEX_UNSPECIFIED, fmt.Errorf("%w: bad index %v", ErrInvalidCommand, index)
+	}
+
+	return int(index[0] - '0'), nil
+}
+
+// splitSource splits "DF0xyz" into "0" and "xyz"
+func splitSource(upper string, prefix string) (string, string, error) {
+	rest := strings.TrimPrefix(upper, prefix)
+
+	if rest == "" {
+		return "", "", fmt.Errorf("%w: %v needs an index", ErrInvalidCommand, upper)
+	}
+
+	return rest[:1], rest[1:], nil
+}
+
+func parseFloppy(upper string) (Command, error) {
+	source, body, err := splitSource(upper, shared.LOW_LEVEL_DEVICE_FLOPPY)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if body == "" {
+		sourceIndex, err := parseIndex(source, true)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &EjectFloppy{Source: sourceIndex}, nil
+	}
+
+	sourceIndex, err := parseIndex(source, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	insert := InsertFloppy{
+		Source: sourceIndex,
+		Target: shared.DRIVE_INDEX_UNSPECIFIED,
+		DiskNo: shared.DISK_INDEX_UNSPECIFIED}
+
+	// optional target, "DF" digit-or-N at the end, body
+	// which is the target only (eg. DF0DF1) has
+	// empty pattern
+	if lenBody := len(body); lenBody >= 3 &&
+		body[lenBody-3:lenBody-1] == shared.LOW_LEVEL_DEVICE_FLOPPY {
+		targetIndex, err := parseIndex(body[lenBody-1:], true)
+
+		if err == nil {
+			insert.Target = targetIndex
+			body = body[:lenBody-3]
+		}
+	}
+
+	if len(body) <= 2 && isDigits(body) {
+		insert.DiskNo, _ = utils.StringUtilsInstance.StringToInt(body, 10, 16)
+
+		return &insert, nil
+	}
+
+	insert.Pattern = strings.TrimSpace(body)
+
+	if insert.Pattern == "" {
+		return nil, fmt.Errorf("%w: %v needs a filename pattern", ErrInvalidCommand, upper)
+	}
+
+	return &insert, nil
+}
+
+func parseCD(upper string) (Command, error) {
+	source, body, err := splitSource(upper, shared.LOW_LEVEL_DEVICE_CD)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sourceIndex, err := parseIndex(source, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if body == "" {
+		return &EjectCD{Source: sourceIndex}, nil
+	}
+
+	return &InsertCD{
+		Source:  sourceIndex,
+		Target:  shared.DRIVE_INDEX_UNSPECIFIED,
+		Pattern: strings.TrimSpace(body)}, nil
+}
+
+func parseHardFile(upper string) (Command, error) {
+	source, body, err := splitSource(upper, shared.LOW_LEVEL_DEVICE_HARD_FILE)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sourceIndex, err := parseIndex(source, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if body == "" {
+		return &EjectHardFile{Source: sourceIndex}, nil
+	}
+
+	return &InsertHardFile{
+		Source:  sourceIndex,
+		Target:  shared.DRIVE_INDEX_UNSPECIFIED,
+		Pattern: strings.TrimSpace(body)}, nil
+}
+
+func parseUnmount(upper string) (Command, error) {
+	rest := strings.TrimPrefix(upper, "U")
+
+	if len(rest) != 3 {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownCommand, upper)
+	}
+
+	device := rest[:2]
+
+	switch device {
+	case shared.LOW_LEVEL_DEVICE_FLOPPY,
+		shared.LOW_LEVEL_DEVICE_CD,
+		shared.LOW_LEVEL_DEVICE_HARD_FILE,
+		shared.LOW_LEVEL_DEVICE_HARD_DISK:
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrUnknownCommand, upper)
+	}
+
+	sourceIndex, err := parseIndex(rest[2:], true)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Unmount{Device: device, Source: sourceIndex}, nil
+}
+
+// isLowLevelCopy checks for "C" letter letter digit letter letter digit
+func isLowLevelCopy(upper string) bool {
+	return len(upper) == 7 &&
+		upper[0] == 'C' &&
+		isLetter(upper[1]) && isLetter(upper[2]) && isDigit(upper[3]) &&
+		isLetter(upper[4]) && isLetter(upper[5]) && isDigit(upper[6])
+}
+
+func parseLowLevelCopy(upper string) (Command, error) {
+	sourceIndex, err := parseIndex(upper[3:4], false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	targetIndex, err := parseIndex(upper[6:7], false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &LowLevelCopy{
+		SourceDevice: upper[1:3],
+		Source:       sourceIndex,
+		TargetDevice: upper[4:6],
+		Target:       targetIndex}, nil
+}
+
+// parseArchiveFloppy parses "ADF0DH1", physical floppy
+// in DF0 is archived to the medium mounted as DH1
+func parseArchiveFloppy(upper string) (Command, error) {
+	rest := strings.TrimPrefix(upper, shared.ARCHIVE_COMMAND)
+
+	if len(rest) != 6 || rest[:2] != shared.LOW_LEVEL_DEVICE_FLOPPY {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownCommand, upper)
+	}
+
+	targetDevice := rest[3:5]
+
+	if targetDevice != shared.LOW_LEVEL_DEVICE_FLOPPY &&
+		targetDevice != shared.LOW_LEVEL_DEVICE_HARD_DISK {
+		return nil, fmt.Errorf("%w: cannot archive to %v", ErrInvalidCommand, targetDevice)
+	}
+
+	sourceIndex, err := parseIndex(rest[2:3], false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	targetIndex, err := parseIndex(rest[5:6], false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &ArchiveFloppy{
+		Source:       sourceIndex,
+		TargetDevice: targetDevice,
+		Target:       targetIndex}, nil
+}
+
+// parseWriteFloppy parses "RDF0WORKBENCHDF1", ADF matching the pattern
+// on the medium mounted as DF0 is written to the physical floppy in DF1
+func parseWriteFloppy(upper string) (Command, error) {
+	rest := strings.TrimPrefix(upper, shared.WRITE_FLOPPY_COMMAND)
+
+	if !strings.HasPrefix(rest, shared.LOW_LEVEL_DEVICE_FLOPPY) {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownCommand, upper)
+	}
+
+	source, body, err := splitSource(rest, shared.LOW_LEVEL_DEVICE_FLOPPY)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sourceIndex, err := parseIndex(source, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	lenBody := len(body)
+
+	if lenBody < 3 || body[lenBody-3:lenBody-1] != shared.LOW_LEVEL_DEVICE_FLOPPY {
+		return nil, fmt.Errorf("%w: %v needs a target", ErrInvalidCommand, upper)
+	}
+
+	targetIndex, err := parseIndex(body[lenBody-1:], false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pattern := strings.TrimSpace(body[:lenBody-3])
+
+	if pattern == "" {
+		return nil, fmt.Errorf("%w: %v needs a filename pattern", ErrInvalidCommand, upper)
+	}
+
+	return &WriteFloppy{
+		Source:  sourceIndex,
+		Pattern: pattern,
+		Target:  targetIndex}, nil
+}
+
+// parseCreateAdf parses "FDH1Work" keeping the case of the volume
+// name, empty name means the default one
+func parseCreateAdf(command string) (Command, error) {
+	rest := command[len(shared.CREATE_ADF_COMMAND):]
+
+	if len(rest) < 3 {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownCommand, command)
+	}
+
+	targetDevice := strings.ToUpper(rest[:2])
+
+	if targetDevice != shared.LOW_LEVEL_DEVICE_FLOPPY &&
+		targetDevice != shared.LOW_LEVEL_DEVICE_HARD_DISK {
+		return nil, fmt.Errorf("%w: cannot create ADF on %v", ErrInvalidCommand, targetDevice)
+	}
+
+	targetIndex, err := parseIndex(rest[2:3], false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &CreateAdf{
+		TargetDevice: targetDevice,
+		Target:       targetIndex,
+		VolumeName:   strings.TrimSpace(rest[3:]),
+		FFS:          shared.FORMAT_FLOPPY_FFS}, nil
+}
+
+// parseWifiConnect parses "W,CC,ssid,password" keeping
+// the case of the SSID and the password, SSID
+// can contain commas, the password cannot
+func parseWifiConnect(command string) (Command, error) {
+	rest := command[2:]
+
+	if len(rest) < 3 || rest[2] != ',' {
+		return nil, fmt.Errorf("%w: bad WIFI country code", ErrInvalidCommand)
+	}
+
+	countryCode := strings.ToUpper(rest[:2])
+
+	if !isLetter(countryCode[0]) || !isLetter(countryCode[1]) {
+		return nil, fmt.Errorf("%w: bad WIFI country code", ErrInvalidCommand)
+	}
+
+	credentials := rest[3:]
+	separator := strings.LastIndex(credentials, ",")
+
+	if separator < 0 {
+		return nil, fmt.Errorf("%w: WIFI password missing", ErrInvalidCommand)
+	}
+
+	return &WifiConnect{
+		CountryCode: countryCode,
+		SSID:        credentials[:separator],
+		Password:    credentials[separator+1:]}, nil
+}
+
+func parseSlot(upper string, prefix string) (int, error) {
+	slot, rest, err := splitSource(upper, prefix)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if rest != "" {
+		return 0, fmt.Errorf("%w: bad slot %v", ErrInvalidCommand, upper)
+	}
+
+	return parseIndex(slot, false)
+}
+
+func parseSaveState(upper string) (Command, error) {
+	slot, err := parseSlot(upper, shared.SAVESTATE_COMMAND_SAVE)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &SaveState{Slot: slot}, nil
+}
+
+func parseLoadState(upper string) (Command, error) {
+	slot, err := parseSlot(upper, shared.SAVESTATE_COMMAND_LOAD)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoadState{Slot: slot}, nil
+}
+
+// parseSwitchProfile returns lower-cased profile name,
+// empty name means the default profile
+func parseSwitchProfile(upper string) (Command, error) {
+	return newSwitchProfile(strings.TrimPrefix(upper, shared.PROFILE_COMMAND))
+}
+
+func newSwitchProfile(profile string) (Command, error) {
+	profile = strings.ToLower(profile)
+
+	if profile != shared.AMIPI400_AMIBERRY_DEFAULT_PROFILE &&
+		!shared.PROFILE_NAME_RE.MatchString(profile) {
+		return nil, fmt.Errorf("%w: bad profile name %v", ErrInvalidCommand, profile)
+	}
+
+	return &SwitchProfile{Profile: profile}, nil
+}
@@ -0,0 +1,133 @@
+package commands
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/skazanyNaGlany/go.amipi400/shared"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		command  string
+		expected Command
+	}{
+		{"df0", &EjectFloppy{Source: 0}},
+		{"dfn", &EjectFloppy{Source: shared.DRIVE_INDEX_ALL}},
+		{"df0traps", &InsertFloppy{
+			Source:  0,
+			Target:  shared.DRIVE_INDEX_UNSPECIFIED,
+			Pattern: "TRAPS",
+			DiskNo:  shared.DISK_INDEX_UNSPECIFIED}},
+		{"df0kwaterdf1", &InsertFloppy{
+			Source:  0,
+			Target:  1,
+			Pattern: "KWATER",
+			DiskNo:  shared.DISK_INDEX_UNSPECIFIED}},
+		{"df0kwaterdfn", &InsertFloppy{
+			Source:  0,
+			Target:  shared.DRIVE_INDEX_ALL,
+			Pattern: "KWATER",
+			DiskNo:  shared.DISK_INDEX_UNSPECIFIED}},
+		{"df02", &InsertFloppy{
+			Source: 0,
+			Target: shared.DRIVE_INDEX_UNSPECIFIED,
+			DiskNo: 2}},
+		{"df02df1", &InsertFloppy{Source: 0, Target: 1, DiskNo: 2}},
+		{"df012", &InsertFloppy{
+			Source: 0,
+			Target: shared.DRIVE_INDEX_UNSPECIFIED,
+			DiskNo: 12}},
+		{"df0123", &InsertFloppy{
+			Source:  0,
+			Target:  shared.DRIVE_INDEX_UNSPECIFIED,
+			Pattern: "123",
+			DiskNo:  shared.DISK_INDEX_UNSPECIFIED}},
+		{"cd0", &EjectCD{Source: 0}},
+		{"cd0workbenchiso", &InsertCD{
+			Source:  0,
+			Target:  shared.DRIVE_INDEX_UNSPECIFIED,
+			Pattern: "WORKBENCHISO"}},
+		{"hf0", &EjectHardFile{Source: 0}},
+		{"hf0workbenchhdf", &InsertHardFile{
+			Source:  0,
+			Target:  shared.DRIVE_INDEX_UNSPECIFIED,
+			Pattern: "WORKBENCHHDF"}},
+		{"u", &UnmountAll{}},
+		{"udf0", &Unmount{Device: shared.LOW_LEVEL_DEVICE_FLOPPY, Source: 0}},
+		{"ucdn", &Unmount{Device: shared.LOW_LEVEL_DEVICE_CD, Source: shared.DRIVE_INDEX_ALL}},
+		{"uhf1", &Unmount{Device: shared.LOW_LEVEL_DEVICE_HARD_FILE, Source: 1}},
+		{"udhn", &Unmount{Device: shared.LOW_LEVEL_DEVICE_HARD_DISK, Source: shared.DRIVE_INDEX_ALL}},
+		{"cdf0dh1", &LowLevelCopy{
+			SourceDevice: shared.LOW_LEVEL_DEVICE_FLOPPY,
+			Source:       0,
+			TargetDevice: shared.LOW_LEVEL_DEVICE_HARD_DISK,
+			Target:       1}},
+		{"w,PL,ssid,password", &WifiConnect{CountryCode: "PL", SSID: "ssid", Password: "password"}},
+		{"w,pl,my,ssid,Password", &WifiConnect{CountryCode: "PL", SSID: "my,ssid", Password: "Password"}},
+		{"w", &WifiDisconnect{}},
+		{"pcd32", &SwitchProfile{Profile: "cd32"}},
+		{"p", &SwitchProfile{Profile: shared.AMIPI400_AMIBERRY_DEFAULT_PROFILE}},
+		{"ss1", &SaveState{Slot: 1}},
+		{"ls1", &LoadState{Slot: 1}},
+		{"adf0dh1", &ArchiveFloppy{Source: 0, TargetDevice: shared.LOW_LEVEL_DEVICE_HARD_DISK, Target: 1}},
+		{"adf0df1", &ArchiveFloppy{Source: 0, TargetDevice: shared.LOW_LEVEL_DEVICE_FLOPPY, Target: 1}},
+		{"rdf0workbenchdf1", &WriteFloppy{Source: 0, Pattern: "WORKBENCH", Target: 1}},
+		{"fdh1Work", &CreateAdf{
+			TargetDevice: shared.LOW_LEVEL_DEVICE_HARD_DISK,
+			Target:       1,
+			VolumeName:   "Work",
+			FFS:          shared.FORMAT_FLOPPY_FFS}},
+		{"fdf0", &CreateAdf{
+			TargetDevice: shared.LOW_LEVEL_DEVICE_FLOPPY,
+			Target:       0,
+			FFS:          shared.FORMAT_FLOPPY_FFS}},
+	}
+
+	for _, test := range tests {
+		command, err := Parse(test.command)
+
+		if err != nil {
+			t.Errorf("Parse(%q): %v", test.command, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(command, test.expected) {
+			t.Errorf("Parse(%q) = %#v, expected %#v", test.command, command, test.expected)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		command  string
+		expected error
+	}{
+		{"", ErrUnknownCommand},
+		{"x", ErrUnknownCommand},
+		{"df", ErrInvalidCommand},
+		{"dfx", ErrInvalidCommand},
+		{"df0df1", ErrInvalidCommand},
+		{"df0dfn", ErrInvalidCommand},
+		{"udf", ErrUnknownCommand},
+		{"uxx0", ErrUnknownCommand},
+		{"ss", ErrInvalidCommand},
+		{"ss12", ErrInvalidCommand},
+		{"adf0hf1", ErrInvalidCommand},
+		{"rdf0df1", ErrInvalidCommand},
+		{"rdf0workbench", ErrInvalidCommand},
+		{"fhf0", ErrInvalidCommand},
+		{"w,p,ssid,password", ErrInvalidCommand},
+		{"w,PL,ssid", ErrInvalidCommand},
+		{"pcd 32", ErrInvalidCommand},
+	}
+
+	for _, test := range tests {
+		command, err := Parse(test.command)
+
+		if !errors.Is(err, test.expected) {
+			t.Errorf("Parse(%q) = %#v, %v, expected %v", test.command, command, err, test.expected)
+		}
+	}
+}
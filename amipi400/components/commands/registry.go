@@ -0,0 +1,94 @@
+package commands
+
+import (
+	"fmt"
+	"sync"
+)
+
+type Handler func(command Command) (any, error)
+
+// Registry maps command names to their handlers,
+// so every front-end (keyboard, control socket)
+// executes the same code
+type Registry struct {
+	handlers    map[string]Handler
+	errorCounts map[string]uint64
+	mutex       sync.Mutex
+}
+
+func NewRegistry() *Registry {
+	r := Registry{
+		handlers:    make(map[string]Handler),
+		errorCounts: make(map[string]uint64)}
+
+	return &r
+}
+
+func (r *Registry) Register(name string, handler Handler) {
+	r.handlers[name] = handler
+}
+
+func (r *Registry) HasHandler(name string) bool {
+	_, ok := r.handlers[name]
+
+	return ok
+}
+
+func (r *Registry) countError(err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.errorCounts[ErrorKind(err)]++
+}
+
+// ErrorCounts returns copy of the error counters by ErrorKind
+func (r *Registry) ErrorCounts() map[string]uint64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	counts := make(map[string]uint64, len(r.errorCounts))
+
+	for kind, count := range r.errorCounts {
+		counts[kind] = count
+	}
+
+	return counts
+}
+
+// Execute runs the handler, errors are returned
+// as *CommandError and counted
+func (r *Registry) Execute(command Command) (any, error) {
+	handler, ok := r.handlers[command.Name()]
+
+	if !ok {
+		return nil, r.WrapError(command.Name(), fmt.Errorf("%w: %v", ErrNoHandler, command.Name()))
+	}
+
+	data, err := handler(command)
+
+	if err != nil {
+		return nil, r.WrapError(command.Name(), err)
+	}
+
+	return data, nil
+}
+
+// ExecuteString parses the command and executes it
+func (r *Registry) ExecuteString(command string) (any, error) {
+	parsed, err := Parse(command)
+
+	if err != nil {
+		return nil, r.WrapError("", err)
+	}
+
+	return r.Execute(parsed)
+}
+
+// WrapError counts err and wraps it in *CommandError, also used
+// by the front-ends for errors returned before the command
+// reaches the Registry
+func (r *Registry) WrapError(name string, err error) error {
+	r.countError(err)
+
+	return &CommandError{Name: name, Err: err}
+}
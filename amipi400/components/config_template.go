@@ -0,0 +1,145 @@
+package components
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/skazanyNaGlany/go.amipi400/shared"
+	"github.com/skazanyNaGlany/go.amipi400/shared/components/utils"
+	"github.com/thoas/go-funk"
+)
+
+// ConfigTemplate renders Amiberry config template using text/template,
+// values are referenced as {{.floppy0}} (or {{floppy0}} in the old
+// templates) and can be used in conditionals, like
+// {{if .zoom}}...{{end}}, unknown values are reported as errors
+type ConfigTemplate struct {
+	pathname string
+	values   map[string]any
+}
+
+func NewConfigTemplate(pathname string) *ConfigTemplate {
+	ct := ConfigTemplate{
+		pathname: pathname,
+		values:   make(map[string]any)}
+
+	return &ct
+}
+
+func (ct *ConfigTemplate) Set(key string, value any) {
+	ct.values[key] = value
+}
+
+// convertLegacyPlaceholders converts {{floppy0}} into {{.floppy0}}
+func (ct *ConfigTemplate) convertLegacyPlaceholders(content string) string {
+	re := shared.CONFIG_TEMPLATE_LEGACY_PLACEHOLDER_RE
+
+	return re.ReplaceAllStringFunc(content, func(placeholder string) string {
+		name := utils.RegExInstance.FindNamedMatches(re, placeholder)["name"]
+
+		if funk.ContainsString(shared.CONFIG_TEMPLATE_KEYWORDS, name) {
+			return placeholder
+		}
+
+		return "{{." + name + "}}"
+	})
+}
+
+func (ct *ConfigTemplate) Render() (string, error) {
+	content, n, err := utils.FileUtilsInstance.FileReadBytes(
+		ct.pathname,
+		0,
+		-1,
+		0,
+		0,
+		nil)
+
+	if err != nil {
+		return "", err
+	}
+
+	if n <= 0 {
+		return "", errors.New("Cannot process config file template " + ct.pathname)
+	}
+
+	return ct.RenderString(string(content))
+}
+
+func (ct *ConfigTemplate) RenderString(content string) (string, error) {
+	content = ct.convertLegacyPlaceholders(content)
+
+	tmpl, err := template.New(ct.pathname).
+		Option("missingkey=error").
+		Parse(content)
+
+	if err != nil {
+		return "", fmt.Errorf("cannot parse config file template %v: %w", ct.pathname, err)
+	}
+
+	if err := ct.validate(content, tmpl.Tree.Root); err != nil {
+		return "", err
+	}
+
+	output := bytes.Buffer{}
+
+	if err := tmpl.Execute(&output, ct.values); err != nil {
+		return "", fmt.Errorf("cannot process config file template %v: %w", ct.pathname, err)
+	}
+
+	return output.String(), nil
+}
+
+// getUnfilled returns the lines of the template text (outside
+// the actions) with malformed placeholders, like {{floppy0}
+func (ct *ConfigTemplate) getUnfilled(content string, node parse.Node) []string {
+	unfilled := make([]string, 0)
+
+	switch node := node.(type) {
+	case *parse.TextNode:
+		lineNo := strings.Count(content[:node.Pos], "\n") + 1
+
+		for i, line := range strings.Split(string(node.Text), "\n") {
+			if strings.Contains(line, "{{") || strings.Contains(line, "}}") {
+				unfilled = append(unfilled, fmt.Sprintf("%v: %v", lineNo+i, strings.TrimSpace(line)))
+			}
+		}
+	case *parse.ListNode:
+		if node == nil {
+			break
+		}
+
+		for _, child := range node.Nodes {
+			unfilled = append(unfilled, ct.getUnfilled(content, child)...)
+		}
+	case *parse.IfNode:
+		unfilled = append(unfilled, ct.getUnfilled(content, node.List)...)
+		unfilled = append(unfilled, ct.getUnfilled(content, node.ElseList)...)
+	case *parse.RangeNode:
+		unfilled = append(unfilled, ct.getUnfilled(content, node.List)...)
+		unfilled = append(unfilled, ct.getUnfilled(content, node.ElseList)...)
+	case *parse.WithNode:
+		unfilled = append(unfilled, ct.getUnfilled(content, node.List)...)
+		unfilled = append(unfilled, ct.getUnfilled(content, node.ElseList)...)
+	}
+
+	return unfilled
+}
+
+// validate reports malformed placeholders in the template
+// source, they would be copied to the output as text
+func (ct *ConfigTemplate) validate(content string, root *parse.ListNode) error {
+	unfilled := ct.getUnfilled(content, root)
+
+	if len(unfilled) > 0 {
+		return fmt.Errorf(
+			"unfilled placeholders in config file template %v: %v",
+			ct.pathname,
+			strings.Join(unfilled, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package components
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/skazanyNaGlany/go.amipi400/shared"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+// newTestConfigTemplate returns the template with all the
+// values set, the same way as the emulator does
+func newTestConfigTemplate(pathname string, zoom bool) *ConfigTemplate {
+	commander := AmiberryCommander{}
+	configTemplate := NewConfigTemplate(pathname)
+
+	for i := 0; i < shared.MAX_ADFS; i++ {
+		pathname := ""
+
+		if i == 0 {
+			pathname = "/media/pi/AP4_DF0/Workbench.adf"
+		}
+
+		configTemplate.Set(commander.FormatFloppyCO(i, pathname))
+		configTemplate.Set(commander.FormatFloppySoundConfigOption(i, i == 0))
+		configTemplate.Set(commander.FormatFloppySoundVolumeDiskCO(i, 20))
+		configTemplate.Set(commander.FormatFloppySoundVolumeEmptyCO(i, 20))
+	}
+
+	key, value := commander.FormatHardFile2_UaeController_CO(0, "/media/pi/AP4_DH0/Work.hdf", 32, 1, 2, 512, 0, 0)
+	hardDrives := key + "=" + value + "\n"
+
+	key, value = commander.FormatUaeHf_UaeController_CO(0, "/media/pi/AP4_DH0/Work.hdf", 32, 1, 2, 512, 0, 0)
+	hardDrives += key + "=" + value
+
+	configTemplate.Set("hard_drives", hardDrives)
+	configTemplate.Set(commander.FormatCdImageCO(0, ""))
+
+	height := shared.AMIBERRY_DEFAULT_WINDOW_HEIGHT
+
+	if zoom {
+		height = shared.AMIBERRY_ZOOM_WINDOW_HEIGHT
+	}
+
+	configTemplate.Set(commander.FormatGfxCenterHorizontalCO(zoom))
+	configTemplate.Set(commander.FormatGfxCenterVerticalCO(zoom))
+	configTemplate.Set(commander.FormatGfxHeightCO(height))
+	configTemplate.Set(commander.FormatGfxHeightWindowedCO(height))
+	configTemplate.Set("zoom", zoom)
+	configTemplate.Set("profile", shared.AMIPI400_AMIBERRY_DEFAULT_PROFILE)
+
+	return configTemplate
+}
+
+func TestConfigTemplateGolden(t *testing.T) {
+	tests := []struct {
+		template string
+		zoom     bool
+		golden   string
+	}{
+		{"../a1200.uae.template", false, "a1200.uae.golden"},
+		{"../a1200.uae.template", true, "a1200.zoom.uae.golden"},
+		{"../cd32.uae.template", false, "cd32.uae.golden"},
+	}
+
+	for _, test := range tests {
+		rendered, err := newTestConfigTemplate(test.template, test.zoom).Render()
+
+		if err != nil {
+			t.Errorf("%v: %v", test.template, err)
+			continue
+		}
+
+		golden := filepath.Join("testdata", test.golden)
+
+		if *updateGolden {
+			if err := os.WriteFile(golden, []byte(rendered), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		expected, err := os.ReadFile(golden)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rendered != string(expected) {
+			t.Errorf("%v rendered differently than %v, run go test -update to update it", test.template, golden)
+		}
+	}
+}
+
+func TestConfigTemplateRenderString(t *testing.T) {
+	tests := []struct {
+		content  string
+		expected string
+	}{
+		{"floppy0={{floppy0}}\n", "floppy0=/tmp/{{disk}}.adf\n"},
+		{"floppy0={{.floppy0}}\n", "floppy0=/tmp/{{disk}}.adf\n"},
+		{"{{if .zoom}}gfx_height=568{{else}}gfx_height=540{{end}}\n", "gfx_height=568\n"},
+		{"{{- if eq .profile \"cd32\"}}\nchipset=aga\n{{- end}}\n", "\n"},
+	}
+
+	for _, test := range tests {
+		configTemplate := NewConfigTemplate("test.uae.template")
+
+		// values are not validated, only the template is
+		configTemplate.Set("floppy0", "/tmp/{{disk}}.adf")
+		configTemplate.Set("zoom", true)
+		configTemplate.Set("profile", "")
+
+		rendered, err := configTemplate.RenderString(test.content)
+
+		if err != nil {
+			t.Errorf("RenderString(%q): %v", test.content, err)
+			continue
+		}
+
+		if rendered != test.expected {
+			t.Errorf("RenderString(%q) = %q, expected %q", test.content, rendered, test.expected)
+		}
+	}
+}
+
+func TestConfigTemplateRenderStringErrors(t *testing.T) {
+	tests := []struct {
+		content  string
+		expected string
+	}{
+		{"floppy0={{floppy1}}\n", "cannot process"},
+		{"floppy0={{.floppy0\n", "cannot parse"},
+		{"floppy0={{floppy0}}\nfloppy1=floppy1}}\n", "2: floppy1=floppy1}}"},
+		{"{{if .zoom}}\nfloppy1={ {floppy1}}\n{{end}}\n", "2: floppy1={ {floppy1}}"},
+	}
+
+	for _, test := range tests {
+		configTemplate := NewConfigTemplate("test.uae.template")
+
+		configTemplate.Set("floppy0", "")
+		configTemplate.Set("zoom", true)
+
+		_, err := configTemplate.RenderString(test.content)
+
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("RenderString(%q): %v, expected %q", test.content, err, test.expected)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package components
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/skazanyNaGlany/go.amipi400/shared"
+)
+
+// ControlClient talks to the ControlServer
+// of the running amipi400 process
+type ControlClient struct {
+	socketPathname string
+	timeout        time.Duration
+}
+
+// controlClientResponse is ControlResponse with data
+// left undecoded, so it can be decoded into the
+// caller's type
+type controlClientResponse struct {
+	Success   bool            `json:"success"`
+	Error     string          `json:"error"`
+	ErrorKind string          `json:"error_kind"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// ControlError is an error returned by the server,
+// Kind is one of the commands.ErrorKind values
+type ControlError struct {
+	Kind    string
+	Message string
+}
+
+func (ce *ControlError) Error() string {
+	return ce.Message
+}
+
+func NewControlClient(socketPathname string, timeout time.Duration) *ControlClient {
+	cc := ControlClient{
+		socketPathname: socketPathname,
+		timeout:        timeout}
+
+	return &cc
+}
+
+// Send sends a single command and decodes returned
+// data into "data" (if not nil), error returned by
+// the server is returned as Go error
+func (cc *ControlClient) Send(command string, args map[string]string, data any) error {
+	conn, err := net.DialTimeout("unix", cc.socketPathname, cc.timeout)
+
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	if cc.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(cc.timeout))
+	}
+
+	request := ControlRequest{Command: command, Args: args}
+
+	if err := json.NewEncoder(conn).Encode(&request); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, shared.CONTROL_MAX_REQUEST_SIZE), shared.CONTROL_MAX_RESPONSE_SIZE)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+
+		return errors.New("connection closed by the server")
+	}
+
+	response := controlClientResponse{}
+
+	if err := json.Unmarshal(scanner.Bytes(), &response); err != nil {
+		return err
+	}
+
+	if !response.Success {
+		return &ControlError{Kind: response.ErrorKind, Message: response.Error}
+	}
+
+	if data != nil && len(response.Data) > 0 {
+		return json.Unmarshal(response.Data, data)
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package components
+
+import (
+	"github.com/skazanyNaGlany/go.amipi400/amipi400/components/commands"
+	"github.com/skazanyNaGlany/go.amipi400/shared"
+)
+
+// ControlRequest is a single request sent to the ControlServer,
+// args are named the same as the groups in the keyboard
+// command regexes (source_index, filename_part, etc.)
+type ControlRequest struct {
+	Command string            `json:"command"`
+	Args    map[string]string `json:"args,omitempty"`
+}
+
+// ControlResponse.ErrorKind is commands.ErrorKind of the error
+// so the clients do not need to parse the error message
+type ControlResponse struct {
+	Success   bool   `json:"success"`
+	Error     string `json:"error,omitempty"`
+	ErrorKind string `json:"error_kind,omitempty"`
+	Data      any    `json:"data,omitempty"`
+}
+
+func NewControlResponse(data any, err error) *ControlResponse {
+	cr := ControlResponse{}
+
+	if err != nil {
+		cr.Error = err.Error()
+		cr.ErrorKind = commands.ErrorKind(err)
+	} else {
+		cr.Success = true
+		cr.Data = data
+	}
+
+	return &cr
+}
+
+type ControlStatusMountpoint struct {
+	DevicePathname string `json:"device_pathname"`
+	Mountpoint     string `json:"mountpoint"`
+	Label          string `json:"label"`
+	FsType         string `json:"fs_type"`
+	DFIndex        int    `json:"df_index"`
+	DHIndex        int    `json:"dh_index"`
+	CDIndex        int    `json:"cd_index"`
+	DHBootPriority int    `json:"dh_boot_priority"`
+	DefaultFile    string `json:"default_file"`
+	FilesCount     int    `json:"files_count"`
+}
+
+// ControlStatus is returned by the status command, it contains
+// emulator slots (empty string means nothing attached)
+// and the mountpoints managed by amipi400
+type ControlStatus struct {
+	Profile     string                    `json:"profile"`
+	Adfs        []string                  `json:"adfs"`
+	Hdfs        []string                  `json:"hdfs"`
+	Isos        []string                  `json:"isos"`
+	Mountpoints []ControlStatusMountpoint `json:"mountpoints"`
+	ErrorCounts map[string]uint64         `json:"error_counts"`
+}
+
+func NewControlStatus(
+	emulator *AmiberryEmulator,
+	mountpoints *MountpointList,
+	errorCounts map[string]uint64) *ControlStatus {
+	cs := ControlStatus{
+		Profile:     emulator.GetProfile(),
+		ErrorCounts: errorCounts,
+		Adfs:        make([]string, shared.MAX_ADFS),
+		Hdfs:        make([]string, shared.MAX_HDFS),
+		Isos:        make([]string, shared.MAX_CDS),
+		Mountpoints: make([]ControlStatusMountpoint, 0)}
+
+	for index := range cs.Adfs {
+		cs.Adfs[index] = emulator.GetAdf(index)
+	}
+
+	for index := range cs.Hdfs {
+		cs.Hdfs[index] = emulator.GetHd(index)
+	}
+
+	for index := range cs.Isos {
+		cs.Isos[index] = emulator.GetIso(index)
+	}
+
+	for _, mountpoint := range mountpoints.Mountpoints {
+		cs.Mountpoints = append(cs.Mountpoints, ControlStatusMountpoint{
+			DevicePathname: mountpoint.DevicePathname,
+			Mountpoint:     mountpoint.Mountpoint,
+			Label:          mountpoint.Label,
+			FsType:         mountpoint.FsType,
+			DFIndex:        mountpoint.DFIndex,
+			DHIndex:        mountpoint.DHIndex,
+			CDIndex:        mountpoint.CDIndex,
+			DHBootPriority: mountpoint.DHBootPriority,
+			DefaultFile:    mountpoint.Config.AmiPi400.DefaultFile,
+			FilesCount:     len(mountpoint.Files)})
+	}
+
+	return &cs
+}
+
+// ControlConfig is returned by the config command, Started
+// is the config the emulator was (re)started with and Changes
+// are the options changed at runtime since then
+type ControlConfig struct {
+	Started string            `json:"started"`
+	Runtime string            `json:"runtime"`
+	Changes []UAEConfigChange `json:"changes"`
+}
+
+func NewControlConfig(emulator *AmiberryEmulator) *ControlConfig {
+	cc := ControlConfig{
+		Changes: emulator.DiffRuntimeConfig()}
+
+	if startedConfig := emulator.GetStartedConfig(); startedConfig != nil {
+		cc.Started = startedConfig.String()
+	}
+
+	if runtimeConfig := emulator.GetRuntimeConfig(); runtimeConfig != nil {
+		cc.Runtime = runtimeConfig.String()
+	}
+
+	return &cc
+}
@@ -0,0 +1,253 @@
+package components
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/skazanyNaGlany/go.amipi400/amipi400/interfaces"
+	"github.com/skazanyNaGlany/go.amipi400/shared"
+	"github.com/skazanyNaGlany/go.amipi400/shared/components"
+	shared_interfaces "github.com/skazanyNaGlany/go.amipi400/shared/interfaces"
+)
+
+// ControlServer exposes amipi400 commands over a local Unix
+// socket (one JSON request/response per line) and optionally
+// over HTTP bound to the localhost, HTTP requests must be
+// application/json and carry the token, since the socket
+// permissions do not apply to them
+type ControlServer struct {
+	components.RunnerBase
+
+	socketPathname  string
+	httpAddress     string
+	httpToken       string
+	listener        net.Listener
+	httpServer      *http.Server
+	requestCallback interfaces.ControlRequestCallback
+}
+
+func (cs *ControlServer) loop() {
+	for cs.IsRunning() {
+		conn, err := cs.listener.Accept()
+
+		if err != nil {
+			if !cs.IsRunning() {
+				break
+			}
+
+			if cs.IsDebugMode() {
+				log.Println(err)
+			}
+
+			continue
+		}
+
+		go cs.handleConnection(conn)
+	}
+
+	cs.SetRunning(false)
+}
+
+func (cs *ControlServer) idle() {
+	for cs.IsRunning() {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	cs.SetRunning(false)
+}
+
+func (cs *ControlServer) handleConnection(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, shared.CONTROL_MAX_REQUEST_SIZE), shared.CONTROL_MAX_REQUEST_SIZE)
+
+	encoder := json.NewEncoder(conn)
+
+	for scanner.Scan() {
+		var response *ControlResponse
+		request := ControlRequest{}
+
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			response = NewControlResponse(nil, err)
+		} else {
+			response = cs.handleRequest(&request)
+		}
+
+		if err := encoder.Encode(response); err != nil {
+			if cs.IsDebugMode() {
+				log.Println(err)
+			}
+
+			return
+		}
+	}
+}
+
+func (cs *ControlServer) handleHTTPRequest(writer http.ResponseWriter, httpRequest *http.Request) {
+	var response *ControlResponse
+
+	if httpRequest.Method != http.MethodPost {
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// browsers can send text/plain POST to the localhost
+	// without the preflight, but not application/json
+	mediaType, _, err := mime.ParseMediaType(httpRequest.Header.Get("Content-Type"))
+
+	if err != nil || mediaType != shared.CONTROL_HTTP_CONTENT_TYPE {
+		http.Error(writer, "unsupported media type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	if !cs.isHTTPTokenValid(httpRequest) {
+		http.Error(writer, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	request := ControlRequest{}
+	decoder := json.NewDecoder(
+		http.MaxBytesReader(writer, httpRequest.Body, shared.CONTROL_MAX_REQUEST_SIZE),
+	)
+
+	if err := decoder.Decode(&request); err != nil {
+		response = NewControlResponse(nil, err)
+	} else {
+		response = cs.handleRequest(&request)
+	}
+
+	writer.Header().Set("Content-Type", shared.CONTROL_HTTP_CONTENT_TYPE)
+
+	if err := json.NewEncoder(writer).Encode(response); err != nil {
+		if cs.IsDebugMode() {
+			log.Println(err)
+		}
+	}
+}
+
+func (cs *ControlServer) isHTTPTokenValid(httpRequest *http.Request) bool {
+	authorization := httpRequest.Header.Get("Authorization")
+
+	if !strings.HasPrefix(authorization, shared.CONTROL_HTTP_AUTH_SCHEME) {
+		return false
+	}
+
+	token := strings.TrimPrefix(authorization, shared.CONTROL_HTTP_AUTH_SCHEME)
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(cs.httpToken)) == 1
+}
+
+func (cs *ControlServer) handleRequest(request *ControlRequest) *ControlResponse {
+	if cs.IsVerboseMode() {
+		log.Println("Control request", request.Command)
+	}
+
+	if request.Command == "" {
+		return NewControlResponse(nil, errors.New("empty command"))
+	}
+
+	if cs.requestCallback == nil {
+		return NewControlResponse(nil, errors.New("no request callback"))
+	}
+
+	if request.Args == nil {
+		request.Args = make(map[string]string)
+	}
+
+	data, err := cs.requestCallback(request.Command, request.Args)
+
+	if err != nil && cs.IsDebugMode() {
+		log.Println(request.Command, err)
+	}
+
+	return NewControlResponse(data, err)
+}
+
+func (cs *ControlServer) serveHTTP() {
+	if err := cs.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Println(err)
+	}
+}
+
+func (cs *ControlServer) Run() {
+	var err error
+
+	os.Remove(cs.socketPathname)
+
+	cs.listener, err = net.Listen("unix", cs.socketPathname)
+
+	if err != nil {
+		log.Println(err)
+
+		// the control API is optional, so keep
+		// amipi400 running without it
+		cs.idle()
+		return
+	}
+
+	if err := os.Chmod(cs.socketPathname, 0600); err != nil {
+		log.Println(err)
+	}
+
+	if cs.httpAddress != "" && cs.httpToken == "" {
+		log.Println("HTTP control API is disabled, control_http_token is not set")
+	} else if cs.httpAddress != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc(shared.CONTROL_HTTP_API_PATH, cs.handleHTTPRequest)
+
+		cs.httpServer = &http.Server{Addr: cs.httpAddress, Handler: mux}
+
+		go cs.serveHTTP()
+	}
+
+	cs.loop()
+}
+
+func (cs *ControlServer) Stop(_runner shared_interfaces.Runner) error {
+	cs.RunnerBase.Stop(_runner)
+
+	if cs.listener != nil {
+		cs.listener.Close()
+	}
+
+	if cs.httpServer != nil {
+		cs.httpServer.Close()
+	}
+
+	os.Remove(cs.socketPathname)
+
+	return nil
+}
+
+func (cs *ControlServer) SetSocketPathname(pathname string) {
+	cs.socketPathname = pathname
+}
+
+func (cs *ControlServer) GetSocketPathname() string {
+	return cs.socketPathname
+}
+
+func (cs *ControlServer) SetHTTPAddress(address string) {
+	cs.httpAddress = address
+}
+
+// SetHTTPToken sets the token which HTTP requests must send
+// as "Authorization: Bearer <token>", HTTP is not served
+// without it
+func (cs *ControlServer) SetHTTPToken(token string) {
+	cs.httpToken = token
+}
+
+func (cs *ControlServer) SetRequestCallback(callback interfaces.ControlRequestCallback) {
+	cs.requestCallback = callback
+}
@@ -11,11 +11,13 @@ type MainConfig struct {
 	pathname string `ini:"-"`
 
 	AmiPi400 struct {
-		Zoom            bool   `ini:"zoom"`
-		WIFIManage      bool   `ini:"wifi_manage"`
-		WIFICountryCode string `ini:"wifi_country_code"`
-		WIFISSID        string `ini:"wifi_ssid"`
-		WIFIPassword    string `ini:"wifi_password"`
+		Zoom             bool   `ini:"zoom"`
+		WIFIManage       bool   `ini:"wifi_manage"`
+		WIFICountryCode  string `ini:"wifi_country_code"`
+		WIFISSID         string `ini:"wifi_ssid"`
+		WIFIPassword     string `ini:"wifi_password"`
+		ControlHTTP      bool   `ini:"control_http"`
+		ControlHTTPToken string `ini:"control_http_token"`
 	} `ini:"amipi400"`
 }
 
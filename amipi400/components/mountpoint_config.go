@@ -12,6 +12,7 @@ type MountpointConfig struct {
 
 	AmiPi400 struct {
 		DefaultFile string `ini:"default_file"`
+		Profile     string `ini:"profile"`
 	} `ini:"amipi400"`
 }
 
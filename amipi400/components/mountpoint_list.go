@@ -1,6 +1,10 @@
 package components
 
-import "slices"
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+)
 
 type MountpointList struct {
 	Mountpoints []*Mountpoint
@@ -104,6 +108,19 @@ func (ml *MountpointList) GetMountpointByCDIndex(index int) *Mountpoint {
 	return nil
 }
 
+// GetMountpointByPathname returns mountpoint which holds
+// pathname (file or directory)
+func (ml *MountpointList) GetMountpointByPathname(pathname string) *Mountpoint {
+	for _, iMp := range ml.Mountpoints {
+		if pathname == iMp.Mountpoint ||
+			strings.HasPrefix(pathname, filepath.Clean(iMp.Mountpoint)+string(filepath.Separator)) {
+			return iMp
+		}
+	}
+
+	return nil
+}
+
 func NewMountpointList() *MountpointList {
 	ml := MountpointList{}
 	ml.Mountpoints = make([]*Mountpoint, 0)
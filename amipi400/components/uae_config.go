@@ -0,0 +1,361 @@
+package components
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/skazanyNaGlany/go.amipi400/shared"
+	"github.com/skazanyNaGlany/go.amipi400/shared/components/utils"
+	"github.com/thoas/go-funk"
+	"golang.org/x/exp/slices"
+)
+
+// UAEConfigLine is a single line of the config,
+// Key is empty for comments and empty lines
+type UAEConfigLine struct {
+	Key   string
+	Value string
+	Raw   string
+}
+
+// UAEConfigChange is a difference between two configs,
+// Old or New is empty when the option was added or removed,
+// multi-value options (like hardfile2) are compared
+// as the whole list, joined by "\n"
+type UAEConfigChange struct {
+	Key string `json:"key"`
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// UAEConfig is parsed UAE/Amiberry config file, comments
+// and the order of the options are preserved when
+// it is serialized back by String()
+type UAEConfig struct {
+	lines []*UAEConfigLine
+	mutex sync.Mutex
+}
+
+func NewUAEConfig() *UAEConfig {
+	uc := UAEConfig{}
+	uc.lines = make([]*UAEConfigLine, 0)
+
+	return &uc
+}
+
+func ParseUAEConfig(content string) *UAEConfig {
+	uc := NewUAEConfig()
+
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	content = strings.TrimSuffix(content, "\n")
+
+	for _, raw := range strings.Split(content, "\n") {
+		uc.lines = append(uc.lines, parseUAEConfigLine(raw))
+	}
+
+	return uc
+}
+
+func LoadUAEConfig(pathname string) (*UAEConfig, error) {
+	data, _, err := utils.FileUtilsInstance.FileReadBytes(
+		pathname,
+		0,
+		-1,
+		0,
+		0,
+		nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseUAEConfig(string(data)), nil
+}
+
+func parseUAEConfigLine(raw string) *UAEConfigLine {
+	line := UAEConfigLine{Raw: raw}
+	trimmed := strings.TrimSpace(raw)
+
+	if trimmed == "" || strings.HasPrefix(trimmed, shared.UAE_CONFIG_COMMENT) {
+		return &line
+	}
+
+	key, value, found := strings.Cut(trimmed, "=")
+
+	if !found {
+		return &line
+	}
+
+	line.Key = strings.TrimSpace(key)
+	line.Value = strings.TrimSpace(value)
+
+	return &line
+}
+
+func (uc *UAEConfig) IsMultiValue(key string) bool {
+	return funk.ContainsString(shared.UAE_CONFIG_MULTI_VALUE_KEYS, key)
+}
+
+func (uc *UAEConfig) findLine(key string) int {
+	for i, line := range uc.lines {
+		if line.Key == key {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (uc *UAEConfig) findLastLine(key string) int {
+	for i := len(uc.lines) - 1; i >= 0; i-- {
+		if uc.lines[i].Key == key {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Get returns value of the first occurrence of the key
+func (uc *UAEConfig) Get(key string) (string, bool) {
+	uc.mutex.Lock()
+	defer uc.mutex.Unlock()
+
+	if i := uc.findLine(key); i != -1 {
+		return uc.lines[i].Value, true
+	}
+
+	return "", false
+}
+
+// GetBool returns boolean value of the first occurrence of the
+// key, ok is false when the key is missing or its value
+// is not a boolean
+func (uc *UAEConfig) GetBool(key string) (value bool, ok bool) {
+	str, exists := uc.Get(key)
+
+	if !exists {
+		return false, false
+	}
+
+	str = strings.ToLower(str)
+
+	if funk.ContainsString(shared.UAE_CONFIG_TRUE_VALUES, str) {
+		return true, true
+	}
+
+	if funk.ContainsString(shared.UAE_CONFIG_FALSE_VALUES, str) {
+		return false, true
+	}
+
+	return false, false
+}
+
+// GetInt returns integer value of the first occurrence of the
+// key, ok is false when the key is missing or its value
+// is not an integer
+func (uc *UAEConfig) GetInt(key string) (value int, ok bool) {
+	str, exists := uc.Get(key)
+
+	if !exists {
+		return 0, false
+	}
+
+	value, err := utils.StringUtilsInstance.StringToInt(str, 10, 32)
+
+	if err != nil {
+		return 0, false
+	}
+
+	return value, true
+}
+
+// GetAll returns values of all the occurrences
+// of the key, used for multi-value options
+func (uc *UAEConfig) GetAll(key string) []string {
+	uc.mutex.Lock()
+	defer uc.mutex.Unlock()
+
+	values := make([]string, 0)
+
+	for _, line := range uc.lines {
+		if line.Key == key {
+			values = append(values, line.Value)
+		}
+	}
+
+	return values
+}
+
+// Keys returns unique keys in the order of
+// the first occurrence
+func (uc *UAEConfig) Keys() []string {
+	uc.mutex.Lock()
+	defer uc.mutex.Unlock()
+
+	keys := make([]string, 0)
+
+	for _, line := range uc.lines {
+		if line.Key != "" && !funk.ContainsString(keys, line.Key) {
+			keys = append(keys, line.Key)
+		}
+	}
+
+	return keys
+}
+
+// Set replaces value of the key in place and removes
+// its other occurrences, new keys are appended
+func (uc *UAEConfig) Set(key string, value string) {
+	uc.mutex.Lock()
+	defer uc.mutex.Unlock()
+
+	line := UAEConfigLine{Key: key, Value: value, Raw: key + "=" + value}
+	i := uc.findLine(key)
+
+	if i == -1 {
+		uc.lines = append(uc.lines, &line)
+		return
+	}
+
+	uc.lines[i] = &line
+
+	for j := len(uc.lines) - 1; j > i; j-- {
+		if uc.lines[j].Key == key {
+			uc.lines = slices.Delete(uc.lines, j, j+1)
+		}
+	}
+}
+
+func (uc *UAEConfig) SetBool(key string, value bool) {
+	if value {
+		uc.Set(key, shared.UAE_CONFIG_TRUE)
+	} else {
+		uc.Set(key, shared.UAE_CONFIG_FALSE)
+	}
+}
+
+func (uc *UAEConfig) SetInt(key string, value int) {
+	uc.Set(key, fmt.Sprintf("%v", value))
+}
+
+// Add adds next value of the multi-value key,
+// after its last occurrence
+func (uc *UAEConfig) Add(key string, value string) {
+	uc.mutex.Lock()
+	defer uc.mutex.Unlock()
+
+	line := UAEConfigLine{Key: key, Value: value, Raw: key + "=" + value}
+	i := uc.findLastLine(key)
+
+	if i == -1 {
+		uc.lines = append(uc.lines, &line)
+		return
+	}
+
+	uc.lines = slices.Insert(uc.lines, i+1, &line)
+}
+
+// Apply sets the option the same way as the emulator
+// does for cfgfile_parse_line, multi-value options
+// are added, the rest is replaced
+func (uc *UAEConfig) Apply(key string, value string) {
+	if uc.IsMultiValue(key) {
+		uc.Add(key, value)
+		return
+	}
+
+	uc.Set(key, value)
+}
+
+func (uc *UAEConfig) Delete(key string) {
+	uc.mutex.Lock()
+	defer uc.mutex.Unlock()
+
+	lines := make([]*UAEConfigLine, 0, len(uc.lines))
+
+	for _, line := range uc.lines {
+		if line.Key != key {
+			lines = append(lines, line)
+		}
+	}
+
+	uc.lines = lines
+}
+
+func (uc *UAEConfig) Clone() *UAEConfig {
+	uc.mutex.Lock()
+	defer uc.mutex.Unlock()
+
+	clone := NewUAEConfig()
+
+	for _, line := range uc.lines {
+		lineCopy := *line
+		clone.lines = append(clone.lines, &lineCopy)
+	}
+
+	return clone
+}
+
+// Diff returns changes needed to turn uc into other
+func (uc *UAEConfig) Diff(other *UAEConfig) []UAEConfigChange {
+	changes := make([]UAEConfigChange, 0)
+	keys := uc.Keys()
+
+	for _, key := range other.Keys() {
+		if !funk.ContainsString(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+
+	for _, key := range keys {
+		oldValue := strings.Join(uc.GetAll(key), "\n")
+		newValue := strings.Join(other.GetAll(key), "\n")
+
+		if oldValue != newValue {
+			changes = append(changes, UAEConfigChange{Key: key, Old: oldValue, New: newValue})
+		}
+	}
+
+	return changes
+}
+
+func (uc *UAEConfig) String() string {
+	uc.mutex.Lock()
+	defer uc.mutex.Unlock()
+
+	lines := make([]string, 0, len(uc.lines))
+
+	for _, line := range uc.lines {
+		lines = append(lines, line.Raw)
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Save writes the config to the file, the previous
+// (possibly longer) contents are truncated
+func (uc *UAEConfig) Save(pathname string) error {
+	content := []byte(uc.String())
+
+	n, err := utils.FileUtilsInstance.FileWriteBytes(
+		pathname,
+		0,
+		content,
+		os.O_CREATE|os.O_WRONLY|os.O_TRUNC,
+		0777,
+		nil)
+
+	if err != nil {
+		return err
+	}
+
+	if n < len(content) {
+		return errors.New("Cannot write config file " + pathname)
+	}
+
+	return nil
+}
@@ -0,0 +1,195 @@
+package components
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testUAEConfig = `; amipi400 test config
+config_description=test
+
+floppy0=/media/pi/AP4_DF0/Workbench.adf
+floppy0sound=1
+gfx_height=568
+gfx_center_vertical=smart
+amiberry.gfx_auto_crop=Yes
+hardfile2=rw,DH0:/media/pi/AP4_DH0/Work.hdf,32,1,2,512,0,,uae0
+; hard drives
+hardfile2=rw,DH1:/media/pi/AP4_DH1/Games.hdf,32,1,2,512,0,,uae1
+`
+
+func TestUAEConfigString(t *testing.T) {
+	config := ParseUAEConfig(testUAEConfig)
+
+	if config.String() != testUAEConfig {
+		t.Errorf("String() = %q, expected %q", config.String(), testUAEConfig)
+	}
+
+	crlf := ParseUAEConfig("floppy0=\r\nfloppy1=\r\n")
+
+	if crlf.String() != "floppy0=\nfloppy1=\n" {
+		t.Errorf("String() = %q for CRLF config", crlf.String())
+	}
+}
+
+func TestUAEConfigGet(t *testing.T) {
+	config := ParseUAEConfig(testUAEConfig)
+
+	if value, ok := config.Get("floppy0"); !ok || value != "/media/pi/AP4_DF0/Workbench.adf" {
+		t.Errorf("Get(floppy0) = %v, %v", value, ok)
+	}
+
+	if value, ok := config.Get("floppy1"); ok || value != "" {
+		t.Errorf("Get(floppy1) = %v, %v", value, ok)
+	}
+
+	expected := []string{
+		"rw,DH0:/media/pi/AP4_DH0/Work.hdf,32,1,2,512,0,,uae0",
+		"rw,DH1:/media/pi/AP4_DH1/Games.hdf,32,1,2,512,0,,uae1"}
+
+	if values := config.GetAll("hardfile2"); !reflect.DeepEqual(values, expected) {
+		t.Errorf("GetAll(hardfile2) = %v", values)
+	}
+
+	expectedKeys := []string{
+		"config_description",
+		"floppy0",
+		"floppy0sound",
+		"gfx_height",
+		"gfx_center_vertical",
+		"amiberry.gfx_auto_crop",
+		"hardfile2"}
+
+	if keys := config.Keys(); !reflect.DeepEqual(keys, expectedKeys) {
+		t.Errorf("Keys() = %v", keys)
+	}
+}
+
+func TestUAEConfigTypedGet(t *testing.T) {
+	config := ParseUAEConfig(testUAEConfig)
+
+	boolTests := []struct {
+		key   string
+		value bool
+		ok    bool
+	}{
+		{"floppy0sound", true, true},
+		{"amiberry.gfx_auto_crop", true, true},
+		{"gfx_center_vertical", false, false},
+		{"missing", false, false},
+	}
+
+	for _, test := range boolTests {
+		if value, ok := config.GetBool(test.key); value != test.value || ok != test.ok {
+			t.Errorf("GetBool(%v) = %v, %v, expected %v, %v", test.key, value, ok, test.value, test.ok)
+		}
+	}
+
+	intTests := []struct {
+		key   string
+		value int
+		ok    bool
+	}{
+		{"gfx_height", 568, true},
+		{"floppy0sound", 1, true},
+		{"gfx_center_vertical", 0, false},
+		{"missing", 0, false},
+	}
+
+	for _, test := range intTests {
+		if value, ok := config.GetInt(test.key); value != test.value || ok != test.ok {
+			t.Errorf("GetInt(%v) = %v, %v, expected %v, %v", test.key, value, ok, test.value, test.ok)
+		}
+	}
+}
+
+func TestUAEConfigSet(t *testing.T) {
+	config := ParseUAEConfig(testUAEConfig)
+
+	config.SetInt("gfx_height", 512)
+	config.SetBool("amiberry.gfx_auto_crop", false)
+	config.Set("floppy1", "/tmp/disk.adf")
+	config.Apply("hardfile2", "rw,DH2:/tmp/disk.hdf,32,1,2,512,0,,uae2")
+	config.Apply("floppy0", "")
+	config.Delete("floppy0sound")
+
+	expected := `; amipi400 test config
+config_description=test
+
+floppy0=
+gfx_height=512
+gfx_center_vertical=smart
+amiberry.gfx_auto_crop=false
+hardfile2=rw,DH0:/media/pi/AP4_DH0/Work.hdf,32,1,2,512,0,,uae0
+; hard drives
+hardfile2=rw,DH1:/media/pi/AP4_DH1/Games.hdf,32,1,2,512,0,,uae1
+hardfile2=rw,DH2:/tmp/disk.hdf,32,1,2,512,0,,uae2
+floppy1=/tmp/disk.adf
+`
+
+	if config.String() != expected {
+		t.Errorf("String() = %q, expected %q", config.String(), expected)
+	}
+
+	config.Set("hardfile2", "")
+
+	if values := config.GetAll("hardfile2"); !reflect.DeepEqual(values, []string{""}) {
+		t.Errorf("GetAll(hardfile2) = %v after Set", values)
+	}
+}
+
+func TestUAEConfigDiff(t *testing.T) {
+	started := ParseUAEConfig(testUAEConfig)
+	runtime := started.Clone()
+
+	runtime.Set("floppy0", "")
+	runtime.Add("hardfile2", "rw,DH2:/tmp/disk.hdf,32,1,2,512,0,,uae2")
+	runtime.Set("floppy1", "/tmp/disk.adf")
+	runtime.Delete("gfx_height")
+
+	expected := []UAEConfigChange{
+		{Key: "floppy0", Old: "/media/pi/AP4_DF0/Workbench.adf", New: ""},
+		{Key: "gfx_height", Old: "568", New: ""},
+		{
+			Key: "hardfile2",
+			Old: "rw,DH0:/media/pi/AP4_DH0/Work.hdf,32,1,2,512,0,,uae0\n" +
+				"rw,DH1:/media/pi/AP4_DH1/Games.hdf,32,1,2,512,0,,uae1",
+			New: "rw,DH0:/media/pi/AP4_DH0/Work.hdf,32,1,2,512,0,,uae0\n" +
+				"rw,DH1:/media/pi/AP4_DH1/Games.hdf,32,1,2,512,0,,uae1\n" +
+				"rw,DH2:/tmp/disk.hdf,32,1,2,512,0,,uae2"},
+		{Key: "floppy1", Old: "", New: "/tmp/disk.adf"},
+	}
+
+	if changes := started.Diff(runtime); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Diff() = %v, expected %v", changes, expected)
+	}
+
+	if changes := started.Diff(started.Clone()); len(changes) != 0 {
+		t.Errorf("Diff() = %v for the clone", changes)
+	}
+}
+
+func TestUAEConfigSave(t *testing.T) {
+	pathname := filepath.Join(t.TempDir(), "amiberry.uae")
+
+	// longer contents must be truncated
+	if err := os.WriteFile(pathname, []byte(testUAEConfig+testUAEConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ParseUAEConfig(testUAEConfig).Save(pathname); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadUAEConfig(pathname)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.String() != testUAEConfig {
+		t.Errorf("LoadUAEConfig() = %q, expected %q", loaded.String(), testUAEConfig)
+	}
+}
@@ -0,0 +1,3 @@
+package interfaces
+
+type ControlRequestCallback func(command string, args map[string]string) (any, error)
@@ -0,0 +1,573 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	components_amipi400 "github.com/skazanyNaGlany/go.amipi400/amipi400/components"
+	"github.com/skazanyNaGlany/go.amipi400/shared"
+	"github.com/skazanyNaGlany/go.amipi400/shared/components/utils"
+	"github.com/thoas/go-funk"
+)
+
+const usage = `usage: %v [options] <command> [arguments]
+
+commands:
+  df insert <source index> <filename part> [--target <index|n>]
+  df insert <source index> --disk <disk no> [--target <index|n>]
+  df eject <index|n>
+  df unmount <index|n>
+  cd insert <source index> <filename part>
+  cd eject <index>
+  cd unmount <index|n>
+  hf insert <source index> <filename part> [--target <index>]
+  hf eject <index>
+  hf unmount <index|n>
+  dh unmount <index|n>
+  unmount
+  copy <df|dh><index> <df|dh><index>
+  archive df<index> <df|dh><index>
+  write df<index> <filename part> df<index>
+  create <df|dh><index> [volume name] [--fs <ofs|ffs>]
+  reset <soft|hard>
+  zoom
+  state <save|load> <slot>
+  profile [name]
+  config <started|runtime|diff>
+  wifi connect <country code> <ssid> <password>
+  wifi disconnect
+  keyboard <keyboard command>
+  status
+
+options:
+`
+
+var errUsage = errors.New("invalid usage")
+
+var driveCommands = map[string]map[string]string{
+	"df": {
+		"insert":  shared.CONTROL_CMD_DF_INSERT,
+		"eject":   shared.CONTROL_CMD_DF_EJECT,
+		"unmount": shared.CONTROL_CMD_DF_UNMOUNT,
+	},
+	"cd": {
+		"insert":  shared.CONTROL_CMD_CD_INSERT,
+		"eject":   shared.CONTROL_CMD_CD_EJECT,
+		"unmount": shared.CONTROL_CMD_CD_UNMOUNT,
+	},
+	"hf": {
+		"insert":  shared.CONTROL_CMD_HF_INSERT,
+		"eject":   shared.CONTROL_CMD_HF_EJECT,
+		"unmount": shared.CONTROL_CMD_HF_UNMOUNT,
+	},
+	"dh": {
+		"unmount": shared.CONTROL_CMD_DH_UNMOUNT,
+	},
+}
+
+var socketPathname = flag.String("socket", shared.CONTROL_SOCKET_PATHNAME, "control socket pathname")
+var timeoutSecs = flag.Int("timeout", 0, "timeout in seconds, 0 means wait until done")
+var jsonOutput = flag.Bool("json", false, "print status and config diff as JSON")
+
+func printUsage() {
+	fmt.Fprintf(flag.CommandLine.Output(), usage, shared.AMIPI400CTL_UNIXNAME)
+	flag.PrintDefaults()
+}
+
+// parseOptions splits arguments into positional ones
+// and --name value / --name=value options
+func parseOptions(args []string, names ...string) ([]string, map[string]string, error) {
+	positional := make([]string, 0)
+	options := make(map[string]string)
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		if !strings.HasPrefix(arg, "--") {
+			positional = append(positional, arg)
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+
+		if !funk.ContainsString(names, name) {
+			return nil, nil, fmt.Errorf("%w: unknown option --%v", errUsage, name)
+		}
+
+		if !hasValue {
+			if i+1 >= len(args) {
+				return nil, nil, fmt.Errorf("%w: option --%v needs a value", errUsage, name)
+			}
+
+			i++
+			value = args[i]
+		}
+
+		options[name] = value
+	}
+
+	return positional, options, nil
+}
+
+func runDrive(client *components_amipi400.ControlClient, drive string, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	command, ok := driveCommands[drive][strings.ToLower(args[0])]
+
+	if !ok {
+		return fmt.Errorf("%w: unknown %v command %v", errUsage, drive, args[0])
+	}
+
+	positional, options, err := parseOptions(args[1:], "target", "disk")
+
+	if err != nil {
+		return err
+	}
+
+	controlArgs := make(map[string]string)
+
+	if target, ok := options["target"]; ok {
+		controlArgs["target_index"] = target
+	}
+
+	if command == shared.CONTROL_CMD_DF_INSERT {
+		if diskNo, ok := options["disk"]; ok {
+			if len(positional) != 1 {
+				return errUsage
+			}
+
+			controlArgs["source_index"] = positional[0]
+			controlArgs["disk_no"] = diskNo
+
+			return client.Send(shared.CONTROL_CMD_DF_INSERT_BY_DISK_NO, controlArgs, nil)
+		}
+	} else if _, ok := options["disk"]; ok {
+		return fmt.Errorf("%w: --disk can be used only with df insert", errUsage)
+	}
+
+	switch command {
+	case shared.CONTROL_CMD_DF_INSERT, shared.CONTROL_CMD_CD_INSERT, shared.CONTROL_CMD_HF_INSERT:
+		if len(positional) != 2 {
+			return errUsage
+		}
+
+		controlArgs["source_index"] = positional[0]
+		controlArgs["filename_part"] = positional[1]
+	default:
+		if len(positional) != 1 {
+			return errUsage
+		}
+
+		controlArgs["source_index"] = positional[0]
+	}
+
+	return client.Send(command, controlArgs, nil)
+}
+
+func runCopy(client *components_amipi400.ControlClient, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+
+	source := utils.RegExInstance.FindNamedMatches(shared.AMIPI400CTL_LOW_LEVEL_DEVICE_RE, args[0])
+	target := utils.RegExInstance.FindNamedMatches(shared.AMIPI400CTL_LOW_LEVEL_DEVICE_RE, args[1])
+
+	if len(source) == 0 || len(target) == 0 {
+		return errUsage
+	}
+
+	return client.Send(
+		shared.CONTROL_CMD_LOW_LEVEL_COPY,
+		map[string]string{
+			"source_low_level_device": source["low_level_device"],
+			"source_index":            source["index"],
+			"target_low_level_device": target["low_level_device"],
+			"target_index":            target["index"],
+		},
+		nil)
+}
+
+// runArchive archives physical floppy in
+// the drive to the mounted DF/DH medium
+func runArchive(client *components_amipi400.ControlClient, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+
+	source := utils.RegExInstance.FindNamedMatches(shared.AMIPI400CTL_LOW_LEVEL_DEVICE_RE, args[0])
+	target := utils.RegExInstance.FindNamedMatches(shared.AMIPI400CTL_LOW_LEVEL_DEVICE_RE, args[1])
+
+	if len(source) == 0 || len(target) == 0 {
+		return errUsage
+	}
+
+	if strings.ToUpper(source["low_level_device"]) != shared.LOW_LEVEL_DEVICE_FLOPPY {
+		return errUsage
+	}
+
+	return client.Send(
+		shared.CONTROL_CMD_DF_ARCHIVE,
+		map[string]string{
+			"source_index":            source["index"],
+			"target_low_level_device": target["low_level_device"],
+			"target_index":            target["index"],
+		},
+		nil)
+}
+
+// runWrite writes ADF from the mounted DF
+// medium to the physical floppy in the drive
+func runWrite(client *components_amipi400.ControlClient, args []string) error {
+	if len(args) != 3 {
+		return errUsage
+	}
+
+	source := utils.RegExInstance.FindNamedMatches(shared.AMIPI400CTL_LOW_LEVEL_DEVICE_RE, args[0])
+	target := utils.RegExInstance.FindNamedMatches(shared.AMIPI400CTL_LOW_LEVEL_DEVICE_RE, args[2])
+
+	if len(source) == 0 || len(target) == 0 {
+		return errUsage
+	}
+
+	if strings.ToUpper(source["low_level_device"]) != shared.LOW_LEVEL_DEVICE_FLOPPY ||
+		strings.ToUpper(target["low_level_device"]) != shared.LOW_LEVEL_DEVICE_FLOPPY {
+		return errUsage
+	}
+
+	return client.Send(
+		shared.CONTROL_CMD_DF_WRITE,
+		map[string]string{
+			"source_index":  source["index"],
+			"filename_part": args[1],
+			"target_index":  target["index"],
+		},
+		nil)
+}
+
+// runCreate creates blank ADF on the mounted DF/DH medium
+func runCreate(client *components_amipi400.ControlClient, args []string) error {
+	positional, options, err := parseOptions(args, "fs")
+
+	if err != nil {
+		return err
+	}
+
+	if len(positional) < 1 || len(positional) > 2 {
+		return errUsage
+	}
+
+	target := utils.RegExInstance.FindNamedMatches(shared.AMIPI400CTL_LOW_LEVEL_DEVICE_RE, positional[0])
+
+	if len(target) == 0 {
+		return errUsage
+	}
+
+	return client.Send(
+		shared.CONTROL_CMD_CREATE_ADF,
+		map[string]string{
+			"target_low_level_device": target["low_level_device"],
+			"target_index":            target["index"],
+			"volume_name":             strings.Join(positional[1:], ""),
+			"file_system":             options["fs"],
+		},
+		nil)
+}
+
+func runReset(client *components_amipi400.ControlClient, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "soft":
+		return client.Send(shared.CONTROL_CMD_SOFT_RESET, nil, nil)
+	case "hard":
+		return client.Send(shared.CONTROL_CMD_HARD_RESET, nil, nil)
+	}
+
+	return errUsage
+}
+
+func runState(client *components_amipi400.ControlClient, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+
+	controlArgs := map[string]string{"slot": args[1]}
+
+	switch strings.ToLower(args[0]) {
+	case "save":
+		return client.Send(shared.CONTROL_CMD_SAVE_STATE, controlArgs, nil)
+	case "load":
+		return client.Send(shared.CONTROL_CMD_LOAD_STATE, controlArgs, nil)
+	}
+
+	return errUsage
+}
+
+func runConfig(client *components_amipi400.ControlClient, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	config := components_amipi400.ControlConfig{}
+
+	if err := client.Send(shared.CONTROL_CMD_CONFIG, nil, &config); err != nil {
+		return err
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "started":
+		fmt.Print(config.Started)
+	case "runtime":
+		fmt.Print(config.Runtime)
+	case "diff":
+		if *jsonOutput {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+
+			return encoder.Encode(config.Changes)
+		}
+
+		// like diff -u, without the context
+		for _, change := range config.Changes {
+			if change.Old != "" {
+				for _, value := range strings.Split(change.Old, "\n") {
+					fmt.Printf("-%v=%v\n", change.Key, value)
+				}
+			}
+
+			if change.New != "" {
+				for _, value := range strings.Split(change.New, "\n") {
+					fmt.Printf("+%v=%v\n", change.Key, value)
+				}
+			}
+		}
+	default:
+		return errUsage
+	}
+
+	return nil
+}
+
+func runWifi(client *components_amipi400.ControlClient, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "connect":
+		if len(args) != 4 {
+			return errUsage
+		}
+
+		return client.Send(
+			shared.CONTROL_CMD_WIFI_CONNECT,
+			map[string]string{
+				"country_code_iso_iec_3166_1": args[1],
+				"ssid":                        args[2],
+				"password":                    args[3],
+			},
+			nil)
+	case "disconnect":
+		if len(args) != 1 {
+			return errUsage
+		}
+
+		return client.Send(shared.CONTROL_CMD_WIFI_DISCONNECT, nil, nil)
+	}
+
+	return errUsage
+}
+
+func printSlots(writer *tabwriter.Writer, prefix string, slots []string) {
+	for index, pathname := range slots {
+		if pathname == "" {
+			pathname = "-"
+		}
+
+		fmt.Fprintf(writer, "%v%v:\t%v\n", prefix, index, pathname)
+	}
+}
+
+func formatIndex(prefix string, index int) string {
+	if index == shared.DRIVE_INDEX_UNSPECIFIED {
+		return ""
+	}
+
+	return fmt.Sprintf("%v%v", prefix, index)
+}
+
+func runStatus(client *components_amipi400.ControlClient, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	status := components_amipi400.ControlStatus{}
+
+	if err := client.Send(shared.CONTROL_CMD_STATUS, nil, &status); err != nil {
+		return err
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(&status)
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	profile := status.Profile
+
+	if profile == shared.AMIPI400_AMIBERRY_DEFAULT_PROFILE {
+		profile = "default"
+	}
+
+	fmt.Fprintf(writer, "Profile:\t%v\n\n", profile)
+	printSlots(writer, "DF", status.Adfs)
+	printSlots(writer, "DH", status.Hdfs)
+	printSlots(writer, "CD", status.Isos)
+
+	fmt.Fprintln(writer)
+	fmt.Fprintln(writer, "DEVICE\tMOUNTPOINT\tLABEL\tFS\tDRIVE\tFILES\tDEFAULT FILE")
+
+	for _, mountpoint := range status.Mountpoints {
+		drive := strings.Join(
+			[]string{
+				formatIndex("DF", mountpoint.DFIndex),
+				formatIndex("DH", mountpoint.DHIndex),
+				formatIndex("CD", mountpoint.CDIndex),
+			},
+			"")
+
+		fmt.Fprintf(
+			writer,
+			"%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			mountpoint.DevicePathname,
+			mountpoint.Mountpoint,
+			mountpoint.Label,
+			mountpoint.FsType,
+			drive,
+			mountpoint.FilesCount,
+			mountpoint.DefaultFile)
+	}
+
+	if len(status.ErrorCounts) > 0 {
+		kinds := make([]string, 0, len(status.ErrorCounts))
+
+		for kind := range status.ErrorCounts {
+			kinds = append(kinds, kind)
+		}
+
+		sort.Strings(kinds)
+
+		fmt.Fprintln(writer)
+		fmt.Fprintln(writer, "ERROR\tCOUNT")
+
+		for _, kind := range kinds {
+			fmt.Fprintf(writer, "%v\t%v\n", kind, status.ErrorCounts[kind])
+		}
+	}
+
+	return writer.Flush()
+}
+
+func run(client *components_amipi400.ControlClient, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	command := strings.ToLower(args[0])
+	args = args[1:]
+
+	switch command {
+	case "df", "cd", "hf", "dh":
+		return runDrive(client, command, args)
+	case "unmount":
+		if len(args) != 0 {
+			return errUsage
+		}
+
+		return client.Send(shared.CONTROL_CMD_UNMOUNT_ALL, nil, nil)
+	case "copy":
+		return runCopy(client, args)
+	case "archive":
+		return runArchive(client, args)
+	case "write":
+		return runWrite(client, args)
+	case "create":
+		return runCreate(client, args)
+	case "reset":
+		return runReset(client, args)
+	case "zoom":
+		if len(args) != 0 {
+			return errUsage
+		}
+
+		return client.Send(shared.CONTROL_CMD_TOGGLE_ZOOM, nil, nil)
+	case "state":
+		return runState(client, args)
+	case "config":
+		return runConfig(client, args)
+	case "profile":
+		if len(args) > 1 {
+			return errUsage
+		}
+
+		return client.Send(
+			shared.CONTROL_CMD_SWITCH_PROFILE,
+			map[string]string{"profile": strings.Join(args, "")},
+			nil)
+	case "wifi":
+		return runWifi(client, args)
+	case "keyboard":
+		if len(args) == 0 {
+			return errUsage
+		}
+
+		return client.Send(
+			shared.CONTROL_CMD_KEYBOARD,
+			map[string]string{"command": strings.Join(args, " ")},
+			nil)
+	case "status":
+		return runStatus(client, args)
+	}
+
+	return fmt.Errorf("%w: unknown command %v", errUsage, command)
+}
+
+func main() {
+	flag.Usage = printUsage
+	flag.Parse()
+
+	client := components_amipi400.NewControlClient(
+		*socketPathname,
+		time.Duration(*timeoutSecs)*time.Second)
+
+	if err := run(client, flag.Args()); err != nil {
+		controlError := &components_amipi400.ControlError{}
+
+		if errors.As(err, &controlError) && controlError.Kind != "" {
+			fmt.Fprintf(os.Stderr, "%v (%v)\n", controlError, controlError.Kind)
+		} else {
+			fmt.Fprintln(os.Stderr, err)
+		}
+
+		if errors.Is(err, errUsage) {
+			printUsage()
+			os.Exit(2)
+		}
+
+		os.Exit(1)
+	}
+}
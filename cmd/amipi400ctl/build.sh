@@ -0,0 +1,3 @@
+#!/bin/bash
+
+go build amipi400ctl.go
@@ -0,0 +1,116 @@
+package amigafs
+
+import (
+	"encoding/binary"
+	"strings"
+	"time"
+
+	"github.com/skazanyNaGlany/go.amipi400/shared"
+)
+
+// getLong returns big-endian long at offset
+func getLong(block []byte, offset int) uint32 {
+	return binary.BigEndian.Uint32(block[offset : offset+4])
+}
+
+// blockChecksum computes checksum of the header block,
+// the checksum field itself is not included
+func blockChecksum(block []byte) uint32 {
+	return blockChecksumAt(block, shared.AMIGAFS_CHECKSUM_OFFSET)
+}
+
+// blockChecksumAt computes checksum of the block which
+// has the checksum field at offset (eg. bitmap block)
+func blockChecksumAt(block []byte, checksumOffset int) uint32 {
+	sum := uint32(0)
+
+	for offset := 0; offset < len(block); offset += 4 {
+		if offset != checksumOffset {
+			sum += getLong(block, offset)
+		}
+	}
+
+	return -sum
+}
+
+// getBCPLString returns string stored as the length byte
+// followed by the characters (ISO-8859-1)
+func getBCPLString(block []byte, offset int, maxLength int) string {
+	length := int(block[offset])
+
+	if length > maxLength {
+		length = maxLength
+	}
+
+	var builder strings.Builder
+
+	for _, c := range block[offset+1 : offset+1+length] {
+		builder.WriteRune(rune(c))
+	}
+
+	return builder.String()
+}
+
+// getDate returns the time stored as days since 1978-01-01,
+// minutes since midnight and ticks (1/50 s)
+func getDate(block []byte, offset int) time.Time {
+	epoch := time.Date(1978, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	return epoch.AddDate(0, 0, int(getLong(block, offset))).
+		Add(time.Duration(getLong(block, offset+4)) * time.Minute).
+		Add(time.Duration(getLong(block, offset+8)) * time.Second / shared.AMIGAFS_TICKS_PER_SECOND)
+}
+
+// putLong stores big-endian long at offset
+func putLong(block []byte, offset int, value uint32) {
+	binary.BigEndian.PutUint32(block[offset:offset+4], value)
+}
+
+// bootBlockChecksum computes checksum of the boot block (both boot
+// blocks), it is the sum with carry, not the negated sum
+func bootBlockChecksum(bootBlock []byte) uint32 {
+	sum := uint32(0)
+
+	for offset := 0; offset < len(bootBlock); offset += 4 {
+		if offset == shared.AMIGAFS_BOOT_CHECKSUM_OFFSET {
+			continue
+		}
+
+		value := getLong(bootBlock, offset)
+
+		if sum+value < sum {
+			// carry
+			sum++
+		}
+
+		sum += value
+	}
+
+	return ^sum
+}
+
+// putBCPLString stores the string as the length byte followed
+// by the characters (ISO-8859-1), it must be validated before
+func putBCPLString(block []byte, offset int, s string) {
+	length := 0
+
+	for _, r := range s {
+		block[offset+1+length] = byte(r)
+		length++
+	}
+
+	block[offset] = byte(length)
+}
+
+// putDate stores the time as days since 1978-01-01,
+// minutes since midnight and ticks (1/50 s)
+func putDate(block []byte, offset int, t time.Time) {
+	t = t.UTC()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	epoch := time.Date(1978, 1, 1, 0, 0, 0, 0, time.UTC)
+	sinceMidnight := t.Sub(midnight)
+
+	putLong(block, offset, uint32(midnight.Sub(epoch).Hours()/24))
+	putLong(block, offset+4, uint32(sinceMidnight/time.Minute))
+	putLong(block, offset+8, uint32((sinceMidnight%time.Minute)*shared.AMIGAFS_TICKS_PER_SECOND/time.Second))
+}
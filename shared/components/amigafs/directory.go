@@ -0,0 +1,231 @@
+package amigafs
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/skazanyNaGlany/go.amipi400/shared"
+)
+
+var ErrNotFound = errors.New("not found")
+var ErrNotDirectory = errors.New("not a directory")
+var ErrTooDeep = errors.New("directory tree too deep")
+
+// toUpper converts the character like AmigaDOS does, the INTL
+// variant converts ISO-8859-1 accented characters too
+func toUpper(c rune, international bool) rune {
+	if c >= 'a' && c <= 'z' {
+		return c - 'a' + 'A'
+	}
+
+	if international && c >= 0xe0 && c <= 0xfe && c != 0xf7 {
+		return c - 0x20
+	}
+
+	return c
+}
+
+// nameHash returns index in the hash table of
+// the directory where the name is stored
+func nameHash(name string, international bool) int {
+	chars := []rune(name)
+	hash := uint32(len(chars))
+
+	for _, c := range chars {
+		hash = (hash*13 + uint32(toUpper(c, international))) & shared.AMIGAFS_NAME_HASH_MASK
+	}
+
+	return int(hash % shared.AMIGAFS_HASH_TABLE_SIZE)
+}
+
+// namesEqual compares the names case-insensitively
+func namesEqual(name1, name2 string, international bool) bool {
+	chars1 := []rune(name1)
+	chars2 := []rune(name2)
+
+	if len(chars1) != len(chars2) {
+		return false
+	}
+
+	for i := range chars1 {
+		if toUpper(chars1[i], international) != toUpper(chars2[i], international) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// readEntry reads the entry from the header block at index, hard
+// links are resolved, the next entry with the same hash is
+// returned too
+func (v *Volume) readEntry(index uint32, parent *Entry) (*Entry, uint32, error) {
+	block, err := v.readHeaderBlock(index)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	entry := &Entry{
+		block:      index,
+		name:       getBCPLString(block, shared.AMIGAFS_NAME_OFFSET, shared.AMIGAFS_MAX_NAME_LENGTH),
+		protection: getLong(block, shared.AMIGAFS_PROTECTION_OFFSET),
+		comment:    getBCPLString(block, shared.AMIGAFS_COMMENT_OFFSET, shared.AMIGAFS_MAX_COMMENT_LENGTH),
+		modified:   getDate(block, shared.AMIGAFS_DATE_OFFSET)}
+
+	entry.path = path.Join(parent.path, entry.name)
+	next := getLong(block, shared.AMIGAFS_NEXT_SAME_HASH_OFFSET)
+	secType := getLong(block, shared.AMIGAFS_SEC_TYPE_OFFSET)
+
+	if secType == shared.AMIGAFS_ST_LINKFILE || secType == shared.AMIGAFS_ST_LINKDIR {
+		entry.block = getLong(block, shared.AMIGAFS_REAL_ENTRY_OFFSET)
+
+		if block, err = v.readHeaderBlock(entry.block); err != nil {
+			return nil, 0, err
+		}
+
+		secType = getLong(block, shared.AMIGAFS_SEC_TYPE_OFFSET)
+	}
+
+	switch secType {
+	case shared.AMIGAFS_ST_USERDIR:
+		entry.directory = true
+	case shared.AMIGAFS_ST_FILE:
+		entry.size = getLong(block, shared.AMIGAFS_BYTE_SIZE_OFFSET)
+	case shared.AMIGAFS_ST_SOFTLINK:
+		entry.softLink = true
+	default:
+		return nil, 0, ErrBadBlock
+	}
+
+	return entry, next, nil
+}
+
+// ReadDir returns the entries of the directory sorted by name,
+// the hash table is used, so it works for DirCache too
+func (v *Volume) ReadDir(dir *Entry) ([]*Entry, error) {
+	if !dir.directory {
+		return nil, ErrNotDirectory
+	}
+
+	block, err := v.readHeaderBlock(dir.block)
+
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*Entry, 0)
+	visited := make(map[uint32]bool)
+
+	for hash := 0; hash < shared.AMIGAFS_HASH_TABLE_SIZE; hash++ {
+		index := getLong(block, shared.AMIGAFS_HASH_TABLE_OFFSET+hash*4)
+
+		for index != 0 {
+			if visited[index] {
+				return nil, ErrBadBlock
+			}
+
+			visited[index] = true
+			entry, next, err := v.readEntry(index, dir)
+
+			if err != nil {
+				return nil, err
+			}
+
+			entries = append(entries, entry)
+			index = next
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return strings.ToLower(entries[i].name) < strings.ToLower(entries[j].name)
+	})
+
+	return entries, nil
+}
+
+// Lookup returns the entry by its path relative to the root
+// directory, the names are compared case-insensitively
+func (v *Volume) Lookup(pathname string) (*Entry, error) {
+	current := v.root
+
+	for _, name := range strings.Split(pathname, "/") {
+		if name == "" {
+			continue
+		}
+
+		if !current.directory {
+			return nil, ErrNotDirectory
+		}
+
+		block, err := v.readHeaderBlock(current.block)
+
+		if err != nil {
+			return nil, err
+		}
+
+		hash := nameHash(name, v.IsInternational())
+		index := getLong(block, shared.AMIGAFS_HASH_TABLE_OFFSET+hash*4)
+		visited := make(map[uint32]bool)
+		parent := current
+
+		for current == parent {
+			if index == 0 {
+				return nil, ErrNotFound
+			}
+
+			if visited[index] {
+				return nil, ErrBadBlock
+			}
+
+			visited[index] = true
+			entry, next, err := v.readEntry(index, parent)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if namesEqual(entry.name, name, v.IsInternational()) {
+				current = entry
+			}
+
+			index = next
+		}
+	}
+
+	return current, nil
+}
+
+// Walk calls fn for every entry in the directory tree,
+// parent directories are visited before their entries
+func (v *Volume) Walk(fn func(entry *Entry) error) error {
+	return v.walk(v.root, 0, fn)
+}
+
+func (v *Volume) walk(dir *Entry, depth int, fn func(entry *Entry) error) error {
+	if depth > shared.AMIGAFS_MAX_DIRECTORY_DEPTH {
+		return ErrTooDeep
+	}
+
+	entries, err := v.ReadDir(dir)
+
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := fn(entry); err != nil {
+			return err
+		}
+
+		if entry.directory {
+			if err := v.walk(entry, depth+1, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
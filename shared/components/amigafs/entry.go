@@ -0,0 +1,58 @@
+package amigafs
+
+import (
+	"time"
+)
+
+// Entry is the file, directory or soft link
+// stored in the volume
+type Entry struct {
+	block      uint32
+	name       string
+	path       string
+	directory  bool
+	softLink   bool
+	size       uint32
+	protection uint32
+	comment    string
+	modified   time.Time
+}
+
+// Name returns the name of the entry, empty
+// for the root directory
+func (e *Entry) Name() string {
+	return e.name
+}
+
+// Path returns the path relative to the root
+// directory, the separator is "/"
+func (e *Entry) Path() string {
+	return e.path
+}
+
+func (e *Entry) IsDir() bool {
+	return e.directory
+}
+
+func (e *Entry) IsSoftLink() bool {
+	return e.softLink
+}
+
+// Size returns the size of the file in bytes
+func (e *Entry) Size() uint32 {
+	return e.size
+}
+
+// Protection returns the protection bits, note that the lowest
+// four bits (RWED) are set when the action is NOT allowed
+func (e *Entry) Protection() uint32 {
+	return e.protection
+}
+
+func (e *Entry) Comment() string {
+	return e.comment
+}
+
+func (e *Entry) Modified() time.Time {
+	return e.modified
+}
@@ -0,0 +1,266 @@
+package amigafs
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/skazanyNaGlany/go.amipi400/shared"
+)
+
+var ErrNotFile = errors.New("not a file")
+var ErrNotSoftLink = errors.New("not a soft link")
+var ErrBadOffset = errors.New("bad offset")
+
+// dataBlockSize returns the number of file bytes in the data
+// block, OFS data blocks start with the header
+func (v *Volume) dataBlockSize() int64 {
+	if v.IsFFS() {
+		return shared.AMIGAFS_BLOCK_SIZE
+	}
+
+	return shared.AMIGAFS_BLOCK_SIZE - shared.AMIGAFS_OFS_DATA_HEADER_SIZE
+}
+
+// isFileSizeValid returns false when the file cannot fit in
+// the volume, the size comes from the (possibly corrupted)
+// file header block, it must be checked before allocating
+func (v *Volume) isFileSizeValid(file *Entry) bool {
+	return int64(file.size) <= (v.blocks-shared.AMIGAFS_BOOT_BLOCKS)*v.dataBlockSize()
+}
+
+// getDataBlocks returns the data blocks of the file, the first
+// ones are listed (in reverse order) in the file header block,
+// others in the chained extension blocks
+func (v *Volume) getDataBlocks(file *Entry) ([]uint32, error) {
+	if !v.isFileSizeValid(file) {
+		return nil, ErrBadBlock
+	}
+
+	dataBlockSize := v.dataBlockSize()
+	count := int((int64(file.size) + dataBlockSize - 1) / dataBlockSize)
+	dataBlocks := make([]uint32, 0, count)
+	visited := make(map[uint32]bool)
+
+	block, err := v.readHeaderBlock(file.block)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		highSeq := int(getLong(block, shared.AMIGAFS_HIGH_SEQ_OFFSET))
+
+		if highSeq > shared.AMIGAFS_DATA_BLOCKS_PER_HEADER {
+			return nil, ErrBadBlock
+		}
+
+		for i := 0; i < highSeq && len(dataBlocks) < count; i++ {
+			offset := shared.AMIGAFS_HASH_TABLE_OFFSET + (shared.AMIGAFS_DATA_BLOCKS_PER_HEADER-1-i)*4
+
+			dataBlocks = append(dataBlocks, getLong(block, offset))
+		}
+
+		if len(dataBlocks) >= count {
+			return dataBlocks, nil
+		}
+
+		ext := getLong(block, shared.AMIGAFS_EXTENSION_OFFSET)
+
+		if ext == 0 || visited[ext] {
+			return nil, ErrBadBlock
+		}
+
+		visited[ext] = true
+
+		if block, err = v.readBlock(ext); err != nil {
+			return nil, err
+		}
+
+		if getLong(block, 0) != shared.AMIGAFS_T_LIST ||
+			getLong(block, 4) != ext ||
+			getLong(block, shared.AMIGAFS_CHECKSUM_OFFSET) != blockChecksum(block) {
+			return nil, ErrBadBlock
+		}
+	}
+}
+
+// readDataBlock returns the file bytes stored in the data block
+func (v *Volume) readDataBlock(file *Entry, index uint32) ([]byte, error) {
+	block, err := v.readBlock(index)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if v.IsFFS() {
+		return block, nil
+	}
+
+	dataSize := getLong(block, shared.AMIGAFS_OFS_DATA_SIZE_OFFSET)
+
+	if getLong(block, 0) != shared.AMIGAFS_T_DATA ||
+		getLong(block, 4) != file.block ||
+		dataSize > uint32(v.dataBlockSize()) ||
+		getLong(block, shared.AMIGAFS_CHECKSUM_OFFSET) != blockChecksum(block) {
+		return nil, ErrBadBlock
+	}
+
+	return block[shared.AMIGAFS_OFS_DATA_HEADER_SIZE : shared.AMIGAFS_OFS_DATA_HEADER_SIZE+dataSize], nil
+}
+
+// File is the open file, the list of its data blocks is
+// read once, so the reads do not follow the extension
+// blocks again
+type File struct {
+	volume     *Volume
+	entry      *Entry
+	dataBlocks []uint32
+}
+
+// OpenFile reads the list of the data blocks of the file
+func (v *Volume) OpenFile(file *Entry) (*File, error) {
+	if file.directory || file.softLink {
+		return nil, ErrNotFile
+	}
+
+	dataBlocks, err := v.getDataBlocks(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{volume: v, entry: file, dataBlocks: dataBlocks}, nil
+}
+
+func (f *File) Entry() *Entry {
+	return f.entry
+}
+
+// ReadFileAt reads len(buff) bytes of the file starting
+// at offset, io.EOF is returned at the end of the file
+func (v *Volume) ReadFileAt(file *Entry, buff []byte, offset int64) (int, error) {
+	if file.directory || file.softLink {
+		return 0, ErrNotFile
+	}
+
+	if offset < 0 {
+		return 0, ErrBadOffset
+	}
+
+	if offset >= int64(file.size) {
+		return 0, io.EOF
+	}
+
+	openFile, err := v.OpenFile(file)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return openFile.ReadAt(buff, offset)
+}
+
+// ReadAt reads len(buff) bytes of the file starting
+// at offset, io.EOF is returned at the end of the file
+func (f *File) ReadAt(buff []byte, offset int64) (int, error) {
+	v := f.volume
+	file := f.entry
+
+	if offset < 0 {
+		return 0, ErrBadOffset
+	}
+
+	if offset >= int64(file.size) {
+		return 0, io.EOF
+	}
+
+	dataBlockSize := v.dataBlockSize()
+	n := 0
+
+	for n < len(buff) && offset < int64(file.size) {
+		data, err := v.readDataBlock(file, f.dataBlocks[offset/dataBlockSize])
+
+		if err != nil {
+			return n, err
+		}
+
+		blockOffset := offset % dataBlockSize
+		remaining := int64(file.size) - offset
+
+		if blockOffset >= int64(len(data)) {
+			// short OFS data block
+			return n, ErrBadBlock
+		}
+
+		data = data[blockOffset:]
+
+		if int64(len(data)) > remaining {
+			data = data[:remaining]
+		}
+
+		copied := copy(buff[n:], data)
+
+		n += copied
+		offset += int64(copied)
+	}
+
+	if n < len(buff) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// ReadFile returns the contents of the file
+func (v *Volume) ReadFile(file *Entry) ([]byte, error) {
+	if file.directory || file.softLink {
+		return nil, ErrNotFile
+	}
+
+	if !v.isFileSizeValid(file) {
+		return nil, ErrBadBlock
+	}
+
+	data := make([]byte, file.size)
+
+	if len(data) == 0 {
+		return data, nil
+	}
+
+	n, err := v.ReadFileAt(file, data, 0)
+
+	if err != nil && !(err == io.EOF && n == len(data)) {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// ReadSoftLink returns the path the soft link points
+// to, it is AmigaDOS path like "Work:C/Dir"
+func (v *Volume) ReadSoftLink(link *Entry) (string, error) {
+	if !link.softLink {
+		return "", ErrNotSoftLink
+	}
+
+	block, err := v.readHeaderBlock(link.block)
+
+	if err != nil {
+		return "", err
+	}
+
+	var builder strings.Builder
+
+	offset := shared.AMIGAFS_SOFTLINK_PATH_OFFSET
+
+	for _, c := range block[offset : offset+shared.AMIGAFS_SOFTLINK_PATH_LENGTH] {
+		if c == 0 {
+			break
+		}
+
+		builder.WriteRune(rune(c))
+	}
+
+	return builder.String(), nil
+}
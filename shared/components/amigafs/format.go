@@ -0,0 +1,227 @@
+package amigafs
+
+import (
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/skazanyNaGlany/go.amipi400/shared"
+)
+
+var ErrBadVolumeName = errors.New("bad volume name")
+var ErrBadVolumeSize = errors.New("bad volume size")
+
+// FormatOptions selects the file system variant, OFS is used
+// when FFS is false, International and DirCache are
+// the INTL and DCFS variants (Kickstart 2.0+/3.0+)
+type FormatOptions struct {
+	VolumeName    string
+	FFS           bool
+	International bool
+	DirCache      bool
+}
+
+// dosType returns the fourth byte of the "DOS" signature
+func (fo *FormatOptions) dosType() byte {
+	flags := byte(0)
+
+	if fo.FFS {
+		flags |= shared.AMIGAFS_DOS_FLAG_FFS
+	}
+
+	if fo.DirCache {
+		// DirCache implies INTL
+		flags |= shared.AMIGAFS_DOS_FLAG_DIRCACHE
+	} else if fo.International {
+		flags |= shared.AMIGAFS_DOS_FLAG_INTL
+	}
+
+	return flags
+}
+
+// ValidateVolumeName checks if the name can be used as the volume
+// name, it must be ISO-8859-1 without ':' and '/'
+func ValidateVolumeName(name string) error {
+	length := 0
+
+	for _, r := range name {
+		if r < ' ' || r > 0xff || r == 0x7f || strings.ContainsRune(":/", r) {
+			return ErrBadVolumeName
+		}
+
+		length++
+	}
+
+	if length == 0 || length > shared.AMIGAFS_MAX_NAME_LENGTH {
+		return ErrBadVolumeName
+	}
+
+	return nil
+}
+
+// Format creates empty volume of size bytes (whole blocks), only the
+// boot block, root block, bitmap (and the directory cache) blocks
+// are written, other blocks are left untouched
+func Format(w io.WriterAt, size int64, options FormatOptions) error {
+	if options.VolumeName == "" {
+		options.VolumeName = shared.AMIGAFS_DEFAULT_VOLUME_NAME
+	}
+
+	if err := ValidateVolumeName(options.VolumeName); err != nil {
+		return err
+	}
+
+	blocks := size / shared.AMIGAFS_BLOCK_SIZE
+
+	if blocks <= shared.AMIGAFS_BOOT_BLOCKS*2 || blocks > math.MaxUint32 {
+		return ErrBadVolumeSize
+	}
+
+	bootBlock := make([]byte, shared.AMIGAFS_BOOT_BLOCKS*shared.AMIGAFS_BLOCK_SIZE)
+	rootBlock := blocks / 2
+
+	copy(bootBlock, shared.AMIGAFS_DOS_TYPE_PREFIX)
+	bootBlock[len(shared.AMIGAFS_DOS_TYPE_PREFIX)] = options.dosType()
+	putLong(bootBlock, shared.AMIGAFS_BOOT_ROOT_BLOCK_OFFSET, uint32(rootBlock))
+	putLong(bootBlock, shared.AMIGAFS_BOOT_CHECKSUM_OFFSET, bootBlockChecksum(bootBlock))
+
+	// blocks after the root block: bitmap blocks, bitmap
+	// extension blocks and the directory cache block
+	bitsPerBitmapBlock := int64(shared.AMIGAFS_BITMAP_LONGS * 32)
+	bitmapBlocks := (blocks - shared.AMIGAFS_BOOT_BLOCKS + bitsPerBitmapBlock - 1) / bitsPerBitmapBlock
+	bitmapExtBlocks := int64(0)
+
+	if bitmapBlocks > shared.AMIGAFS_BITMAP_PAGES {
+		bitmapExtBlocks = (bitmapBlocks - shared.AMIGAFS_BITMAP_PAGES + shared.AMIGAFS_BITMAP_EXT_PAGES - 1) /
+			shared.AMIGAFS_BITMAP_EXT_PAGES
+	}
+
+	dirCacheBlocks := int64(0)
+
+	if options.DirCache {
+		dirCacheBlocks = 1
+	}
+
+	metaBlocks := 1 + bitmapBlocks + bitmapExtBlocks + dirCacheBlocks
+
+	if rootBlock+metaBlocks > blocks {
+		return ErrBadVolumeSize
+	}
+
+	meta := make([]byte, metaBlocks*shared.AMIGAFS_BLOCK_SIZE)
+	block := func(index int64) []byte {
+		offset := (index - rootBlock) * shared.AMIGAFS_BLOCK_SIZE
+
+		return meta[offset : offset+shared.AMIGAFS_BLOCK_SIZE]
+	}
+
+	firstBitmapBlock := rootBlock + 1
+	firstBitmapExtBlock := firstBitmapBlock + bitmapBlocks
+	dirCacheBlock := firstBitmapExtBlock + bitmapExtBlocks
+
+	// bitmap, bit set means free block, the boot
+	// blocks are not included
+	for index := int64(shared.AMIGAFS_BOOT_BLOCKS); index < blocks; index++ {
+		if index >= rootBlock && index < rootBlock+metaBlocks {
+			continue
+		}
+
+		bit := index - shared.AMIGAFS_BOOT_BLOCKS
+		bitmap := block(firstBitmapBlock + bit/bitsPerBitmapBlock)
+		offset := 4 + int(bit%bitsPerBitmapBlock/32)*4
+
+		putLong(bitmap, offset, getLong(bitmap, offset)|1<<(bit%32))
+	}
+
+	for index := firstBitmapBlock; index < firstBitmapExtBlock; index++ {
+		bitmap := block(index)
+
+		putLong(bitmap, 0, blockChecksumAt(bitmap, 0))
+	}
+
+	// pointers to the bitmap blocks, the first
+	// ones in the root block, others in the
+	// bitmap extension blocks
+	root := block(rootBlock)
+
+	for page := int64(0); page < bitmapBlocks; page++ {
+		if page < shared.AMIGAFS_BITMAP_PAGES {
+			putLong(root, shared.AMIGAFS_BITMAP_PAGES_OFFSET+int(page)*4, uint32(firstBitmapBlock+page))
+
+			continue
+		}
+
+		extPage := page - shared.AMIGAFS_BITMAP_PAGES
+		ext := block(firstBitmapExtBlock + extPage/shared.AMIGAFS_BITMAP_EXT_PAGES)
+
+		putLong(ext, int(extPage%shared.AMIGAFS_BITMAP_EXT_PAGES)*4, uint32(firstBitmapBlock+page))
+	}
+
+	// bitmap extension blocks are chained
+	for index := firstBitmapExtBlock; index+1 < dirCacheBlock; index++ {
+		putLong(block(index), shared.AMIGAFS_BITMAP_EXT_PAGES*4, uint32(index+1))
+	}
+
+	if bitmapExtBlocks > 0 {
+		putLong(root, shared.AMIGAFS_BITMAP_EXT_OFFSET, uint32(firstBitmapExtBlock))
+	}
+
+	now := time.Now()
+
+	putLong(root, 0, shared.AMIGAFS_T_HEADER)
+	putLong(root, 12, shared.AMIGAFS_HASH_TABLE_SIZE)
+	putLong(root, shared.AMIGAFS_BITMAP_FLAG_OFFSET, math.MaxUint32)
+	putDate(root, shared.AMIGAFS_ROOT_ALTERED_OFFSET, now)
+	putBCPLString(root, shared.AMIGAFS_NAME_OFFSET, options.VolumeName)
+	putDate(root, shared.AMIGAFS_VOLUME_ALTERED_OFFSET, now)
+	putDate(root, shared.AMIGAFS_VOLUME_CREATED_OFFSET, now)
+	putLong(root, shared.AMIGAFS_SEC_TYPE_OFFSET, shared.AMIGAFS_ST_ROOT)
+
+	if options.DirCache {
+		// empty directory cache block of the root directory
+		dirCache := block(dirCacheBlock)
+
+		putLong(dirCache, 0, shared.AMIGAFS_T_DIRCACHE)
+		putLong(dirCache, 4, uint32(dirCacheBlock))
+		putLong(dirCache, 8, uint32(rootBlock))
+		putLong(dirCache, shared.AMIGAFS_CHECKSUM_OFFSET, blockChecksum(dirCache))
+
+		putLong(root, shared.AMIGAFS_EXTENSION_OFFSET, uint32(dirCacheBlock))
+	}
+
+	putLong(root, shared.AMIGAFS_CHECKSUM_OFFSET, blockChecksum(root))
+
+	if _, err := w.WriteAt(bootBlock, 0); err != nil {
+		return err
+	}
+
+	if _, err := w.WriteAt(meta, rootBlock*shared.AMIGAFS_BLOCK_SIZE); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// NewImage returns the image of empty volume, eg. blank
+// ADF for shared.FLOPPY_ADF_SIZE
+func NewImage(size int64, options FormatOptions) ([]byte, error) {
+	image := imageWriter(make([]byte, size))
+
+	if err := Format(image, size, options); err != nil {
+		return nil, err
+	}
+
+	return image, nil
+}
+
+type imageWriter []byte
+
+func (iw imageWriter) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > int64(len(iw)) {
+		return 0, io.ErrShortWrite
+	}
+
+	return copy(iw[off:], p), nil
+}
@@ -0,0 +1,241 @@
+package amigafs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"math/bits"
+	"time"
+
+	"github.com/skazanyNaGlany/go.amipi400/shared"
+)
+
+var ErrNotDOS = errors.New("not an AmigaDOS disk")
+var ErrBadRootBlock = errors.New("bad root block")
+var ErrBadBlock = errors.New("bad block")
+
+// Volume is read-only AmigaDOS (OFS/FFS, INTL and DirCache
+// variants) file system stored in the disk image (ADF or
+// HDF without RDB)
+type Volume struct {
+	reader    io.ReaderAt
+	blocks    int64
+	dosType   byte
+	rootBlock uint32
+	name      string
+	created   time.Time
+	altered   time.Time
+	root      *Entry
+}
+
+// Open reads the boot block and the root block of the disk
+// image of size bytes, other blocks are read on demand
+func Open(reader io.ReaderAt, size int64) (*Volume, error) {
+	blocks := size / shared.AMIGAFS_BLOCK_SIZE
+
+	if blocks <= shared.AMIGAFS_BOOT_BLOCKS || blocks > math.MaxUint32 {
+		return nil, ErrNotDOS
+	}
+
+	header := make([]byte, len(shared.AMIGAFS_DOS_TYPE_PREFIX)+1)
+
+	if _, err := reader.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+
+	dosType := header[len(shared.AMIGAFS_DOS_TYPE_PREFIX)]
+
+	// DOS\6 and DOS\7 (long file names) are not supported
+	if !bytes.HasPrefix(header, shared.AMIGAFS_DOS_TYPE_PREFIX) ||
+		dosType > shared.AMIGAFS_DOS_FLAG_FFS|shared.AMIGAFS_DOS_FLAG_DIRCACHE {
+		return nil, ErrNotDOS
+	}
+
+	volume := &Volume{
+		reader:    reader,
+		blocks:    blocks,
+		dosType:   dosType,
+		rootBlock: uint32(blocks / 2)}
+
+	block, err := volume.readHeaderBlock(volume.rootBlock)
+
+	if err != nil || getLong(block, shared.AMIGAFS_SEC_TYPE_OFFSET) != shared.AMIGAFS_ST_ROOT {
+		return nil, ErrBadRootBlock
+	}
+
+	volume.name = getBCPLString(block, shared.AMIGAFS_NAME_OFFSET, shared.AMIGAFS_MAX_NAME_LENGTH)
+	volume.created = getDate(block, shared.AMIGAFS_VOLUME_CREATED_OFFSET)
+	volume.altered = getDate(block, shared.AMIGAFS_VOLUME_ALTERED_OFFSET)
+	volume.root = &Entry{
+		block:     volume.rootBlock,
+		directory: true,
+		modified:  getDate(block, shared.AMIGAFS_ROOT_ALTERED_OFFSET)}
+
+	return volume, nil
+}
+
+// ReadVolumeName returns the volume name from the root
+// block of the whole disk image (ADF or HDF without RDB)
+func ReadVolumeName(image []byte) (string, error) {
+	volume, err := Open(bytes.NewReader(image), int64(len(image)))
+
+	if err != nil {
+		return "", err
+	}
+
+	return volume.Name(), nil
+}
+
+func (v *Volume) Name() string {
+	return v.name
+}
+
+func (v *Volume) Created() time.Time {
+	return v.created
+}
+
+func (v *Volume) Altered() time.Time {
+	return v.altered
+}
+
+// Blocks returns the number of blocks including the boot blocks
+func (v *Volume) Blocks() int64 {
+	return v.blocks
+}
+
+func (v *Volume) IsFFS() bool {
+	return v.dosType&shared.AMIGAFS_DOS_FLAG_FFS != 0
+}
+
+// IsInternational returns true when the names are compared
+// with ISO-8859-1 case folding, DirCache implies INTL
+func (v *Volume) IsInternational() bool {
+	return v.dosType&(shared.AMIGAFS_DOS_FLAG_INTL|shared.AMIGAFS_DOS_FLAG_DIRCACHE) != 0
+}
+
+func (v *Volume) IsDirCache() bool {
+	return v.dosType&shared.AMIGAFS_DOS_FLAG_DIRCACHE != 0
+}
+
+// Root returns the root directory
+func (v *Volume) Root() *Entry {
+	return v.root
+}
+
+// FreeBlocks counts the blocks marked as free in the bitmap, valid
+// is false when the bitmap was not written back (eg. the disk
+// was removed while being written) and needs validation
+func (v *Volume) FreeBlocks() (free int64, valid bool, err error) {
+	root, err := v.readHeaderBlock(v.rootBlock)
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	valid = getLong(root, shared.AMIGAFS_BITMAP_FLAG_OFFSET) == math.MaxUint32
+	pages := make([]uint32, 0, shared.AMIGAFS_BITMAP_PAGES)
+
+	for page := 0; page < shared.AMIGAFS_BITMAP_PAGES; page++ {
+		pages = append(pages, getLong(root, shared.AMIGAFS_BITMAP_PAGES_OFFSET+page*4))
+	}
+
+	visited := make(map[uint32]bool)
+
+	for ext := getLong(root, shared.AMIGAFS_BITMAP_EXT_OFFSET); ext != 0; {
+		if visited[ext] {
+			return 0, false, ErrBadBlock
+		}
+
+		visited[ext] = true
+		block, err := v.readBlock(ext)
+
+		if err != nil {
+			return 0, false, err
+		}
+
+		for page := 0; page < shared.AMIGAFS_BITMAP_EXT_PAGES; page++ {
+			pages = append(pages, getLong(block, page*4))
+		}
+
+		ext = getLong(block, shared.AMIGAFS_BITMAP_EXT_PAGES*4)
+	}
+
+	// the boot blocks are not included
+	remaining := v.blocks - shared.AMIGAFS_BOOT_BLOCKS
+
+	for _, page := range pages {
+		if remaining <= 0 {
+			break
+		}
+
+		if page == 0 {
+			return 0, false, ErrBadBlock
+		}
+
+		block, err := v.readBlock(page)
+
+		if err != nil {
+			return 0, false, err
+		}
+
+		if getLong(block, 0) != blockChecksumAt(block, 0) {
+			return 0, false, ErrBadBlock
+		}
+
+		for offset := 4; offset < shared.AMIGAFS_BLOCK_SIZE && remaining > 0; offset += 4 {
+			value := getLong(block, offset)
+
+			if remaining < 32 {
+				value &= 1<<remaining - 1
+			}
+
+			free += int64(bits.OnesCount32(value))
+			remaining -= 32
+		}
+	}
+
+	if remaining > 0 {
+		return 0, false, ErrBadBlock
+	}
+
+	return free, valid, nil
+}
+
+// readBlock reads the block at index, the boot
+// blocks cannot be read this way
+func (v *Volume) readBlock(index uint32) ([]byte, error) {
+	if index < shared.AMIGAFS_BOOT_BLOCKS || int64(index) >= v.blocks {
+		return nil, ErrBadBlock
+	}
+
+	block := make([]byte, shared.AMIGAFS_BLOCK_SIZE)
+
+	if _, err := v.reader.ReadAt(block, int64(index)*shared.AMIGAFS_BLOCK_SIZE); err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// readHeaderBlock reads the header block (root, directory, file
+// or link) at index and checks its type, key and checksum
+func (v *Volume) readHeaderBlock(index uint32) ([]byte, error) {
+	block, err := v.readBlock(index)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if getLong(block, 0) != shared.AMIGAFS_T_HEADER ||
+		getLong(block, shared.AMIGAFS_CHECKSUM_OFFSET) != blockChecksum(block) {
+		return nil, ErrBadBlock
+	}
+
+	// the root block has no header key
+	if index != v.rootBlock && getLong(block, 4) != index {
+		return nil, ErrBadBlock
+	}
+
+	return block, nil
+}
@@ -0,0 +1,565 @@
+package amigafs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/skazanyNaGlany/go.amipi400/shared"
+)
+
+// testImage adds entries to the formatted image, like
+// AmigaDOS does, used blocks are marked in the bitmap
+type testImage struct {
+	t      *testing.T
+	image  []byte
+	volume *Volume
+	next   uint32
+}
+
+func newTestImage(t *testing.T, size int64, options FormatOptions) *testImage {
+	image, err := NewImage(size, options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	volume, err := Open(bytes.NewReader(image), size)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testImage{
+		t:      t,
+		image:  image,
+		volume: volume,
+		next:   shared.AMIGAFS_BOOT_BLOCKS}
+}
+
+func (ti *testImage) block(index uint32) []byte {
+	offset := int64(index) * shared.AMIGAFS_BLOCK_SIZE
+
+	return ti.image[offset : offset+shared.AMIGAFS_BLOCK_SIZE]
+}
+
+func (ti *testImage) updateChecksum(index uint32) {
+	block := ti.block(index)
+
+	putLong(block, shared.AMIGAFS_CHECKSUM_OFFSET, blockChecksum(block))
+}
+
+// allocate returns the next free block, the blocks
+// after the root block are used by Format
+func (ti *testImage) allocate() uint32 {
+	index := ti.next
+
+	if index == ti.volume.rootBlock {
+		ti.t.Fatal("volume is full")
+	}
+
+	ti.next++
+
+	bitsPerBitmapBlock := uint32(shared.AMIGAFS_BITMAP_LONGS * 32)
+	bit := index - shared.AMIGAFS_BOOT_BLOCKS
+	root := ti.block(ti.volume.rootBlock)
+	bitmapIndex := getLong(root, shared.AMIGAFS_BITMAP_PAGES_OFFSET+int(bit/bitsPerBitmapBlock)*4)
+	bitmap := ti.block(bitmapIndex)
+	offset := 4 + int(bit%bitsPerBitmapBlock/32)*4
+
+	putLong(bitmap, offset, getLong(bitmap, offset)&^(1<<(bit%32)))
+	putLong(bitmap, 0, blockChecksumAt(bitmap, 0))
+
+	return index
+}
+
+// addHeader adds the header block to the hash table of the
+// parent directory, the entry is the first in the chain
+func (ti *testImage) addHeader(parent uint32, name string, secType uint32) uint32 {
+	index := ti.allocate()
+	block := ti.block(index)
+	parentBlock := ti.block(parent)
+	hashOffset := shared.AMIGAFS_HASH_TABLE_OFFSET + nameHash(name, ti.volume.IsInternational())*4
+
+	putLong(block, 0, shared.AMIGAFS_T_HEADER)
+	putLong(block, 4, index)
+	putBCPLString(block, shared.AMIGAFS_NAME_OFFSET, name)
+	putDate(block, shared.AMIGAFS_DATE_OFFSET, time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC))
+	putLong(block, shared.AMIGAFS_NEXT_SAME_HASH_OFFSET, getLong(parentBlock, hashOffset))
+	putLong(block, shared.AMIGAFS_PARENT_OFFSET, parent)
+	putLong(block, shared.AMIGAFS_SEC_TYPE_OFFSET, secType)
+	putLong(parentBlock, hashOffset, index)
+
+	ti.updateChecksum(index)
+	ti.updateChecksum(parent)
+
+	return index
+}
+
+func (ti *testImage) addDir(parent uint32, name string) uint32 {
+	return ti.addHeader(parent, name, shared.AMIGAFS_ST_USERDIR)
+}
+
+// addFile stores the data in the data blocks, the ones which do
+// not fit in the file header block are in the extension blocks
+func (ti *testImage) addFile(parent uint32, name string, data []byte) uint32 {
+	index := ti.addHeader(parent, name, shared.AMIGAFS_ST_FILE)
+	dataBlockSize := int(ti.volume.dataBlockSize())
+	header := index
+	dataBlocks := make([]uint32, 0)
+	previousData := uint32(0)
+
+	putLong(ti.block(index), shared.AMIGAFS_BYTE_SIZE_OFFSET, uint32(len(data)))
+
+	for offset := 0; offset < len(data); offset += dataBlockSize {
+		if len(dataBlocks) == shared.AMIGAFS_DATA_BLOCKS_PER_HEADER {
+			ext := ti.allocate()
+			extBlock := ti.block(ext)
+
+			putLong(extBlock, 0, shared.AMIGAFS_T_LIST)
+			putLong(extBlock, 4, ext)
+			putLong(extBlock, shared.AMIGAFS_PARENT_OFFSET, index)
+			putLong(extBlock, shared.AMIGAFS_SEC_TYPE_OFFSET, shared.AMIGAFS_ST_FILE)
+			putLong(ti.block(header), shared.AMIGAFS_EXTENSION_OFFSET, ext)
+
+			ti.updateChecksum(header)
+
+			header = ext
+			dataBlocks = dataBlocks[:0]
+		}
+
+		chunk := data[offset:]
+
+		if len(chunk) > dataBlockSize {
+			chunk = chunk[:dataBlockSize]
+		}
+
+		dataIndex := ti.allocate()
+		dataBlock := ti.block(dataIndex)
+
+		if ti.volume.IsFFS() {
+			copy(dataBlock, chunk)
+		} else {
+			putLong(dataBlock, 0, shared.AMIGAFS_T_DATA)
+			putLong(dataBlock, 4, index)
+			putLong(dataBlock, 8, uint32(offset/dataBlockSize+1))
+			putLong(dataBlock, shared.AMIGAFS_OFS_DATA_SIZE_OFFSET, uint32(len(chunk)))
+			copy(dataBlock[shared.AMIGAFS_OFS_DATA_HEADER_SIZE:], chunk)
+
+			if previousData != 0 {
+				putLong(ti.block(previousData), shared.AMIGAFS_OFS_NEXT_DATA_OFFSET, dataIndex)
+				ti.updateChecksum(previousData)
+			}
+
+			ti.updateChecksum(dataIndex)
+			previousData = dataIndex
+		}
+
+		// the data blocks are stored in reverse order
+		dataBlocks = append(dataBlocks, dataIndex)
+		headerBlock := ti.block(header)
+		tableOffset := shared.AMIGAFS_HASH_TABLE_OFFSET + (shared.AMIGAFS_DATA_BLOCKS_PER_HEADER-len(dataBlocks))*4
+
+		putLong(headerBlock, tableOffset, dataIndex)
+		putLong(headerBlock, shared.AMIGAFS_HIGH_SEQ_OFFSET, uint32(len(dataBlocks)))
+
+		if offset == 0 {
+			putLong(headerBlock, 16, dataIndex)
+		}
+
+		ti.updateChecksum(header)
+	}
+
+	return index
+}
+
+func (ti *testImage) addHardLink(parent uint32, name string, target uint32, secType uint32) uint32 {
+	index := ti.addHeader(parent, name, secType)
+
+	putLong(ti.block(index), shared.AMIGAFS_REAL_ENTRY_OFFSET, target)
+	ti.updateChecksum(index)
+
+	return index
+}
+
+func (ti *testImage) addSoftLink(parent uint32, name string, target string) uint32 {
+	index := ti.addHeader(parent, name, shared.AMIGAFS_ST_SOFTLINK)
+
+	copy(ti.block(index)[shared.AMIGAFS_SOFTLINK_PATH_OFFSET:], target)
+	ti.updateChecksum(index)
+
+	return index
+}
+
+func (ti *testImage) open() *Volume {
+	volume, err := Open(bytes.NewReader(ti.image), int64(len(ti.image)))
+
+	if err != nil {
+		ti.t.Fatal(err)
+	}
+
+	return volume
+}
+
+func newTestData(size int) []byte {
+	data := make([]byte, size)
+
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+
+	return data
+}
+
+var testFormatOptions = []FormatOptions{
+	{VolumeName: "OFS"},
+	{VolumeName: "FFS", FFS: true},
+	{VolumeName: "OFS INTL", International: true},
+	{VolumeName: "FFS INTL", FFS: true, International: true},
+	{VolumeName: "FFS DC", FFS: true, DirCache: true},
+}
+
+func TestFormat(t *testing.T) {
+	for _, options := range testFormatOptions {
+		image, err := NewImage(shared.FLOPPY_ADF_SIZE, options)
+
+		if err != nil {
+			t.Fatalf("%v: %v", options.VolumeName, err)
+		}
+
+		name, err := ReadVolumeName(image)
+
+		if err != nil || name != options.VolumeName {
+			t.Errorf("%v: ReadVolumeName() = %q, %v", options.VolumeName, name, err)
+		}
+
+		volume, err := Open(bytes.NewReader(image), int64(len(image)))
+
+		if err != nil {
+			t.Fatalf("%v: %v", options.VolumeName, err)
+		}
+
+		if volume.IsFFS() != options.FFS ||
+			volume.IsInternational() != (options.International || options.DirCache) ||
+			volume.IsDirCache() != options.DirCache {
+			t.Errorf("%v: wrong DOS type %v", options.VolumeName, volume.dosType)
+		}
+
+		if volume.Blocks() != shared.FLOPPY_ADF_SIZE/shared.AMIGAFS_BLOCK_SIZE {
+			t.Errorf("%v: Blocks() = %v", options.VolumeName, volume.Blocks())
+		}
+
+		// the boot blocks, the root block and the bitmap block
+		// (and the directory cache block) are not free
+		expectedFree := volume.Blocks() - shared.AMIGAFS_BOOT_BLOCKS - 2
+
+		if options.DirCache {
+			expectedFree--
+		}
+
+		if free, valid, err := volume.FreeBlocks(); free != expectedFree || !valid || err != nil {
+			t.Errorf("%v: FreeBlocks() = %v, %v, %v, expected %v", options.VolumeName, free, valid, err, expectedFree)
+		}
+
+		if entries, err := volume.ReadDir(volume.Root()); len(entries) != 0 || err != nil {
+			t.Errorf("%v: ReadDir() = %v, %v", options.VolumeName, entries, err)
+		}
+	}
+}
+
+func TestFormatErrors(t *testing.T) {
+	tests := []struct {
+		size     int64
+		name     string
+		expected error
+	}{
+		{shared.FLOPPY_ADF_SIZE, "Work:", ErrBadVolumeName},
+		{shared.FLOPPY_ADF_SIZE, "Work/1", ErrBadVolumeName},
+		{shared.FLOPPY_ADF_SIZE, "0123456789012345678901234567890", ErrBadVolumeName},
+		{shared.AMIGAFS_BLOCK_SIZE * 4, "Work", ErrBadVolumeSize},
+	}
+
+	for _, test := range tests {
+		_, err := NewImage(test.size, FormatOptions{VolumeName: test.name})
+
+		if !errors.Is(err, test.expected) {
+			t.Errorf("NewImage(%v, %q) = %v, expected %v", test.size, test.name, err, test.expected)
+		}
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	if _, err := Open(bytes.NewReader(make([]byte, shared.FLOPPY_ADF_SIZE)), shared.FLOPPY_ADF_SIZE); !errors.Is(err, ErrNotDOS) {
+		t.Errorf("Open() of NDOS disk = %v, expected %v", err, ErrNotDOS)
+	}
+
+	image, err := NewImage(shared.FLOPPY_ADF_SIZE, FormatOptions{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// corrupted root block
+	image[shared.FLOPPY_ADF_SIZE/2+shared.AMIGAFS_NAME_OFFSET] ^= 0xff
+
+	if _, err := Open(bytes.NewReader(image), shared.FLOPPY_ADF_SIZE); !errors.Is(err, ErrBadRootBlock) {
+		t.Errorf("Open() of corrupted disk = %v, expected %v", err, ErrBadRootBlock)
+	}
+}
+
+func TestVolume(t *testing.T) {
+	startupSequence := []byte("C:SetPatch QUIET\nC:Version >NIL:\n")
+	// needs the extension block for both OFS and FFS
+	bigData := newTestData(shared.AMIGAFS_DATA_BLOCKS_PER_HEADER*shared.AMIGAFS_BLOCK_SIZE + 1000)
+
+	for _, options := range testFormatOptions {
+		ti := newTestImage(t, shared.FLOPPY_ADF_SIZE, options)
+		root := ti.volume.rootBlock
+
+		s := ti.addDir(root, "S")
+		startup := ti.addFile(s, "Startup-Sequence", startupSequence)
+		ti.addFile(root, "Big", bigData)
+		ti.addFile(root, "Empty", nil)
+		ti.addFile(root, "Café", []byte("coffee"))
+		ti.addHardLink(root, "Startup", startup, shared.AMIGAFS_ST_LINKFILE)
+		ti.addHardLink(root, "Scripts", s, shared.AMIGAFS_ST_LINKDIR)
+		ti.addSoftLink(root, "Soft", "Work:S")
+
+		volume := ti.open()
+
+		if volume.Name() != options.VolumeName {
+			t.Errorf("%v: Name() = %q", options.VolumeName, volume.Name())
+		}
+
+		// directory listing
+		entries, err := volume.ReadDir(volume.Root())
+
+		if err != nil {
+			t.Fatalf("%v: ReadDir(): %v", options.VolumeName, err)
+		}
+
+		names := make([]string, 0, len(entries))
+
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+
+		expectedNames := []string{"Big", "Café", "Empty", "S", "Scripts", "Soft", "Startup"}
+
+		if !reflect.DeepEqual(names, expectedNames) {
+			t.Errorf("%v: ReadDir() = %v, expected %v", options.VolumeName, names, expectedNames)
+		}
+
+		// lookup is case-insensitive, INTL variants
+		// fold the accented characters too
+		lookupTests := []struct {
+			pathname string
+			path     string
+			dir      bool
+			size     uint32
+			err      error
+		}{
+			{"S/Startup-Sequence", "S/Startup-Sequence", false, uint32(len(startupSequence)), nil},
+			{"s/startup-sequence", "S/Startup-Sequence", false, uint32(len(startupSequence)), nil},
+			{"/S/", "S", true, 0, nil},
+			{"Startup", "Startup", false, uint32(len(startupSequence)), nil},
+			{"Scripts/Startup-Sequence", "Scripts/Startup-Sequence", false, uint32(len(startupSequence)), nil},
+			{"Big", "Big", false, uint32(len(bigData)), nil},
+			{"café", "Café", false, 6, nil},
+			{"Missing", "", false, 0, ErrNotFound},
+			{"Big/Missing", "", false, 0, ErrNotDirectory},
+		}
+
+		if volume.IsInternational() {
+			lookupTests = append(lookupTests, struct {
+				pathname string
+				path     string
+				dir      bool
+				size     uint32
+				err      error
+			}{"CAFÉ", "Café", false, 6, nil})
+		} else {
+			lookupTests = append(lookupTests, struct {
+				pathname string
+				path     string
+				dir      bool
+				size     uint32
+				err      error
+			}{"CAFÉ", "", false, 0, ErrNotFound})
+		}
+
+		for _, test := range lookupTests {
+			entry, err := volume.Lookup(test.pathname)
+
+			if !errors.Is(err, test.err) {
+				t.Errorf("%v: Lookup(%q) = %v, expected %v", options.VolumeName, test.pathname, err, test.err)
+				continue
+			}
+
+			if err != nil {
+				continue
+			}
+
+			if entry.Path() != test.path || entry.IsDir() != test.dir || entry.Size() != test.size {
+				t.Errorf("%v: Lookup(%q) = %v %v %v", options.VolumeName, test.pathname, entry.Path(), entry.IsDir(), entry.Size())
+			}
+		}
+
+		// reading the files
+		big, err := volume.Lookup("Big")
+
+		if err != nil {
+			t.Fatalf("%v: %v", options.VolumeName, err)
+		}
+
+		if data, err := volume.ReadFile(big); err != nil || !bytes.Equal(data, bigData) {
+			t.Errorf("%v: ReadFile(Big) = %v bytes, %v", options.VolumeName, len(data), err)
+		}
+
+		buff := make([]byte, 1500)
+		offset := int64(len(bigData) - 1000)
+
+		if n, err := volume.ReadFileAt(big, buff, offset); n != 1000 || err != io.EOF || !bytes.Equal(buff[:n], bigData[offset:]) {
+			t.Errorf("%v: ReadFileAt(Big, %v) = %v, %v", options.VolumeName, offset, n, err)
+		}
+
+		if n, err := volume.ReadFileAt(big, buff, 10); n != len(buff) || err != nil || !bytes.Equal(buff, bigData[10:10+len(buff)]) {
+			t.Errorf("%v: ReadFileAt(Big, 10) = %v, %v", options.VolumeName, n, err)
+		}
+
+		if n, err := volume.ReadFileAt(big, buff, int64(len(bigData))); n != 0 || err != io.EOF {
+			t.Errorf("%v: ReadFileAt(Big) at the end = %v, %v", options.VolumeName, n, err)
+		}
+
+		// open file reads the data blocks once
+		if openFile, err := volume.OpenFile(big); err != nil {
+			t.Errorf("%v: OpenFile(Big) = %v", options.VolumeName, err)
+		} else {
+			data := make([]byte, len(bigData))
+
+			for offset := 0; offset < len(data); offset += len(buff) {
+				end := offset + len(buff)
+
+				if end > len(data) {
+					end = len(data)
+				}
+
+				if n, err := openFile.ReadAt(data[offset:end], int64(offset)); n != end-offset || err != nil {
+					t.Errorf("%v: ReadAt(%v) = %v, %v", options.VolumeName, offset, n, err)
+				}
+			}
+
+			if openFile.Entry() != big || !bytes.Equal(data, bigData) {
+				t.Errorf("%v: ReadAt() returned wrong data", options.VolumeName)
+			}
+		}
+
+		if _, err := volume.OpenFile(volume.Root()); !errors.Is(err, ErrNotFile) {
+			t.Errorf("%v: OpenFile(root) = %v, expected %v", options.VolumeName, err, ErrNotFile)
+		}
+
+		if empty, err := volume.Lookup("Empty"); err != nil {
+			t.Errorf("%v: %v", options.VolumeName, err)
+		} else if data, err := volume.ReadFile(empty); err != nil || len(data) != 0 {
+			t.Errorf("%v: ReadFile(Empty) = %v, %v", options.VolumeName, data, err)
+		}
+
+		if startup, err := volume.Lookup("Startup"); err != nil {
+			t.Errorf("%v: %v", options.VolumeName, err)
+		} else if data, err := volume.ReadFile(startup); err != nil || !bytes.Equal(data, startupSequence) {
+			t.Errorf("%v: ReadFile(Startup) = %q, %v", options.VolumeName, data, err)
+		}
+
+		if _, err := volume.ReadFile(volume.Root()); !errors.Is(err, ErrNotFile) {
+			t.Errorf("%v: ReadFile(root) = %v, expected %v", options.VolumeName, err, ErrNotFile)
+		}
+
+		// soft link
+		if soft, err := volume.Lookup("Soft"); err != nil || !soft.IsSoftLink() {
+			t.Errorf("%v: Lookup(Soft) = %v", options.VolumeName, err)
+		} else if target, err := volume.ReadSoftLink(soft); err != nil || target != "Work:S" {
+			t.Errorf("%v: ReadSoftLink(Soft) = %q, %v", options.VolumeName, target, err)
+		}
+
+		// walk
+		paths := make([]string, 0)
+
+		err = volume.Walk(func(entry *Entry) error {
+			paths = append(paths, entry.Path())
+
+			return nil
+		})
+
+		expectedPaths := []string{
+			"Big",
+			"Café",
+			"Empty",
+			"S",
+			"S/Startup-Sequence",
+			"Scripts",
+			"Scripts/Startup-Sequence",
+			"Soft",
+			"Startup"}
+
+		if err != nil || !reflect.DeepEqual(paths, expectedPaths) {
+			t.Errorf("%v: Walk() = %v, %v", options.VolumeName, paths, err)
+		}
+
+		// every added block is marked as used
+		expectedFree := volume.Blocks() - shared.AMIGAFS_BOOT_BLOCKS - 2 - int64(ti.next-shared.AMIGAFS_BOOT_BLOCKS)
+
+		if options.DirCache {
+			expectedFree--
+		}
+
+		if free, valid, err := volume.FreeBlocks(); free != expectedFree || !valid || err != nil {
+			t.Errorf("%v: FreeBlocks() = %v, %v, %v, expected %v", options.VolumeName, free, valid, err, expectedFree)
+		}
+	}
+}
+
+func TestVolumeCorrupted(t *testing.T) {
+	for _, options := range testFormatOptions {
+		ti := newTestImage(t, shared.FLOPPY_ADF_SIZE, options)
+		root := ti.volume.rootBlock
+		file := ti.addFile(root, "File", newTestData(2000))
+		loop := ti.addFile(root, "Loop", nil)
+
+		// size larger than the volume, it must not be allocated
+		putLong(ti.block(file), shared.AMIGAFS_BYTE_SIZE_OFFSET, 0xfffffff0)
+		ti.updateChecksum(file)
+
+		// hash chain pointing to itself
+		putLong(ti.block(loop), shared.AMIGAFS_NEXT_SAME_HASH_OFFSET, loop)
+		ti.updateChecksum(loop)
+
+		volume := ti.open()
+		entry, err := volume.Lookup("File")
+
+		if err != nil {
+			t.Fatalf("%v: %v", options.VolumeName, err)
+		}
+
+		if _, err := volume.ReadFile(entry); !errors.Is(err, ErrBadBlock) {
+			t.Errorf("%v: ReadFile() = %v, expected %v", options.VolumeName, err, ErrBadBlock)
+		}
+
+		if _, err := volume.ReadFileAt(entry, make([]byte, 10), 0); !errors.Is(err, ErrBadBlock) {
+			t.Errorf("%v: ReadFileAt() = %v, expected %v", options.VolumeName, err, ErrBadBlock)
+		}
+
+		if _, err := volume.ReadDir(volume.Root()); !errors.Is(err, ErrBadBlock) {
+			t.Errorf("%v: ReadDir() = %v, expected %v", options.VolumeName, err, ErrBadBlock)
+		}
+
+		// bad checksum
+		ti.block(file)[shared.AMIGAFS_NAME_OFFSET+1] ^= 0xff
+
+		if _, err := volume.Lookup("File"); !errors.Is(err, ErrBadBlock) {
+			t.Errorf("%v: Lookup() = %v, expected %v", options.VolumeName, err, ErrBadBlock)
+		}
+	}
+}
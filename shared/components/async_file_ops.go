@@ -1,9 +1,11 @@
 package components
 
 import (
+	"errors"
 	"io"
 	"io/fs"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/ncw/directio"
@@ -13,78 +15,264 @@ import (
 	"golang.org/x/exp/slices"
 )
 
+var ErrAsyncFileOpCancelled = errors.New("file operation cancelled")
+
+// asyncFileWrite is a single FileWriteBytes call, coalesced
+// writes are executed once, but callback of every
+// write is called
+type asyncFileWrite struct {
+	buff     []byte
+	callback interfaces.FileWriteBytesCallback
+}
+
+type asyncFileOp struct {
+	seq              uint64
+	_type            string
+	priority         int
+	name             string
+	offset           int64
+	size             int64
+	flag             int
+	perm             fs.FileMode
+	useHandle        *os.File
+	writes           []asyncFileWrite
+	readCallback     interfaces.FileReadBytesDirectCallback
+	prefetchCallback interfaces.FilePrefetchCallback
+}
+
+func (op *asyncFileOp) getBuff() []byte {
+	return op.writes[len(op.writes)-1].buff
+}
+
+func (op *asyncFileOp) overlaps(name string, offset, size int64) bool {
+	if op.name != name {
+		return false
+	}
+
+	opSize := op.size
+
+	if op._type == shared.ASYNC_FILE_OP_WRITE {
+		opSize = int64(len(op.getBuff()))
+	}
+
+	return offset < op.offset+opSize && op.offset < offset+size
+}
+
+// asyncFileOpsFlush is a Flush call waiting
+// for the operations queued before it
+type asyncFileOpsFlush struct {
+	pending map[uint64]bool
+	done    chan struct{}
+}
+
+// AsyncFileOps executes file operations in the background, by priority
+// (shared.ASYNC_FILE_OP_PRIORITY_*), operations with the same priority
+// are executed in order, repeated writes of the same range are coalesced,
+// callers are blocked when too many operations are queued
 type AsyncFileOps struct {
 	RunnerBase
-	operations             []map[string]any          // TODO convert to channel
-	oneTimeFinalOperations map[string]map[string]any // TODO convert to channel
+	queues                 [shared.ASYNC_FILE_OP_PRIORITIES][]*asyncFileOp
+	oneTimeFinalOperations map[string]*asyncFileOp
+	flushes                []*asyncFileOpsFlush
+	seq                    uint64
+	queued                 int
+	wakeup                 chan struct{}
+	notFull                *sync.Cond
+	mutex                  sync.Mutex
+	initOnce               sync.Once
+}
+
+func (afo *AsyncFileOps) init() {
+	afo.initOnce.Do(func() {
+		afo.wakeup = make(chan struct{}, 1)
+		afo.notFull = sync.NewCond(&afo.mutex)
+		afo.oneTimeFinalOperations = make(map[string]*asyncFileOp)
+	})
 }
 
 func (afo *AsyncFileOps) loop() {
-	for afo.running {
-		time.Sleep(time.Millisecond * 10)
+	handles := make(map[string]*os.File)
+
+	for afo.isRunning() {
+		op := afo.next()
+
+		if op == nil {
+			// queue is empty, do not keep
+			// the handles open
+			afo.closeHandles(handles)
+			afo.wait()
+
+			continue
+		}
 
-		afo.execute()
-		afo.executeOneTimeFinal()
+		if op._type == shared.ASYNC_FILE_OP_PREFETCH {
+			if !op.prefetchCallback(op.name, op.offset, op.size) {
+				// postponed, retry later
+				afo.wait()
+
+				continue
+			}
+		} else {
+			afo.executeOperation(op, handles)
+		}
+
+		afo.complete(op)
 	}
 
-	afo.running = false
+	afo.closeHandles(handles)
 }
 
-func (afo *AsyncFileOps) execute() {
-	handles := make(map[string]*os.File)
+// isRunning returns the running flag, it is
+// changed by Stop while the loop is running
+func (afo *AsyncFileOps) isRunning() bool {
+	afo.mutex.Lock()
+	defer afo.mutex.Unlock()
 
-	for len(afo.operations) > 0 {
-		ioperation := afo.operations[0]
-		afo.operations = slices.Delete(afo.operations, 0, 0+1)
+	return afo.running
+}
 
-		afo.executeOperation(ioperation, handles)
+func (afo *AsyncFileOps) wait() {
+	select {
+	case <-afo.wakeup:
+	case <-time.After(time.Millisecond * shared.ASYNC_FILE_OPS_IDLE_MS):
 	}
+}
 
-	for name := range handles {
-		ihandle := handles[name]
+func (afo *AsyncFileOps) notify() {
+	select {
+	case afo.wakeup <- struct{}{}:
+	default:
+		// already notified
+	}
+}
 
-		ihandle.Close()
+func (afo *AsyncFileOps) closeHandles(handles map[string]*os.File) {
+	for name, handle := range handles {
+		handle.Close()
 
 		delete(handles, name)
 	}
 }
 
-func (afo *AsyncFileOps) executeOneTimeFinal() {
-	if len(afo.operations) > 0 {
-		return
+// next returns the first operation with the highest priority, one
+// time final operations are executed when there are no other
+// writes, prefetch operations stay queued until completed
+func (afo *AsyncFileOps) next() *asyncFileOp {
+	afo.mutex.Lock()
+	defer afo.mutex.Unlock()
+
+	for priority := range afo.queues {
+		if priority == shared.ASYNC_FILE_OP_PRIORITY_LOW {
+			for name, op := range afo.oneTimeFinalOperations {
+				delete(afo.oneTimeFinalOperations, name)
+
+				return op
+			}
+		}
+
+		queue := afo.queues[priority]
+
+		if len(queue) == 0 {
+			continue
+		}
+
+		op := queue[0]
+
+		if op._type != shared.ASYNC_FILE_OP_PREFETCH {
+			afo.queues[priority] = slices.Delete(queue, 0, 0+1)
+		}
+
+		return op
 	}
 
-	handles := make(map[string]*os.File)
+	return nil
+}
+
+// complete removes finished operation from
+// the queue and notifies Flush callers
+func (afo *AsyncFileOps) complete(op *asyncFileOp) {
+	afo.mutex.Lock()
+	defer afo.mutex.Unlock()
 
-	for len(afo.oneTimeFinalOperations) > 0 {
-		if len(afo.operations) > 0 {
-			break
+	if op._type == shared.ASYNC_FILE_OP_PREFETCH {
+		queue := afo.queues[op.priority]
+
+		if len(queue) > 0 && queue[0] == op {
+			afo.queues[op.priority] = slices.Delete(queue, 0, 0+1)
 		}
 
-		for name := range afo.oneTimeFinalOperations {
-			if len(afo.operations) > 0 {
-				break
-			}
+		return
+	}
 
-			ioperation, exists := afo.oneTimeFinalOperations[name]
+	// one time final operations are not
+	// limited by ASYNC_FILE_OPS_MAX_QUEUED
+	if op.priority != shared.ASYNC_FILE_OP_PRIORITY_LOW {
+		afo.queued--
+		afo.notFull.Broadcast()
+	}
 
-			if !exists {
-				continue
-			}
+	flushes := afo.flushes[:0]
 
-			delete(afo.oneTimeFinalOperations, name)
+	for _, flush := range afo.flushes {
+		delete(flush.pending, op.seq)
 
-			afo.executeOperation(ioperation, handles)
+		if len(flush.pending) == 0 {
+			close(flush.done)
+		} else {
+			flushes = append(flushes, flush)
 		}
 	}
 
-	for name := range handles {
-		ihandle := handles[name]
+	afo.flushes = flushes
+}
 
-		ihandle.Close()
+// enqueue adds the operation, must be called with the mutex locked,
+// blocks while the queue is full (backpressure)
+func (afo *AsyncFileOps) enqueue(op *asyncFileOp) {
+	if op._type != shared.ASYNC_FILE_OP_PREFETCH {
+		for afo.running && afo.queued >= shared.ASYNC_FILE_OPS_MAX_QUEUED {
+			afo.notFull.Wait()
+		}
 
-		delete(handles, name)
+		afo.queued++
 	}
+
+	afo.seq++
+	op.seq = afo.seq
+
+	afo.queues[op.priority] = append(afo.queues[op.priority], op)
+
+	afo.notify()
+}
+
+// coalesce merges write with the last queued write of exactly
+// the same range, only if there is no other write overlapping
+// the range queued after it (to keep the order of writes)
+func (afo *AsyncFileOps) coalesce(name string, offset int64, flag int, write asyncFileWrite) bool {
+	queue := afo.queues[shared.ASYNC_FILE_OP_PRIORITY_NORMAL]
+	size := int64(len(write.buff))
+
+	for i := len(queue) - 1; i >= 0; i-- {
+		op := queue[i]
+
+		if !op.overlaps(name, offset, size) {
+			continue
+		}
+
+		if op._type != shared.ASYNC_FILE_OP_WRITE ||
+			op.offset != offset ||
+			op.flag != flag ||
+			op.useHandle != nil ||
+			int64(len(op.getBuff())) != size {
+			return false
+		}
+
+		op.writes = append(op.writes, write)
+
+		return true
+	}
+
+	return false
 }
 
 func (afo *AsyncFileOps) openDirectIOHandle(
@@ -134,38 +322,35 @@ func (afo *AsyncFileOps) openHandle(
 }
 
 func (afo *AsyncFileOps) executeDirectReadOperation(
-	ioperation map[string]any,
+	op *asyncFileOp,
 	handles map[string]*os.File,
 ) {
 	var err error
 	var n int
 
-	name := ioperation["name"].(string)
-	offset := ioperation["offset"].(int64)
-	flag := ioperation["flag"].(int)
-	perm := ioperation["perm"].(fs.FileMode)
-	useHandle := ioperation["useHandle"].(*os.File)
-	callback := ioperation["callback"].(interfaces.FileReadBytesDirectCallback)
+	useHandle := op.useHandle
+	flag := op.flag
+	callback := op.readCallback
 
 	if flag == 0 {
 		flag = os.O_RDWR
 	}
 
 	if useHandle == nil {
-		useHandle, err = afo.openDirectIOHandle(name, flag, perm, handles)
+		useHandle, err = afo.openDirectIOHandle(op.name, flag, op.perm, handles)
 
 		if err != nil {
 			if callback != nil {
-				callback(name, nil, n, offset, useHandle, err)
+				callback(op.name, nil, n, op.offset, useHandle, err)
 			}
 
 			return
 		}
 	}
 
-	if _, err = useHandle.Seek(offset, io.SeekStart); err != nil {
+	if _, err = useHandle.Seek(op.offset, io.SeekStart); err != nil {
 		if callback != nil {
-			callback(name, nil, n, offset, useHandle, err)
+			callback(op.name, nil, n, op.offset, useHandle, err)
 		}
 
 		return
@@ -177,73 +362,70 @@ func (afo *AsyncFileOps) executeDirectReadOperation(
 
 	if err != nil {
 		if callback != nil {
-			callback(name, block, n, offset, useHandle, err)
+			callback(op.name, block, n, op.offset, useHandle, err)
 		}
 
 		return
 	}
 
 	if callback != nil {
-		callback(name, block, n, offset, useHandle, nil)
+		callback(op.name, block, n, op.offset, useHandle, nil)
 	}
 }
 
 func (afo *AsyncFileOps) executeWriteOperation(
-	ioperation map[string]any,
+	op *asyncFileOp,
 	handles map[string]*os.File,
 ) {
 	var err error
 	var n int
 
-	name := ioperation["name"].(string)
-	offset := ioperation["offset"].(int64)
-	buff := ioperation["buff"].([]byte)
-	flag := ioperation["flag"].(int)
-	perm := ioperation["perm"].(fs.FileMode)
-	useHandle := ioperation["useHandle"].(*os.File)
-	callback := ioperation["callback"].(interfaces.FileWriteBytesCallback)
+	useHandle := op.useHandle
+	flag := op.flag
+	buff := op.getBuff()
 
 	if flag == 0 {
 		flag = os.O_RDWR
 	}
 
 	if useHandle == nil {
-		useHandle, err = afo.openHandle(name, flag, perm, handles)
-
-		if err != nil {
-			if callback != nil {
-				callback(name, offset, buff, flag, perm, useHandle, 0, err)
-			}
-
-			return
-		}
+		useHandle, err = afo.openHandle(op.name, flag, op.perm, handles)
 	}
 
-	n, err = utils.FileUtilsInstance.FileWriteBytes(
-		name,
-		offset,
-		buff,
-		flag,
-		perm,
-		useHandle,
-	)
+	if err == nil {
+		n, err = utils.FileUtilsInstance.FileWriteBytes(
+			op.name,
+			op.offset,
+			buff,
+			flag,
+			op.perm,
+			useHandle,
+		)
+	}
 
-	if callback != nil {
-		callback(name, offset, buff, flag, perm, useHandle, n, err)
+	// the last buffer was written, it contains
+	// data of all the coalesced writes
+	for _, write := range op.writes {
+		if write.callback != nil {
+			write.callback(op.name, op.offset, write.buff, flag, op.perm, useHandle, n, err)
+		}
 	}
 }
 
 func (afo *AsyncFileOps) executeOperation(
-	ioperation map[string]any,
+	op *asyncFileOp,
 	handles map[string]*os.File,
 ) {
-	if ioperation["type"] == shared.ASYNC_FILE_OP_DIRECT_READ {
-		afo.executeDirectReadOperation(ioperation, handles)
-	} else if ioperation["type"] == shared.ASYNC_FILE_OP_WRITE {
-		afo.executeWriteOperation(ioperation, handles)
+	if op._type == shared.ASYNC_FILE_OP_DIRECT_READ {
+		afo.executeDirectReadOperation(op, handles)
+	} else if op._type == shared.ASYNC_FILE_OP_WRITE {
+		afo.executeWriteOperation(op, handles)
 	}
 }
 
+// FileReadBytesDirect queues direct-io read of a single block,
+// it is used to move the motor of the drive while the emulator
+// is reading, so it has the highest priority
 func (afo *AsyncFileOps) FileReadBytesDirect(
 	name string,
 	offset int64,
@@ -252,25 +434,28 @@ func (afo *AsyncFileOps) FileReadBytesDirect(
 	useHandle *os.File,
 	max int,
 	callback interfaces.FileReadBytesDirectCallback) {
+	afo.init()
+
+	afo.mutex.Lock()
+	defer afo.mutex.Unlock()
+
 	if max > 0 {
-		count := afo.getCountOpsForName(name, afo.operations)
+		count := afo.getCountOpsForName(name, afo.queues[shared.ASYNC_FILE_OP_PRIORITY_HIGH])
 
 		if count >= max {
 			return
 		}
 	}
 
-	op := make(map[string]any)
-
-	op["name"] = name
-	op["offset"] = offset
-	op["flag"] = flag
-	op["perm"] = perm
-	op["useHandle"] = useHandle
-	op["type"] = shared.ASYNC_FILE_OP_DIRECT_READ
-	op["callback"] = callback
-
-	afo.operations = append(afo.operations, op)
+	afo.enqueue(&asyncFileOp{
+		_type:        shared.ASYNC_FILE_OP_DIRECT_READ,
+		priority:     shared.ASYNC_FILE_OP_PRIORITY_HIGH,
+		name:         name,
+		offset:       offset,
+		flag:         flag,
+		perm:         perm,
+		useHandle:    useHandle,
+		readCallback: callback})
 }
 
 func (afo *AsyncFileOps) FileWriteBytes(
@@ -282,33 +467,44 @@ func (afo *AsyncFileOps) FileWriteBytes(
 	useHandle *os.File,
 	max int,
 	callback interfaces.FileWriteBytesCallback) {
-	if max > 0 {
-		count := afo.getCountOpsForName(name, afo.operations)
-
-		if count >= max {
-			return
-		}
-	}
+	afo.init()
 
 	// make a copy of the buffer to
 	// avoid race condition issues
 	buffCopy := make([]byte, len(buff))
 	copy(buffCopy, buff)
 
-	op := make(map[string]any)
+	write := asyncFileWrite{buff: buffCopy, callback: callback}
+
+	afo.mutex.Lock()
+	defer afo.mutex.Unlock()
+
+	if useHandle == nil && afo.coalesce(name, offset, flag, write) {
+		return
+	}
+
+	if max > 0 {
+		count := afo.getCountOpsForName(name, afo.queues[shared.ASYNC_FILE_OP_PRIORITY_NORMAL])
 
-	op["name"] = name
-	op["offset"] = offset
-	op["buff"] = buffCopy
-	op["flag"] = flag
-	op["perm"] = perm
-	op["useHandle"] = useHandle
-	op["type"] = shared.ASYNC_FILE_OP_WRITE
-	op["callback"] = callback
+		if count >= max {
+			return
+		}
+	}
 
-	afo.operations = append(afo.operations, op)
+	afo.enqueue(&asyncFileOp{
+		_type:     shared.ASYNC_FILE_OP_WRITE,
+		priority:  shared.ASYNC_FILE_OP_PRIORITY_NORMAL,
+		name:      name,
+		offset:    offset,
+		flag:      flag,
+		perm:      perm,
+		useHandle: useHandle,
+		writes:    []asyncFileWrite{write}})
 }
 
+// FileWriteBytesOneTimeFinal queues write which replaces previous
+// one time final write for the same name, it is executed when
+// there are no other writes queued
 func (afo *AsyncFileOps) FileWriteBytesOneTimeFinal(
 	name string,
 	offset int64,
@@ -317,40 +513,244 @@ func (afo *AsyncFileOps) FileWriteBytesOneTimeFinal(
 	perm fs.FileMode,
 	useHandle *os.File,
 	callback interfaces.FileWriteBytesCallback) {
+	afo.init()
+
 	// make a copy of the buffer to
 	// avoid race condition issues
 	buffCopy := make([]byte, len(buff))
 	copy(buffCopy, buff)
 
-	op := make(map[string]any)
+	afo.mutex.Lock()
+	defer afo.mutex.Unlock()
+
+	// replaced operation keeps its sequence
+	// number, so Flush waits for the new one
+	seq := afo.seq + 1
+
+	if previous, exists := afo.oneTimeFinalOperations[name]; exists {
+		seq = previous.seq
+	} else {
+		afo.seq = seq
+	}
+
+	afo.oneTimeFinalOperations[name] = &asyncFileOp{
+		seq:       seq,
+		_type:     shared.ASYNC_FILE_OP_WRITE,
+		priority:  shared.ASYNC_FILE_OP_PRIORITY_LOW,
+		name:      name,
+		offset:    offset,
+		flag:      flag,
+		perm:      perm,
+		useHandle: useHandle,
+		writes:    []asyncFileWrite{{buff: buffCopy, callback: callback}}}
+
+	afo.notify()
+}
+
+// FilePrefetch queues operation with the lowest priority, executed
+// only when there are no other operations, callback performs
+// the read and returns false to postpone the operation
+func (afo *AsyncFileOps) FilePrefetch(
+	name string,
+	offset int64,
+	size int64,
+	callback interfaces.FilePrefetchCallback) {
+	afo.init()
+
+	afo.mutex.Lock()
+	defer afo.mutex.Unlock()
+
+	afo.enqueue(&asyncFileOp{
+		_type:            shared.ASYNC_FILE_OP_PREFETCH,
+		priority:         shared.ASYNC_FILE_OP_PRIORITY_IDLE,
+		name:             name,
+		offset:           offset,
+		size:             size,
+		prefetchCallback: callback})
+}
+
+// CancelPrefetch removes all prefetch operations for name
+func (afo *AsyncFileOps) CancelPrefetch(name string) {
+	afo.init()
+
+	afo.mutex.Lock()
+	defer afo.mutex.Unlock()
+
+	queue := afo.queues[shared.ASYNC_FILE_OP_PRIORITY_IDLE]
+	operations := make([]*asyncFileOp, 0, len(queue))
+
+	for i, op := range queue {
+		// the first one can be executed right now,
+		// it will be removed by complete
+		if i == 0 || op.name != name {
+			operations = append(operations, op)
+		}
+	}
+
+	afo.queues[shared.ASYNC_FILE_OP_PRIORITY_IDLE] = operations
+}
+
+// CancelWrites removes queued writes for name (the one being
+// executed is not cancelled), their callbacks are called
+// with ErrAsyncFileOpCancelled, returns number of
+// the cancelled writes
+func (afo *AsyncFileOps) CancelWrites(name string) int {
+	afo.init()
+
+	afo.mutex.Lock()
+
+	cancelled := make([]*asyncFileOp, 0)
+
+	if op, exists := afo.oneTimeFinalOperations[name]; exists {
+		delete(afo.oneTimeFinalOperations, name)
+
+		cancelled = append(cancelled, op)
+	}
+
+	queue := afo.queues[shared.ASYNC_FILE_OP_PRIORITY_NORMAL]
+	operations := make([]*asyncFileOp, 0, len(queue))
+
+	for _, op := range queue {
+		if op._type == shared.ASYNC_FILE_OP_WRITE && op.name == name {
+			cancelled = append(cancelled, op)
+		} else {
+			operations = append(operations, op)
+		}
+	}
+
+	afo.queues[shared.ASYNC_FILE_OP_PRIORITY_NORMAL] = operations
+
+	afo.mutex.Unlock()
+
+	for _, op := range cancelled {
+		// Flush callers do not wait for them anymore
+		afo.complete(op)
+
+		for _, write := range op.writes {
+			if write.callback != nil {
+				write.callback(op.name, op.offset, write.buff, op.flag, op.perm, op.useHandle, 0, ErrAsyncFileOpCancelled)
+			}
+		}
+	}
+
+	return len(cancelled)
+}
+
+func (afo *AsyncFileOps) GetPrefetchCount(name string) int {
+	afo.init()
 
-	op["name"] = name
-	op["offset"] = offset
-	op["buff"] = buffCopy
-	op["flag"] = flag
-	op["perm"] = perm
-	op["useHandle"] = useHandle
-	op["type"] = shared.ASYNC_FILE_OP_WRITE
-	op["callback"] = callback
+	afo.mutex.Lock()
+	defer afo.mutex.Unlock()
 
-	afo.oneTimeFinalOperations[name] = op
+	return afo.getCountOpsForName(name, afo.queues[shared.ASYNC_FILE_OP_PRIORITY_IDLE])
+}
+
+// GetQueuedCount returns number of queued operations for name
+// (or all of them for empty name), without prefetch operations
+func (afo *AsyncFileOps) GetQueuedCount(name string) int {
+	afo.init()
+
+	afo.mutex.Lock()
+	defer afo.mutex.Unlock()
+
+	count := 0
+
+	for priority := range afo.queues {
+		if priority != shared.ASYNC_FILE_OP_PRIORITY_IDLE {
+			count += afo.getCountOpsForName(name, afo.queues[priority])
+		}
+	}
+
+	for _, op := range afo.oneTimeFinalOperations {
+		if name == "" || op.name == name {
+			count++
+		}
+	}
+
+	return count
+}
+
+// Flush returns channel closed when all the operations for name
+// (or all of them for empty name) queued before the call are
+// executed, prefetch operations are not waited for
+func (afo *AsyncFileOps) Flush(name string) <-chan struct{} {
+	afo.init()
+
+	afo.mutex.Lock()
+	defer afo.mutex.Unlock()
+
+	flush := asyncFileOpsFlush{
+		pending: make(map[uint64]bool),
+		done:    make(chan struct{})}
+
+	for priority := range afo.queues {
+		if priority == shared.ASYNC_FILE_OP_PRIORITY_IDLE {
+			continue
+		}
+
+		for _, op := range afo.queues[priority] {
+			if name == "" || op.name == name {
+				flush.pending[op.seq] = true
+			}
+		}
+	}
+
+	for _, op := range afo.oneTimeFinalOperations {
+		if name == "" || op.name == name {
+			flush.pending[op.seq] = true
+		}
+	}
+
+	if len(flush.pending) == 0 {
+		close(flush.done)
+	} else {
+		afo.flushes = append(afo.flushes, &flush)
+	}
+
+	return flush.done
+}
+
+// FlushTimeout waits for Flush, returns false on timeout
+func (afo *AsyncFileOps) FlushTimeout(name string, timeout time.Duration) bool {
+	select {
+	case <-afo.Flush(name):
+		return true
+	case <-time.After(timeout):
+		return false
+	}
 }
 
 func (afo *AsyncFileOps) getCountOpsForName(
 	name string,
-	sliceToCheck []map[string]any,
+	sliceToCheck []*asyncFileOp,
 ) int {
 	count := 0
 
-	for _, ioperation := range sliceToCheck {
-		if ioperation["name"].(string) == name {
+	for _, op := range sliceToCheck {
+		if name == "" || op.name == name {
 			count++
 		}
 	}
 	return count
 }
 
+func (afo *AsyncFileOps) Stop(_runner interfaces.Runner) error {
+	afo.init()
+
+	afo.mutex.Lock()
+
+	err := afo.RunnerBase.Stop(_runner)
+
+	// wake up blocked callers and the loop
+	afo.notFull.Broadcast()
+	afo.mutex.Unlock()
+
+	afo.notify()
+
+	return err
+}
+
 func (afo *AsyncFileOps) Run() {
-	afo.oneTimeFinalOperations = make(map[string]map[string]any)
+	afo.init()
 	afo.loop()
 }
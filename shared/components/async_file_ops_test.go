@@ -0,0 +1,468 @@
+package components
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skazanyNaGlany/go.amipi400/shared"
+)
+
+const testAsyncFileOpsTimeout = time.Second * 10
+
+// asyncFileOpsRecorder records the callbacks, they
+// are called from the goroutine of the loop
+type asyncFileOpsRecorder struct {
+	events []string
+	errs   []error
+	mutex  sync.Mutex
+}
+
+func (r *asyncFileOpsRecorder) add(event string, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.events = append(r.events, event)
+	r.errs = append(r.errs, err)
+}
+
+func (r *asyncFileOpsRecorder) get() ([]string, []error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]string{}, r.events...), append([]error{}, r.errs...)
+}
+
+func (r *asyncFileOpsRecorder) write(event string) func(string, int64, []byte, int, fs.FileMode, *os.File, int, error) {
+	return func(name string, offset int64, buff []byte, flag int, perm fs.FileMode, useHandle *os.File, n int, err error) {
+		if err == nil && n != len(buff) {
+			err = fmt.Errorf("short write %v of %v", n, len(buff))
+		}
+
+		r.add(event, err)
+	}
+}
+
+func (r *asyncFileOpsRecorder) prefetch(event string) func(string, int64, int64) bool {
+	return func(name string, offset int64, size int64) bool {
+		r.add(event, nil)
+
+		return true
+	}
+}
+
+func newTestAsyncFileOpsFile(t *testing.T, size int) string {
+	pathname := filepath.Join(t.TempDir(), "medium.adf")
+
+	if err := os.WriteFile(pathname, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return pathname
+}
+
+func startTestAsyncFileOps(t *testing.T, afo *AsyncFileOps) {
+	if err := afo.Start(afo); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		afo.Stop(afo)
+	})
+}
+
+func waitTestAsyncFileOpsFlush(t *testing.T, afo *AsyncFileOps, name string) {
+	if !afo.FlushTimeout(name, testAsyncFileOpsTimeout) {
+		t.Fatalf("Flush(%q) timed out", name)
+	}
+}
+
+func TestAsyncFileOpsWriteOrder(t *testing.T) {
+	afo := &AsyncFileOps{}
+	recorder := &asyncFileOpsRecorder{}
+	pathname := newTestAsyncFileOpsFile(t, 16)
+
+	// overlapping writes of different sizes are not coalesced
+	afo.FileWriteBytes(pathname, 0, []byte("AAAAAAAA"), 0, 0, nil, 0, recorder.write("1"))
+	afo.FileWriteBytes(pathname, 4, []byte("BBBB"), 0, 0, nil, 0, recorder.write("2"))
+	afo.FileWriteBytes(pathname, 2, []byte("CC"), 0, 0, nil, 0, recorder.write("3"))
+	afo.FileWriteBytes(pathname, 8, []byte("DDDDDDDD"), 0, 0, nil, 0, recorder.write("4"))
+
+	if count := afo.GetQueuedCount(pathname); count != 4 {
+		t.Errorf("GetQueuedCount() = %v, expected 4", count)
+	}
+
+	startTestAsyncFileOps(t, afo)
+	waitTestAsyncFileOpsFlush(t, afo, pathname)
+
+	events, errs := recorder.get()
+
+	if !reflect.DeepEqual(events, []string{"1", "2", "3", "4"}) {
+		t.Errorf("callbacks called in order %v", events)
+	}
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("callback %v: %v", events[i], err)
+		}
+	}
+
+	data, err := os.ReadFile(pathname)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []byte("AACCBBBBDDDDDDDD"); !bytes.Equal(data, expected) {
+		t.Errorf("file contains %q, expected %q", data, expected)
+	}
+}
+
+func TestAsyncFileOpsCoalesce(t *testing.T) {
+	afo := &AsyncFileOps{}
+	recorder := &asyncFileOpsRecorder{}
+	pathname := newTestAsyncFileOpsFile(t, 8)
+
+	afo.FileWriteBytes(pathname, 0, []byte("AAAA"), 0, 0, nil, 0, recorder.write("1"))
+	afo.FileWriteBytes(pathname, 0, []byte("BBBB"), 0, 0, nil, 0, recorder.write("2"))
+	afo.FileWriteBytes(pathname, 0, []byte("CCCC"), 0, 0, nil, 0, recorder.write("3"))
+
+	if count := afo.GetQueuedCount(pathname); count != 1 {
+		t.Errorf("GetQueuedCount() = %v, expected 1", count)
+	}
+
+	// overlapping write queued after, the next one
+	// cannot be coalesced with the first one
+	afo.FileWriteBytes(pathname, 2, []byte("DD"), 0, 0, nil, 0, recorder.write("4"))
+	afo.FileWriteBytes(pathname, 0, []byte("EEEE"), 0, 0, nil, 0, recorder.write("5"))
+
+	if count := afo.GetQueuedCount(pathname); count != 3 {
+		t.Errorf("GetQueuedCount() = %v, expected 3", count)
+	}
+
+	startTestAsyncFileOps(t, afo)
+	waitTestAsyncFileOpsFlush(t, afo, pathname)
+
+	events, errs := recorder.get()
+
+	if !reflect.DeepEqual(events, []string{"1", "2", "3", "4", "5"}) {
+		t.Errorf("callbacks called in order %v", events)
+	}
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("callback %v: %v", events[i], err)
+		}
+	}
+
+	data, err := os.ReadFile(pathname)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []byte("EEEE\x00\x00\x00\x00"); !bytes.Equal(data, expected) {
+		t.Errorf("file contains %q, expected %q", data, expected)
+	}
+}
+
+func TestAsyncFileOpsPriority(t *testing.T) {
+	afo := &AsyncFileOps{}
+	recorder := &asyncFileOpsRecorder{}
+	pathname := newTestAsyncFileOpsFile(t, 8)
+
+	afo.FilePrefetch(pathname, 0, 4, recorder.prefetch("prefetch"))
+	afo.FileWriteBytesOneTimeFinal(pathname, 0, []byte("AAAA"), 0, 0, nil, recorder.write("final1"))
+	afo.FileWriteBytes(pathname, 4, []byte("BBBB"), 0, 0, nil, 0, recorder.write("write"))
+
+	// replaces the previous one time final write
+	afo.FileWriteBytesOneTimeFinal(pathname, 0, []byte("CCCC"), 0, 0, nil, recorder.write("final2"))
+
+	if count := afo.GetPrefetchCount(pathname); count != 1 {
+		t.Errorf("GetPrefetchCount() = %v, expected 1", count)
+	}
+
+	if count := afo.GetQueuedCount(pathname); count != 2 {
+		t.Errorf("GetQueuedCount() = %v, expected 2", count)
+	}
+
+	startTestAsyncFileOps(t, afo)
+	waitTestAsyncFileOpsFlush(t, afo, pathname)
+
+	deadline := time.Now().Add(testAsyncFileOpsTimeout)
+
+	for afo.GetPrefetchCount(pathname) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("prefetch was not executed")
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	events, _ := recorder.get()
+
+	if !reflect.DeepEqual(events, []string{"write", "final2", "prefetch"}) {
+		t.Errorf("callbacks called in order %v", events)
+	}
+
+	data, err := os.ReadFile(pathname)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []byte("CCCCBBBB"); !bytes.Equal(data, expected) {
+		t.Errorf("file contains %q, expected %q", data, expected)
+	}
+}
+
+func TestAsyncFileOpsFlush(t *testing.T) {
+	afo := &AsyncFileOps{}
+	pathname1 := newTestAsyncFileOpsFile(t, 8)
+	pathname2 := newTestAsyncFileOpsFile(t, 8)
+
+	select {
+	case <-afo.Flush(""):
+	default:
+		t.Error("Flush() of empty queue not closed")
+	}
+
+	afo.FileWriteBytes(pathname1, 0, []byte("AAAA"), 0, 0, nil, 0, nil)
+	afo.FileWriteBytesOneTimeFinal(pathname2, 0, []byte("BBBB"), 0, 0, nil, nil)
+
+	// prefetch operations are not waited for
+	afo.FilePrefetch(pathname1, 0, 4, func(name string, offset, size int64) bool {
+		return false
+	})
+
+	flush1 := afo.Flush(pathname1)
+	flushAll := afo.Flush("")
+
+	select {
+	case <-flush1:
+		t.Error("Flush() closed before the operations were executed")
+	case <-flushAll:
+		t.Error("Flush() closed before the operations were executed")
+	default:
+	}
+
+	startTestAsyncFileOps(t, afo)
+
+	for _, done := range []<-chan struct{}{flush1, flushAll} {
+		select {
+		case <-done:
+		case <-time.After(testAsyncFileOpsTimeout):
+			t.Fatal("Flush() timed out")
+		}
+	}
+
+	if count := afo.GetQueuedCount(""); count != 0 {
+		t.Errorf("GetQueuedCount() = %v, expected 0", count)
+	}
+
+	if count := afo.GetPrefetchCount(pathname1); count != 1 {
+		t.Errorf("GetPrefetchCount() = %v, expected 1", count)
+	}
+}
+
+func TestAsyncFileOpsCancelPrefetch(t *testing.T) {
+	afo := &AsyncFileOps{}
+	postponed := func(name string, offset, size int64) bool {
+		return false
+	}
+
+	afo.FilePrefetch("medium1", 0, 4, postponed)
+	afo.FilePrefetch("medium1", 4, 4, postponed)
+	afo.FilePrefetch("medium2", 0, 4, postponed)
+	afo.FilePrefetch("medium1", 8, 4, postponed)
+
+	afo.CancelPrefetch("medium1")
+
+	// the first one can be executed, it is not removed
+	if count := afo.GetPrefetchCount("medium1"); count != 1 {
+		t.Errorf("GetPrefetchCount(medium1) = %v, expected 1", count)
+	}
+
+	if count := afo.GetPrefetchCount("medium2"); count != 1 {
+		t.Errorf("GetPrefetchCount(medium2) = %v, expected 1", count)
+	}
+}
+
+func TestAsyncFileOpsCancelWrites(t *testing.T) {
+	afo := &AsyncFileOps{}
+	recorder := &asyncFileOpsRecorder{}
+	pathname1 := newTestAsyncFileOpsFile(t, 8)
+	pathname2 := newTestAsyncFileOpsFile(t, 8)
+
+	afo.FileWriteBytes(pathname1, 0, []byte("AAAA"), 0, 0, nil, 0, recorder.write("1"))
+	afo.FileWriteBytes(pathname1, 0, []byte("BBBB"), 0, 0, nil, 0, recorder.write("2"))
+	afo.FileWriteBytes(pathname2, 0, []byte("CCCC"), 0, 0, nil, 0, recorder.write("3"))
+	afo.FileWriteBytesOneTimeFinal(pathname1, 4, []byte("DDDD"), 0, 0, nil, recorder.write("4"))
+
+	flush := afo.Flush(pathname1)
+
+	// coalesced writes are a single operation
+	if cancelled := afo.CancelWrites(pathname1); cancelled != 2 {
+		t.Errorf("CancelWrites() = %v, expected 2", cancelled)
+	}
+
+	select {
+	case <-flush:
+	default:
+		t.Error("Flush() not closed after the writes were cancelled")
+	}
+
+	events, errs := recorder.get()
+
+	if !reflect.DeepEqual(events, []string{"4", "1", "2"}) {
+		t.Errorf("callbacks called in order %v", events)
+	}
+
+	for i, err := range errs {
+		if !errors.Is(err, ErrAsyncFileOpCancelled) {
+			t.Errorf("callback %v: %v, expected %v", events[i], err, ErrAsyncFileOpCancelled)
+		}
+	}
+
+	if count := afo.GetQueuedCount(""); count != 1 {
+		t.Errorf("GetQueuedCount() = %v, expected 1", count)
+	}
+
+	startTestAsyncFileOps(t, afo)
+	waitTestAsyncFileOpsFlush(t, afo, "")
+
+	data, err := os.ReadFile(pathname1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, make([]byte, 8)) {
+		t.Errorf("cancelled writes were executed, file contains %q", data)
+	}
+}
+
+// blockTestAsyncFileOps queues write which blocks the loop until
+// the returned function is called, the write is counted
+// as queued until it is completed
+func blockTestAsyncFileOps(t *testing.T, afo *AsyncFileOps, pathname string) func() {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+
+	afo.FileWriteBytes(pathname, 0, []byte{1}, 0, 0, nil, 0,
+		func(string, int64, []byte, int, fs.FileMode, *os.File, int, error) {
+			close(entered)
+			<-release
+		})
+
+	select {
+	case <-entered:
+	case <-time.After(testAsyncFileOpsTimeout):
+		t.Fatal("blocking write was not executed")
+	}
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			close(release)
+		})
+	}
+}
+
+// fillTestAsyncFileOps queues writes (which cannot be coalesced)
+// until ASYNC_FILE_OPS_MAX_QUEUED operations are queued, the
+// blocking write is one of them
+func fillTestAsyncFileOps(afo *AsyncFileOps, pathname string) {
+	for i := 1; i < shared.ASYNC_FILE_OPS_MAX_QUEUED; i++ {
+		afo.FileWriteBytes(pathname, int64(i), []byte{2}, 0, 0, nil, 0, nil)
+	}
+}
+
+func TestAsyncFileOpsBackpressure(t *testing.T) {
+	afo := &AsyncFileOps{}
+	pathname := newTestAsyncFileOpsFile(t, shared.ASYNC_FILE_OPS_MAX_QUEUED+1)
+
+	startTestAsyncFileOps(t, afo)
+
+	release := blockTestAsyncFileOps(t, afo, pathname)
+	defer release()
+
+	fillTestAsyncFileOps(afo, pathname)
+
+	queued := make(chan struct{})
+
+	go func() {
+		afo.FileWriteBytes(pathname, shared.ASYNC_FILE_OPS_MAX_QUEUED, []byte{3}, 0, 0, nil, 0, nil)
+		close(queued)
+	}()
+
+	select {
+	case <-queued:
+		t.Fatal("FileWriteBytes() not blocked while the queue is full")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	release()
+
+	select {
+	case <-queued:
+	case <-time.After(testAsyncFileOpsTimeout):
+		t.Fatal("FileWriteBytes() still blocked after the queue was drained")
+	}
+
+	waitTestAsyncFileOpsFlush(t, afo, pathname)
+
+	data, err := os.ReadFile(pathname)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data[0] != 1 || data[1] != 2 || data[shared.ASYNC_FILE_OPS_MAX_QUEUED] != 3 {
+		t.Errorf("file contains %v", data)
+	}
+}
+
+func TestAsyncFileOpsStop(t *testing.T) {
+	afo := &AsyncFileOps{}
+	pathname := newTestAsyncFileOpsFile(t, shared.ASYNC_FILE_OPS_MAX_QUEUED+1)
+
+	startTestAsyncFileOps(t, afo)
+
+	release := blockTestAsyncFileOps(t, afo, pathname)
+	defer release()
+
+	fillTestAsyncFileOps(afo, pathname)
+
+	queued := make(chan struct{})
+
+	go func() {
+		afo.FileWriteBytes(pathname, shared.ASYNC_FILE_OPS_MAX_QUEUED, []byte{3}, 0, 0, nil, 0, nil)
+		close(queued)
+	}()
+
+	select {
+	case <-queued:
+		t.Fatal("FileWriteBytes() not blocked while the queue is full")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	// blocked callers are woken up
+	if err := afo.Stop(afo); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-queued:
+	case <-time.After(testAsyncFileOpsTimeout):
+		t.Fatal("FileWriteBytes() still blocked after Stop()")
+	}
+}
@@ -12,10 +12,17 @@ import (
 type PowerLEDControl struct {
 	RunnerBase
 	blinkPowerLedSecs int
+	doNotEject        bool
 }
 
 func (plc *PowerLEDControl) loop() {
 	for plc.running {
+		if plc.doNotEject {
+			plc.blinkDoNotEject()
+
+			continue
+		}
+
 		if plc.blinkPowerLedSecs <= 0 {
 			time.Sleep(time.Millisecond * 10)
 		}
@@ -32,7 +39,7 @@ func (plc *PowerLEDControl) loop() {
 func (plc *PowerLEDControl) blinkPowerLed() {
 	step := 0
 
-	for plc.blinkPowerLedSecs > 0 {
+	for plc.blinkPowerLedSecs > 0 && !plc.doNotEject {
 		if step%2 == 0 {
 			plc.DisablePowerLed()
 		} else {
@@ -46,6 +53,18 @@ func (plc *PowerLEDControl) blinkPowerLed() {
 	}
 }
 
+// blinkDoNotEject blinks fast while the do not
+// eject state is set, then turns the LED on
+func (plc *PowerLEDControl) blinkDoNotEject() {
+	for plc.doNotEject && plc.running {
+		plc.DisablePowerLed()
+		time.Sleep(time.Millisecond * shared.POWER_LED_DO_NOT_EJECT_BLINK_MS)
+
+		plc.EnablePowerLed()
+		time.Sleep(time.Millisecond * shared.POWER_LED_DO_NOT_EJECT_BLINK_MS)
+	}
+}
+
 func (plc *PowerLEDControl) setPowerLedBrightness(brightness int) {
 	brightnessStr := strconv.FormatInt(int64(brightness), 10)
 
@@ -70,6 +89,17 @@ func (plc *PowerLEDControl) BlinkPowerLEDSecs(seconds int) {
 	plc.blinkPowerLedSecs = seconds
 }
 
+// SetDoNotEject sets the distinct, fast blinking pattern
+// (it has precedence over BlinkPowerLEDSecs), used
+// while the writes to the medium are pending
+func (plc *PowerLEDControl) SetDoNotEject(doNotEject bool) {
+	plc.doNotEject = doNotEject
+}
+
+func (plc *PowerLEDControl) IsDoNotEject() bool {
+	return plc.doNotEject
+}
+
 func (plc *PowerLEDControl) Run() {
 	plc.loop()
 }
@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/skazanyNaGlany/go.amipi400/shared"
+)
+
+type AmiPi400Utils struct{}
+
+var AmiPi400UtilsInstance AmiPi400Utils
+
+var ErrLabelNotMatched = errors.New("label does not match")
+var ErrIndexOutOfRange = errors.New("index out of range")
+var ErrNoFreeSlot = errors.New("no free slot")
+
+// MediumLabel is parsed AP4_DF, AP4_DH, AP4_HF or AP4_CD
+// medium label
+type MediumLabel struct {
+	Label string
+	Index int
+	// AutoIndex is true when the label used X as the index
+	// and Index is the first free slot
+	AutoIndex bool
+	// BootPriority is shared.DH_BOOT_PRIORITY_UNSPECIFIED
+	// for schemes without the _N suffix (DF, CD)
+	BootPriority int
+}
+
+// ParseMediumLabel parses label using one of the AP4_MEDIUM_*_RE
+// regexes, empty index means 0, X means the first slot
+// for which isSlotUsed returns false
+func (apu *AmiPi400Utils) ParseMediumLabel(
+	label string,
+	regex *regexp.Regexp,
+	maxIndex int,
+	isSlotUsed func(index int) bool,
+) (MediumLabel, error) {
+	medium := MediumLabel{
+		Label:        label,
+		Index:        shared.DRIVE_INDEX_UNSPECIFIED,
+		BootPriority: shared.DH_BOOT_PRIORITY_UNSPECIFIED}
+
+	matches := RegExInstance.FindNamedMatches(regex, label)
+
+	if len(matches) == 0 {
+		return medium, fmt.Errorf("%w: %v", ErrLabelNotMatched, label)
+	}
+
+	switch index := matches["index"]; index {
+	case "":
+		medium.Index = 0
+	case shared.AP4_MEDIUM_AUTO_INDEX:
+		medium.AutoIndex = true
+
+		for i := 0; i < maxIndex; i++ {
+			if isSlotUsed == nil || !isSlotUsed(i) {
+				medium.Index = i
+				break
+			}
+		}
+
+		if medium.Index == shared.DRIVE_INDEX_UNSPECIFIED {
+			return medium, fmt.Errorf("%w for %v", ErrNoFreeSlot, label)
+		}
+	default:
+		indexInt, err := StringUtilsInstance.StringToInt(index, 10, 16)
+
+		if err != nil {
+			return medium, err
+		}
+
+		medium.Index = indexInt
+	}
+
+	if medium.Index < 0 || medium.Index >= maxIndex {
+		return medium, fmt.Errorf("%w: %v (max %v)", ErrIndexOutOfRange, label, maxIndex-1)
+	}
+
+	if regex.SubexpIndex("boot_priority") == -1 {
+		return medium, nil
+	}
+
+	medium.BootPriority = shared.DH_BOOT_PRIORITY_DEFAULT
+
+	if bootPriority := matches["boot_priority"]; bootPriority != "" {
+		bootPriorityInt, err := StringUtilsInstance.StringToInt(bootPriority, 10, 16)
+
+		if err != nil {
+			return medium, err
+		}
+
+		medium.BootPriority = bootPriorityInt
+	}
+
+	return medium, nil
+}
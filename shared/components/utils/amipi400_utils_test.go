@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/skazanyNaGlany/go.amipi400/shared"
+)
+
+func TestParseMediumLabel(t *testing.T) {
+	usedSlots := map[int]bool{0: true, 1: true}
+
+	isSlotUsed := func(index int) bool {
+		return usedSlots[index]
+	}
+
+	allSlotsUsed := func(index int) bool {
+		return true
+	}
+
+	tests := []struct {
+		label      string
+		regex      *regexp.Regexp
+		maxIndex   int
+		isSlotUsed func(index int) bool
+		expected   MediumLabel
+		err        error
+	}{
+		{
+			label:    "AP4_DF",
+			regex:    shared.AP4_MEDIUM_DF_RE,
+			maxIndex: shared.MAX_ADFS,
+			expected: MediumLabel{Index: 0, BootPriority: shared.DH_BOOT_PRIORITY_UNSPECIFIED}},
+		{
+			label:    "AP4_DF3",
+			regex:    shared.AP4_MEDIUM_DF_RE,
+			maxIndex: shared.MAX_ADFS,
+			expected: MediumLabel{Index: 3, BootPriority: shared.DH_BOOT_PRIORITY_UNSPECIFIED}},
+		{
+			label:    "AP4_DF4",
+			regex:    shared.AP4_MEDIUM_DF_RE,
+			maxIndex: shared.MAX_ADFS,
+			expected: MediumLabel{Index: 4, BootPriority: shared.DH_BOOT_PRIORITY_UNSPECIFIED},
+			err:      ErrIndexOutOfRange},
+		{
+			label:      "AP4_DFX",
+			regex:      shared.AP4_MEDIUM_DF_RE,
+			maxIndex:   shared.MAX_ADFS,
+			isSlotUsed: isSlotUsed,
+			expected:   MediumLabel{Index: 2, AutoIndex: true, BootPriority: shared.DH_BOOT_PRIORITY_UNSPECIFIED}},
+		{
+			label:    "AP4_DFX",
+			regex:    shared.AP4_MEDIUM_DF_RE,
+			maxIndex: shared.MAX_ADFS,
+			expected: MediumLabel{Index: 0, AutoIndex: true, BootPriority: shared.DH_BOOT_PRIORITY_UNSPECIFIED}},
+		{
+			label:      "AP4_DFX",
+			regex:      shared.AP4_MEDIUM_DF_RE,
+			maxIndex:   shared.MAX_ADFS,
+			isSlotUsed: allSlotsUsed,
+			expected: MediumLabel{
+				Index:        shared.DRIVE_INDEX_UNSPECIFIED,
+				AutoIndex:    true,
+				BootPriority: shared.DH_BOOT_PRIORITY_UNSPECIFIED},
+			err: ErrNoFreeSlot},
+		{
+			label:    "AP4_DF12",
+			regex:    shared.AP4_MEDIUM_DF_RE,
+			maxIndex: shared.MAX_ADFS,
+			expected: MediumLabel{
+				Index:        shared.DRIVE_INDEX_UNSPECIFIED,
+				BootPriority: shared.DH_BOOT_PRIORITY_UNSPECIFIED},
+			err: ErrLabelNotMatched},
+		{
+			label:    "WORKBENCH",
+			regex:    shared.AP4_MEDIUM_DF_RE,
+			maxIndex: shared.MAX_ADFS,
+			expected: MediumLabel{
+				Index:        shared.DRIVE_INDEX_UNSPECIFIED,
+				BootPriority: shared.DH_BOOT_PRIORITY_UNSPECIFIED},
+			err: ErrLabelNotMatched},
+		{
+			label:    "AP4_DH",
+			regex:    shared.AP4_MEDIUM_DH_RE,
+			maxIndex: shared.MAX_HDFS,
+			expected: MediumLabel{Index: 0, BootPriority: shared.DH_BOOT_PRIORITY_DEFAULT}},
+		{
+			label:    "AP4_DH1_5",
+			regex:    shared.AP4_MEDIUM_DH_RE,
+			maxIndex: shared.MAX_HDFS,
+			expected: MediumLabel{Index: 1, BootPriority: 5}},
+		{
+			label:      "AP4_DHX_2",
+			regex:      shared.AP4_MEDIUM_DH_RE,
+			maxIndex:   shared.MAX_HDFS,
+			isSlotUsed: isSlotUsed,
+			expected:   MediumLabel{Index: 2, AutoIndex: true, BootPriority: 2}},
+		{
+			label:    "AP4_HF6",
+			regex:    shared.AP4_MEDIUM_HF_RE,
+			maxIndex: shared.MAX_HDFS,
+			expected: MediumLabel{Index: 6, BootPriority: shared.DH_BOOT_PRIORITY_DEFAULT}},
+		{
+			label:    "AP4_HF7",
+			regex:    shared.AP4_MEDIUM_HF_RE,
+			maxIndex: shared.MAX_HDFS,
+			expected: MediumLabel{Index: 7, BootPriority: shared.DH_BOOT_PRIORITY_UNSPECIFIED},
+			err:      ErrIndexOutOfRange},
+		{
+			label:    "AP4_CD",
+			regex:    shared.AP4_MEDIUM_CD_RE,
+			maxIndex: shared.MAX_CDS,
+			expected: MediumLabel{Index: 0, BootPriority: shared.DH_BOOT_PRIORITY_UNSPECIFIED}},
+		{
+			label:    "AP4_CD1",
+			regex:    shared.AP4_MEDIUM_CD_RE,
+			maxIndex: shared.MAX_CDS,
+			expected: MediumLabel{Index: 1, BootPriority: shared.DH_BOOT_PRIORITY_UNSPECIFIED},
+			err:      ErrIndexOutOfRange},
+		{
+			label:    "AP4_CD_1",
+			regex:    shared.AP4_MEDIUM_CD_RE,
+			maxIndex: shared.MAX_CDS,
+			expected: MediumLabel{
+				Index:        shared.DRIVE_INDEX_UNSPECIFIED,
+				BootPriority: shared.DH_BOOT_PRIORITY_UNSPECIFIED},
+			err: ErrLabelNotMatched},
+	}
+
+	for _, test := range tests {
+		medium, err := AmiPi400UtilsInstance.ParseMediumLabel(
+			test.label,
+			test.regex,
+			test.maxIndex,
+			test.isSlotUsed)
+
+		if !errors.Is(err, test.err) {
+			t.Errorf("ParseMediumLabel(%v): error %v, expected %v", test.label, err, test.err)
+		}
+
+		test.expected.Label = test.label
+
+		if medium != test.expected {
+			t.Errorf("ParseMediumLabel(%v) = %+v, expected %+v", test.label, medium, test.expected)
+		}
+	}
+}
@@ -37,6 +37,22 @@ func (k *UnixUtils) SetDeviceReadAHead(handle *os.File, readAHead int) error {
 	return nil
 }
 
+// FlushDeviceBuffers drops the buffers of the block-device,
+// so the next read comes from the medium itself
+func (k *UnixUtils) FlushDeviceBuffers(handle *os.File) error {
+	return unix.IoctlSetInt(int(handle.Fd()), unix.BLKFLSBUF, 0)
+}
+
+// DropFileCache writes the file and drops its pages from
+// the page cache, so the next read comes from the medium
+func (k *UnixUtils) DropFileCache(handle *os.File) error {
+	if err := handle.Sync(); err != nil {
+		return err
+	}
+
+	return unix.Fadvise(int(handle.Fd()), 0, 0, unix.FADV_DONTNEED)
+}
+
 func (k *UnixUtils) RunFsck(devicePathname string) (string, error) {
 	output, err := exec.Command(
 		"fsck",
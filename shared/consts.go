@@ -21,6 +21,8 @@ const HD_OP_DONE_MONITOR_TURN_OFF_SECS = 8
 
 // amipi400.go
 const _AMIPI400_AMIBERRY_CONFIG_PATHNAME = "/boot/amipi400.uae.template"
+const AMIPI400_AMIBERRY_PROFILE_CONFIG_PATHNAME_FORMAT = "/boot/amipi400.%v.uae.template"
+const AMIPI400_AMIBERRY_DEFAULT_PROFILE = ""
 const MAIN_CONFIG_INI_PATHNAME = "/boot/amipi400.ini"
 const _AMIBERRY_EXE_PATHNAME = "../../amiberry/amiberry"
 const AMIBERRY_EMULATOR_TMP_INI_FILENAME = "amiberry.tmp.ini"
@@ -36,14 +38,23 @@ const MEDIUM_CONFIG_INI_NAME = "amipi400.ini"
 const MEDIUM_CONFIG_DEFAULT_SECTION = "amipi400"
 const MEDIUM_CONFIG_DEFAULT_FILE = "default_file"
 const MEDIUM_CONFIG_DEFAULT_FILE_NONE = "none"
+const MEDIUM_CONFIG_PROFILE = "profile"
+const PROFILE_COMMAND = "P"
 const ADF_DISK_NO_OF_MAX = "(Disk %d of %d)"
 const LOW_LEVEL_DEVICE_FLOPPY = "DF"
 const LOW_LEVEL_DEVICE_HARD_DISK = "DH"
+const LOW_LEVEL_DEVICE_HARD_FILE = "HF"
+const LOW_LEVEL_DEVICE_CD = "CD"
 const WPA_SUPPLICANT_CONF_PATHNAME = "/etc/wpa_supplicant/wpa_supplicant.conf"
 const AUTORUN_EMULATOR = true
 
 var SOFT_RESET_KEYS []string = []string{KEY_L_CTRL, KEY_L_ALT, KEY_R_ALT}
 var HARD_RESET_KEYS []string = []string{KEY_L_CTRL, KEY_L_ALT, KEY_R_ALT}
+
+const AP4_MEDIUM_AUTO_INDEX = "X"
+
+var PROFILE_NAME_RE = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
 var AP4_MEDIUM_DF_RE = regexp.MustCompile(`^AP4_DF(?P<index>\d?|X)$`)
 
 var AP4_MEDIUM_DH_RE = regexp.MustCompile(
@@ -55,51 +66,10 @@ var AP4_MEDIUM_HF_RE = regexp.MustCompile(
 )
 var AP4_MEDIUM_CD_RE = regexp.MustCompile(`^AP4_CD(?P<index>\d?|X)$`)
 
-var DF_INSERT_FROM_SOURCE_TO_TARGET_INDEX_RE = regexp.MustCompile(
-	`^DF(?P<source_index>\d)(?P<filename_part>.*)DF(?P<target_index>\d|N)$`,
-)
-
-var DF_INSERT_FROM_SOURCE_TO_TARGET_INDEX_BY_DISK_NO_RE = regexp.MustCompile(
-	`^DF(?P<source_index>\d)(?P<disk_no>\d\d?)DF(?P<target_index>\d|N)$`,
-)
-
-var DF_INSERT_FROM_SOURCE_INDEX_RE = regexp.MustCompile(
-	`^DF(?P<source_index>\d)(?P<filename_part>.*)$`,
-)
-
-var DF_INSERT_FROM_SOURCE_INDEX_BY_DISK_NO_RE = regexp.MustCompile(
-	`^DF(?P<source_index>\d)(?P<disk_no>\d\d?)$`,
-)
-var DF_EJECT_FROM_SOURCE_INDEX_RE = regexp.MustCompile(`^DF(?P<source_index>\d|N)$`)
-
-var CD_INSERT_FROM_SOURCE_INDEX_RE = regexp.MustCompile(
-	`^CD(?P<source_index>\d)(?P<filename_part>.*)$`,
-)
-var CD_EJECT_FROM_SOURCE_INDEX_RE = regexp.MustCompile(`^CD(?P<source_index>\d)$`)
-
 var ADF_DISK_NO_OF_MAX_RE = regexp.MustCompile(
 	`(?P<disk_no_of_max>\((Disk\ \d)\ (of\ \d)\))`,
 )
 
-var HF_INSERT_FROM_SOURCE_INDEX_RE = regexp.MustCompile(
-	`^HF(?P<source_index>\d)(?P<filename_part>.*)$`,
-)
-var HF_EJECT_FROM_SOURCE_INDEX_RE = regexp.MustCompile(`^HF(?P<source_index>\d)$`)
-var DF_UNMOUNT_FROM_SOURCE_INDEX_RE = regexp.MustCompile(`^UDF(?P<source_index>\d|N)$`)
-var CD_UNMOUNT_FROM_SOURCE_INDEX_RE = regexp.MustCompile(`^UCD(?P<source_index>\d|N)$`)
-var HF_UNMOUNT_FROM_SOURCE_INDEX_RE = regexp.MustCompile(`^UHF(?P<source_index>\d|N)$`)
-var DH_UNMOUNT_FROM_SOURCE_INDEX_RE = regexp.MustCompile(`^UDH(?P<source_index>\d|N)$`)
-
-var LOW_LEVEL_COPY_RE = regexp.MustCompile(
-	`^C(?P<source_low_level_device>[A-Z][A-Z])(?P<source_index>\d)(?P<target_low_level_device>[A-Z][A-Z])(?P<target_index>\d)$`,
-)
-var UNMOUNT_ALL_RE = regexp.MustCompile(`^U$`)
-
-var WIFI_CONNECT_RE = regexp.MustCompile(
-	`^(?i)W,(?P<country_code_iso_iec_3166_1>[A-Z][A-Z]),(?P<ssid>.*),(?P<password>.*)$`,
-)
-var WIFI_DISCONNECT_RE = regexp.MustCompile(`^W$`)
-
 var IWCONFIG_INTERFACE_TO_SSID_RE = regexp.MustCompile(
 	`(?P<name>^.*)IEEE.*802.*11.*ESSID\:(?P<ssid>.*)$`,
 )
@@ -107,11 +77,17 @@ var IWCONFIG_INTERFACE_TO_SSID_RE = regexp.MustCompile(
 // amiga_disk_devices.go
 const AMIGA_DISK_DEVICES_UNIXNAME = "amiga_disk_devices"
 const AMIGA_DISK_DEVICES_VERSION = "0.1"
+const AMIGA_DISK_DEVICES_CONFIG_INI_PATHNAME = "/boot/amiga_disk_devices.ini"
 const SYSTEM_INTERNAL_SD_CARD_NAME = "mmcblk0"
 const POOL_DEVICE_NAME = "loop"
 const FILE_SYSTEM_MOUNT = "/tmp/amiga_disk_devices"
 const CACHED_ADFS = "./cached_adfs"
 const CACHED_ADFS_QUOTA = FLOPPY_ADF_SIZE * 1024 // 1024 adf files
+const CACHED_ADFS_INDEX = "./cached_adfs.json"
+const FLOPPY_JOURNAL = "./floppy_journal"
+const FILE_SYSTEM_STATUS_FILENAME = "status.json"
+const PUBLIC_FILENAME_VOLUME_NAME_PREFIX = " [" // eg. __dev__sda [Workbench].adf
+const PUBLIC_FILENAME_VOLUME_NAME_SUFFIX = "]"
 const FLOPPY_READ_MUTE_SECS = 4
 const FLOPPY_WRITE_MUTE_SECS = 4
 const FLOPPY_WRITE_BLINK_POWER_SECS = 8
@@ -122,12 +98,15 @@ const RUNNERS_VERBOSE_MODE = true
 const RUNNERS_DEBUG_MODE = true
 const DRIVERS_VERBOSE_MODE = true
 const DRIVERS_DEBUG_MODE = true
+const FORMAT_FLOPPY_FFS = false // OFS floppies work with Kickstart 1.x
+const FORMAT_HD_FFS = true
 
 var FORCE_INSERT_KEYS []string = []string{KEY_LEFTMETA, KEY_L_SHIFT}
 var FORMAT_DEVICE_KEYS []string = []string{KEY_LEFTMETA, KEY_DEL}
-var EMPTY_DEVICE_HEADER [2048]byte = [2048]byte{'D', 'O', 'S'}
 var TOGGLE_ZOOM_KEYS []string = []string{KEY_LEFTMETA, KEY_H}
 var TOGGLE_ZOOM_KEYS_ALT []string = []string{KEY_LEFTMETA, KEY_H_LOW}
+var PIN_CACHED_ADF_KEYS []string = []string{KEY_LEFTMETA, KEY_P}
+var PIN_CACHED_ADF_KEYS_ALT []string = []string{KEY_LEFTMETA, KEY_P_LOW}
 var SHUTDOWN_KEYS []string = []string{KEY_LEFTMETA, KEY_F10}
 var CLEAR_BUFFER_KEYS []string = []string{KEY_ESC}
 var NUMPAD_EMULATE_ENTER_KEYS []string = []string{KEY_LEFTMETA, KEY_ENTER}
@@ -143,6 +122,7 @@ var AMIGA_DISK_DEVICES_NEEDED_EXECUTABLES = []string{
 
 // PowerLEDControl
 const POWER_LED0_BRIGHTNESS_PATHNAME = "/sys/class/leds/led0/brightness"
+const POWER_LED_DO_NOT_EJECT_BLINK_MS = 150
 
 // NumLockLEDControl
 const NUM_LOCK_LED0_BRIGHTNESS_PATHNAME = "/sys/class/leds/input0::numlock/brightness"
@@ -162,6 +142,22 @@ const FLOPPY_SECTOR_READ_TIME_MS = int64(100)
 const FLOPPY_CACHE_DATA_BETWEEN_SECS = 3
 const FLOPPY_DEVICE_SECTOR_SIZE = 512
 const FLOPPY_DEVICE_LAST_SECTOR = 1474048
+const FLOPPY_PARTIAL_ADF_EXTENSION = "partial"
+const FLOPPY_SECTOR_BITMAP_EXTENSION = "bitmap"
+const FLOPPY_JOURNAL_EXTENSION = "journal"
+const FLOPPY_BAD_SECTORS_EXTENSION = "bad"
+const FLOPPY_TRACK_SECTORS = 11 // Amiga track (80 cylinders x 2 heads x 11 sectors)
+const FLOPPY_TRACK_SIZE = FLOPPY_TRACK_SECTORS * FLOPPY_DEVICE_SECTOR_SIZE
+const FLOPPY_DEVICE_TRACK_SECTORS = 18 // track of the drive (1.44MB medium)
+const FLOPPY_DEVICE_TRACK_SIZE = FLOPPY_DEVICE_TRACK_SECTORS * FLOPPY_DEVICE_SECTOR_SIZE
+const FLOPPY_PREFETCH_IDLE_SECS = 2
+const FLOPPY_READ_RETRIES = 3
+const FLOPPY_READ_RETRY_DELAY_MS = 200
+const FLOPPY_FSYNC_TIMEOUT_SECS = 300 // whole disk may be queued
+
+// boot block, root block and sectors spread over the
+// whole disk, used to identify write-protected mediums
+var FLOPPY_FINGERPRINT_SECTORS = []int64{0, 1, 110, 330, 550, 770, 880, 990, 1210, 1430, 1650}
 
 // HardDiskMediumDriver
 const HD_DEVICE_MIN_SIZE = FLOPPY_DEVICE_SIZE + 1
@@ -175,11 +171,63 @@ const DEFAULT_READ_AHEAD = 256
 // AsyncFileOps
 const ASYNC_FILE_OP_DIRECT_READ = "direct_read"
 const ASYNC_FILE_OP_WRITE = "write"
+const ASYNC_FILE_OP_PREFETCH = "prefetch"
+const ASYNC_FILE_OP_PRIORITY_HIGH = 0   // direct reads, moving the motor while the emulator reads
+const ASYNC_FILE_OP_PRIORITY_NORMAL = 1 // writes
+const ASYNC_FILE_OP_PRIORITY_LOW = 2    // one time final writes (like cached ADF header)
+const ASYNC_FILE_OP_PRIORITY_IDLE = 3   // prefetch
+const ASYNC_FILE_OP_PRIORITIES = 4
+const ASYNC_FILE_OPS_MAX_QUEUED = 256
+const ASYNC_FILE_OPS_IDLE_MS = 100
+const ASYNC_FILE_OPS_FLUSH_TIMEOUT_SECS = 30
 
 // WIFIControl
 const WIFI_CONTROL_OP_CONNECT = "connect"
 const WIFI_CONTROL_OP_DISCONNECT = "disconnect"
 
+// ControlServer
+const CONTROL_SOCKET_PATHNAME = "/tmp/amipi400.sock"
+const CONTROL_HTTP_ADDRESS = "127.0.0.1:8400"
+const CONTROL_HTTP_API_PATH = "/api"
+const CONTROL_HTTP_CONTENT_TYPE = "application/json"
+const CONTROL_HTTP_AUTH_SCHEME = "Bearer "
+const CONTROL_MAX_REQUEST_SIZE = 65536
+const CONTROL_MAX_RESPONSE_SIZE = 1048576
+const CONTROL_CMD_KEYBOARD = "keyboard"
+const CONTROL_CMD_DF_INSERT = "df_insert"
+const CONTROL_CMD_DF_INSERT_BY_DISK_NO = "df_insert_by_disk_no"
+const CONTROL_CMD_DF_EJECT = "df_eject"
+const CONTROL_CMD_CD_INSERT = "cd_insert"
+const CONTROL_CMD_CD_EJECT = "cd_eject"
+const CONTROL_CMD_HF_INSERT = "hf_insert"
+const CONTROL_CMD_HF_EJECT = "hf_eject"
+const CONTROL_CMD_UNMOUNT_ALL = "unmount_all"
+const CONTROL_CMD_DF_UNMOUNT = "df_unmount"
+const CONTROL_CMD_CD_UNMOUNT = "cd_unmount"
+const CONTROL_CMD_HF_UNMOUNT = "hf_unmount"
+const CONTROL_CMD_DH_UNMOUNT = "dh_unmount"
+const CONTROL_CMD_LOW_LEVEL_COPY = "low_level_copy"
+const CONTROL_CMD_SOFT_RESET = "soft_reset"
+const CONTROL_CMD_HARD_RESET = "hard_reset"
+const CONTROL_CMD_TOGGLE_ZOOM = "toggle_zoom"
+const CONTROL_CMD_WIFI_CONNECT = "wifi_connect"
+const CONTROL_CMD_WIFI_DISCONNECT = "wifi_disconnect"
+const CONTROL_CMD_STATUS = "status"
+const CONTROL_CMD_SAVE_STATE = "save_state"
+const CONTROL_CMD_LOAD_STATE = "load_state"
+const CONTROL_CMD_SWITCH_PROFILE = "switch_profile"
+const CONTROL_CMD_CONFIG = "config"
+const CONTROL_CMD_DF_ARCHIVE = "df_archive"
+const CONTROL_CMD_DF_WRITE = "df_write"
+const CONTROL_CMD_CREATE_ADF = "create_adf"
+
+// amipi400ctl.go
+const AMIPI400CTL_UNIXNAME = "amipi400ctl"
+
+var AMIPI400CTL_LOW_LEVEL_DEVICE_RE = regexp.MustCompile(
+	`^(?i)(?P<low_level_device>[A-Z][A-Z])(?P<index>\d)$`,
+)
+
 // CachedADFHeader
 const CACHED_ADF_HEADER_HEADER_TYPE = "CachedADFHeader"
 const CACHED_ADF_HEADER_SHA512_LENGTH = 128
@@ -217,6 +265,8 @@ var AMIPI400_NEEDED_EXECUTABLES = []string{
 
 const DRIVE_INDEX_UNSPECIFIED = -1
 const DRIVE_INDEX_UNSPECIFIED_STR = "-1"
+const DRIVE_INDEX_ALL = -2
+const DRIVE_INDEX_ALL_STR = "N"
 const DH_BOOT_PRIORITY_DEFAULT = 0
 const DH_BOOT_PRIORITY_UNSPECIFIED = -1
 const DISK_INDEX_UNSPECIFIED = -1
@@ -237,6 +287,112 @@ const HDF_TYPE_HDF = 5
 const AMIBERRY_DEFAULT_WINDOW_HEIGHT = 568
 const AMIBERRY_ZOOM_WINDOW_HEIGHT = 512
 
+// UAEConfig
+const UAE_CONFIG_COMMENT = ";"
+
+// options which can be used more than once
+var UAE_CONFIG_MULTI_VALUE_KEYS = []string{"hardfile", "hardfile2", "filesystem", "filesystem2"}
+
+// boolean values accepted by the emulator, case-insensitive
+var UAE_CONFIG_TRUE_VALUES = []string{"true", "t", "yes", "y", "1"}
+var UAE_CONFIG_FALSE_VALUES = []string{"false", "f", "no", "n", "0"}
+
+const UAE_CONFIG_TRUE = "true"
+const UAE_CONFIG_FALSE = "false"
+
+// ConfigTemplate
+// legacy placeholders like {{floppy0}}, converted to {{.floppy0}}
+var CONFIG_TEMPLATE_LEGACY_PLACEHOLDER_RE = regexp.MustCompile(
+	`{{\s*(?P<name>[A-Za-z_][A-Za-z0-9_]*)\s*}}`,
+)
+
+// text/template keywords which look like legacy placeholders
+var CONFIG_TEMPLATE_KEYWORDS = []string{"end", "else", "break", "continue", "nil", "true", "false"}
+
+// AmiberryCommander
+// must be less than LINE_BUFFER_LEN in amiberry.amipi400.patch
+// minus "cmdNN=osd_message "
+const OSD_MESSAGE_MAX_LEN = 128
+
+// amipi400.go, save-states
+const SAVESTATE_COMMAND_SAVE = "SS"
+const SAVESTATE_COMMAND_LOAD = "LS"
+const SAVESTATES_DIR_NAME = "savestates"
+const SAVESTATE_FILENAME_FORMAT = "slot%v.uss"
+
+// amipi400.go, archiving physical floppies
+const ARCHIVE_COMMAND = "A"
+const ARCHIVE_DEFAULT_VOLUME_NAME = "Untitled"
+const ARCHIVE_PROGRESS_STEP_PERCENT = 10
+const ARCHIVE_TMP_EXTENSION = ".tmp"
+
+// not allowed in the filenames on USB mediums (FAT)
+const ARCHIVE_FILENAME_INVALID_CHARS = `/\:*?"<>|`
+
+// amipi400.go, writing ADFs to physical floppies
+const WRITE_FLOPPY_COMMAND = "R"
+
+// amipi400.go, creating blank ADFs
+const CREATE_ADF_COMMAND = "F"
+const CREATE_ADF_FILE_SYSTEM_OFS = "ofs"
+const CREATE_ADF_FILE_SYSTEM_FFS = "ffs"
+
+// amigafs
+const AMIGAFS_BLOCK_SIZE = 512
+const AMIGAFS_BOOT_BLOCKS = 2
+const AMIGAFS_T_HEADER = 2
+const AMIGAFS_T_DIRCACHE = 33
+const AMIGAFS_ST_ROOT = 1
+const AMIGAFS_CHECKSUM_OFFSET = 20
+const AMIGAFS_BOOT_CHECKSUM_OFFSET = 4
+const AMIGAFS_BOOT_ROOT_BLOCK_OFFSET = 8
+const AMIGAFS_NAME_OFFSET = AMIGAFS_BLOCK_SIZE - 80
+const AMIGAFS_MAX_NAME_LENGTH = 30
+const AMIGAFS_HASH_TABLE_SIZE = AMIGAFS_BLOCK_SIZE/4 - 56
+const AMIGAFS_HASH_TABLE_OFFSET = 24
+const AMIGAFS_BITMAP_FLAG_OFFSET = AMIGAFS_BLOCK_SIZE - 200
+const AMIGAFS_BITMAP_PAGES_OFFSET = AMIGAFS_BLOCK_SIZE - 196
+const AMIGAFS_BITMAP_PAGES = 25
+const AMIGAFS_BITMAP_EXT_OFFSET = AMIGAFS_BLOCK_SIZE - 96
+const AMIGAFS_BITMAP_LONGS = AMIGAFS_BLOCK_SIZE/4 - 1         // the first long is the checksum
+const AMIGAFS_BITMAP_EXT_PAGES = AMIGAFS_BLOCK_SIZE/4 - 1     // the last long is the next block
+const AMIGAFS_ROOT_ALTERED_OFFSET = AMIGAFS_BLOCK_SIZE - 92   // days, mins, ticks
+const AMIGAFS_VOLUME_ALTERED_OFFSET = AMIGAFS_BLOCK_SIZE - 40 // days, mins, ticks
+const AMIGAFS_VOLUME_CREATED_OFFSET = AMIGAFS_BLOCK_SIZE - 28 // days, mins, ticks
+const AMIGAFS_EXTENSION_OFFSET = AMIGAFS_BLOCK_SIZE - 8
+const AMIGAFS_SEC_TYPE_OFFSET = AMIGAFS_BLOCK_SIZE - 4
+const AMIGAFS_DOS_FLAG_FFS = 1
+const AMIGAFS_DOS_FLAG_INTL = 2
+const AMIGAFS_DOS_FLAG_DIRCACHE = 4
+const AMIGAFS_TICKS_PER_SECOND = 50
+const AMIGAFS_DEFAULT_VOLUME_NAME = "Empty"
+const AMIGAFS_T_DATA = 8
+const AMIGAFS_T_LIST = 16
+const AMIGAFS_ST_USERDIR = 2
+const AMIGAFS_ST_SOFTLINK = 3
+const AMIGAFS_ST_LINKDIR = 4
+const AMIGAFS_ST_FILE = 0xfffffffd     // -3
+const AMIGAFS_ST_LINKFILE = 0xfffffffc // -4
+const AMIGAFS_HIGH_SEQ_OFFSET = 8
+const AMIGAFS_DATA_BLOCKS_PER_HEADER = AMIGAFS_HASH_TABLE_SIZE
+const AMIGAFS_PROTECTION_OFFSET = AMIGAFS_BLOCK_SIZE - 192
+const AMIGAFS_BYTE_SIZE_OFFSET = AMIGAFS_BLOCK_SIZE - 188
+const AMIGAFS_COMMENT_OFFSET = AMIGAFS_BLOCK_SIZE - 184
+const AMIGAFS_MAX_COMMENT_LENGTH = 79
+const AMIGAFS_DATE_OFFSET = AMIGAFS_BLOCK_SIZE - 92 // days, mins, ticks
+const AMIGAFS_REAL_ENTRY_OFFSET = AMIGAFS_BLOCK_SIZE - 44
+const AMIGAFS_NEXT_SAME_HASH_OFFSET = AMIGAFS_BLOCK_SIZE - 16
+const AMIGAFS_PARENT_OFFSET = AMIGAFS_BLOCK_SIZE - 12
+const AMIGAFS_SOFTLINK_PATH_OFFSET = 24
+const AMIGAFS_SOFTLINK_PATH_LENGTH = AMIGAFS_BLOCK_SIZE - 224
+const AMIGAFS_OFS_DATA_HEADER_SIZE = 24
+const AMIGAFS_OFS_DATA_SIZE_OFFSET = 12
+const AMIGAFS_OFS_NEXT_DATA_OFFSET = 16
+const AMIGAFS_NAME_HASH_MASK = 0x7ff
+const AMIGAFS_MAX_DIRECTORY_DEPTH = 64
+
+var AMIGAFS_DOS_TYPE_PREFIX = []byte{'D', 'O', 'S'}
+
 // AllKeyboardsControl / KeyboardControl
 const MAX_KEYS_SEQUENCE = 128
 const KEY_ESC = "ESC"
@@ -246,6 +402,8 @@ const KEY_LEFTMETA = "KEY_LEFTMETA"
 const KEY_DEL = "Del"
 const KEY_H = "H"
 const KEY_H_LOW = "h"
+const KEY_P = "P"
+const KEY_P_LOW = "p"
 const KEY_L_CTRL = "L_CTRL"
 const KEY_L_ALT = "L_ALT"
 const KEY_R_ALT = "R_ALT"
@@ -0,0 +1,3 @@
+package interfaces
+
+type FilePrefetchCallback func(name string, offset int64, size int64) bool